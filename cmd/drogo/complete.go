@@ -0,0 +1,107 @@
+package main
+
+import (
+	"sort"
+	"strings"
+	"unicode"
+)
+
+var sqlKeywords = []string{
+	"ANALYZE", "AND", "ARROW", "AS", "ASC", "AVG", "BY", "COPY", "COUNT", "CREATE", "CSV",
+	"DESC", "DESCRIBE", "DISTINCT", "EXISTS", "EXPLAIN", "EXTERNAL", "FROM", "GROUP", "HAVING",
+	"HEADER", "IF", "JSON", "LIMIT", "LOCATION", "MAX", "MIN", "NOT", "OFFSET",
+	"OPTIONS", "OR", "ORDER", "PARQUET", "PARTITIONED", "ROW", "SELECT", "SHOW",
+	"STORED", "SUM", "TABLE", "TABLES", "TO", "WHERE", "WITH",
+}
+
+var metaCommands = []string{`\d`, `\format`, `\help`, `\i`, `\q`, `\register`, `\tables`, `\timing`}
+
+// complete returns the completions of the word that ends at pos in line and
+// where that word starts. Words are completed from the SQL keywords and the
+// registered tables and their columns, and a word of the form table.prefix
+// from that table's columns.
+func (s *session) complete(line string, pos int) (int, []string) {
+	start := pos
+	for start > 0 && isWordByte(line[start-1]) {
+		start--
+	}
+	word := line[start:pos]
+	if start > 0 && line[start-1] == '\\' && strings.TrimSpace(line[:start-1]) == "" {
+		return start - 1, matching(metaCommands, `\`+word, false)
+	}
+
+	candidates := []string{}
+	if dot := strings.LastIndexByte(word, '.'); dot >= 0 {
+		table := word[:dot]
+		for _, column := range s.columns(table) {
+			candidates = append(candidates, table+"."+column)
+		}
+		return start, dedupe(matching(candidates, word, false))
+	}
+	tables := s.ec.TableNames()
+	candidates = append(candidates, tables...)
+	for _, table := range tables {
+		candidates = append(candidates, s.columns(table)...)
+	}
+	completions := matching(candidates, word, false)
+	// keywords follow the case of what was typed
+	lower := word != "" && strings.ToLower(word) == word
+	completions = append(completions, matching(sqlKeywords, word, lower)...)
+	return start, dedupe(completions)
+}
+
+func (s *session) columns(table string) []string {
+	df, err := s.ec.Table(table)
+	if err != nil {
+		return nil
+	}
+	names := []string{}
+	for _, f := range df.Schema().Fields() {
+		names = append(names, f.Name)
+	}
+	return names
+}
+
+func isWordByte(c byte) bool {
+	return c == '_' || c == '.' || c >= 0x80 || unicode.IsLetter(rune(c)) || unicode.IsDigit(rune(c))
+}
+
+// matching returns the candidates that start with prefix, ignoring case,
+// converted to lower case if lower is set
+func matching(candidates []string, prefix string, lower bool) []string {
+	matches := []string{}
+	for _, c := range candidates {
+		if len(c) >= len(prefix) && strings.EqualFold(c[:len(prefix)], prefix) {
+			if lower {
+				c = strings.ToLower(c)
+			}
+			matches = append(matches, c)
+		}
+	}
+	return matches
+}
+
+func dedupe(words []string) []string {
+	sort.Strings(words)
+	out := words[:0]
+	for i, w := range words {
+		if i == 0 || w != words[i-1] {
+			out = append(out, w)
+		}
+	}
+	return out
+}
+
+// commonPrefix returns the longest prefix shared by all the words
+func commonPrefix(words []string) string {
+	if len(words) == 0 {
+		return ""
+	}
+	prefix := words[0]
+	for _, w := range words[1:] {
+		for !strings.HasPrefix(w, prefix) {
+			prefix = prefix[:len(prefix)-1]
+		}
+	}
+	return prefix
+}
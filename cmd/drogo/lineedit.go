@@ -0,0 +1,284 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"unicode"
+)
+
+// maxHistory is the number of lines of history that are kept
+const maxHistory = 1000
+
+var errInterrupted = errors.New("interrupted")
+
+// lineEditor reads lines from a terminal in raw mode, with cursor movement,
+// history and tab completion. If the terminal cannot be put in raw mode it
+// reads plain lines instead.
+type lineEditor struct {
+	in  *bufio.Reader
+	out io.Writer
+	// fd is the terminal's file descriptor, or -1 if in is not a terminal
+	fd       int
+	complete func(line string, pos int) (int, []string)
+
+	history     []string
+	historyFile *os.File
+}
+
+func newLineEditor(in io.Reader, out io.Writer, complete func(string, int) (int, []string)) *lineEditor {
+	return &lineEditor{in: bufio.NewReader(in), out: out, fd: -1, complete: complete}
+}
+
+// loadHistory reads the history saved in path and appends new lines to it
+func (e *lineEditor) loadHistory(path string) {
+	if data, err := os.ReadFile(path); err == nil {
+		for _, line := range strings.Split(string(data), "\n") {
+			if line != "" {
+				e.history = append(e.history, line)
+			}
+		}
+		if len(e.history) > maxHistory {
+			e.history = e.history[len(e.history)-maxHistory:]
+			// rewrite the file so it does not grow without bound
+			os.WriteFile(path, []byte(strings.Join(e.history, "\n")+"\n"), 0o600)
+		}
+	}
+	e.historyFile, _ = os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o600)
+}
+
+func (e *lineEditor) addHistory(line string) {
+	if strings.TrimSpace(line) == "" || (len(e.history) > 0 && e.history[len(e.history)-1] == line) {
+		return
+	}
+	e.history = append(e.history, line)
+	if len(e.history) > maxHistory {
+		e.history = e.history[1:]
+	}
+	if e.historyFile != nil {
+		fmt.Fprintln(e.historyFile, line)
+	}
+}
+
+// readLine shows the prompt and returns the line that is entered. It
+// returns errInterrupted for Ctrl-C and io.EOF for Ctrl-D on an empty line.
+func (e *lineEditor) readLine(prompt string) (string, error) {
+	if e.fd >= 0 {
+		restore, err := makeRaw(e.fd)
+		if err == nil {
+			defer restore()
+			return e.edit(prompt)
+		}
+	}
+	fmt.Fprint(e.out, prompt)
+	line, err := e.in.ReadString('\n')
+	if err == io.EOF && line != "" {
+		err = nil
+	}
+	return strings.TrimRight(line, "\r\n"), err
+}
+
+// edit reads keys until the line is entered. The terminal must be in raw
+// mode, so it echoes nothing and every key arrives as it is pressed.
+func (e *lineEditor) edit(prompt string) (string, error) {
+	line := []rune{}
+	pos := 0
+	// index is the history entry being shown, len(history) for the new line
+	index := len(e.history)
+	draft := ""
+	lastTab := false
+
+	refresh := func() {
+		fmt.Fprintf(e.out, "\r%s%s\x1b[K", prompt, string(line))
+		if back := len(line) - pos; back > 0 {
+			fmt.Fprintf(e.out, "\x1b[%dD", back)
+		}
+	}
+	show := func(text string) {
+		line = []rune(text)
+		pos = len(line)
+		refresh()
+	}
+	refresh()
+	for {
+		r, _, err := e.in.ReadRune()
+		if err != nil {
+			return "", err
+		}
+		tab := false
+		switch r {
+		case '\r', '\n':
+			fmt.Fprint(e.out, "\r\n")
+			return string(line), nil
+		case 3: // Ctrl-C
+			fmt.Fprint(e.out, "^C\r\n")
+			return "", errInterrupted
+		case 4: // Ctrl-D
+			if len(line) == 0 {
+				fmt.Fprint(e.out, "\r\n")
+				return "", io.EOF
+			}
+			if pos < len(line) {
+				line = append(line[:pos], line[pos+1:]...)
+			}
+		case 127, 8: // Backspace
+			if pos > 0 {
+				line = append(line[:pos-1], line[pos:]...)
+				pos--
+			}
+		case 1: // Ctrl-A
+			pos = 0
+		case 5: // Ctrl-E
+			pos = len(line)
+		case 2: // Ctrl-B
+			if pos > 0 {
+				pos--
+			}
+		case 6: // Ctrl-F
+			if pos < len(line) {
+				pos++
+			}
+		case 11: // Ctrl-K
+			line = line[:pos]
+		case 21: // Ctrl-U
+			line = line[pos:]
+			pos = 0
+		case 23: // Ctrl-W
+			start := pos
+			for start > 0 && line[start-1] == ' ' {
+				start--
+			}
+			for start > 0 && line[start-1] != ' ' {
+				start--
+			}
+			line = append(line[:start], line[pos:]...)
+			pos = start
+		case 16, 14: // Ctrl-P, Ctrl-N
+			index, draft = e.browse(r == 16, index, draft, string(line), show)
+			continue
+		case '\t':
+			tab = true
+			line, pos = e.completeLine(line, pos, lastTab, prompt)
+		case 27: // escape sequence
+			key := e.escape()
+			switch key {
+			case 'A', 'B':
+				index, draft = e.browse(key == 'A', index, draft, string(line), show)
+				continue
+			case 'C':
+				if pos < len(line) {
+					pos++
+				}
+			case 'D':
+				if pos > 0 {
+					pos--
+				}
+			case 'H':
+				pos = 0
+			case 'F':
+				pos = len(line)
+			case '3': // Delete
+				if pos < len(line) {
+					line = append(line[:pos], line[pos+1:]...)
+				}
+			}
+		default:
+			if unicode.IsPrint(r) {
+				line = append(line[:pos], append([]rune{r}, line[pos:]...)...)
+				pos++
+			}
+		}
+		lastTab = tab
+		refresh()
+	}
+}
+
+// escape reads the rest of an escape sequence and returns the key it
+// stands for: A to D for the arrows, H and F for Home and End and 3 for
+// Delete. It returns 0 for sequences it does not know.
+func (e *lineEditor) escape() rune {
+	r, _, err := e.in.ReadRune()
+	if err != nil || (r != '[' && r != 'O') {
+		return 0
+	}
+	r, _, err = e.in.ReadRune()
+	if err != nil {
+		return 0
+	}
+	if r < '0' || r > '9' {
+		return r
+	}
+	// numbered keys end with ~
+	code := r
+	for {
+		next, _, err := e.in.ReadRune()
+		if err != nil || next == '~' {
+			break
+		}
+		if next < '0' || next > '9' {
+			return 0
+		}
+		code = -1
+	}
+	switch code {
+	case '1', '7':
+		return 'H'
+	case '4', '8':
+		return 'F'
+	case '3':
+		return '3'
+	}
+	return 0
+}
+
+// browse moves to the previous or next history entry. The line being typed
+// is kept as the draft while history is shown.
+func (e *lineEditor) browse(previous bool, index int, draft, current string, show func(string)) (int, string) {
+	if index == len(e.history) {
+		draft = current
+	}
+	switch {
+	case previous && index > 0:
+		index--
+	case !previous && index < len(e.history):
+		index++
+	default:
+		return index, draft
+	}
+	if index == len(e.history) {
+		show(draft)
+	} else {
+		show(e.history[index])
+	}
+	return index, draft
+}
+
+// completeLine completes the word before the cursor. A single completion is
+// inserted. With several, their common prefix is inserted, and a second tab
+// lists them.
+func (e *lineEditor) completeLine(line []rune, pos int, list bool, prompt string) ([]rune, int) {
+	if e.complete == nil {
+		return line, pos
+	}
+	text := string(line[:pos])
+	start, candidates := e.complete(text, len(text))
+	if len(candidates) == 0 {
+		return line, pos
+	}
+	insert := commonPrefix(candidates)
+	if len(candidates) == 1 {
+		insert += " "
+	}
+	if len(insert) >= len(text)-start {
+		completed := []rune(text[:start] + insert)
+		line = append(completed, line[pos:]...)
+		pos = len(completed)
+	}
+	if list && len(candidates) > 1 {
+		fmt.Fprintf(e.out, "\r\n%s\r\n", strings.Join(candidates, "  "))
+	}
+	return line, pos
+}
@@ -0,0 +1,74 @@
+// Command drogo is an interactive SQL shell for the drogo query engine.
+//
+//	drogo [-format table|csv|json] [-timing] [-f script.sql | -c statement]
+//
+// Files are made queryable with CREATE EXTERNAL TABLE or \register, and
+// results can be written out with COPY ... TO. Without -f or -c, drogo reads
+// statements from the terminal with line editing, history and completion of
+// table and column names, or from standard input when it is not a terminal.
+// Type \help for the meta commands.
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+func main() {
+	os.Exit(run(os.Args[1:], os.Stdin, os.Stdout, os.Stderr))
+}
+
+// run runs the shell with command line arguments and returns the exit code
+func run(args []string, stdin io.Reader, stdout, stderr io.Writer) int {
+	flags := flag.NewFlagSet("drogo", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	script := flags.String("f", "", "run the statements in `file` and exit")
+	command := flags.String("c", "", "run `statements` and exit")
+	format := flags.String("format", "table", "print results as table, csv or json")
+	timing := flags.Bool("timing", false, "print how long each statement takes")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	if flags.NArg() > 0 {
+		fmt.Fprintf(stderr, "unexpected argument %s\n", flags.Arg(0))
+		flags.Usage()
+		return 2
+	}
+
+	s := newSession(stdout, stderr)
+	if err := s.setFormat(*format); err != nil {
+		fmt.Fprintln(stderr, err)
+		return 2
+	}
+	s.timing = *timing
+
+	ctx := context.Background()
+	var err error
+	switch {
+	case *command != "":
+		err = s.runScript(ctx, strings.NewReader(*command))
+	case *script != "":
+		err = s.runFile(ctx, *script)
+	default:
+		if f, ok := stdin.(*os.File); ok && isTerminal(f) {
+			s.interactive(ctx, f)
+			return 0
+		}
+		err = s.runScript(ctx, stdin)
+	}
+	if err != nil {
+		fmt.Fprintf(stderr, "Error: %v\n", err)
+		return 1
+	}
+	return 0
+}
+
+// isTerminal reports whether f is a terminal rather than a file or pipe
+func isTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
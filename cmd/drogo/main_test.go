@@ -0,0 +1,170 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const employees = "../../engine/testdata/employees.csv"
+
+func runDrogo(t *testing.T, stdin string, args ...string) (string, string, int) {
+	var stdout, stderr bytes.Buffer
+	code := run(args, strings.NewReader(stdin), &stdout, &stderr)
+	return stdout.String(), stderr.String(), code
+}
+
+func TestRunScript(t *testing.T) {
+	script := filepath.Join(t.TempDir(), "script.sql")
+	require.NoError(t, os.WriteFile(script, []byte(`
+CREATE EXTERNAL TABLE employees STORED AS CSV LOCATION '`+employees+`';
+-- a comment; with a semicolon
+SELECT state, COUNT(*) AS n
+FROM employees
+WHERE state = 'CO' OR state = 'OH'
+GROUP BY state ORDER BY state; SELECT 'it''s'
+`), 0o644))
+
+	stdout, stderr, code := runDrogo(t, "", "-f", script)
+	assert.Equal(t, 0, code, stderr)
+	assert.Equal(t, `+-------+---+
+| state | n |
++-------+---+
+| CO    | 2 |
+| OH    | 2 |
++-------+---+
+(2 rows)
++---------+
+| 'it''s' |
++---------+
+| it's    |
++---------+
+(1 row)
+`, stdout)
+}
+
+func TestRunFormats(t *testing.T) {
+	register := `\register employees ` + employees + "\n"
+	query := "SELECT id, last_name, state FROM employees WHERE id > 3 AND id < 6 ORDER BY id;"
+
+	stdout, stderr, code := runDrogo(t, register+query, "-format", "csv", "-timing")
+	assert.Equal(t, 0, code, stderr)
+	assert.Equal(t, "id,last_name,state\n4,Mill,\n5,Byron,OH\n", stdout)
+	assert.Contains(t, stderr, "Time: ")
+
+	stdout, _, _ = runDrogo(t, register+`\format json`+"\n"+query)
+	assert.Equal(t, `{"id":4,"last_name":"Mill","state":""}`+"\n"+`{"id":5,"last_name":"Byron","state":"OH"}`+"\n", stdout)
+
+	stdout, _, _ = runDrogo(t, register+`\timing on`+"\n"+query)
+	assert.Contains(t, stdout, "|  4 | Mill      |       |")
+	assert.Contains(t, stdout, "Time: ")
+}
+
+func TestRunCommands(t *testing.T) {
+	stdout, stderr, code := runDrogo(t, "", "-c", `CREATE EXTERNAL TABLE e STORED AS csv LOCATION '`+employees+`'; SHOW TABLES`)
+	assert.Equal(t, 0, code, stderr)
+	assert.Contains(t, stdout, "| e          |")
+
+	stdout, _, code = runDrogo(t, `\register e `+employees+"\n"+`\d e`+"\n"+`\q`+"\nSELECT nope;")
+	assert.Equal(t, 0, code)
+	assert.Contains(t, stdout, "| salary      | int64     | YES         |")
+
+	out := filepath.Join(t.TempDir(), "out.json")
+	_, stderr, code = runDrogo(t, `\register e `+employees+"\nCOPY (SELECT id FROM e WHERE id = 1) TO '"+out+"';")
+	assert.Equal(t, 0, code, stderr)
+	data, err := os.ReadFile(out)
+	require.NoError(t, err)
+	assert.Equal(t, `{"id":1}`+"\n", string(data))
+
+	_, stderr, code = runDrogo(t, "SELECT nope FROM e;\nSELECT 1;")
+	assert.Equal(t, 1, code)
+	assert.Equal(t, "Error: table 'e' not found\n", stderr)
+
+	_, stderr, code = runDrogo(t, `\frobnicate`)
+	assert.Equal(t, 1, code)
+	assert.Contains(t, stderr, `unknown command \frobnicate`)
+
+	_, _, code = runDrogo(t, "", "-format", "xml")
+	assert.Equal(t, 2, code)
+}
+
+func TestSplitStatements(t *testing.T) {
+	statements, rest := splitStatements("SELECT ';'; ;\nSELECT \"a;b\" -- ;\nFROM t; SELECT")
+	assert.Equal(t, []string{"SELECT ';'", "\nSELECT \"a;b\" -- ;\nFROM t"}, statements)
+	assert.Equal(t, " SELECT", rest)
+}
+
+func TestComplete(t *testing.T) {
+	s := newSession(io.Discard, io.Discard)
+	require.NoError(t, s.ec.RegisterFile("employees", employees, ""))
+
+	start, candidates := s.complete("SELECT first FROM e", 12)
+	assert.Equal(t, 7, start)
+	assert.Equal(t, []string{"first_name"}, candidates)
+
+	_, candidates = s.complete("SELECT * FROM e", 15)
+	assert.Equal(t, []string{"employees", "exists", "explain", "external"}, candidates)
+
+	_, candidates = s.complete("sel", 3)
+	assert.Equal(t, []string{"select"}, candidates)
+
+	start, candidates = s.complete("SELECT employees.s", 18)
+	assert.Equal(t, 7, start)
+	assert.Equal(t, []string{"employees.salary", "employees.state"}, candidates)
+
+	start, candidates = s.complete(`\re`, 3)
+	assert.Equal(t, 0, start)
+	assert.Equal(t, []string{`\register`}, candidates)
+}
+
+func TestLineEditor(t *testing.T) {
+	complete := func(line string, pos int) (int, []string) {
+		return newSession(io.Discard, io.Discard).complete(line, pos)
+	}
+	keys := strings.Join([]string{
+		"sel\t* FROM t\x1b[Dx\r", // completion and moving left
+		"\x1b[A\x01\x0b\r",       // recall, Ctrl-A and Ctrl-K
+		"ab\x7fc\x17d\r",         // backspace and Ctrl-W
+		"abc\x03",                // Ctrl-C
+		"\x04",                   // Ctrl-D
+	}, "")
+	var out bytes.Buffer
+	e := newLineEditor(strings.NewReader(keys), &out, complete)
+	lines := []string{}
+	for {
+		line, err := e.edit("> ")
+		if err == errInterrupted {
+			lines = append(lines, "^C")
+			continue
+		}
+		if err != nil {
+			assert.Equal(t, io.EOF, err)
+			break
+		}
+		lines = append(lines, line)
+		e.addHistory(line)
+	}
+	assert.Equal(t, []string{"select * FROM xt", "", "d", "^C"}, lines)
+}
+
+func TestHistory(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "history")
+	e := newLineEditor(strings.NewReader(""), io.Discard, nil)
+	e.loadHistory(path)
+	e.addHistory("SELECT 1;")
+	e.addHistory("SELECT 1;")
+	e.addHistory("  ")
+	e.addHistory(`\d`)
+
+	e = newLineEditor(strings.NewReader("\x1b[A\x1b[A\r"), io.Discard, nil)
+	e.loadHistory(path)
+	line, err := e.edit("> ")
+	require.NoError(t, err)
+	assert.Equal(t, "SELECT 1;", line)
+}
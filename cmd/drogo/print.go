@@ -0,0 +1,189 @@
+package main
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/apache/arrow/go/v12/arrow"
+	"github.com/briansterle/drogo/engine"
+)
+
+// printResults writes the rows of a query result in one of the output
+// formats
+func printResults(w io.Writer, format string, schema engine.Schema, batches []engine.RecordBatch) error {
+	switch format {
+	case "csv":
+		return printCsv(w, schema, batches)
+	case "json":
+		return printJson(w, schema, batches)
+	default:
+		return printTable(w, schema, batches)
+	}
+}
+
+// printTable draws the rows in a box with a column per field, numbers
+// aligned right. Cells with several lines, such as plans, span several
+// lines of the box.
+func printTable(w io.Writer, schema engine.Schema, batches []engine.RecordBatch) error {
+	fields := schema.Fields()
+	widths := make([]int, len(fields))
+	for i, f := range fields {
+		widths[i] = utf8.RuneCountInString(f.Name)
+	}
+	rows := [][][]string{}
+	for _, batch := range batches {
+		for r := 0; r < batch.RowCount(); r++ {
+			row := make([][]string, len(fields))
+			for i, column := range batch.Fields {
+				row[i] = cellLines(formatCell(column.GetValue(r)))
+				for _, line := range row[i] {
+					if n := utf8.RuneCountInString(line); n > widths[i] {
+						widths[i] = n
+					}
+				}
+			}
+			rows = append(rows, row)
+		}
+	}
+
+	out := bufio.NewWriter(w)
+	var border strings.Builder
+	border.WriteString("+")
+	for _, width := range widths {
+		border.WriteString(strings.Repeat("-", width+2) + "+")
+	}
+	border.WriteString("\n")
+	writeRow := func(cells [][]string, header bool) {
+		height := 1
+		for _, lines := range cells {
+			if len(lines) > height {
+				height = len(lines)
+			}
+		}
+		for l := 0; l < height; l++ {
+			out.WriteString("|")
+			for i, lines := range cells {
+				cell := ""
+				if l < len(lines) {
+					cell = lines[l]
+				}
+				padding := strings.Repeat(" ", widths[i]-utf8.RuneCountInString(cell))
+				if !header && isNumeric(fields[i].Type) {
+					out.WriteString(" " + padding + cell + " |")
+				} else {
+					out.WriteString(" " + cell + padding + " |")
+				}
+			}
+			out.WriteString("\n")
+		}
+	}
+	out.WriteString(border.String())
+	names := make([][]string, len(fields))
+	for i, f := range fields {
+		names[i] = []string{f.Name}
+	}
+	writeRow(names, true)
+	out.WriteString(border.String())
+	for _, row := range rows {
+		writeRow(row, false)
+	}
+	if len(rows) > 0 {
+		out.WriteString(border.String())
+	}
+	if len(rows) == 1 {
+		out.WriteString("(1 row)\n")
+	} else {
+		fmt.Fprintf(out, "(%d rows)\n", len(rows))
+	}
+	return out.Flush()
+}
+
+// cellLines splits a value into the lines it is drawn as, with tabs
+// expanded
+func cellLines(cell string) []string {
+	cell = strings.TrimSuffix(strings.ReplaceAll(cell, "\t", "  "), "\n")
+	return strings.Split(cell, "\n")
+}
+
+func isNumeric(dtype arrow.DataType) bool {
+	return arrow.IsInteger(dtype.ID()) || arrow.IsFloating(dtype.ID())
+}
+
+func formatCell(v any) string {
+	switch v := v.(type) {
+	case nil:
+		return "NULL"
+	case float32:
+		return strconv.FormatFloat(float64(v), 'f', -1, 32)
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+// printCsv writes a header row and the rows, with nulls as empty fields
+func printCsv(w io.Writer, schema engine.Schema, batches []engine.RecordBatch) error {
+	out := csv.NewWriter(w)
+	record := make([]string, len(schema.Fields()))
+	for i, f := range schema.Fields() {
+		record[i] = f.Name
+	}
+	if err := out.Write(record); err != nil {
+		return err
+	}
+	for _, batch := range batches {
+		for r := 0; r < batch.RowCount(); r++ {
+			for i, column := range batch.Fields {
+				record[i] = ""
+				if v := column.GetValue(r); v != nil {
+					record[i] = formatCell(v)
+				}
+			}
+			if err := out.Write(record); err != nil {
+				return err
+			}
+		}
+	}
+	out.Flush()
+	return out.Error()
+}
+
+// printJson writes a JSON object per row, one per line, with the fields in
+// column order
+func printJson(w io.Writer, schema engine.Schema, batches []engine.RecordBatch) error {
+	out := bufio.NewWriter(w)
+	keys := make([][]byte, len(schema.Fields()))
+	for i, f := range schema.Fields() {
+		key, err := json.Marshal(f.Name)
+		if err != nil {
+			return err
+		}
+		keys[i] = key
+	}
+	for _, batch := range batches {
+		for r := 0; r < batch.RowCount(); r++ {
+			out.WriteByte('{')
+			for i, column := range batch.Fields {
+				if i > 0 {
+					out.WriteByte(',')
+				}
+				value, err := json.Marshal(column.GetValue(r))
+				if err != nil {
+					return err
+				}
+				out.Write(keys[i])
+				out.WriteByte(':')
+				out.Write(value)
+			}
+			out.WriteString("}\n")
+		}
+	}
+	return out.Flush()
+}
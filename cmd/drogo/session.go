@@ -0,0 +1,287 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/briansterle/drogo/engine"
+)
+
+const helpText = `Statements end with a semicolon and may span lines:
+  CREATE EXTERNAL TABLE name STORED AS csv|json|parquet|arrow LOCATION 'path';
+  SELECT ...;
+  COPY {table | (query)} TO 'path' [STORED AS format] [PARTITIONED BY (columns)]
+      [OPTIONS (compression 'gzip', ...)];
+  EXPLAIN [ANALYZE] SELECT ...;
+  SHOW TABLES;  DESCRIBE name;
+
+Meta commands:
+  \register name path [format]  register a file, directory or glob as a table
+  \d                            list tables
+  \d name                       describe a table
+  \format table|csv|json        set how results are printed
+  \timing [on|off]              print how long each statement takes
+  \i file                       run the statements in a file
+  \help                         show this help
+  \q                            quit
+`
+
+var errQuit = errors.New("quit")
+
+// session holds the state of the shell: the tables registered so far, the
+// output settings and the text of a statement that is not finished yet
+type session struct {
+	ec      *engine.ExecutionContext
+	out     io.Writer
+	errOut  io.Writer
+	format  string
+	timing  bool
+	pending strings.Builder
+}
+
+func newSession(out, errOut io.Writer) *session {
+	return &session{ec: &engine.ExecutionContext{}, out: out, errOut: errOut, format: "table"}
+}
+
+func (s *session) setFormat(format string) error {
+	switch format {
+	case "table", "csv", "json":
+		s.format = format
+		return nil
+	default:
+		return fmt.Errorf("unknown format '%s', use table, csv or json", format)
+	}
+}
+
+// feed adds a line of input. A meta command runs at once if no statement is
+// in progress, and statements run as semicolons complete them.
+func (s *session) feed(ctx context.Context, line string) error {
+	if s.pending.Len() == 0 && strings.HasPrefix(strings.TrimSpace(line), `\`) {
+		return s.meta(ctx, strings.TrimSpace(line))
+	}
+	s.pending.WriteString(line)
+	s.pending.WriteString("\n")
+	statements, rest := splitStatements(s.pending.String())
+	s.pending.Reset()
+	if strings.TrimSpace(rest) != "" {
+		s.pending.WriteString(rest)
+	}
+	for _, stmt := range statements {
+		if err := s.execute(ctx, stmt); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// flush runs a final statement that was not ended with a semicolon
+func (s *session) flush(ctx context.Context) error {
+	stmt := s.pending.String()
+	s.pending.Reset()
+	if isBlank(stmt) {
+		return nil
+	}
+	return s.execute(ctx, stmt)
+}
+
+// runScript runs every statement and meta command read from r, stopping at
+// the first error
+func (s *session) runScript(ctx context.Context, r io.Reader) error {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		if err := s.feed(ctx, scanner.Text()); err != nil {
+			if err == errQuit {
+				return nil
+			}
+			return err
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	return s.flush(ctx)
+}
+
+func (s *session) runFile(ctx context.Context, path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return s.runScript(ctx, f)
+}
+
+// interactive reads statements from the terminal until \q or end of input.
+// Errors are printed and the shell carries on.
+func (s *session) interactive(ctx context.Context, terminal *os.File) {
+	fmt.Fprintln(s.out, `drogo SQL shell. Type \help for help.`)
+	editor := newLineEditor(terminal, s.out, s.complete)
+	editor.fd = int(terminal.Fd())
+	if home, err := os.UserHomeDir(); err == nil {
+		editor.loadHistory(filepath.Join(home, ".drogo_history"))
+	}
+	for {
+		prompt := "drogo> "
+		if s.pending.Len() > 0 {
+			prompt = "  ...> "
+		}
+		line, err := editor.readLine(prompt)
+		if err == errInterrupted {
+			s.pending.Reset()
+			continue
+		}
+		if err != nil {
+			if err != io.EOF {
+				fmt.Fprintf(s.errOut, "Error: %v\n", err)
+			}
+			return
+		}
+		editor.addHistory(line)
+		if err := s.feed(ctx, line); err != nil {
+			if err == errQuit {
+				return
+			}
+			s.pending.Reset()
+			fmt.Fprintf(s.errOut, "Error: %v\n", err)
+		}
+	}
+}
+
+// execute plans and runs a statement and prints its results. Interrupting
+// the shell cancels the statement.
+func (s *session) execute(ctx context.Context, stmt string) error {
+	ctx, stop := signal.NotifyContext(ctx, os.Interrupt)
+	defer stop()
+	start := time.Now()
+	df, err := s.ec.Sql(ctx, stmt)
+	if err != nil {
+		return err
+	}
+	batches, err := df.Collect(ctx)
+	if err != nil {
+		return err
+	}
+	if len(df.Schema().Fields()) > 0 {
+		if err := printResults(s.out, s.format, df.Schema(), batches); err != nil {
+			return err
+		}
+	}
+	if s.timing {
+		// keep csv and json output parseable
+		w := s.out
+		if s.format != "table" {
+			w = s.errOut
+		}
+		fmt.Fprintf(w, "Time: %s\n", time.Since(start).Round(time.Microsecond))
+	}
+	return nil
+}
+
+func (s *session) meta(ctx context.Context, line string) error {
+	fields := strings.Fields(line)
+	args := fields[1:]
+	switch fields[0] {
+	case `\q`, `\quit`:
+		return errQuit
+	case `\help`, `\?`:
+		fmt.Fprint(s.out, helpText)
+	case `\register`:
+		if len(args) < 2 || len(args) > 3 {
+			return fmt.Errorf(`usage: \register name path [format]`)
+		}
+		format := ""
+		if len(args) == 3 {
+			format = strings.ToLower(args[2])
+		}
+		return s.ec.RegisterFile(args[0], args[1], format)
+	case `\d`, `\tables`:
+		switch len(args) {
+		case 0:
+			return s.execute(ctx, "SHOW TABLES")
+		case 1:
+			return s.execute(ctx, "DESCRIBE "+quoteIdent(args[0]))
+		default:
+			return fmt.Errorf(`usage: \d [name]`)
+		}
+	case `\format`:
+		if len(args) != 1 {
+			return fmt.Errorf(`usage: \format table|csv|json`)
+		}
+		return s.setFormat(args[0])
+	case `\timing`:
+		switch {
+		case len(args) == 0:
+			s.timing = !s.timing
+		case len(args) == 1 && (args[0] == "on" || args[0] == "off"):
+			s.timing = args[0] == "on"
+		default:
+			return fmt.Errorf(`usage: \timing [on|off]`)
+		}
+		state := "off"
+		if s.timing {
+			state = "on"
+		}
+		fmt.Fprintf(s.out, "Timing is %s.\n", state)
+	case `\i`:
+		if len(args) != 1 {
+			return fmt.Errorf(`usage: \i file`)
+		}
+		return s.runFile(ctx, args[0])
+	default:
+		return fmt.Errorf(`unknown command %s, type \help for help`, fields[0])
+	}
+	return nil
+}
+
+func quoteIdent(name string) string {
+	return `"` + strings.ReplaceAll(name, `"`, `""`) + `"`
+}
+
+// splitStatements returns the statements in text that are ended by a
+// semicolon outside quotes and comments, and the text after the last one
+func splitStatements(text string) ([]string, string) {
+	statements := []string{}
+	var quote byte
+	start := 0
+	for i := 0; i < len(text); i++ {
+		c := text[i]
+		switch {
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '\'' || c == '"':
+			quote = c
+		case c == '-' && strings.HasPrefix(text[i:], "--"):
+			for i < len(text) && text[i] != '\n' {
+				i++
+			}
+		case c == ';':
+			if stmt := text[start:i]; !isBlank(stmt) {
+				statements = append(statements, stmt)
+			}
+			start = i + 1
+		}
+	}
+	return statements, text[start:]
+}
+
+// isBlank reports whether a statement has nothing but whitespace and comments
+func isBlank(stmt string) bool {
+	for _, line := range strings.Split(stmt, "\n") {
+		line = strings.TrimSpace(line)
+		if line != "" && !strings.HasPrefix(line, "--") {
+			return false
+		}
+	}
+	return true
+}
@@ -27,6 +27,9 @@ type Array struct {
 	float32Data *array.Float32
 	float64Data *array.Float64
 	stringData  *array.String
+	// nestedData holds struct and list arrays, whose values are returned as
+	// map[string]any and []any
+	nestedData arrow.Array
 }
 
 func (arr *Array) String() string {
@@ -47,6 +50,8 @@ func (arr *Array) String() string {
 		return arr.float64Data.String()
 	case *arrow.StringType:
 		return arr.stringData.String()
+	case *arrow.StructType, *arrow.ListType:
+		return arr.nestedData.String()
 	default:
 		panic("Unsupported Arrow type")
 	}
@@ -71,12 +76,17 @@ func (arr Array) Len() int {
 		return arr.float64Data.Len()
 	case *arrow.StringType:
 		return arr.stringData.Len()
+	case *arrow.StructType, *arrow.ListType:
+		return arr.nestedData.Len()
 	default:
 		panic("Unsupported Arrow type")
 	}
 }
 
 func (arr Array) GetValue(i int) any {
+	if arr.IsNull(i) {
+		return nil
+	}
 	switch arr.dtype.(type) {
 	case *arrow.BooleanType:
 		return arr.boolData.Value(i)
@@ -94,6 +104,34 @@ func (arr Array) GetValue(i int) any {
 		return arr.float64Data.Value(i)
 	case *arrow.StringType:
 		return arr.stringData.Value(i)
+	case *arrow.StructType, *arrow.ListType:
+		return nestedValue(arr.nestedData, i)
+	default:
+		panic("Unsupported Arrow type")
+	}
+}
+
+// IsNull reports whether the value at index i is null
+func (arr Array) IsNull(i int) bool {
+	switch arr.dtype.(type) {
+	case *arrow.BooleanType:
+		return arr.boolData.IsNull(i)
+	case *arrow.Int8Type:
+		return arr.int8Data.IsNull(i)
+	case *arrow.Int16Type:
+		return arr.int16Data.IsNull(i)
+	case *arrow.Int32Type:
+		return arr.int32Data.IsNull(i)
+	case *arrow.Int64Type:
+		return arr.int64Data.IsNull(i)
+	case *arrow.Float32Type:
+		return arr.float32Data.IsNull(i)
+	case *arrow.Float64Type:
+		return arr.float64Data.IsNull(i)
+	case *arrow.StringType:
+		return arr.stringData.IsNull(i)
+	case *arrow.StructType, *arrow.ListType:
+		return arr.nestedData.IsNull(i)
 	default:
 		panic("Unsupported Arrow type")
 	}
@@ -103,68 +141,235 @@ func (arr Array) DataType() arrow.DataType {
 	return arr.dtype
 }
 
+// ArrowArray returns the arrow array backing arr
+func (arr Array) ArrowArray() arrow.Array {
+	switch arr.dtype.(type) {
+	case *arrow.BooleanType:
+		return arr.boolData
+	case *arrow.Int8Type:
+		return arr.int8Data
+	case *arrow.Int16Type:
+		return arr.int16Data
+	case *arrow.Int32Type:
+		return arr.int32Data
+	case *arrow.Int64Type:
+		return arr.int64Data
+	case *arrow.Float32Type:
+		return arr.float32Data
+	case *arrow.Float64Type:
+		return arr.float64Data
+	case *arrow.StringType:
+		return arr.stringData
+	case *arrow.StructType, *arrow.ListType:
+		return arr.nestedData
+	default:
+		panic("Unsupported Arrow type")
+	}
+}
+
+// FromArrow wraps an arrow array without copying it
+func FromArrow(data arrow.Array) Array {
+	out := Array{dtype: data.DataType()}
+	switch data := data.(type) {
+	case *array.Boolean:
+		out.boolData = data
+	case *array.Int8:
+		out.int8Data = data
+	case *array.Int16:
+		out.int16Data = data
+	case *array.Int32:
+		out.int32Data = data
+	case *array.Int64:
+		out.int64Data = data
+	case *array.Float32:
+		out.float32Data = data
+	case *array.Float64:
+		out.float64Data = data
+	case *array.String:
+		out.stringData = data
+	case *array.Struct, *array.List:
+		out.nestedData = data
+	default:
+		panic("Unsupported Arrow type")
+	}
+	return out
+}
+
+// DefaultAllocator is shared by every array built with New
+var DefaultAllocator memory.Allocator = memory.NewGoAllocator()
+
 func New(arrowType arrow.DataType, initialCapacity int, data []any) Array {
-	rootAllocator := memory.NewGoAllocator()
+	return NewWithAllocator(DefaultAllocator, arrowType, initialCapacity, data)
+}
+
+// NewWithAllocator is like New but allocates the array's buffers from mem
+func NewWithAllocator(mem memory.Allocator, arrowType arrow.DataType, initialCapacity int, data []any) Array {
 	out := Array{dtype: arrowType}
 	switch arrowType.(type) {
 	case *arrow.BooleanType:
-		vs := array.NewBooleanBuilder(rootAllocator)
+		vs := array.NewBooleanBuilder(mem)
 		vs.Reserve(initialCapacity)
 		for _, v := range data {
+			if v == nil {
+				vs.AppendNull()
+				continue
+			}
 			vs.Append(v.(bool))
 		}
 		out.boolData = vs.NewBooleanArray()
 	case *arrow.Int8Type:
-		vs := array.NewInt8Builder(rootAllocator)
+		vs := array.NewInt8Builder(mem)
 		vs.Reserve(initialCapacity)
 		for _, v := range data {
+			if v == nil {
+				vs.AppendNull()
+				continue
+			}
 			vs.Append(v.(int8))
 		}
 		out.int8Data = vs.NewInt8Array()
 	case *arrow.Int16Type:
-		vs := array.NewInt16Builder(rootAllocator)
+		vs := array.NewInt16Builder(mem)
 		vs.Reserve(initialCapacity)
 		for _, v := range data {
+			if v == nil {
+				vs.AppendNull()
+				continue
+			}
 			vs.Append(v.(int16))
 		}
 		out.int16Data = vs.NewInt16Array()
 	case *arrow.Int32Type:
-		vs := array.NewInt32Builder(rootAllocator)
+		vs := array.NewInt32Builder(mem)
 		vs.Reserve(initialCapacity)
 		for _, v := range data {
+			if v == nil {
+				vs.AppendNull()
+				continue
+			}
 			vs.Append(v.(int32))
 		}
 		out.int32Data = vs.NewInt32Array()
 	case *arrow.Int64Type:
-		vs := array.NewInt64Builder(rootAllocator)
+		vs := array.NewInt64Builder(mem)
 		vs.Reserve(initialCapacity)
 		for _, v := range data {
+			if v == nil {
+				vs.AppendNull()
+				continue
+			}
 			vs.Append(v.(int64))
 		}
 		out.int64Data = vs.NewInt64Array()
 	case *arrow.Float32Type:
-		vs := array.NewFloat32Builder(rootAllocator)
+		vs := array.NewFloat32Builder(mem)
 		vs.Reserve(initialCapacity)
 		for _, v := range data {
+			if v == nil {
+				vs.AppendNull()
+				continue
+			}
 			vs.Append(v.(float32))
 		}
 		out.float32Data = vs.NewFloat32Array()
 	case *arrow.Float64Type:
-		vs := array.NewFloat64Builder(rootAllocator)
+		vs := array.NewFloat64Builder(mem)
 		vs.Reserve(initialCapacity)
 		for _, v := range data {
+			if v == nil {
+				vs.AppendNull()
+				continue
+			}
 			vs.Append(v.(float64))
 		}
 		out.float64Data = vs.NewFloat64Array()
 	case *arrow.StringType:
-		vs := array.NewStringBuilder(rootAllocator)
+		vs := array.NewStringBuilder(mem)
 		vs.Reserve(initialCapacity)
 		for _, v := range data {
+			if v == nil {
+				vs.AppendNull()
+				continue
+			}
 			vs.Append(v.(string))
 		}
 		out.stringData = vs.NewStringArray()
+	case *arrow.StructType, *arrow.ListType:
+		vs := array.NewBuilder(mem, arrowType)
+		vs.Reserve(initialCapacity)
+		for _, v := range data {
+			appendValue(vs, v)
+		}
+		out.nestedData = vs.NewArray()
 	default:
 		panic("Unsupported Arrow type")
 	}
 	return out
 }
+
+// nestedValue returns the value at index i of a struct or list array as a
+// map[string]any or []any of the values drogo uses for the child types
+func nestedValue(data arrow.Array, i int) any {
+	if data.IsNull(i) {
+		return nil
+	}
+	switch data := data.(type) {
+	case *array.Struct:
+		fields := data.DataType().(*arrow.StructType).Fields()
+		value := make(map[string]any, len(fields))
+		for j, f := range fields {
+			value[f.Name] = FromArrow(data.Field(j)).GetValue(i)
+		}
+		return value
+	case *array.List:
+		start, end := data.ValueOffsets(i)
+		values := FromArrow(data.ListValues())
+		value := make([]any, 0, end-start)
+		for j := start; j < end; j++ {
+			value = append(value, values.GetValue(int(j)))
+		}
+		return value
+	default:
+		panic("Unsupported Arrow type")
+	}
+}
+
+// appendValue appends v to a builder of any type New supports, taking the
+// same Go values that GetValue returns
+func appendValue(b array.Builder, v any) {
+	if v == nil {
+		b.AppendNull()
+		return
+	}
+	switch b := b.(type) {
+	case *array.BooleanBuilder:
+		b.Append(v.(bool))
+	case *array.Int8Builder:
+		b.Append(v.(int8))
+	case *array.Int16Builder:
+		b.Append(v.(int16))
+	case *array.Int32Builder:
+		b.Append(v.(int32))
+	case *array.Int64Builder:
+		b.Append(v.(int64))
+	case *array.Float32Builder:
+		b.Append(v.(float32))
+	case *array.Float64Builder:
+		b.Append(v.(float64))
+	case *array.StringBuilder:
+		b.Append(v.(string))
+	case *array.StructBuilder:
+		value := v.(map[string]any)
+		b.Append(true)
+		for i, f := range b.Type().(*arrow.StructType).Fields() {
+			appendValue(b.FieldBuilder(i), value[f.Name])
+		}
+	case *array.ListBuilder:
+		b.Append(true)
+		for _, e := range v.([]any) {
+			appendValue(b.ValueBuilder(), e)
+		}
+	default:
+		panic("Unsupported Arrow type")
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"testing"
 
 	"github.com/apache/arrow/go/v12/arrow"
+	"github.com/apache/arrow/go/v12/arrow/memory"
 	"github.com/briansterle/drogo/util"
 	"github.com/stretchr/testify/assert"
 )
@@ -91,3 +92,43 @@ func TestCreate(t *testing.T) {
 	assert.Equal(t, Float32, arr.DataType(), "should equal type")
 
 }
+
+func TestNewWithAllocator(t *testing.T) {
+	mem := memory.NewCheckedAllocator(memory.NewGoAllocator())
+	arr := NewWithAllocator(mem, Int64, 3, util.SliceToAny([]int64{1, 2, 3}))
+	assert.Equal(t, int64(2), arr.GetValue(1), "should equal int 64")
+	assert.Greater(t, mem.CurrentAlloc(), 0, "should allocate from mem")
+}
+
+func TestNull(t *testing.T) {
+	arr := New(Float64, 3, []any{1.5, nil, 2.5})
+	assert.Equal(t, 1.5, arr.GetValue(0), "should equal float64")
+	assert.Nil(t, arr.GetValue(1), "should be null")
+	assert.True(t, arr.IsNull(1), "should be null")
+	assert.Equal(t, "[1.5 (null) 2.5]", arr.String(), "should equal string")
+}
+
+func TestFromArrow(t *testing.T) {
+	arr := New(String, 2, util.SliceToAny([]string{"a", "b"}))
+	wrapped := FromArrow(arr.ArrowArray())
+	assert.Equal(t, "b", wrapped.GetValue(1), "should equal string")
+	assert.Equal(t, arr.ArrowArray(), wrapped.ArrowArray(), "should not copy")
+}
+
+func TestNested(t *testing.T) {
+	point := arrow.StructOf(
+		arrow.Field{Name: "x", Type: Int64, Nullable: true},
+		arrow.Field{Name: "tags", Type: arrow.ListOf(String), Nullable: true},
+	)
+	data := []any{
+		map[string]any{"x": int64(1), "tags": []any{"a", "b"}},
+		nil,
+		map[string]any{"x": nil, "tags": []any{}},
+	}
+	arr := New(point, 3, data)
+	assert.Equal(t, 3, arr.Len(), "should equal length")
+	assert.Equal(t, data[0], arr.GetValue(0), "should equal struct")
+	assert.True(t, arr.IsNull(1), "should be null")
+	assert.Equal(t, data[2], arr.GetValue(2), "should equal struct")
+	assert.Equal(t, data[0], FromArrow(arr.ArrowArray()).GetValue(0), "should equal struct")
+}
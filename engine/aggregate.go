@@ -0,0 +1,474 @@
+package engine
+
+import (
+	"context"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/apache/arrow/go/v12/arrow"
+	"github.com/apache/arrow/go/v12/arrow/array"
+	"github.com/apache/arrow/go/v12/arrow/ipc"
+	"github.com/briansterle/drogo"
+)
+
+// aggregatePartitions is the number of partitions the hash table of a
+// HashAggregateExec is split into. Only one partition has to fit in memory
+// when merging spilled state back.
+const aggregatePartitions = 16
+
+// accumulatorSize is the estimated memory used by the state of one
+// accumulator
+const accumulatorSize = 32
+
+// HashAggregateExec groups its input by the grouping expressions and feeds
+// each group's rows into one accumulator per aggregate expression.
+//
+// The hash table is split into partitions by the hash of the group key. When
+// the query's memory pool cannot hold another group, the largest partitions
+// have their groups' intermediate accumulator state written to a temporary
+// Arrow IPC file and are cleared. Once the input is exhausted each spilled
+// partition is read back and merged with the groups that are still in memory
+// before its results are emitted.
+//
+// Aggregation can be split in two phases so it runs in parallel: a partial
+// aggregate per input partition emits each group's accumulator state, and a
+// final aggregate merges the states of every group after the partial results
+// have been repartitioned by group key.
+type HashAggregateExec struct {
+	Input         PhysicalPlan
+	GroupExpr     []Expression
+	AggregateExpr []AggregateExpression
+	// Schema is the schema of the aggregate's final results
+	Schema  Schema
+	Mode    AggregateMode
+	metrics *Metrics
+}
+
+// AggregateMode is the phase of a two-phase aggregation a HashAggregateExec
+// performs
+type AggregateMode int
+
+const (
+	// AggregateSingle aggregates input rows into final results
+	AggregateSingle AggregateMode = iota
+	// AggregatePartial aggregates input rows into the group keys followed by
+	// the accumulators' state fields
+	AggregatePartial
+	// AggregateFinal merges the output of partial aggregates into final
+	// results. The group expressions refer to the partial output's group key
+	// columns, and the accumulator state follows them.
+	AggregateFinal
+)
+
+func (m AggregateMode) String() string {
+	switch m {
+	case AggregatePartial:
+		return "Partial"
+	case AggregateFinal:
+		return "Final"
+	default:
+		return "Single"
+	}
+}
+
+func (a HashAggregateExec) GetSchema() Schema {
+	if a.Mode == AggregatePartial {
+		return Schema{a.stateSchema()}
+	}
+	return a.Schema
+}
+
+func (a HashAggregateExec) OutputPartitions() int {
+	return a.Input.OutputPartitions()
+}
+
+func (a HashAggregateExec) Children() []PhysicalPlan {
+	return []PhysicalPlan{a.Input}
+}
+
+func (a HashAggregateExec) String() string {
+	return fmt.Sprintf("HashAggregateExec: mode=%s, groupExpr=%s, aggrExpr=%s", a.Mode, a.GroupExpr, a.AggregateExpr)
+}
+
+// stateSchema is the layout of partial aggregates, both spilled and emitted by
+// the partial phase: the group keys followed by the state fields of every
+// accumulator
+func (a HashAggregateExec) stateSchema() *arrow.Schema {
+	fields := append([]arrow.Field{}, a.Schema.Fields()[:len(a.GroupExpr)]...)
+	for _, e := range a.AggregateExpr {
+		fields = append(fields, e.StateFields()...)
+	}
+	return arrow.NewSchema(fields, nil)
+}
+
+func (a HashAggregateExec) Execute(ctx context.Context, task *TaskContext, partition int) RecordBatchStream {
+	s := &hashAggregateStream{
+		ctx:         ctx,
+		exec:        a,
+		input:       a.Input.Execute(ctx, task, partition),
+		task:        task,
+		partitions:  make([]*aggregatePartition, aggregatePartitions),
+		stateSchema: a.stateSchema(),
+	}
+	s.reservation = task.Memory.NewSpillableReservation("HashAggregateExec", s)
+	s.reservation.metrics = a.metrics
+	for i := range s.partitions {
+		s.partitions[i] = &aggregatePartition{groups: map[string]*aggregateGroup{}}
+	}
+	return a.metrics.record(s)
+}
+
+func (a HashAggregateExec) Metrics() *Metrics {
+	return a.metrics
+}
+
+type aggregateGroup struct {
+	keys         []any
+	accumulators []Accumulator
+}
+
+type aggregatePartition struct {
+	groups map[string]*aggregateGroup
+	// groups in the order they were first seen so output is deterministic
+	order []*aggregateGroup
+	size  int64
+	// set while the partition's spilled state is read back, when it must
+	// not be spilled again
+	merging bool
+	file    *os.File
+	writer  *ipc.Writer
+}
+
+type hashAggregateStream struct {
+	ctx         context.Context
+	exec        HashAggregateExec
+	input       RecordBatchStream
+	task        *TaskContext
+	reservation *MemoryReservation
+	partitions  []*aggregatePartition
+	stateSchema *arrow.Schema
+	// order of all groups across partitions, only kept until the first spill
+	order    []*aggregateGroup
+	spilled  bool
+	consumed bool
+	emitted  int
+}
+
+func (s *hashAggregateStream) Next() (RecordBatch, error) {
+	batch, err := s.next()
+	if err != nil && err != io.EOF {
+		// a failed or cancelled aggregation cannot resume, so its spill files
+		// are removed straight away rather than when the stream is closed
+		s.Close()
+	}
+	return batch, err
+}
+
+func (s *hashAggregateStream) next() (RecordBatch, error) {
+	if !s.consumed {
+		err := forEachBatch(s.ctx, s.input, s.aggregateBatch)
+		s.input.Close()
+		if err != nil {
+			return RecordBatch{}, err
+		}
+		s.consumed = true
+		if !s.spilled {
+			s.emitted = len(s.partitions)
+			if len(s.order) > 0 {
+				return s.output(s.order), nil
+			}
+			if len(s.exec.GroupExpr) == 0 && s.exec.Mode != AggregatePartial {
+				// without grouping an empty input still has one result row
+				return s.output([]*aggregateGroup{s.newGroup(nil)}), nil
+			}
+		}
+	}
+	for s.emitted < len(s.partitions) {
+		if err := s.ctx.Err(); err != nil {
+			return RecordBatch{}, err
+		}
+		p := s.partitions[s.emitted]
+		s.emitted++
+		if err := s.merge(p); err != nil {
+			return RecordBatch{}, err
+		}
+		groups := p.order
+		p.groups, p.order = nil, nil
+		s.reservation.Shrink(p.size)
+		p.size = 0
+		if len(groups) > 0 {
+			return s.output(groups), nil
+		}
+	}
+	return RecordBatch{}, io.EOF
+}
+
+func (s *hashAggregateStream) aggregateBatch(batch RecordBatch) error {
+	groupKeys := make([]ColumnVector, len(s.exec.GroupExpr))
+	for i, e := range s.exec.GroupExpr {
+		v, err := evaluate(e, batch)
+		if err != nil {
+			return err
+		}
+		groupKeys[i] = v
+	}
+	if s.exec.Mode == AggregateFinal {
+		// the input is the output of partial aggregates
+		state := batch.Fields[len(groupKeys):]
+		for row := 0; row < batch.RowCount(); row++ {
+			group, err := s.group(rowValues(groupKeys, row))
+			if err != nil {
+				return err
+			}
+			s.mergeState(group, rowValues(state, row))
+		}
+		return nil
+	}
+	aggrInputs := make([]ColumnVector, len(s.exec.AggregateExpr))
+	for i, e := range s.exec.AggregateExpr {
+		v, err := evaluate(e.InputExpression(), batch)
+		if err != nil {
+			return err
+		}
+		aggrInputs[i] = v
+	}
+	for row := 0; row < batch.RowCount(); row++ {
+		group, err := s.group(rowValues(groupKeys, row))
+		if err != nil {
+			return err
+		}
+		for i, acc := range group.accumulators {
+			acc.Accumulate(aggrInputs[i].GetValue(row))
+		}
+	}
+	return nil
+}
+
+// group finds or creates the group for a row's key values, reserving memory
+// for new groups
+func (s *hashAggregateStream) group(keys []any) (*aggregateGroup, error) {
+	key := encodeGroupKey(keys)
+	h := fnv.New32a()
+	h.Write([]byte(key))
+	p := s.partitions[h.Sum32()%uint32(len(s.partitions))]
+	if group, ok := p.groups[key]; ok {
+		return group, nil
+	}
+
+	size := int64(len(key)) + estimateSize(keys) + accumulatorSize*int64(len(s.exec.AggregateExpr))
+	// this may spill, including the partition the group belongs to
+	if err := s.reservation.TryGrow(size); err != nil {
+		return nil, err
+	}
+	group := s.newGroup(keys)
+	p.groups[key] = group
+	p.order = append(p.order, group)
+	p.size += size
+	if !s.spilled {
+		s.order = append(s.order, group)
+	}
+	return group, nil
+}
+
+func (s *hashAggregateStream) newGroup(keys []any) *aggregateGroup {
+	group := &aggregateGroup{keys, make([]Accumulator, len(s.exec.AggregateExpr))}
+	for i, e := range s.exec.AggregateExpr {
+		group.accumulators[i] = e.CreateAccumulator()
+	}
+	return group
+}
+
+// Spill writes the largest partitions to disk until at least half of the
+// reserved memory has been freed
+func (s *hashAggregateStream) Spill() error {
+	candidates := []*aggregatePartition{}
+	for _, p := range s.partitions {
+		if p.size > 0 && !p.merging {
+			candidates = append(candidates, p)
+		}
+	}
+	sort.Slice(candidates, func(i, j int) bool {
+		return candidates[i].size > candidates[j].size
+	})
+	target := s.reservation.Size() / 2
+	var freed int64
+	for _, p := range candidates {
+		if freed >= target {
+			break
+		}
+		freed += p.size
+		if err := s.spillPartition(p); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *hashAggregateStream) spillPartition(p *aggregatePartition) error {
+	if p.writer == nil {
+		file, err := os.CreateTemp(s.task.TempDir, "drogo-aggregate-*.arrow")
+		if err != nil {
+			return err
+		}
+		p.file = file
+		p.writer = ipc.NewWriter(file, ipc.WithSchema(s.stateSchema))
+	}
+
+	columns := make([][]any, len(s.stateSchema.Fields()))
+	for _, group := range p.order {
+		for i, k := range group.keys {
+			columns[i] = append(columns[i], k)
+		}
+		i := len(group.keys)
+		for _, acc := range group.accumulators {
+			for _, v := range acc.State() {
+				columns[i] = append(columns[i], v)
+				i++
+			}
+		}
+	}
+	record := newRecord(s.stateSchema, columns, len(p.order))
+	defer record.Release()
+	offset, _ := p.file.Seek(0, io.SeekCurrent)
+	if err := p.writer.Write(record); err != nil {
+		return fmt.Errorf("spilling aggregate state: %w", err)
+	}
+	end, _ := p.file.Seek(0, io.SeekCurrent)
+	s.exec.metrics.recordSpill(end - offset)
+
+	p.groups = map[string]*aggregateGroup{}
+	p.order = nil
+	s.reservation.Shrink(p.size)
+	p.size = 0
+	s.spilled = true
+	s.order = nil
+	return nil
+}
+
+// merge reads back a partition's spilled state and merges it into the groups
+// still held in memory
+func (s *hashAggregateStream) merge(p *aggregatePartition) error {
+	if p.writer == nil {
+		return nil
+	}
+	p.merging = true
+	defer s.removeSpillFile(p)
+	if err := p.writer.Close(); err != nil {
+		return err
+	}
+	if _, err := p.file.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	reader, err := ipc.NewReader(p.file)
+	if err != nil {
+		return err
+	}
+	defer reader.Release()
+
+	keyCount := len(s.exec.GroupExpr)
+	for reader.Next() {
+		if err := s.ctx.Err(); err != nil {
+			return err
+		}
+		record := reader.Record()
+		columns := make([]ColumnVector, record.NumCols())
+		for i, col := range record.Columns() {
+			columns[i] = drogo.FromArrow(col)
+		}
+		for row := 0; row < int(record.NumRows()); row++ {
+			values := rowValues(columns, row)
+			group, err := s.group(values[:keyCount])
+			if err != nil {
+				return err
+			}
+			s.mergeState(group, values[keyCount:])
+		}
+	}
+	return reader.Err()
+}
+
+// mergeState merges the state fields of every accumulator, laid out as in
+// the state schema, into a group
+func (s *hashAggregateStream) mergeState(group *aggregateGroup, state []any) {
+	i := 0
+	for j, acc := range group.accumulators {
+		n := len(s.exec.AggregateExpr[j].StateFields())
+		acc.Merge(state[i : i+n])
+		i += n
+	}
+}
+
+func (s *hashAggregateStream) removeSpillFile(p *aggregatePartition) {
+	if p.file == nil {
+		return
+	}
+	if p.writer != nil {
+		p.writer.Close()
+	}
+	p.file.Close()
+	os.Remove(p.file.Name())
+	p.file, p.writer = nil, nil
+}
+
+func (s *hashAggregateStream) output(groups []*aggregateGroup) RecordBatch {
+	schema := s.exec.GetSchema()
+	columns := make([][]any, len(schema.Fields()))
+	for _, group := range groups {
+		for i, k := range group.keys {
+			columns[i] = append(columns[i], k)
+		}
+		j := len(group.keys)
+		for _, acc := range group.accumulators {
+			if s.exec.Mode == AggregatePartial {
+				for _, v := range acc.State() {
+					columns[j] = append(columns[j], v)
+					j++
+				}
+				continue
+			}
+			columns[j] = append(columns[j], acc.FinalValue())
+			j++
+		}
+	}
+	fields := make([]ColumnVector, len(columns))
+	for i, values := range columns {
+		fields[i] = drogo.New(schema.Field(i).Type, len(groups), values)
+	}
+	return RecordBatch{schema, fields}
+}
+
+func (s *hashAggregateStream) Close() error {
+	for _, p := range s.partitions {
+		s.removeSpillFile(p)
+		p.groups, p.order = nil, nil
+	}
+	s.reservation.Free()
+	return s.input.Close()
+}
+
+// encodeGroupKey builds an unambiguous map key from a row's grouping values
+func encodeGroupKey(keys []any) string {
+	var sb strings.Builder
+	for _, k := range keys {
+		if k == nil {
+			sb.WriteString("N;")
+			continue
+		}
+		s := fmt.Sprint(k)
+		fmt.Fprintf(&sb, "%T:%d:%s;", k, len(s), s)
+	}
+	return sb.String()
+}
+
+// newRecord builds an arrow record from column values
+func newRecord(schema *arrow.Schema, columns [][]any, rows int) arrow.Record {
+	arrays := make([]arrow.Array, len(columns))
+	for i, values := range columns {
+		arrays[i] = drogo.New(schema.Field(i).Type, rows, values).ArrowArray()
+	}
+	return array.NewRecord(schema, arrays, int64(rows))
+}
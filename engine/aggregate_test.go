@@ -0,0 +1,173 @@
+package engine
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/apache/arrow/go/v12/arrow"
+	"github.com/briansterle/drogo"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// writeEventsCsv writes rows events for users user ids, so each user has
+// rows/users events with amounts 0..rows-1
+func writeEventsCsv(t *testing.T, rows, users int) string {
+	var sb strings.Builder
+	sb.WriteString("user,amount\n")
+	for i := 0; i < rows; i++ {
+		fmt.Fprintf(&sb, "user-%d,%d\n", i%users, i)
+	}
+	path := filepath.Join(t.TempDir(), "events.csv")
+	require.NoError(t, os.WriteFile(path, []byte(sb.String()), 0o644))
+	return path
+}
+
+func aggregateRows(t *testing.T, ctx *ExecutionContext, df DataFrame) []string {
+	stream, err := ctx.Execute(context.Background(), df)
+	require.NoError(t, err)
+	batches, err := ReadAll(stream)
+	require.NoError(t, err)
+	rows := []string{}
+	for _, batch := range batches {
+		for row := 0; row < batch.RowCount(); row++ {
+			rows = append(rows, fmt.Sprint(rowValues(batch.Fields, row)))
+		}
+	}
+	sort.Strings(rows)
+	return rows
+}
+
+func TestHashAggregateSpill(t *testing.T) {
+	path := writeEventsCsv(t, 20000, 2000)
+	tempDir := t.TempDir()
+	aggregate := func(ctx *ExecutionContext) DataFrame {
+		return ctx.Csv(path).Aggregate(
+			[]LogicalExpr{Col("user")},
+			[]AggregateExpr{Sum(Col("amount")), Min(Col("amount")), Avg(Col("amount")), Count(Col("amount"))})
+	}
+
+	unlimited := &ExecutionContext{}
+	expected := aggregateRows(t, unlimited, aggregate(unlimited))
+	require.Len(t, expected, 2000)
+
+	limited := &ExecutionContext{QueryMemoryLimit: 64 * 1024, TempDir: tempDir}
+	assert.Equal(t, expected, aggregateRows(t, limited, aggregate(limited)))
+	assert.LessOrEqual(t, limited.MemoryPool().Peak(), int64(64*1024))
+	assert.Greater(t, unlimited.MemoryPool().Peak(), int64(64*1024), "should have needed to spill")
+
+	files, err := os.ReadDir(tempDir)
+	require.NoError(t, err)
+	assert.Empty(t, files, "spill files should be removed")
+}
+
+func TestHashAggregateSpillExhausted(t *testing.T) {
+	path := writeEventsCsv(t, 1000, 1000)
+	// not even a single partition fits when merging
+	ctx := &ExecutionContext{QueryMemoryLimit: 1024, TempDir: t.TempDir()}
+	df := ctx.Csv(path).Aggregate([]LogicalExpr{Col("user")}, []AggregateExpr{Count(Col("amount"))})
+
+	stream, err := ctx.Execute(context.Background(), df)
+	require.NoError(t, err)
+	_, err = ReadAll(stream)
+	var exhausted *ResourcesExhaustedError
+	assert.ErrorAs(t, err, &exhausted)
+}
+
+func TestHashAggregateSpillWhileMerging(t *testing.T) {
+	path := writeEventsCsv(t, 20000, 4000)
+	source := NewCsvDataSource(path, Schema{}, true, 256)
+	exec := HashAggregateExec{
+		Input:         ScanExec{source, []string{}, 0, nil},
+		GroupExpr:     []Expression{ColumnExpression{0}},
+		AggregateExpr: []AggregateExpression{SumExpression{ColumnExpression{1}, drogo.Int64}, CountExpression{ColumnExpression{1}}},
+		Schema: Schema{arrow.NewSchema([]arrow.Field{
+			{Name: "user", Type: drogo.String},
+			{Name: "SUM", Type: drogo.Int64},
+			{Name: "COUNT", Type: drogo.Int64},
+		}, nil)},
+	}
+	run := func(task *TaskContext) (rows []string, spilledWhileMerging bool) {
+		s := exec.Execute(context.Background(), task, 0).(*hashAggregateStream)
+		defer s.Close()
+		// consume the input up front so that spills made while merging can
+		// be told apart from those made while aggregating
+		require.NoError(t, forEachBatch(context.Background(), s.input, s.aggregateBatch))
+		s.consumed = true
+		for {
+			before := spillFileSizes(t, s)
+			batch, err := s.Next()
+			if err == io.EOF {
+				break
+			}
+			require.NoError(t, err)
+			// partitions that have not been emitted yet were spilled again
+			// while an earlier partition was merged
+			after := spillFileSizes(t, s)
+			for i := s.emitted; i < len(s.partitions); i++ {
+				if before[i] != after[i] {
+					spilledWhileMerging = true
+				}
+			}
+			for row := 0; row < batch.RowCount(); row++ {
+				rows = append(rows, fmt.Sprint(rowValues(batch.Fields, row)))
+			}
+		}
+		sort.Strings(rows)
+		return rows, spilledWhileMerging
+	}
+
+	expected, _ := run(NewTaskContext())
+	require.Len(t, expected, 4000)
+	actual, spilledWhileMerging := run(&TaskContext{Memory: NewMemoryPool(30 * 1024), TempDir: t.TempDir()})
+	assert.True(t, spilledWhileMerging, "merging should have forced other partitions to spill")
+	assert.Equal(t, expected, actual)
+}
+
+// spillFileSizes is the size of each partition's spill file, or 0 if it has
+// none
+func spillFileSizes(t *testing.T, s *hashAggregateStream) []int64 {
+	sizes := make([]int64, len(s.partitions))
+	for i, p := range s.partitions {
+		if p.file == nil {
+			continue
+		}
+		info, err := p.file.Stat()
+		require.NoError(t, err)
+		sizes[i] = info.Size()
+	}
+	return sizes
+}
+
+func TestTwoPhaseAggregate(t *testing.T) {
+	path := writeEventsCsv(t, 10000, 7)
+	ctx := &ExecutionContext{TargetPartitions: 4, BatchSize: 100}
+	df := ctx.Csv(path).Aggregate([]LogicalExpr{Col("user")}, []AggregateExpr{Avg(Col("amount")), Count(Col("amount"))})
+
+	plan, err := QueryPlanner{4}.CreatePhysicalPlan(context.Background(), df.LogicalPlan())
+	require.NoError(t, err)
+	final := plan.(HashAggregateExec)
+	assert.Equal(t, AggregateFinal, final.Mode)
+	assert.Equal(t, 4, final.OutputPartitions())
+	partial := final.Input.(*RepartitionExec).Input.(HashAggregateExec)
+	assert.Equal(t, AggregatePartial, partial.Mode)
+	names := []string{}
+	for _, f := range partial.GetSchema().Fields() {
+		names = append(names, f.Name)
+	}
+	assert.Equal(t, []string{"user", "AVG(#1)[sum]", "AVG(#1)[count]", "COUNT(#1)[count]"}, names)
+
+	serial := &ExecutionContext{TargetPartitions: 1}
+	assert.Equal(t, aggregateRows(t, serial, df), aggregateRows(t, ctx, df))
+
+	total := ctx.Csv(path).Aggregate([]LogicalExpr{}, []AggregateExpr{Count(Col("amount")), Avg(Col("amount"))})
+	assert.Equal(t, []string{"[10000 4999.5]"}, aggregateRows(t, ctx, total))
+	none := ctx.Csv(path).Filter(Lt(Col("amount"), Int(0))).Aggregate([]LogicalExpr{}, []AggregateExpr{Count(Col("amount"))})
+	assert.Equal(t, []string{"[0]"}, aggregateRows(t, ctx, none))
+}
@@ -0,0 +1,279 @@
+package engine
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+
+	"github.com/apache/arrow/go/v12/arrow"
+	"github.com/apache/arrow/go/v12/arrow/array"
+	"github.com/apache/arrow/go/v12/arrow/ipc"
+	"github.com/briansterle/drogo"
+)
+
+// ArrowIpcDataSource reads a file in either Arrow IPC format, telling them
+// apart by the magic bytes at the start of the file format. Records are
+// handed on without copying their columns, and dictionary-encoded columns
+// are read as DictionaryVectors of their value type.
+//
+// The file format allows random access, so its record batches are dealt
+// round-robin to the source's partitions. A stream can only be read from
+// start to end and is scanned as a single partition.
+type ArrowIpcDataSource struct {
+	Filename string
+	// partitions is the most partitions the record batches are spread over
+	partitions int
+
+	loadOnce sync.Once
+	schema   Schema
+	// stream is true for the streaming format
+	stream bool
+	// records is the number of record batches in the file format
+	records   int
+	schemaErr error
+}
+
+func NewArrowIpcDataSource(filename string) *ArrowIpcDataSource {
+	return &ArrowIpcDataSource{Filename: filename, partitions: 1}
+}
+
+// WithPartitions allows the record batches of an IPC file to be scanned as up
+// to n partitions
+func (ds *ArrowIpcDataSource) WithPartitions(n int) *ArrowIpcDataSource {
+	ds.partitions = n
+	return ds
+}
+
+// isArrowFile reports whether the file starts with the magic bytes of the
+// IPC file format
+func isArrowFile(file *os.File) (bool, error) {
+	magic := make([]byte, len(ipc.Magic))
+	n, err := file.ReadAt(magic, 0)
+	if err != nil && err != io.EOF {
+		return false, err
+	}
+	return bytes.Equal(magic[:n], ipc.Magic), nil
+}
+
+func (ds *ArrowIpcDataSource) load() {
+	ds.loadOnce.Do(func() {
+		file, err := os.Open(ds.Filename)
+		if err != nil {
+			ds.schemaErr = err
+			return
+		}
+		defer file.Close()
+		isFile, err := isArrowFile(file)
+		if err != nil {
+			ds.schemaErr = err
+			return
+		}
+		if isFile {
+			reader, err := ipc.NewFileReader(file)
+			if err != nil {
+				ds.schemaErr = fmt.Errorf("%s: %w", ds.Filename, err)
+				return
+			}
+			defer reader.Close()
+			ds.schema = Schema{valueSchema(reader.Schema())}
+			ds.records = reader.NumRecords()
+			return
+		}
+		reader, err := ipc.NewReader(file)
+		if err != nil {
+			ds.schemaErr = fmt.Errorf("%s: %w", ds.Filename, err)
+			return
+		}
+		defer reader.Release()
+		ds.schema = Schema{valueSchema(reader.Schema())}
+		ds.stream = true
+	})
+}
+
+// valueSchema gives dictionary-encoded columns the type of their values.
+// They are read as DictionaryVectors.
+func valueSchema(schema *arrow.Schema) *arrow.Schema {
+	fields := append([]arrow.Field{}, schema.Fields()...)
+	for i, f := range fields {
+		if dict, ok := f.Type.(*arrow.DictionaryType); ok {
+			fields[i].Type = dict.ValueType
+		}
+	}
+	metadata := schema.Metadata()
+	return arrow.NewSchema(fields, &metadata)
+}
+
+// GetSchema returns the schema stored in the file, or an empty schema if it
+// could not be read
+func (ds *ArrowIpcDataSource) GetSchema() Schema {
+	ds.load()
+	if ds.schemaErr != nil {
+		return Schema{arrow.NewSchema(nil, nil)}
+	}
+	return ds.schema
+}
+
+// SchemaError is the error that prevented the schema from being read
+func (ds *ArrowIpcDataSource) SchemaError() error {
+	ds.load()
+	return ds.schemaErr
+}
+
+func (ds *ArrowIpcDataSource) Partitions() int {
+	ds.load()
+	if ds.stream || ds.records < 1 {
+		return 1
+	}
+	if ds.records < ds.partitions {
+		return ds.records
+	}
+	if ds.partitions < 1 {
+		return 1
+	}
+	return ds.partitions
+}
+
+func (ds *ArrowIpcDataSource) Scan(ctx context.Context, projection []string, fetch int, partition int) RecordBatchStream {
+	if err := ds.SchemaError(); err != nil {
+		return &arrowIpcStream{err: err}
+	}
+	schema := ds.schema
+	if len(projection) > 0 {
+		schema = schema.Select(projection)
+	}
+	indices := make([]int, len(schema.Fields()))
+	for i, f := range schema.Fields() {
+		if !drogoType(f.Type) {
+			return &arrowIpcStream{err: fmt.Errorf("column %s: unsupported type %s", f.Name, f.Type)}
+		}
+		indices[i] = ds.schema.FieldIndices(f.Name)[0]
+	}
+	return &arrowIpcStream{ctx: ctx, source: ds, schema: schema, indices: indices,
+		fetch: fetch, next: partition}
+}
+
+type arrowIpcStream struct {
+	ctx     context.Context
+	source  *ArrowIpcDataSource
+	schema  Schema
+	indices []int
+	fetch   int
+	read    int
+	// err is returned by Next when the stream could not be opened
+	err  error
+	file *os.File
+	// fileReader reads the file format. The partition reads every record
+	// batch starting at its own index and counting up by the number of
+	// partitions; next is the index of the next one.
+	fileReader *ipc.FileReader
+	next       int
+	// streamReader reads the streaming format
+	streamReader *ipc.Reader
+	done         bool
+}
+
+func (s *arrowIpcStream) open() error {
+	file, err := os.Open(s.source.Filename)
+	if err != nil {
+		return err
+	}
+	s.file = file
+	if s.source.stream {
+		s.streamReader, err = ipc.NewReader(file)
+	} else {
+		s.fileReader, err = ipc.NewFileReader(file)
+	}
+	if err != nil {
+		return fmt.Errorf("%s: %w", s.source.Filename, err)
+	}
+	return nil
+}
+
+// nextRecord returns the partition's next record, which the caller owns, or
+// nil at the end of the partition
+func (s *arrowIpcStream) nextRecord() (arrow.Record, error) {
+	if s.streamReader != nil {
+		if !s.streamReader.Next() {
+			return nil, s.streamReader.Err()
+		}
+		record := s.streamReader.Record()
+		record.Retain()
+		return record, nil
+	}
+	if s.next >= s.fileReader.NumRecords() {
+		return nil, nil
+	}
+	record, err := s.fileReader.RecordAt(s.next)
+	s.next += s.source.Partitions()
+	return record, err
+}
+
+func (s *arrowIpcStream) Next() (RecordBatch, error) {
+	if s.err != nil {
+		return RecordBatch{}, s.err
+	}
+	for {
+		if s.done || (s.fetch > 0 && s.read >= s.fetch) {
+			s.Close()
+			return RecordBatch{}, io.EOF
+		}
+		if err := s.ctx.Err(); err != nil {
+			s.Close()
+			return RecordBatch{}, err
+		}
+		if s.file == nil {
+			if err := s.open(); err != nil {
+				s.Close()
+				return RecordBatch{}, err
+			}
+		}
+		record, err := s.nextRecord()
+		if err != nil && err != io.EOF {
+			s.Close()
+			return RecordBatch{}, err
+		}
+		if record == nil {
+			s.Close()
+			return RecordBatch{}, io.EOF
+		}
+		if record.NumRows() == 0 {
+			continue
+		}
+		rows := int(record.NumRows())
+		if s.fetch > 0 && s.fetch-s.read < rows {
+			rows = s.fetch - s.read
+			record = record.NewSlice(0, int64(rows))
+		}
+		s.read += rows
+		fields := make([]ColumnVector, len(s.indices))
+		for i, idx := range s.indices {
+			if dict, ok := record.Column(idx).(*array.Dictionary); ok {
+				fields[i] = dictionaryFromArrow(dict)
+			} else {
+				fields[i] = drogo.FromArrow(record.Column(idx))
+			}
+		}
+		return RecordBatch{s.schema, fields}, nil
+	}
+}
+
+func (s *arrowIpcStream) Close() error {
+	s.done = true
+	if s.streamReader != nil {
+		s.streamReader.Release()
+		s.streamReader = nil
+	}
+	if s.fileReader != nil {
+		s.fileReader.Close()
+		s.fileReader = nil
+	}
+	if s.file == nil {
+		return nil
+	}
+	err := s.file.Close()
+	s.file = nil
+	return err
+}
@@ -0,0 +1,64 @@
+package engine
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// writeEventsArrow writes the events CSV to an Arrow IPC file made of many
+// record batches
+func writeEventsArrow(t *testing.T, rows int, stream bool) string {
+	ctx := &ExecutionContext{BatchSize: 100, TargetPartitions: 1}
+	path := filepath.Join(t.TempDir(), "events.arrow")
+	df := ctx.Csv(writeEventsCsv(t, rows, 10))
+	require.NoError(t, df.WriteArrow(context.Background(), path, ArrowWriteOptions{Stream: stream, Compression: "zstd"}))
+	return path
+}
+
+func TestArrowIpcFile(t *testing.T) {
+	source := NewArrowIpcDataSource(writeEventsArrow(t, 1000, false)).WithPartitions(3)
+	require.NoError(t, source.SchemaError())
+	assert.False(t, source.stream)
+	assert.Equal(t, 10, source.records)
+	assert.Equal(t, 3, source.Partitions())
+
+	all := []RecordBatch{}
+	for p := 0; p < source.Partitions(); p++ {
+		batches, err := ReadAll(source.Scan(context.Background(), []string{"amount"}, 0, p))
+		require.NoError(t, err)
+		assert.NotEmpty(t, batches)
+		all = append(all, batches...)
+	}
+	assert.Equal(t, 1, all[0].ColumnCount())
+	assert.Equal(t, sequence(1000), int64Column(all, 0))
+}
+
+func TestArrowIpcStream(t *testing.T) {
+	path := writeEventsArrow(t, 1000, true)
+	ctx := &ExecutionContext{TargetPartitions: 4}
+	df := ctx.Arrow(path)
+	source := df.LogicalPlan().(Scan).Source
+	assert.Equal(t, 1, source.Partitions())
+
+	batches, err := df.Filter(Eq(Col("user"), Str("user-3"))).
+		Project([]LogicalExpr{Col("amount")}).
+		Collect(context.Background())
+	require.NoError(t, err)
+	assert.Len(t, int64Column(batches, 0), 100)
+
+	batches, err = ReadAll(source.Scan(context.Background(), nil, 250, 0))
+	require.NoError(t, err)
+	assert.Equal(t, sequence(250), int64Column(batches, 1))
+}
+
+func TestArrowIpcInvalidFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "bad.arrow")
+	require.NoError(t, os.WriteFile(path, []byte("not arrow"), 0o644))
+	_, err := (&ExecutionContext{}).Arrow(path).Collect(context.Background())
+	assert.Error(t, err)
+}
@@ -0,0 +1,134 @@
+package engine
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/golang/snappy"
+	"github.com/klauspost/compress/zstd"
+)
+
+// inputCodec decompresses one format of compressed input file
+type inputCodec struct {
+	name string
+	// magic starts every file in the format
+	magic []byte
+	// extensions name files in the format whose magic is not recognised
+	extensions []string
+	open       func(r io.Reader) (io.Reader, io.Closer, error)
+}
+
+var inputCodecs = []inputCodec{
+	{"gzip", []byte{0x1f, 0x8b}, []string{".gz", ".gzip"}, func(r io.Reader) (io.Reader, io.Closer, error) {
+		gz, err := gzip.NewReader(r)
+		return gz, gz, err
+	}},
+	// the decoder decompresses blocks ahead of the reader on up to
+	// GOMAXPROCS goroutines
+	{"zstd", []byte{0x28, 0xb5, 0x2f, 0xfd}, []string{".zst", ".zstd"}, func(r io.Reader) (io.Reader, io.Closer, error) {
+		dec, err := zstd.NewReader(r, zstd.WithDecoderConcurrency(0))
+		if err != nil {
+			return nil, nil, err
+		}
+		return dec, dec.IOReadCloser(), nil
+	}},
+	// snappy files use the framing format, as the block format cannot be
+	// decompressed as a stream
+	{"snappy", []byte("\xff\x06\x00\x00sNaPpY"), []string{".sz", ".snappy"}, func(r io.Reader) (io.Reader, io.Closer, error) {
+		return snappy.NewReader(r), nil, nil
+	}},
+}
+
+// detectCodec picks the codec of a file from its first bytes, falling back to
+// its extension. It returns nil for uncompressed files.
+func detectCodec(filename string, head []byte) *inputCodec {
+	for i, codec := range inputCodecs {
+		if bytes.HasPrefix(head, codec.magic) {
+			return &inputCodecs[i]
+		}
+	}
+	for i, codec := range inputCodecs {
+		if hasExtension(filename, codec.extensions) {
+			return &inputCodecs[i]
+		}
+	}
+	return nil
+}
+
+// trimCompression removes a compression extension from a file name, so that
+// events.csv.gz is listed as a CSV file
+func trimCompression(filename string) string {
+	for _, codec := range inputCodecs {
+		for _, ext := range codec.extensions {
+			if strings.HasSuffix(filename, ext) {
+				return strings.TrimSuffix(filename, ext)
+			}
+		}
+	}
+	return filename
+}
+
+// peekCodec opens a file and detects its compression
+func peekCodec(filename string) (*os.File, *bufio.Reader, *inputCodec, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	buffered := bufio.NewReader(file)
+	head, err := buffered.Peek(16)
+	if err != nil && !errors.Is(err, io.EOF) {
+		file.Close()
+		return nil, nil, nil, err
+	}
+	return file, buffered, detectCodec(filename, head), nil
+}
+
+// isCompressed reports whether a file is read through a codec. Unreadable
+// files are left to fail when they are opened.
+func isCompressed(filename string) bool {
+	file, _, codec, err := peekCodec(filename)
+	if err != nil {
+		return false
+	}
+	file.Close()
+	return codec != nil
+}
+
+// openInput opens a file for reading, decompressing it as it is read if it is
+// gzip, zstd or snappy compressed
+func openInput(filename string) (io.ReadCloser, error) {
+	file, buffered, codec, err := peekCodec(filename)
+	if err != nil {
+		return nil, err
+	}
+	if codec == nil {
+		return readCloser{buffered, file, nil}, nil
+	}
+	r, closer, err := codec.open(buffered)
+	if err != nil {
+		file.Close()
+		return nil, fmt.Errorf("%s: %s: %w", filename, codec.name, err)
+	}
+	return readCloser{r, file, closer}, nil
+}
+
+// readCloser reads from a wrapper of a file and closes both
+type readCloser struct {
+	io.Reader
+	file *os.File
+	// codec is the decompressor, if it needs closing
+	codec io.Closer
+}
+
+func (r readCloser) Close() error {
+	if r.codec != nil {
+		r.codec.Close()
+	}
+	return r.file.Close()
+}
@@ -0,0 +1,100 @@
+package engine
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/golang/snappy"
+	"github.com/klauspost/compress/zstd"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// writeCompressed writes data to a file in a temporary directory compressed by codec
+func writeCompressed(t *testing.T, name, codec string, data string) string {
+	var buf bytes.Buffer
+	var w io.WriteCloser
+	switch codec {
+	case "gzip":
+		w = gzip.NewWriter(&buf)
+	case "zstd":
+		var err error
+		w, err = zstd.NewWriter(&buf)
+		require.NoError(t, err)
+	case "snappy":
+		w = snappy.NewBufferedWriter(&buf)
+	}
+	_, err := w.Write([]byte(data))
+	require.NoError(t, err)
+	require.NoError(t, w.Close())
+	path := filepath.Join(t.TempDir(), name)
+	require.NoError(t, os.WriteFile(path, buf.Bytes(), 0o644))
+	return path
+}
+
+func TestDetectCodec(t *testing.T) {
+	assert.Equal(t, "gzip", detectCodec("events.csv", []byte{0x1f, 0x8b, 8}).name)
+	assert.Equal(t, "zstd", detectCodec("events.csv.zst", nil).name)
+	assert.Equal(t, "snappy", detectCodec("events", []byte("\xff\x06\x00\x00sNaPpY")).name)
+	assert.Nil(t, detectCodec("events.csv", []byte("user,amount")))
+	assert.Equal(t, "events.json", trimCompression("events.json.gz"))
+}
+
+func TestCompressedCsv(t *testing.T) {
+	var sb strings.Builder
+	sb.WriteString("user,amount\n")
+	for i := 0; i < 5000; i++ {
+		fmt.Fprintf(&sb, "user-%d,%d\n", i%10, i)
+	}
+	for _, codec := range []string{"gzip", "zstd", "snappy"} {
+		t.Run(codec, func(t *testing.T) {
+			// the name does not give the compression away
+			path := writeCompressed(t, "events.csv", codec, sb.String())
+			source := NewCsvDataSource(path, Schema{}, true, 1000).WithPartitions(4)
+			source.minPartitionBytes = 1
+			require.NoError(t, source.SchemaError())
+			assert.Equal(t, 1, source.Partitions())
+
+			ctx := &ExecutionContext{TargetPartitions: 4}
+			batches, err := ctx.Scan(path, source).
+				Filter(Eq(Col("user"), Str("user-3"))).
+				Project([]LogicalExpr{Col("amount")}).
+				Collect(context.Background())
+			require.NoError(t, err)
+			assert.Len(t, int64Column(batches, 0), 500)
+		})
+	}
+}
+
+func TestCompressedJson(t *testing.T) {
+	path := writeCompressed(t, "logs.json.zst", "zstd", logLines)
+	batches, err := (&ExecutionContext{}).Json(path).Collect(context.Background())
+	require.NoError(t, err)
+	assert.NotEmpty(t, batches)
+
+	bad := filepath.Join(t.TempDir(), "bad.json.gz")
+	require.NoError(t, os.WriteFile(bad, []byte("{}\n"), 0o644))
+	_, err = (&ExecutionContext{}).Json(bad).Collect(context.Background())
+	assert.ErrorContains(t, err, "gzip")
+}
+
+func TestCompressedListing(t *testing.T) {
+	dir := t.TempDir()
+	for i, codec := range []string{"gzip", "zstd", "snappy"} {
+		path := writeCompressed(t, "part.csv", codec, fmt.Sprintf("n\n%d\n", i))
+		data, err := os.ReadFile(path)
+		require.NoError(t, err)
+		name := fmt.Sprintf("part-%d.csv%s", i, []string{".gz", ".zst", ".sz"}[i])
+		require.NoError(t, os.WriteFile(filepath.Join(dir, name), data, 0o644))
+	}
+	batches, err := (&ExecutionContext{}).Csv(dir).Collect(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, []int64{0, 1, 2}, int64Column(batches, 0))
+}
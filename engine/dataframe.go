@@ -1,33 +1,75 @@
 package engine
 
 import (
+	"context"
+	"database/sql"
+	"errors"
 	"fmt"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+	"time"
 
 	"github.com/apache/arrow/go/v12/arrow"
+	"github.com/briansterle/drogo"
 )
 
 type DataFrame interface {
 	Project(expr []LogicalExpr) DataFrame
 	Filter(expr LogicalExpr) DataFrame
 	Aggregate(groupBy []LogicalExpr, aggregateExpr []AggregateExpr) DataFrame
+	Sort(expr []SortExpr) DataFrame
+	Limit(n int) DataFrame
+	Offset(n int) DataFrame
+	// Unnest expands a list column into a row for each of its elements
+	Unnest(column string) DataFrame
 	Schema() Schema
 	LogicalPlan() LogicalPlan
+	// Collect executes the DataFrame in the ExecutionContext it was created
+	// from and returns all of its results
+	Collect(ctx context.Context) ([]RecordBatch, error)
+	// WriteCsv, WriteJson, WriteParquet and WriteArrow execute the DataFrame
+	// and write its results to path, or to a directory of Hive-style
+	// partitions under path when the options name columns to partition by
+	WriteCsv(ctx context.Context, path string, options CsvWriteOptions) error
+	WriteJson(ctx context.Context, path string, options JsonWriteOptions) error
+	WriteParquet(ctx context.Context, path string, options ParquetWriteOptions) error
+	WriteArrow(ctx context.Context, path string, options ArrowWriteOptions) error
 }
 
 type DataFrameImpl struct {
+	ec   *ExecutionContext
 	plan LogicalPlan
 }
 
 func (df *DataFrameImpl) Project(expr []LogicalExpr) DataFrame {
-	return &DataFrameImpl{Projection{df.plan, expr}}
+	return &DataFrameImpl{df.ec, Projection{df.plan, expr}}
 }
 
 func (df *DataFrameImpl) Filter(expr LogicalExpr) DataFrame {
-	return &DataFrameImpl{Selection{df.plan, expr}}
+	return &DataFrameImpl{df.ec, Selection{df.plan, expr}}
 }
 
 func (df *DataFrameImpl) Aggregate(groupBy []LogicalExpr, aggregateExpr []AggregateExpr) DataFrame {
-	return &DataFrameImpl{Aggregate{df.plan, groupBy, aggregateExpr}}
+	return &DataFrameImpl{df.ec, Aggregate{df.plan, groupBy, aggregateExpr}}
+}
+
+func (df *DataFrameImpl) Sort(expr []SortExpr) DataFrame {
+	return &DataFrameImpl{df.ec, Sort{Input: df.plan, Expr: expr}}
+}
+
+func (df *DataFrameImpl) Limit(n int) DataFrame {
+	return &DataFrameImpl{df.ec, Limit{df.plan, 0, n}}
+}
+
+func (df *DataFrameImpl) Offset(n int) DataFrame {
+	return &DataFrameImpl{df.ec, Limit{df.plan, n, -1}}
+}
+
+func (df *DataFrameImpl) Unnest(column string) DataFrame {
+	return &DataFrameImpl{df.ec, Unnest{df.plan, column}}
 }
 
 func (df *DataFrameImpl) Schema() Schema {
@@ -38,10 +80,324 @@ func (df *DataFrameImpl) LogicalPlan() LogicalPlan {
 	return df.plan
 }
 
-type ExecutionContext struct{}
+type ExecutionContext struct {
+	// BatchSize is the number of rows data sources read at a time. Zero means
+	// the default of 1024.
+	BatchSize int
+	// TargetPartitions is the number of partitions queries are split into so
+	// they can use several cores. Zero means runtime.GOMAXPROCS.
+	TargetPartitions int
+	// MemoryLimit caps the memory in bytes reserved by all queries running in
+	// this context. Zero means no limit.
+	MemoryLimit int64
+	// QueryMemoryLimit caps the memory in bytes reserved by a single query.
+	// Zero means a query is only bound by MemoryLimit.
+	QueryMemoryLimit int64
+	// TempDir is where operators that exceed their memory spill to disk.
+	// Empty means the system default.
+	TempDir string
+	// QueryTimeout cancels a query that is still running after this long.
+	// Zero means no timeout.
+	QueryTimeout time.Duration
+
+	poolOnce sync.Once
+	pool     *MemoryPool
+
+	tablesMu sync.Mutex
+	tables   map[string]DataSource
+
+	functionsMu sync.Mutex
+	functions   map[string]*ScalarUDF
+	aggregates  map[string]*AggregateUDF
+	// tableFunctions holds registered table functions
+	tableFunctions map[string]TableFunction
+}
+
+// MemoryPool returns the session-level pool that every query's memory is
+// reserved from
+func (ec *ExecutionContext) MemoryPool() *MemoryPool {
+	ec.poolOnce.Do(func() {
+		ec.pool = NewMemoryPool(ec.MemoryLimit)
+	})
+	return ec.pool
+}
 
+// Csv reads a CSV file with a header row, or every CSV file under a directory
+// or matched by a glob pattern
 func (ec *ExecutionContext) Csv(filename string) DataFrame {
-	return &DataFrameImpl{Scan{filename, &CsvDataSource{Filename: filename}, []string{}}}
+	return ec.Scan(filename, ec.fileSource(filename, "csv"))
+}
+
+// Scan returns a DataFrame that reads every column of a data source. The
+// name identifies the source in query plans.
+func (ec *ExecutionContext) Scan(name string, source DataSource) DataFrame {
+	return &DataFrameImpl{ec, Scan{Path: name, Source: source, Projection: []string{}}}
+}
+
+// Json reads a file of newline-delimited JSON objects, inferring its schema,
+// or every such file under a directory or matched by a glob pattern
+func (ec *ExecutionContext) Json(filename string) DataFrame {
+	return ec.Scan(filename, ec.fileSource(filename, "json"))
+}
+
+// Arrow reads a file in the Arrow IPC file or streaming format, or every such
+// file under a directory or matched by a glob pattern
+func (ec *ExecutionContext) Arrow(filename string) DataFrame {
+	return ec.Scan(filename, ec.fileSource(filename, "arrow"))
+}
+
+// Parquet reads one or more Parquet files with the same schema, or every
+// Parquet file under a directory or matched by a glob pattern
+func (ec *ExecutionContext) Parquet(filenames ...string) DataFrame {
+	return ec.Scan(strings.Join(filenames, ","), ec.parquet(filenames))
+}
+
+func (ec *ExecutionContext) parquet(filenames []string) DataSource {
+	if len(filenames) == 1 && isListing(filenames[0]) {
+		return ec.listing(filenames[0], "parquet")
+	}
+	return NewParquetDataSource(filenames, ec.BatchSize).WithPartitions(ec.targetPartitions())
+}
+
+func (ec *ExecutionContext) listing(path, format string) *ListingTable {
+	return NewListingTable(path, format, ec.BatchSize).WithPartitions(ec.targetPartitions())
+}
+
+// fileSource returns a source for a file, directory or glob pattern in one of
+// the formats of fileFormats
+func (ec *ExecutionContext) fileSource(path, format string) DataSource {
+	if isListing(path) {
+		return ec.listing(path, format)
+	}
+	switch format {
+	case "csv":
+		return NewCsvDataSource(path, Schema{}, true, ec.BatchSize).WithPartitions(ec.targetPartitions())
+	case "json":
+		return NewJsonDataSource(path, Schema{}, ec.BatchSize)
+	case "arrow":
+		return NewArrowIpcDataSource(path).WithPartitions(ec.targetPartitions())
+	default:
+		return ec.parquet([]string{path})
+	}
+}
+
+// fileFormatOf names the format of a file, or of the files a glob pattern
+// matches, from its extension. It returns "" if the extension is unknown.
+func fileFormatOf(path string) string {
+	ext := filepath.Ext(trimCompression(path))
+	for name, format := range fileFormats {
+		if hasExtension(ext, format.extensions) {
+			return name
+		}
+	}
+	return ""
+}
+
+// RegisterDataSource makes source available as the table name, replacing any
+// table already registered under that name
+func (ec *ExecutionContext) RegisterDataSource(name string, source DataSource) {
+	ec.tablesMu.Lock()
+	defer ec.tablesMu.Unlock()
+	if ec.tables == nil {
+		ec.tables = map[string]DataSource{}
+	}
+	ec.tables[name] = source
+}
+
+// RegisterParquet registers Parquet files, or a directory or glob pattern of
+// them, as the table name. It fails if the files' metadata cannot be read or
+// their schemas differ.
+func (ec *ExecutionContext) RegisterParquet(name string, filenames ...string) error {
+	source := ec.parquet(filenames)
+	if err := source.(schemaLoader).SchemaError(); err != nil {
+		return err
+	}
+	ec.RegisterDataSource(name, source)
+	return nil
+}
+
+// RegisterFile registers a csv, json, parquet or arrow file, or a directory
+// or glob pattern of them, as the table name. The format is taken from the
+// extension if it is empty. It fails if the files' schema cannot be read.
+func (ec *ExecutionContext) RegisterFile(name, path, format string) error {
+	if format == "" {
+		if format = fileFormatOf(path); format == "" {
+			return fmt.Errorf("cannot tell the format of %s", path)
+		}
+	}
+	if _, ok := fileFormats[format]; !ok {
+		return fmt.Errorf("unknown file format '%s'", format)
+	}
+	source := ec.fileSource(path, format)
+	if loader, ok := source.(schemaLoader); ok {
+		if err := loader.SchemaError(); err != nil {
+			return err
+		}
+	}
+	ec.RegisterDataSource(name, source)
+	return nil
+}
+
+// RegisterSqlTable registers a table of a database as the table name. It
+// fails if the table's columns cannot be read.
+func (ec *ExecutionContext) RegisterSqlTable(name string, db *sql.DB, dialect SqlDialect, table string) error {
+	source := NewSqlDataSource(db, dialect, table, ec.BatchSize)
+	if err := source.SchemaError(); err != nil {
+		return err
+	}
+	ec.RegisterDataSource(name, source)
+	return nil
+}
+
+// Table returns a DataFrame that scans a registered table
+func (ec *ExecutionContext) Table(name string) (DataFrame, error) {
+	ec.tablesMu.Lock()
+	source, ok := ec.tables[name]
+	ec.tablesMu.Unlock()
+	if !ok {
+		return nil, fmt.Errorf("table '%s' not found", name)
+	}
+	return ec.Scan(name, source), nil
+}
+
+// TableNames lists the registered tables in order
+func (ec *ExecutionContext) TableNames() []string {
+	ec.tablesMu.Lock()
+	defer ec.tablesMu.Unlock()
+	names := make([]string, 0, len(ec.tables))
+	for name := range ec.tables {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func (ec *ExecutionContext) targetPartitions() int {
+	if ec.TargetPartitions <= 0 {
+		return runtime.GOMAXPROCS(0)
+	}
+	return ec.TargetPartitions
+}
+
+// Execute optimizes the DataFrame's logical plan, plans it and starts
+// executing it. The caller must drain or Close the returned stream.
+//
+// The query is cancelled when ctx is done or QueryTimeout has passed, after
+// which the stream returns the context's error. Closing the stream stops any
+// goroutines the query started and removes its temporary files.
+func (ec *ExecutionContext) Execute(ctx context.Context, df DataFrame) (RecordBatchStream, error) {
+	ctx, cancel := ec.queryContext(ctx)
+	_, physicalPlan, err := ec.plan(ctx, df)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	return ec.execute(ctx, cancel, physicalPlan), nil
+}
+
+// FromLogicalPlan returns a DataFrame of a logical plan built elsewhere, such
+// as one read back by a PlanCodec
+func (ec *ExecutionContext) FromLogicalPlan(plan LogicalPlan) DataFrame {
+	return &DataFrameImpl{ec, plan}
+}
+
+// PhysicalPlan optimizes and plans a DataFrame without executing it. The plan
+// produces its results as a single partition.
+func (ec *ExecutionContext) PhysicalPlan(ctx context.Context, df DataFrame) (PhysicalPlan, error) {
+	_, physicalPlan, err := ec.plan(ctx, df)
+	return physicalPlan, err
+}
+
+// ExecutePhysicalPlan starts executing a physical plan, as Execute does for
+// a DataFrame. The partitions of a plan with several are merged.
+func (ec *ExecutionContext) ExecutePhysicalPlan(ctx context.Context, plan PhysicalPlan) RecordBatchStream {
+	ctx, cancel := ec.queryContext(ctx)
+	return ec.execute(ctx, cancel, coalesce(plan))
+}
+
+// queryContext returns the context a query runs in, which is cancelled after
+// QueryTimeout
+func (ec *ExecutionContext) queryContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	if ec.QueryTimeout > 0 {
+		return context.WithTimeout(ctx, ec.QueryTimeout)
+	}
+	return context.WithCancel(ctx)
+}
+
+// plan optimizes the DataFrame's logical plan and creates the physical plan
+// that produces its results as a single partition
+func (ec *ExecutionContext) plan(ctx context.Context, df DataFrame) (LogicalPlan, PhysicalPlan, error) {
+	plan := NewOptimizer().Optimize(df.LogicalPlan())
+	physicalPlan, err := QueryPlanner{ec.targetPartitions()}.CreatePhysicalPlan(ctx, plan)
+	if err != nil {
+		return nil, nil, err
+	}
+	return plan, coalesce(physicalPlan), nil
+}
+
+func (ec *ExecutionContext) execute(ctx context.Context, cancel context.CancelFunc, plan PhysicalPlan) RecordBatchStream {
+	task := &TaskContext{
+		Memory:  ec.MemoryPool().NewChild(ec.QueryMemoryLimit),
+		TempDir: ec.TempDir,
+	}
+	return &queryStream{plan.Execute(ctx, task, 0), cancel}
+}
+
+// Explain describes how a DataFrame is executed, as rows of a plan_type and
+// a plan: the logical plan, the plan the optimizer turns it into and the
+// physical plan. With analyze set the query is run and its results thrown
+// away, and the physical plan is shown with the metrics of every operator.
+func (ec *ExecutionContext) Explain(ctx context.Context, df DataFrame, analyze bool) (DataFrame, error) {
+	ctx, cancel := ec.queryContext(ctx)
+	optimized, physicalPlan, err := ec.plan(ctx, df)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	rows := [][]any{}
+	if analyze {
+		stream := ec.execute(ctx, cancel, physicalPlan)
+		err := forEachBatch(ctx, stream, func(RecordBatch) error { return nil })
+		stream.Close()
+		if err != nil {
+			return nil, err
+		}
+		rows = append(rows, []any{"physical_plan_with_metrics", FormatMetrics(physicalPlan, 0)})
+	} else {
+		cancel()
+		rows = append(rows,
+			[]any{"logical_plan", Format(df.LogicalPlan(), 0)},
+			[]any{"optimized_logical_plan", Format(optimized, 0)},
+			[]any{"physical_plan", FormatPhysical(physicalPlan, 0)},
+		)
+	}
+	return ec.values([]arrow.Field{
+		{Name: "plan_type", Type: drogo.String},
+		{Name: "plan", Type: drogo.String},
+	}, rows)
+}
+
+// queryStream releases the query's context when it is closed
+type queryStream struct {
+	RecordBatchStream
+	cancel context.CancelFunc
+}
+
+func (s *queryStream) Close() error {
+	err := s.RecordBatchStream.Close()
+	s.cancel()
+	return err
+}
+
+func (df *DataFrameImpl) Collect(ctx context.Context) ([]RecordBatch, error) {
+	if df.ec == nil {
+		return nil, errors.New("DataFrame was not created by an ExecutionContext")
+	}
+	stream, err := df.ec.Execute(ctx, df)
+	if err != nil {
+		return nil, err
+	}
+	return ReadAll(stream)
 }
 
 func Col(name string) Column {
@@ -0,0 +1,134 @@
+package engine
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/apache/arrow/go/v12/arrow"
+	"github.com/briansterle/drogo"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// endlessSource produces batches of distinct users until it is closed, and
+// never checks its context so that the operators above it have to
+type endlessSource struct {
+	partitions int
+	open       atomic.Int32
+}
+
+func (s *endlessSource) GetSchema() Schema {
+	return Schema{arrow.NewSchema([]arrow.Field{
+		{Name: "user", Type: drogo.String},
+		{Name: "amount", Type: drogo.Int64},
+	}, nil)}
+}
+
+func (s *endlessSource) Partitions() int {
+	return s.partitions
+}
+
+func (s *endlessSource) Scan(ctx context.Context, projection []string, fetch int, partition int) RecordBatchStream {
+	s.open.Add(1)
+	return &endlessStream{source: s, partition: partition}
+}
+
+type endlessStream struct {
+	source    *endlessSource
+	partition int
+	next      int
+	closed    bool
+}
+
+func (s *endlessStream) Next() (RecordBatch, error) {
+	users := make([]any, 100)
+	amounts := make([]any, 100)
+	for i := range users {
+		users[i] = fmt.Sprintf("user-%d-%d", s.partition, s.next)
+		amounts[i] = int64(s.next)
+		s.next++
+	}
+	return RecordBatch{s.source.GetSchema(), []ColumnVector{
+		drogo.New(drogo.String, len(users), users),
+		drogo.New(drogo.Int64, len(amounts), amounts),
+	}}, nil
+}
+
+func (s *endlessStream) Close() error {
+	if !s.closed {
+		s.closed = true
+		s.source.open.Add(-1)
+	}
+	return nil
+}
+
+func endlessAggregate(ec *ExecutionContext, source *endlessSource) DataFrame {
+	df := &DataFrameImpl{ec, Scan{Path: "endless", Source: source, Projection: []string{}}}
+	return df.Filter(Gt(Col("amount"), Int(-1))).
+		Aggregate([]LogicalExpr{Col("user")}, []AggregateExpr{Sum(Col("amount"))})
+}
+
+func TestCollect(t *testing.T) {
+	ec := &ExecutionContext{}
+	batches, err := ec.Csv("testdata/employees.csv").
+		Filter(Eq(Col("state"), Str("CO"))).
+		Project([]LogicalExpr{Col("id")}).
+		Collect(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, []any{int64(2), int64(3)}, column(batches, 0))
+
+	_, err = (&DataFrameImpl{plan: Scan{}}).Collect(context.Background())
+	assert.Error(t, err)
+}
+
+func TestCancelQuery(t *testing.T) {
+	tempDir := t.TempDir()
+	// the aggregate spills while it runs, so cancelling must remove its files
+	ec := &ExecutionContext{TargetPartitions: 4, QueryMemoryLimit: 256 * 1024, TempDir: tempDir}
+	source := &endlessSource{partitions: 2}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() {
+		_, err := endlessAggregate(ec, source).Collect(ctx)
+		done <- err
+	}()
+	require.Eventually(t, func() bool {
+		files, _ := os.ReadDir(tempDir)
+		return len(files) > 0
+	}, 5*time.Second, time.Millisecond, "should spill before being cancelled")
+	cancel()
+
+	select {
+	case err := <-done:
+		assert.ErrorIs(t, err, context.Canceled)
+	case <-time.After(5 * time.Second):
+		t.Fatal("query did not stop after being cancelled")
+	}
+	assert.Eventually(t, func() bool { return source.open.Load() == 0 }, 5*time.Second, time.Millisecond,
+		"every scan should be closed")
+	files, err := os.ReadDir(tempDir)
+	require.NoError(t, err)
+	assert.Empty(t, files, "spill files should be removed")
+	assert.Zero(t, ec.MemoryPool().Reserved())
+}
+
+func TestQueryTimeout(t *testing.T) {
+	ec := &ExecutionContext{TargetPartitions: 2, QueryTimeout: 50 * time.Millisecond}
+	source := &endlessSource{partitions: 1}
+	_, err := endlessAggregate(ec, source).Collect(context.Background())
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	assert.Eventually(t, func() bool { return source.open.Load() == 0 }, 5*time.Second, time.Millisecond)
+}
+
+func TestCancelBeforePlanning(t *testing.T) {
+	ec := &ExecutionContext{}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err := ec.Execute(ctx, ec.Csv("testdata/employees.csv"))
+	assert.ErrorIs(t, err, context.Canceled)
+}
@@ -1,16 +1,416 @@
 package engine
 
+import (
+	"bufio"
+	"context"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"sync"
+
+	"github.com/apache/arrow/go/v12/arrow"
+	"github.com/briansterle/drogo"
+)
+
+const defaultBatchSize = 1024
+
+// csvMinPartitionBytes is the smallest byte range a CSV file is split into,
+// so that small files are not scanned by many near-empty partitions
+const csvMinPartitionBytes = 1 << 20
+
+// CsvDataSource reads a CSV file. The file can be split into byte ranges that
+// are scanned as separate partitions; each range starts at the first line
+// that begins inside it. A line break inside a quoted value would make a
+// range start mid-record, so files containing one are scanned as a single
+// partition, as are compressed files, which are decompressed as they are
+// read.
 type CsvDataSource struct {
 	Filename   string
 	Schema     Schema
 	hasHeaders bool
 	batchSize  int
+	// partitions is the number of byte ranges the file may be split into
+	partitions        int
+	minPartitionBytes int64
+	// schemaErr is set when the schema could not be inferred
+	schemaErr error
+
+	splitOnce sync.Once
+	// splittable is false when the file is compressed or a quoted value
+	// spans lines
+	splittable bool
 }
 
+func NewCsvDataSource(filename string, schema Schema, hasHeaders bool, batchSize int) *CsvDataSource {
+	return &CsvDataSource{Filename: filename, Schema: schema, hasHeaders: hasHeaders, batchSize: batchSize,
+		partitions: 1, minPartitionBytes: csvMinPartitionBytes}
+}
+
+// WithPartitions allows the file to be scanned as up to n partitions
+func (ds *CsvDataSource) WithPartitions(n int) *CsvDataSource {
+	ds.partitions = n
+	return ds
+}
+
+// GetSchema returns the schema the source was created with, or infers one
+// from the file if none was given. Column names come from the header row (or
+// field_N without headers) and types from the first batch of rows.
+//
+// If the schema cannot be inferred, such as when the file does not exist, an
+// empty schema is returned and the error is reported by SchemaError and by
+// scans of the source.
 func (ds *CsvDataSource) GetSchema() Schema {
+	if ds.Schema.Schema == nil && ds.schemaErr == nil {
+		schema, err := ds.inferSchema()
+		if err != nil {
+			ds.schemaErr = err
+		} else {
+			ds.Schema = schema
+		}
+	}
+	if ds.schemaErr != nil {
+		return Schema{arrow.NewSchema(nil, nil)}
+	}
 	return ds.Schema
 }
 
-func (ds *CsvDataSource) Scan(projection []string) []RecordBatch {
-	return []RecordBatch{{ds.Schema, []ColumnVector{}}}
+// SchemaError is the error that prevented the schema from being inferred
+func (ds *CsvDataSource) SchemaError() error {
+	ds.GetSchema()
+	return ds.schemaErr
+}
+
+func (ds *CsvDataSource) inferSchema() (Schema, error) {
+	input, err := openInput(ds.Filename)
+	if err != nil {
+		return Schema{}, err
+	}
+	defer input.Close()
+	reader := csv.NewReader(input)
+
+	first, err := reader.Read()
+	if err != nil {
+		return Schema{}, fmt.Errorf("cannot infer schema of %s: %w", ds.Filename, err)
+	}
+	names := make([]string, len(first))
+	rows := [][]string{}
+	if ds.hasHeaders {
+		copy(names, first)
+	} else {
+		for i := range names {
+			names[i] = fmt.Sprintf("field_%d", i+1)
+		}
+		rows = append(rows, first)
+	}
+	for len(rows) < ds.getBatchSize() {
+		row, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return Schema{}, err
+		}
+		rows = append(rows, row)
+	}
+
+	fields := make([]arrow.Field, len(names))
+	for i, name := range names {
+		values := make([]string, len(rows))
+		for j, row := range rows {
+			values[j] = row[i]
+		}
+		fields[i] = arrow.Field{Name: name, Type: inferType(values), Nullable: true}
+	}
+	return Schema{arrow.NewSchema(fields, nil)}, nil
+}
+
+// inferType picks the narrowest of bool, int64, float64 and string that can
+// represent every non-empty value
+func inferType(values []string) arrow.DataType {
+	seen, isBool, isInt, isFloat := false, true, true, true
+	for _, v := range values {
+		if v == "" {
+			continue
+		}
+		seen = true
+		if _, err := strconv.ParseBool(v); err != nil || (v != "true" && v != "false") {
+			isBool = false
+		}
+		if _, err := strconv.ParseInt(v, 10, 64); err != nil {
+			isInt = false
+		}
+		if _, err := strconv.ParseFloat(v, 64); err != nil {
+			isFloat = false
+		}
+	}
+	switch {
+	case !seen:
+		return drogo.String
+	case isBool:
+		return drogo.Boolean
+	case isInt:
+		return drogo.Int64
+	case isFloat:
+		return drogo.Float64
+	default:
+		return drogo.String
+	}
+}
+
+func (ds *CsvDataSource) getBatchSize() int {
+	if ds.batchSize <= 0 {
+		return defaultBatchSize
+	}
+	return ds.batchSize
+}
+
+// Partitions is the configured partition count, reduced so that no partition
+// is smaller than the minimum byte range
+func (ds *CsvDataSource) Partitions() int {
+	n := ds.partitions
+	info, err := os.Stat(ds.Filename)
+	if err != nil || n < 1 {
+		return 1
+	}
+	if max := int(info.Size() / ds.minPartitionBytes); n > max {
+		n = max
+	}
+	if n <= 1 {
+		return 1
+	}
+	ds.splitOnce.Do(func() {
+		ds.splittable = !isCompressed(ds.Filename) && !hasQuotedNewline(ds.Filename)
+	})
+	if !ds.splittable {
+		return 1
+	}
+	return n
+}
+
+// hasQuotedNewline reports whether a quoted value in the file contains a line
+// break. Escaped quotes toggle the quoted state twice, so they need no special
+// handling. Unreadable files are left to fail when scanned.
+func hasQuotedNewline(filename string) bool {
+	file, err := os.Open(filename)
+	if err != nil {
+		return false
+	}
+	defer file.Close()
+	reader := bufio.NewReader(file)
+	quoted := false
+	for {
+		b, err := reader.ReadByte()
+		if err != nil {
+			return false
+		}
+		switch {
+		case b == '"':
+			quoted = !quoted
+		case quoted && (b == '\n' || b == '\r'):
+			return true
+		}
+	}
+}
+
+func (ds *CsvDataSource) Scan(ctx context.Context, projection []string, fetch int, partition int) RecordBatchStream {
+	if err := ds.SchemaError(); err != nil {
+		return &csvStream{err: err}
+	}
+	schema := ds.GetSchema()
+	if len(projection) > 0 {
+		schema = schema.Select(projection)
+	}
+	indices := make([]int, len(schema.Fields()))
+	for i, f := range schema.Fields() {
+		indices[i] = ds.GetSchema().FieldIndices(f.Name)[0]
+	}
+	return &csvStream{ctx: ctx, source: ds, schema: schema, indices: indices, fetch: fetch,
+		partition: partition, partitions: ds.Partitions()}
+}
+
+type csvStream struct {
+	ctx context.Context
+	// err is returned by Next when the source cannot be read at all
+	err        error
+	source     *CsvDataSource
+	schema     Schema
+	indices    []int
+	fetch      int
+	partition  int
+	partitions int
+	read       int
+	file       io.Closer
+	reader     *csv.Reader
+	done       bool
+}
+
+func (s *csvStream) open() error {
+	if s.partitions == 1 {
+		// read the whole file as a stream, which may be compressed
+		input, err := openInput(s.source.Filename)
+		if err != nil {
+			return err
+		}
+		s.file = input
+		s.reader = csv.NewReader(input)
+		s.reader.ReuseRecord = true
+		return s.skipHeader()
+	}
+	file, err := os.Open(s.source.Filename)
+	if err != nil {
+		return err
+	}
+	s.file = file
+	info, err := file.Stat()
+	if err != nil {
+		return err
+	}
+	size := info.Size()
+	start, err := lineBoundary(file, size*int64(s.partition)/int64(s.partitions))
+	if err != nil {
+		return err
+	}
+	end, err := lineBoundary(file, size*int64(s.partition+1)/int64(s.partitions))
+	if err != nil {
+		return err
+	}
+	s.reader = csv.NewReader(io.NewSectionReader(file, start, end-start))
+	s.reader.ReuseRecord = true
+	if start == 0 {
+		return s.skipHeader()
+	}
+	return nil
+}
+
+func (s *csvStream) skipHeader() error {
+	if s.source.hasHeaders {
+		if _, err := s.reader.Read(); err != nil && err != io.EOF {
+			return err
+		}
+	}
+	return nil
+}
+
+// lineBoundary returns the offset of the first line that starts at or after
+// offset
+func lineBoundary(file *os.File, offset int64) (int64, error) {
+	if offset == 0 {
+		return 0, nil
+	}
+	reader := bufio.NewReader(io.NewSectionReader(file, offset-1, 1<<62))
+	n := 0
+	for {
+		line, err := reader.ReadSlice('\n')
+		n += len(line)
+		if err == bufio.ErrBufferFull {
+			continue
+		}
+		if err != nil && err != io.EOF {
+			return 0, err
+		}
+		return offset - 1 + int64(n), nil
+	}
+}
+
+func (s *csvStream) Next() (RecordBatch, error) {
+	if s.err != nil {
+		return RecordBatch{}, s.err
+	}
+	if err := s.ctx.Err(); err != nil {
+		s.Close()
+		return RecordBatch{}, err
+	}
+	if s.done || (s.fetch > 0 && s.read >= s.fetch) {
+		// either the file is exhausted or the consumer has all the rows it
+		// asked for, so stop reading
+		s.Close()
+		return RecordBatch{}, io.EOF
+	}
+	if s.reader == nil {
+		if err := s.open(); err != nil {
+			s.Close()
+			return RecordBatch{}, err
+		}
+	}
+
+	size := s.source.getBatchSize()
+	if s.fetch > 0 && s.fetch-s.read < size {
+		size = s.fetch - s.read
+	}
+	columns := make([][]any, len(s.indices))
+	rows := 0
+	for rows < size {
+		record, err := s.reader.Read()
+		if err == io.EOF {
+			s.done = true
+			break
+		}
+		if err != nil {
+			return RecordBatch{}, err
+		}
+		for i, idx := range s.indices {
+			v, err := parseCsvValue(record[idx], s.schema.Field(i).Type)
+			if err != nil {
+				return RecordBatch{}, fmt.Errorf("%s: column %s: %w", s.source.Filename, s.schema.Field(i).Name, err)
+			}
+			columns[i] = append(columns[i], v)
+		}
+		rows++
+	}
+	if rows == 0 {
+		s.Close()
+		return RecordBatch{}, io.EOF
+	}
+	s.read += rows
+
+	fields := make([]ColumnVector, len(columns))
+	for i, values := range columns {
+		fields[i] = drogo.New(s.schema.Field(i).Type, rows, values)
+	}
+	return RecordBatch{s.schema, fields}, nil
+}
+
+func (s *csvStream) Close() error {
+	s.done = true
+	s.reader = nil
+	if s.file == nil {
+		return nil
+	}
+	err := s.file.Close()
+	s.file = nil
+	return err
+}
+
+// parseCsvValue converts a raw CSV field into the Go value drogo.New expects
+// for the arrow type. Empty fields are null unless the column is a string.
+func parseCsvValue(raw string, dtype arrow.DataType) (any, error) {
+	if raw == "" && dtype.ID() != arrow.STRING {
+		return nil, nil
+	}
+	switch dtype.ID() {
+	case arrow.BOOL:
+		return strconv.ParseBool(raw)
+	case arrow.INT8:
+		v, err := strconv.ParseInt(raw, 10, 8)
+		return int8(v), err
+	case arrow.INT16:
+		v, err := strconv.ParseInt(raw, 10, 16)
+		return int16(v), err
+	case arrow.INT32:
+		v, err := strconv.ParseInt(raw, 10, 32)
+		return int32(v), err
+	case arrow.INT64:
+		return strconv.ParseInt(raw, 10, 64)
+	case arrow.FLOAT32:
+		v, err := strconv.ParseFloat(raw, 32)
+		return float32(v), err
+	case arrow.FLOAT64:
+		return strconv.ParseFloat(raw, 64)
+	case arrow.STRING:
+		return raw, nil
+	default:
+		return nil, fmt.Errorf("unsupported type %s", dtype)
+	}
 }
@@ -1,11 +1,15 @@
 package engine
 
 import (
+	"context"
 	"fmt"
+	"io"
 	"strconv"
 	"strings"
 
 	"github.com/apache/arrow/go/v12/arrow"
+	"github.com/apache/arrow/go/v12/arrow/array"
+	"github.com/briansterle/drogo"
 )
 
 // struct embed arrow.Schema to add new methods + convenience
@@ -41,6 +45,59 @@ func (v LiteralValueVector) Len() int {
 	return v.size
 }
 
+// DictionaryVector is a dictionary-encoded column: row i holds the value at
+// Keys[i] in Values, or null where the key is negative. Expressions that
+// transform single values, such as the string functions, evaluate such a
+// column once per distinct value rather than once per row.
+type DictionaryVector struct {
+	Keys   []int32
+	Values ColumnVector
+}
+
+// dictionaryFromArrow copies the indices of an arrow dictionary array and
+// wraps its dictionary
+func dictionaryFromArrow(data *array.Dictionary) DictionaryVector {
+	keys := make([]int32, data.Len())
+	for i := range keys {
+		keys[i] = -1
+		if data.IsValid(i) {
+			keys[i] = int32(data.GetValueIndex(i))
+		}
+	}
+	return DictionaryVector{keys, drogo.FromArrow(data.Dictionary())}
+}
+
+func (v DictionaryVector) DataType() arrow.DataType {
+	return v.Values.DataType()
+}
+
+func (v DictionaryVector) GetValue(i int) any {
+	if v.Keys[i] < 0 {
+		return nil
+	}
+	return v.Values.GetValue(int(v.Keys[i]))
+}
+
+func (v DictionaryVector) Len() int {
+	return len(v.Keys)
+}
+
+// mapValues applies fn to the distinct values and returns its result for
+// every row. A string result stays dictionary-encoded.
+func (v DictionaryVector) mapValues(fn func(values ColumnVector) ColumnVector) ColumnVector {
+	mapped := fn(v.Values)
+	if mapped.DataType().ID() == arrow.STRING {
+		return DictionaryVector{v.Keys, mapped}
+	}
+	values := make([]any, len(v.Keys))
+	for i, k := range v.Keys {
+		if k >= 0 {
+			values[i] = mapped.GetValue(int(k))
+		}
+	}
+	return drogo.New(mapped.DataType(), len(values), values)
+}
+
 // RecordBatch represents a batch of columnar data.
 type RecordBatch struct {
 	Schema Schema
@@ -59,9 +116,106 @@ func (r *RecordBatch) Field(i int) ColumnVector {
 	return r.Fields[i]
 }
 
+// RecordBatchStream produces record batches one at a time so that consumers
+// can stop pulling from their input once they have seen enough rows.
+// Next returns io.EOF once the stream is exhausted.
+type RecordBatchStream interface {
+	Next() (RecordBatch, error)
+	Close() error
+}
+
+// ReadAll drains the stream and closes it
+func ReadAll(stream RecordBatchStream) ([]RecordBatch, error) {
+	defer stream.Close()
+	batches := []RecordBatch{}
+	for {
+		batch, err := stream.Next()
+		if err == io.EOF {
+			return batches, nil
+		}
+		if err != nil {
+			return batches, err
+		}
+		batches = append(batches, batch)
+	}
+}
+
+type batchStream struct {
+	batches []RecordBatch
+}
+
+// NewRecordBatchStream returns a stream over batches that are already in memory
+func NewRecordBatchStream(batches ...RecordBatch) RecordBatchStream {
+	return &batchStream{batches}
+}
+
+func (s *batchStream) Next() (RecordBatch, error) {
+	if len(s.batches) == 0 {
+		return RecordBatch{}, io.EOF
+	}
+	batch := s.batches[0]
+	s.batches = s.batches[1:]
+	return batch, nil
+}
+
+func (s *batchStream) Close() error {
+	s.batches = nil
+	return nil
+}
+
+// DataSource is scanned with the columns to read and a fetch hint. A fetch
+// greater than zero means the caller needs at most that many rows, so the
+// source may stop reading early; zero means read everything.
+//
+// A source may be split into several partitions that are scanned
+// independently and in parallel. Together they produce every row once. The
+// stream returned by Scan stops with the context's error once ctx is done.
 type DataSource interface {
 	GetSchema() Schema
-	Scan(projection []string) []RecordBatch
+	Partitions() int
+	Scan(ctx context.Context, projection []string, fetch int, partition int) RecordBatchStream
+}
+
+// schemaLoader is implemented by data sources that discover their schema
+// when it is first needed, which can fail
+type schemaLoader interface {
+	SchemaError() error
+}
+
+// contextSchemaLoader is implemented by data sources that read their schema
+// from a database, so that reading it can be cancelled
+type contextSchemaLoader interface {
+	LoadSchema(ctx context.Context) error
+}
+
+// loadSchema returns the error that prevented a source from discovering its
+// schema, giving up once ctx is done if the source supports it
+func loadSchema(ctx context.Context, source DataSource) error {
+	switch loader := source.(type) {
+	case contextSchemaLoader:
+		return loader.LoadSchema(ctx)
+	case schemaLoader:
+		return loader.SchemaError()
+	}
+	return nil
+}
+
+// FilteredDataSource is a data source that can use filters to skip reading
+// data. The filters are only a hint: the source may still produce rows that
+// do not match them, so they must be applied to its output as well.
+type FilteredDataSource interface {
+	DataSource
+	// WithFilters returns a source that may skip rows which do not satisfy
+	// all of the filters
+	WithFilters(filters []LogicalExpr) DataSource
+}
+
+// ExactFilteredDataSource is a filtered data source that only produces rows
+// which match the filters it reports as exact, so the optimizer does not
+// need to apply those filters again above the scan
+type ExactFilteredDataSource interface {
+	FilteredDataSource
+	ExactFilter(filter LogicalExpr) bool
 }
 
 type LogicalPlan interface {
@@ -229,10 +383,23 @@ func (m MathExpr) String() string {
 func (m MathExpr) ToField(input LogicalPlan) arrow.Field {
 	return arrow.Field{
 		Name: m.Name,
-		Type: arrow.PrimitiveTypes.Float64,
+		Type: numericResultType(m.L.ToField(input).Type, m.R.ToField(input).Type),
 	}
 }
 
+// numericResultType is the type both operands of a math expression are
+// coerced to: the common type if they match, otherwise the widest of
+// int64/float64 that can hold both.
+func numericResultType(l, r arrow.DataType) arrow.DataType {
+	if arrow.TypeEqual(l, r) {
+		return l
+	}
+	if arrow.IsFloating(l.ID()) || arrow.IsFloating(r.ID()) {
+		return arrow.PrimitiveTypes.Float64
+	}
+	return arrow.PrimitiveTypes.Int64
+}
+
 func Add(l LogicalExpr, r LogicalExpr) MathExpr {
 	return MathExpr{"add", "+", l, r}
 }
@@ -256,6 +423,12 @@ func Modulus(l LogicalExpr, r LogicalExpr) MathExpr {
 type AggregateExpr struct {
 	Name string
 	Expr LogicalExpr
+	// Alias names the output column, which is otherwise named after the
+	// function
+	Alias string
+	// Func is the user-defined function named Name, or nil for the
+	// built-in aggregates
+	Func *AggregateUDF
 }
 
 func (e *AggregateExpr) String() string {
@@ -263,36 +436,55 @@ func (e *AggregateExpr) String() string {
 }
 
 func (e *AggregateExpr) toField(input LogicalPlan) arrow.Field {
+	name := e.Name
+	if e.Alias != "" {
+		name = e.Alias
+	}
+	if e.Func != nil {
+		return arrow.Field{Name: name, Type: e.Func.ReturnType, Nullable: true}
+	}
+	switch e.Name {
+	case "COUNT":
+		return arrow.Field{Name: name, Type: arrow.PrimitiveTypes.Int64}
+	case "AVG":
+		return arrow.Field{Name: name, Type: arrow.PrimitiveTypes.Float64}
+	}
 	return arrow.Field{
-		Name: e.Name,
+		Name: name,
 		Type: e.Expr.ToField(input).Type,
 	}
 }
 
 func Sum(input LogicalExpr) AggregateExpr {
-	return AggregateExpr{"SUM", input}
+	return AggregateExpr{Name: "SUM", Expr: input}
 }
 
 func Min(input LogicalExpr) AggregateExpr {
-	return AggregateExpr{"MIN", input}
+	return AggregateExpr{Name: "MIN", Expr: input}
 }
 
 func Max(input LogicalExpr) AggregateExpr {
-	return AggregateExpr{"MAX", input}
+	return AggregateExpr{Name: "MAX", Expr: input}
 }
 
 func Avg(input LogicalExpr) AggregateExpr {
-	return AggregateExpr{"AVG", input}
+	return AggregateExpr{Name: "AVG", Expr: input}
 }
 
 func Count(input LogicalExpr) AggregateExpr {
-	return AggregateExpr{"COUNT", input}
+	return AggregateExpr{Name: "COUNT", Expr: input}
 }
 
 type Scan struct {
 	Path       string
 	Source     DataSource
 	Projection []string
+	// Fetch is the maximum number of rows the scan needs to produce, pushed
+	// down from a Limit by the optimizer. Zero means no limit.
+	Fetch int
+	// Filters are predicates pushed down by the optimizer that the source can
+	// use to skip data. Rows are still filtered above the scan.
+	Filters []LogicalExpr
 }
 
 func (s Schema) Select(projection []string) Schema {
@@ -321,10 +513,19 @@ func (s Scan) Children() []LogicalPlan {
 }
 
 func (s Scan) String() string {
+	var str string
 	if len(s.Projection) == 0 {
-		return fmt.Sprintf("Scan: %s; projection=None", s.Path)
+		str = fmt.Sprintf("Scan: %s; projection=None", s.Path)
+	} else {
+		str = fmt.Sprintf("Scan: %s; projection=%v", s.Path, s.Projection)
+	}
+	if len(s.Filters) > 0 {
+		str += fmt.Sprintf("; filters=%v", s.Filters)
+	}
+	if s.Fetch > 0 {
+		str += fmt.Sprintf("; fetch=%d", s.Fetch)
 	}
-	return fmt.Sprintf("Scan: %s; projection=%v", s.Path, s.Projection)
+	return str
 }
 
 type Projection struct {
@@ -392,5 +593,84 @@ func (a Aggregate) Children() []LogicalPlan {
 }
 
 func (a Aggregate) String() string {
-	return fmt.Sprintf("Aggregate: groupExpr=%s, aggregateExpr=%s", a.GroupExpr, a.AggregateExpr)
+	aggregates := make([]string, len(a.AggregateExpr))
+	for i, e := range a.AggregateExpr {
+		aggregates[i] = fmt.Sprintf("{%s %s %s}", e.Name, e.Expr, e.Alias)
+	}
+	return fmt.Sprintf("Aggregate: groupExpr=%s, aggregateExpr=[%s]", a.GroupExpr, strings.Join(aggregates, " "))
+}
+
+// Limit skips the first Skip rows of its input and then produces at most
+// Fetch rows. A negative Fetch means no limit, which is how OFFSET without
+// LIMIT is represented.
+type Limit struct {
+	Input LogicalPlan
+	Skip  int
+	Fetch int
+}
+
+func (l Limit) Schema() Schema {
+	return l.Input.Schema()
+}
+
+func (l Limit) Children() []LogicalPlan {
+	return []LogicalPlan{l.Input}
+}
+
+func (l Limit) String() string {
+	if l.Fetch < 0 {
+		return fmt.Sprintf("Limit: skip=%d, fetch=None", l.Skip)
+	}
+	return fmt.Sprintf("Limit: skip=%d, fetch=%d", l.Skip, l.Fetch)
+}
+
+type SortExpr struct {
+	Expr LogicalExpr
+	Asc  bool
+}
+
+func (e SortExpr) String() string {
+	if e.Asc {
+		return e.Expr.String() + " ASC"
+	}
+	return e.Expr.String() + " DESC"
+}
+
+func Asc(expr LogicalExpr) SortExpr {
+	return SortExpr{expr, true}
+}
+
+func Desc(expr LogicalExpr) SortExpr {
+	return SortExpr{expr, false}
+}
+
+// Sort orders its input by the sort expressions. Nulls sort after all other
+// values in ascending order and before them in descending order.
+type Sort struct {
+	Input LogicalPlan
+	Expr  []SortExpr
+	// Fetch is set by the optimizer when only the first Fetch rows of the
+	// sorted output are needed, which lets the planner use a top-k operator
+	// instead of a full sort. Zero means all rows are needed.
+	Fetch int
+}
+
+func (s Sort) Schema() Schema {
+	return s.Input.Schema()
+}
+
+func (s Sort) Children() []LogicalPlan {
+	return []LogicalPlan{s.Input}
+}
+
+func (s Sort) String() string {
+	strs := []string{}
+	for _, e := range s.Expr {
+		strs = append(strs, e.String())
+	}
+	str := fmt.Sprintf("Sort: %s", strings.Join(strs, ", "))
+	if s.Fetch > 0 {
+		str += fmt.Sprintf("; fetch=%d", s.Fetch)
+	}
+	return str
 }
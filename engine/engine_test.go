@@ -4,15 +4,16 @@ import (
 	"fmt"
 	"testing"
 
+	"github.com/apache/arrow/go/v12/arrow"
 	"github.com/stretchr/testify/assert"
 )
 
 func TestLogicalPlan(t *testing.T) {
 	// data source
-	csv := &CsvDataSource{"employees.csv", Schema{}, true, 100}
+	csv := NewCsvDataSource("employees.csv", Schema{}, true, 100)
 
 	// FROM
-	scan := Scan{"employee", csv, []string{}}
+	scan := Scan{Path: "employee", Source: csv, Projection: []string{}}
 
 	// WHERE
 	filterExpr := Eq(Column{"state"}, LiteralString{"CO"})
@@ -58,3 +59,11 @@ func TestDataFrame(t *testing.T) {
 
 	assert.Equal(t, expected, actual, "plan should equal")
 }
+
+func TestNumericResultType(t *testing.T) {
+	i32, i64, f64 := arrow.PrimitiveTypes.Int32, arrow.PrimitiveTypes.Int64, arrow.PrimitiveTypes.Float64
+	assert.Equal(t, i32, numericResultType(i32, i32))
+	assert.Equal(t, i64, numericResultType(i32, i64))
+	assert.Equal(t, f64, numericResultType(i64, f64))
+	assert.Equal(t, f64, numericResultType(arrow.PrimitiveTypes.Float32, i32))
+}
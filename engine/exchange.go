@@ -0,0 +1,464 @@
+package engine
+
+import (
+	"context"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"sync"
+)
+
+// channelItem is a batch or error sent between the goroutines of an exchange
+type channelItem struct {
+	batch RecordBatch
+	err   error
+}
+
+// channelStream reads batches sent by producer goroutines. Closing it tells
+// the producers to stop.
+type channelStream struct {
+	ctx       context.Context
+	ch        <-chan channelItem
+	closeOnce sync.Once
+	close     func()
+}
+
+func (s *channelStream) Next() (RecordBatch, error) {
+	if err := s.ctx.Err(); err != nil {
+		return RecordBatch{}, err
+	}
+	select {
+	case item, ok := <-s.ch:
+		if !ok {
+			return RecordBatch{}, io.EOF
+		}
+		return item.batch, item.err
+	case <-s.ctx.Done():
+		return RecordBatch{}, s.ctx.Err()
+	}
+}
+
+func (s *channelStream) Close() error {
+	s.closeOnce.Do(s.close)
+	return nil
+}
+
+// produce drains input into ch until the input is exhausted, stop is closed
+// or ctx is done, and closes the input when done
+func produce(ctx context.Context, input RecordBatchStream, ch chan<- channelItem, stop <-chan struct{}) {
+	defer input.Close()
+	for {
+		batch, err := input.Next()
+		if err == io.EOF {
+			return
+		}
+		select {
+		case ch <- channelItem{batch, err}:
+		case <-stop:
+			return
+		case <-ctx.Done():
+			return
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+// CoalescePartitionsExec merges all partitions of its input into a single
+// partition by executing each of them on its own goroutine. Batches are
+// emitted in the order they are produced.
+type CoalescePartitionsExec struct {
+	Input   PhysicalPlan
+	metrics *Metrics
+}
+
+func (c CoalescePartitionsExec) GetSchema() Schema {
+	return c.Input.GetSchema()
+}
+
+func (c CoalescePartitionsExec) OutputPartitions() int {
+	return 1
+}
+
+func (c CoalescePartitionsExec) Children() []PhysicalPlan {
+	return []PhysicalPlan{c.Input}
+}
+
+func (c CoalescePartitionsExec) String() string {
+	return "CoalescePartitionsExec"
+}
+
+func (c CoalescePartitionsExec) Execute(ctx context.Context, task *TaskContext, partition int) RecordBatchStream {
+	n := c.Input.OutputPartitions()
+	if n == 1 {
+		return c.metrics.record(c.Input.Execute(ctx, task, 0))
+	}
+	ch := make(chan channelItem, n)
+	stop := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(n)
+	for i := 0; i < n; i++ {
+		input := c.Input.Execute(ctx, task, i)
+		go func() {
+			defer wg.Done()
+			produce(ctx, input, ch, stop)
+		}()
+	}
+	go func() {
+		wg.Wait()
+		close(ch)
+	}()
+	return c.metrics.record(&channelStream{ctx: ctx, ch: ch, close: func() { close(stop) }})
+}
+
+func (c CoalescePartitionsExec) Metrics() *Metrics {
+	return c.metrics
+}
+
+// Partitioning describes how RepartitionExec distributes rows. With no
+// expressions batches are dealt out round-robin; otherwise each row goes to
+// the partition chosen by the hash of the expressions' values.
+type Partitioning struct {
+	Partitions int
+	HashExpr   []Expression
+}
+
+func RoundRobinPartitioning(n int) Partitioning {
+	return Partitioning{n, nil}
+}
+
+func HashPartitioning(n int, exprs []Expression) Partitioning {
+	return Partitioning{n, exprs}
+}
+
+func (p Partitioning) String() string {
+	if len(p.HashExpr) == 0 {
+		return fmt.Sprintf("RoundRobinBatch(%d)", p.Partitions)
+	}
+	return fmt.Sprintf("Hash(%s, %d)", p.HashExpr, p.Partitions)
+}
+
+// RepartitionExec reads every input partition on its own goroutine and
+// redistributes the rows across Partitioning.Partitions output partitions.
+// All output partitions of an execution share the same goroutines, which
+// stop once every output partition has been closed.
+//
+// Each output partition queues at most repartitionQueueSize batches, which
+// are reserved from the task's memory pool. A producer with a batch for a
+// full queue waits until that partition is read or closed, so the output
+// partitions must be read concurrently, as CoalescePartitionsExec does.
+type RepartitionExec struct {
+	Input        PhysicalPlan
+	Partitioning Partitioning
+	metrics      *Metrics
+
+	mu sync.Mutex
+	// the running exchange for each execution of the plan
+	runs map[*TaskContext]*repartitionRun
+}
+
+func NewRepartitionExec(input PhysicalPlan, partitioning Partitioning) *RepartitionExec {
+	return &RepartitionExec{Input: input, Partitioning: partitioning, metrics: NewMetrics()}
+}
+
+func (r *RepartitionExec) GetSchema() Schema {
+	return r.Input.GetSchema()
+}
+
+func (r *RepartitionExec) OutputPartitions() int {
+	return r.Partitioning.Partitions
+}
+
+func (r *RepartitionExec) Children() []PhysicalPlan {
+	return []PhysicalPlan{r.Input}
+}
+
+func (r *RepartitionExec) String() string {
+	return fmt.Sprintf("RepartitionExec: partitioning=%s", r.Partitioning)
+}
+
+func (r *RepartitionExec) Execute(ctx context.Context, task *TaskContext, partition int) RecordBatchStream {
+	r.mu.Lock()
+	if r.runs == nil {
+		r.runs = map[*TaskContext]*repartitionRun{}
+	}
+	run, ok := r.runs[task]
+	if !ok {
+		run = r.start(ctx, task)
+		r.runs[task] = run
+	}
+	r.mu.Unlock()
+
+	return r.metrics.record(&repartitionStream{ctx: ctx, run: run, queue: run.queues[partition], done: func() {
+		r.mu.Lock()
+		delete(r.runs, task)
+		r.mu.Unlock()
+	}})
+}
+
+func (r *RepartitionExec) Metrics() *Metrics {
+	return r.metrics
+}
+
+// repartitionQueueSize is the number of batches queued for an output
+// partition before the producers wait for it to be read
+const repartitionQueueSize = 2
+
+type repartitionRun struct {
+	queues []*repartitionQueue
+	// closed once every output partition has been closed
+	stop chan struct{}
+
+	mu          sync.Mutex
+	open        int
+	reservation *MemoryReservation
+}
+
+// repartitionQueue holds the batches sent to one output partition until they
+// are read
+type repartitionQueue struct {
+	mu sync.Mutex
+	// ready wakes up the consumer after the queue has changed
+	ready chan struct{}
+	// space is closed, and replaced, to wake up the producers waiting for a
+	// full queue once a batch has been taken or the queue has been closed
+	space chan struct{}
+	items []queuedItem
+	// finished is set once every producer has returned
+	finished bool
+	closed   bool
+}
+
+type queuedItem struct {
+	channelItem
+	size int64
+}
+
+func (r *RepartitionExec) start(ctx context.Context, task *TaskContext) *repartitionRun {
+	n := r.Partitioning.Partitions
+	run := &repartitionRun{
+		queues:      make([]*repartitionQueue, n),
+		stop:        make(chan struct{}),
+		open:        n,
+		reservation: task.Memory.NewReservation("RepartitionExec"),
+	}
+	run.reservation.metrics = r.metrics
+	for i := range run.queues {
+		run.queues[i] = &repartitionQueue{ready: make(chan struct{}, 1), space: make(chan struct{})}
+	}
+
+	inputs := r.Input.OutputPartitions()
+	var wg sync.WaitGroup
+	wg.Add(inputs)
+	for i := 0; i < inputs; i++ {
+		input := r.Input.Execute(ctx, task, i)
+		// stagger the round-robin start so small inputs spread out
+		next := i
+		go func() {
+			defer wg.Done()
+			defer input.Close()
+			for !run.stopped() {
+				batch, err := input.Next()
+				if err == io.EOF {
+					return
+				}
+				if err != nil {
+					run.fail(err)
+					return
+				}
+				if len(r.Partitioning.HashExpr) == 0 {
+					if err := run.send(ctx, next%n, batch); err != nil {
+						run.fail(err)
+						return
+					}
+					next++
+					continue
+				}
+				parts, err := r.hashPartition(batch)
+				if err != nil {
+					run.fail(err)
+					return
+				}
+				for k, part := range parts {
+					if part.RowCount() == 0 {
+						continue
+					}
+					if err := run.send(ctx, k, part); err != nil {
+						run.fail(err)
+						return
+					}
+				}
+			}
+		}()
+	}
+	go func() {
+		wg.Wait()
+		for _, q := range run.queues {
+			q.mu.Lock()
+			q.finished = true
+			q.notify()
+			q.mu.Unlock()
+		}
+	}()
+	return run
+}
+
+func (run *repartitionRun) stopped() bool {
+	select {
+	case <-run.stop:
+		return true
+	default:
+		return false
+	}
+}
+
+// send queues a batch for an output partition, waiting while the queue is
+// full, and drops it if that partition has been closed
+func (run *repartitionRun) send(ctx context.Context, partition int, batch RecordBatch) error {
+	q := run.queues[partition]
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for len(q.items) >= repartitionQueueSize && !q.closed {
+		space := q.space
+		q.mu.Unlock()
+		select {
+		case <-space:
+		case <-ctx.Done():
+			q.mu.Lock()
+			return ctx.Err()
+		}
+		q.mu.Lock()
+	}
+	if q.closed {
+		return nil
+	}
+	size := estimateBatchSize(batch)
+	run.mu.Lock()
+	err := run.reservation.TryGrow(size)
+	run.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	q.items = append(q.items, queuedItem{channelItem{batch: batch}, size})
+	q.notify()
+	return nil
+}
+
+func (q *repartitionQueue) notify() {
+	select {
+	case q.ready <- struct{}{}:
+	default:
+	}
+}
+
+// release wakes up the producers waiting for space in the queue
+func (q *repartitionQueue) release() {
+	close(q.space)
+	q.space = make(chan struct{})
+}
+
+// fail delivers an error to every output partition
+func (run *repartitionRun) fail(err error) {
+	for _, q := range run.queues {
+		q.mu.Lock()
+		if !q.closed {
+			q.items = append(q.items, queuedItem{channelItem{err: err}, 0})
+			q.notify()
+		}
+		q.mu.Unlock()
+	}
+}
+
+func (run *repartitionRun) shrink(size int64) {
+	run.mu.Lock()
+	run.reservation.Shrink(size)
+	run.mu.Unlock()
+}
+
+type repartitionStream struct {
+	ctx       context.Context
+	run       *repartitionRun
+	queue     *repartitionQueue
+	closeOnce sync.Once
+	done      func()
+}
+
+func (s *repartitionStream) Next() (RecordBatch, error) {
+	q := s.queue
+	for {
+		if err := s.ctx.Err(); err != nil {
+			return RecordBatch{}, err
+		}
+		q.mu.Lock()
+		if len(q.items) > 0 {
+			item := q.items[0]
+			q.items[0] = queuedItem{}
+			q.items = q.items[1:]
+			q.release()
+			q.mu.Unlock()
+			s.run.shrink(item.size)
+			return item.batch, item.err
+		}
+		done := q.finished || q.closed
+		q.mu.Unlock()
+		if done {
+			return RecordBatch{}, io.EOF
+		}
+		select {
+		case <-q.ready:
+		case <-s.ctx.Done():
+		}
+	}
+}
+
+func (s *repartitionStream) Close() error {
+	s.closeOnce.Do(func() {
+		q := s.queue
+		q.mu.Lock()
+		q.closed = true
+		var size int64
+		for _, item := range q.items {
+			size += item.size
+		}
+		q.items = nil
+		q.notify()
+		q.release()
+		q.mu.Unlock()
+		s.run.shrink(size)
+
+		s.run.mu.Lock()
+		defer s.run.mu.Unlock()
+		s.run.open--
+		if s.run.open == 0 {
+			close(s.run.stop)
+			s.done()
+		}
+	})
+	return nil
+}
+
+// hashPartition splits a batch into one batch per output partition
+func (r *RepartitionExec) hashPartition(batch RecordBatch) ([]RecordBatch, error) {
+	n := r.Partitioning.Partitions
+	keys := make([]ColumnVector, len(r.Partitioning.HashExpr))
+	for i, e := range r.Partitioning.HashExpr {
+		v, err := evaluate(e, batch)
+		if err != nil {
+			return nil, err
+		}
+		keys[i] = v
+	}
+	rows := make([][]int, n)
+	for row := 0; row < batch.RowCount(); row++ {
+		h := fnv.New32a()
+		h.Write([]byte(encodeGroupKey(rowValues(keys, row))))
+		k := h.Sum32() % uint32(n)
+		rows[k] = append(rows[k], row)
+	}
+	parts := make([]RecordBatch, n)
+	for k, indices := range rows {
+		parts[k] = batch.Take(indices)
+	}
+	return parts, nil
+}
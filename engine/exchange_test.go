@@ -0,0 +1,205 @@
+package engine
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func int64Column(batches []RecordBatch, i int) []int64 {
+	values := []int64{}
+	for _, v := range column(batches, i) {
+		values = append(values, v.(int64))
+	}
+	sort.Slice(values, func(a, b int) bool { return values[a] < values[b] })
+	return values
+}
+
+func sequence(n int) []int64 {
+	values := make([]int64, n)
+	for i := range values {
+		values[i] = int64(i)
+	}
+	return values
+}
+
+func TestCsvPartitionsCoverFile(t *testing.T) {
+	path := writeEventsCsv(t, 5000, 10)
+	source := NewCsvDataSource(path, Schema{}, true, 100).WithPartitions(7)
+	source.minPartitionBytes = 1
+	require.Equal(t, 7, source.Partitions())
+
+	all := []RecordBatch{}
+	for p := 0; p < source.Partitions(); p++ {
+		batches, err := ReadAll(source.Scan(context.Background(), []string{"amount"}, 0, p))
+		require.NoError(t, err)
+		assert.NotEmpty(t, batches, "partition %d should have rows", p)
+		all = append(all, batches...)
+	}
+	assert.Equal(t, sequence(5000), int64Column(all, 0))
+}
+
+func TestRepartitionByHash(t *testing.T) {
+	path := writeEventsCsv(t, 1000, 10)
+	source := NewCsvDataSource(path, Schema{}, true, 64)
+	schema := source.GetSchema()
+	repartition := NewRepartitionExec(ScanExec{source, []string{}, 0, nil}, HashPartitioning(4, []Expression{ColumnExpression{0}}))
+
+	task := NewTaskContext()
+	all := []RecordBatch{}
+	users := map[any]int{}
+	// the queues are bounded, so the output partitions are read concurrently
+	partitions := make([][]RecordBatch, 4)
+	var wg sync.WaitGroup
+	for p := range partitions {
+		stream := repartition.Execute(context.Background(), task, p)
+		wg.Add(1)
+		go func(p int) {
+			defer wg.Done()
+			batches, err := ReadAll(stream)
+			assert.NoError(t, err)
+			partitions[p] = batches
+		}(p)
+	}
+	wg.Wait()
+	for p, batches := range partitions {
+		for _, user := range column(batches, 0) {
+			if other, ok := users[user]; ok {
+				assert.Equal(t, other, p, "%s should be in one partition", user)
+			}
+			users[user] = p
+		}
+		all = append(all, batches...)
+	}
+	assert.Len(t, users, 10)
+	assert.Equal(t, sequence(1000), int64Column(all, schema.FieldIndices("amount")[0]))
+	assert.Zero(t, task.Memory.Reserved(), "buffered batches should be released")
+}
+
+func TestRepartitionWaitsForSlowReader(t *testing.T) {
+	// small enough to be scanned as a single partition and repartitioned
+	path := writeEventsCsv(t, 100000, 10)
+	ec := &ExecutionContext{TargetPartitions: 4, QueryMemoryLimit: 1 << 20, BatchSize: 1024}
+	stream, err := ec.Execute(context.Background(), ec.Csv(path).Filter(Gt(Col("amount"), Int(-1))))
+	require.NoError(t, err)
+	defer stream.Close()
+	rows := 0
+	for {
+		batch, err := stream.Next()
+		if err == io.EOF {
+			break
+		}
+		require.NoError(t, err)
+		rows += batch.RowCount()
+		time.Sleep(2 * time.Millisecond)
+	}
+	assert.Equal(t, 100000, rows)
+}
+
+func TestRepartitionSendStopsOnCancel(t *testing.T) {
+	source := &countingSource{rows: 1000}
+	repartition := NewRepartitionExec(partitionedScan{[]*countingSource{source}}, RoundRobinPartitioning(2))
+	ctx, cancel := context.WithCancel(context.Background())
+	task := NewTaskContext()
+	streams := []RecordBatchStream{repartition.Execute(ctx, task, 0), repartition.Execute(ctx, task, 1)}
+	_, err := streams[0].Next()
+	require.NoError(t, err)
+	// the producer ends up waiting for partition 1, which nobody reads
+	time.Sleep(50 * time.Millisecond)
+	q := repartition.runs[task].queues[1]
+	q.mu.Lock()
+	assert.Len(t, q.items, repartitionQueueSize)
+	q.mu.Unlock()
+	assert.False(t, source.closed.Load())
+	cancel()
+	assert.Eventually(t, source.closed.Load, 5*time.Second, 10*time.Millisecond)
+	for _, stream := range streams {
+		require.NoError(t, stream.Close())
+	}
+}
+
+func TestCsvQuotedNewlineSinglePartition(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "notes.csv")
+	content := "id,note\n"
+	for i := 0; i < 100; i++ {
+		content += fmt.Sprintf("%d,\"line one\nline %d\"\n", i, i)
+	}
+	require.NoError(t, os.WriteFile(path, []byte(content), 0o644))
+
+	source := NewCsvDataSource(path, Schema{}, true, 10).WithPartitions(4)
+	source.minPartitionBytes = 1
+	require.Equal(t, 1, source.Partitions())
+	batches, err := ReadAll(source.Scan(context.Background(), []string{"id", "note"}, 0, 0))
+	require.NoError(t, err)
+	assert.Equal(t, sequence(100), int64Column(batches, 0))
+	assert.Equal(t, "line one\nline 42", column(batches, 1)[42])
+}
+
+func TestCoalesceCloseStopsProducers(t *testing.T) {
+	sources := make([]*countingSource, 4)
+	for i := range sources {
+		sources[i] = &countingSource{rows: 1000}
+	}
+	coalesce := CoalescePartitionsExec{partitionedScan{sources}, nil}
+
+	stream := coalesce.Execute(context.Background(), NewTaskContext(), 0)
+	_, err := stream.Next()
+	require.NoError(t, err)
+	require.NoError(t, stream.Close())
+
+	assert.Eventually(t, func() bool {
+		for _, s := range sources {
+			if !s.closed.Load() {
+				return false
+			}
+		}
+		return true
+	}, 5*time.Second, 10*time.Millisecond)
+}
+
+func TestParallelQueryMatchesSerial(t *testing.T) {
+	path := writeEventsCsv(t, 20000, 50)
+	run := func(partitions int) []string {
+		ctx := &ExecutionContext{TargetPartitions: partitions, BatchSize: 128}
+		df := ctx.Csv(path).
+			Filter(Gt(Modulus(Col("amount"), Int(3)), Int(0))).
+			Project([]LogicalExpr{Col("user"), Alias{Multiply(Col("amount"), Int(2)), "double"}}).
+			Aggregate([]LogicalExpr{Col("user")}, []AggregateExpr{Sum(Col("double")), Count(Col("double"))})
+		return aggregateRows(t, ctx, df)
+	}
+	assert.Equal(t, run(1), run(8))
+}
+
+// partitionedScan exposes each source as one partition
+type partitionedScan struct {
+	sources []*countingSource
+}
+
+func (p partitionedScan) GetSchema() Schema {
+	return Schema{}
+}
+
+func (p partitionedScan) OutputPartitions() int {
+	return len(p.sources)
+}
+
+func (p partitionedScan) Execute(ctx context.Context, task *TaskContext, partition int) RecordBatchStream {
+	return p.sources[partition].Scan(ctx, nil, 0, 0)
+}
+
+func (p partitionedScan) Children() []PhysicalPlan {
+	return []PhysicalPlan{}
+}
+
+func (p partitionedScan) Metrics() *Metrics {
+	return nil
+}
@@ -0,0 +1,451 @@
+package engine
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+
+	"github.com/apache/arrow/go/v12/arrow"
+	"github.com/briansterle/drogo"
+)
+
+// defaultJsonSampleSize is the number of lines read to infer the schema of a
+// JSON file
+const defaultJsonSampleSize = 1000
+
+// JsonDataSource reads newline-delimited JSON, one object per line, from a
+// plain or compressed file. Each top level key is a column; keys that
+// are missing from a line are null.
+type JsonDataSource struct {
+	Filename  string
+	Schema    Schema
+	batchSize int
+	// sampleSize is the number of lines the schema is inferred from
+	sampleSize int
+	// schemaErr is set when the schema could not be inferred
+	schemaErr error
+}
+
+func NewJsonDataSource(filename string, schema Schema, batchSize int) *JsonDataSource {
+	return &JsonDataSource{Filename: filename, Schema: schema, batchSize: batchSize}
+}
+
+// WithSampleSize sets the number of lines the schema is inferred from. Zero
+// means the default of 1000 and a negative size reads the whole file.
+func (ds *JsonDataSource) WithSampleSize(n int) *JsonDataSource {
+	ds.sampleSize = n
+	return ds
+}
+
+// GetSchema returns the schema the source was created with, or infers one
+// from the first lines of the file if none was given. Keys become columns in
+// the order they are first seen. Objects are inferred as structs, arrays as
+// lists, and values whose types conflict as strings holding their JSON text.
+//
+// When the sample ends before the file does, integers are inferred as
+// float64 so that fractions in later lines can be read. Keys that first
+// appear after the sample are not columns of the schema and are ignored
+// when reading; a larger sample or an explicit schema includes them.
+//
+// If the schema cannot be inferred, an empty schema is returned and the
+// error is reported by SchemaError and by scans of the source.
+func (ds *JsonDataSource) GetSchema() Schema {
+	if ds.Schema.Schema == nil && ds.schemaErr == nil {
+		schema, err := ds.inferSchema()
+		if err != nil {
+			ds.schemaErr = err
+		} else {
+			ds.Schema = schema
+		}
+	}
+	if ds.schemaErr != nil {
+		return Schema{arrow.NewSchema(nil, nil)}
+	}
+	return ds.Schema
+}
+
+// SchemaError is the error that prevented the schema from being inferred
+func (ds *JsonDataSource) SchemaError() error {
+	ds.GetSchema()
+	return ds.schemaErr
+}
+
+func (ds *JsonDataSource) Partitions() int {
+	return 1
+}
+
+func (ds *JsonDataSource) getBatchSize() int {
+	if ds.batchSize <= 0 {
+		return defaultBatchSize
+	}
+	return ds.batchSize
+}
+
+func (ds *JsonDataSource) getSampleSize() int {
+	if ds.sampleSize == 0 {
+		return defaultJsonSampleSize
+	}
+	return ds.sampleSize
+}
+
+func (ds *JsonDataSource) inferSchema() (Schema, error) {
+	input, err := openInput(ds.Filename)
+	if err != nil {
+		return Schema{}, err
+	}
+	defer input.Close()
+	reader := bufio.NewReader(input)
+
+	var inferred arrow.DataType = arrow.Null
+	// complete is set if the sample covers the whole file
+	sample, complete := ds.getSampleSize(), false
+	for lines := 0; ; {
+		line, err := readLine(reader)
+		if err == io.EOF {
+			complete = true
+			break
+		}
+		if err != nil {
+			return Schema{}, err
+		}
+		if len(line) == 0 {
+			continue
+		}
+		if lines == sample {
+			break
+		}
+		lines++
+		dec := json.NewDecoder(bytes.NewReader(line))
+		dec.UseNumber()
+		dtype, err := inferJsonType(dec)
+		if err != nil {
+			return Schema{}, fmt.Errorf("cannot infer schema of %s: %w", ds.Filename, err)
+		}
+		if _, ok := dtype.(*arrow.StructType); !ok {
+			return Schema{}, fmt.Errorf("cannot infer schema of %s: line is not an object", ds.Filename)
+		}
+		inferred = mergeJsonTypes(inferred, dtype)
+	}
+	if inferred.ID() == arrow.NULL {
+		return Schema{}, fmt.Errorf("cannot infer schema of %s: no lines", ds.Filename)
+	}
+	if !complete {
+		inferred = widenIntegers(inferred)
+	}
+	return Schema{arrow.NewSchema(resolveNullTypes(inferred).(*arrow.StructType).Fields(), nil)}, nil
+}
+
+// widenIntegers replaces int64 with float64, including in nested types
+func widenIntegers(dtype arrow.DataType) arrow.DataType {
+	switch dtype := dtype.(type) {
+	case *arrow.Int64Type:
+		return drogo.Float64
+	case *arrow.ListType:
+		return arrow.ListOf(widenIntegers(dtype.Elem()))
+	case *arrow.StructType:
+		fields := make([]arrow.Field, len(dtype.Fields()))
+		for i, f := range dtype.Fields() {
+			fields[i] = arrow.Field{Name: f.Name, Type: widenIntegers(f.Type), Nullable: f.Nullable}
+		}
+		return arrow.StructOf(fields...)
+	default:
+		return dtype
+	}
+}
+
+// inferJsonType reads the next value from dec and returns its type. Objects
+// are read token by token so struct fields keep the order of their keys.
+func inferJsonType(dec *json.Decoder) (arrow.DataType, error) {
+	token, err := dec.Token()
+	if err != nil {
+		return nil, err
+	}
+	switch t := token.(type) {
+	case nil:
+		return arrow.Null, nil
+	case bool:
+		return drogo.Boolean, nil
+	case json.Number:
+		if _, err := t.Int64(); err == nil {
+			return drogo.Int64, nil
+		}
+		return drogo.Float64, nil
+	case string:
+		return drogo.String, nil
+	case json.Delim:
+		if t == '[' {
+			var elem arrow.DataType = arrow.Null
+			for dec.More() {
+				dtype, err := inferJsonType(dec)
+				if err != nil {
+					return nil, err
+				}
+				elem = mergeJsonTypes(elem, dtype)
+			}
+			_, err := dec.Token()
+			return arrow.ListOf(elem), err
+		}
+		var dtype arrow.DataType = arrow.StructOf()
+		for dec.More() {
+			key, err := dec.Token()
+			if err != nil {
+				return nil, err
+			}
+			value, err := inferJsonType(dec)
+			if err != nil {
+				return nil, err
+			}
+			field := arrow.Field{Name: key.(string), Type: value, Nullable: true}
+			dtype = mergeJsonTypes(dtype, arrow.StructOf(field))
+		}
+		_, err := dec.Token()
+		return dtype, err
+	default:
+		return nil, fmt.Errorf("unexpected token %v", token)
+	}
+}
+
+// mergeJsonTypes returns a type that can hold values of both a and b. Null
+// is absorbed by any other type, integers widen to floats, structs take the
+// union of their fields and any other conflict becomes a string.
+func mergeJsonTypes(a, b arrow.DataType) arrow.DataType {
+	switch {
+	case a.ID() == arrow.NULL:
+		return b
+	case b.ID() == arrow.NULL:
+		return a
+	case arrow.TypeEqual(a, b):
+		return a
+	case a.ID() == arrow.STRUCT && b.ID() == arrow.STRUCT:
+		fields := append([]arrow.Field{}, a.(*arrow.StructType).Fields()...)
+		for _, f := range b.(*arrow.StructType).Fields() {
+			merged := false
+			for i := range fields {
+				if fields[i].Name == f.Name {
+					fields[i].Type = mergeJsonTypes(fields[i].Type, f.Type)
+					merged = true
+				}
+			}
+			if !merged {
+				fields = append(fields, f)
+			}
+		}
+		return arrow.StructOf(fields...)
+	case a.ID() == arrow.LIST && b.ID() == arrow.LIST:
+		return arrow.ListOf(mergeJsonTypes(a.(*arrow.ListType).Elem(), b.(*arrow.ListType).Elem()))
+	case isJsonNumber(a) && isJsonNumber(b):
+		return drogo.Float64
+	default:
+		return drogo.String
+	}
+}
+
+func isJsonNumber(dtype arrow.DataType) bool {
+	return dtype.ID() == arrow.INT64 || dtype.ID() == arrow.FLOAT64
+}
+
+// resolveNullTypes replaces the types of values that were only ever null
+// with string
+func resolveNullTypes(dtype arrow.DataType) arrow.DataType {
+	switch dtype := dtype.(type) {
+	case *arrow.NullType:
+		return drogo.String
+	case *arrow.ListType:
+		return arrow.ListOf(resolveNullTypes(dtype.Elem()))
+	case *arrow.StructType:
+		fields := make([]arrow.Field, len(dtype.Fields()))
+		for i, f := range dtype.Fields() {
+			fields[i] = arrow.Field{Name: f.Name, Type: resolveNullTypes(f.Type), Nullable: true}
+		}
+		return arrow.StructOf(fields...)
+	default:
+		return dtype
+	}
+}
+
+func (ds *JsonDataSource) Scan(ctx context.Context, projection []string, fetch int, partition int) RecordBatchStream {
+	if err := ds.SchemaError(); err != nil {
+		return &jsonStream{err: err}
+	}
+	schema := ds.GetSchema()
+	if len(projection) > 0 {
+		schema = schema.Select(projection)
+	}
+	return &jsonStream{ctx: ctx, source: ds, schema: schema, fetch: fetch}
+}
+
+type jsonStream struct {
+	ctx    context.Context
+	source *JsonDataSource
+	schema Schema
+	fetch  int
+	read   int
+	line   int
+	// err is returned by Next when the stream could not be opened
+	err    error
+	input  io.ReadCloser
+	reader *bufio.Reader
+	done   bool
+}
+
+func (s *jsonStream) Next() (RecordBatch, error) {
+	if s.err != nil {
+		return RecordBatch{}, s.err
+	}
+	if s.done || (s.fetch > 0 && s.read >= s.fetch) {
+		s.Close()
+		return RecordBatch{}, io.EOF
+	}
+	if err := s.ctx.Err(); err != nil {
+		s.Close()
+		return RecordBatch{}, err
+	}
+	if s.reader == nil {
+		input, err := openInput(s.source.Filename)
+		if err != nil {
+			s.Close()
+			return RecordBatch{}, err
+		}
+		s.input, s.reader = input, bufio.NewReader(input)
+	}
+
+	size := s.source.getBatchSize()
+	if s.fetch > 0 && s.fetch-s.read < size {
+		size = s.fetch - s.read
+	}
+	columns := make([][]any, len(s.schema.Fields()))
+	rows := 0
+	for rows < size {
+		line, err := readLine(s.reader)
+		if err == io.EOF {
+			s.done = true
+			break
+		}
+		if err != nil {
+			return RecordBatch{}, err
+		}
+		s.line++
+		if len(line) == 0 {
+			continue
+		}
+		// only the projected values are decoded
+		var object map[string]json.RawMessage
+		if err := json.Unmarshal(line, &object); err != nil {
+			return RecordBatch{}, fmt.Errorf("%s: line %d: %w", s.source.Filename, s.line, err)
+		}
+		for i, f := range s.schema.Fields() {
+			v, err := parseJsonValue(object[f.Name], f.Type)
+			if err != nil {
+				return RecordBatch{}, fmt.Errorf("%s: line %d: column %s: %w", s.source.Filename, s.line, f.Name, err)
+			}
+			columns[i] = append(columns[i], v)
+		}
+		rows++
+	}
+	if rows == 0 {
+		s.Close()
+		return RecordBatch{}, io.EOF
+	}
+	s.read += rows
+
+	fields := make([]ColumnVector, len(columns))
+	for i, values := range columns {
+		fields[i] = drogo.New(s.schema.Field(i).Type, rows, values)
+	}
+	return RecordBatch{s.schema, fields}, nil
+}
+
+func (s *jsonStream) Close() error {
+	s.done = true
+	s.reader = nil
+	if s.input == nil {
+		return nil
+	}
+	err := s.input.Close()
+	s.input = nil
+	return err
+}
+
+// readLine returns the next line without its line ending
+func readLine(reader *bufio.Reader) ([]byte, error) {
+	line, err := reader.ReadBytes('\n')
+	if err == io.EOF && len(line) > 0 {
+		err = nil
+	}
+	return bytes.TrimSpace(line), err
+}
+
+// parseJsonValue converts a raw JSON value into the Go value drogo.New
+// expects for the arrow type. A missing value is null.
+func parseJsonValue(raw json.RawMessage, dtype arrow.DataType) (any, error) {
+	if len(raw) == 0 {
+		return nil, nil
+	}
+	if dtype.ID() == arrow.STRING && raw[0] != '"' && !bytes.Equal(raw, []byte("null")) {
+		// values of other types in a string column keep their JSON text
+		return string(raw), nil
+	}
+	dec := json.NewDecoder(bytes.NewReader(raw))
+	dec.UseNumber()
+	var value any
+	if err := dec.Decode(&value); err != nil {
+		return nil, err
+	}
+	return convertJsonValue(value, dtype)
+}
+
+func convertJsonValue(value any, dtype arrow.DataType) (any, error) {
+	if value == nil {
+		return nil, nil
+	}
+	switch dtype := dtype.(type) {
+	case *arrow.BooleanType:
+		if b, ok := value.(bool); ok {
+			return b, nil
+		}
+	case *arrow.Int64Type:
+		if n, ok := value.(json.Number); ok {
+			return n.Int64()
+		}
+	case *arrow.Float64Type:
+		if n, ok := value.(json.Number); ok {
+			return n.Float64()
+		}
+	case *arrow.StringType:
+		if s, ok := value.(string); ok {
+			return s, nil
+		}
+		text, err := json.Marshal(value)
+		return string(text), err
+	case *arrow.StructType:
+		if object, ok := value.(map[string]any); ok {
+			out := make(map[string]any, len(dtype.Fields()))
+			for _, f := range dtype.Fields() {
+				v, err := convertJsonValue(object[f.Name], f.Type)
+				if err != nil {
+					return nil, fmt.Errorf("%s: %w", f.Name, err)
+				}
+				out[f.Name] = v
+			}
+			return out, nil
+		}
+	case *arrow.ListType:
+		if values, ok := value.([]any); ok {
+			out := make([]any, len(values))
+			for i, v := range values {
+				elem, err := convertJsonValue(v, dtype.Elem())
+				if err != nil {
+					return nil, err
+				}
+				out[i] = elem
+			}
+			return out, nil
+		}
+	default:
+		return nil, fmt.Errorf("unsupported type %s", dtype)
+	}
+	return nil, fmt.Errorf("cannot convert %v to %s", value, dtype)
+}
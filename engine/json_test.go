@@ -0,0 +1,129 @@
+package engine
+
+import (
+	"compress/gzip"
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/apache/arrow/go/v12/arrow"
+	"github.com/briansterle/drogo"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const logLines = `{"level":"info","ts":1,"user":{"id":7,"name":"ann"},"tags":["a","b"]}
+{"level":"warn","ts":2.5,"tags":[],"extra":null}
+
+{"level":"error","ts":3,"user":{"id":8,"admin":true},"extra":{"code":500}}
+{"level":5,"tags":null}
+`
+
+func writeJson(t *testing.T, name, content string) string {
+	path := filepath.Join(t.TempDir(), name)
+	require.NoError(t, os.WriteFile(path, []byte(content), 0o644))
+	return path
+}
+
+func fieldNames(schema Schema) []string {
+	names := []string{}
+	for _, f := range schema.Fields() {
+		names = append(names, f.Name)
+	}
+	return names
+}
+
+func TestJsonSchemaInference(t *testing.T) {
+	source := NewJsonDataSource(writeJson(t, "logs.json", logLines), Schema{}, 0)
+	require.NoError(t, source.SchemaError())
+	expected := arrow.NewSchema([]arrow.Field{
+		{Name: "level", Type: drogo.String, Nullable: true},
+		{Name: "ts", Type: drogo.Float64, Nullable: true},
+		{Name: "user", Type: arrow.StructOf(
+			arrow.Field{Name: "id", Type: drogo.Int64, Nullable: true},
+			arrow.Field{Name: "name", Type: drogo.String, Nullable: true},
+			arrow.Field{Name: "admin", Type: drogo.Boolean, Nullable: true},
+		), Nullable: true},
+		{Name: "tags", Type: arrow.ListOf(drogo.String), Nullable: true},
+		{Name: "extra", Type: arrow.StructOf(
+			arrow.Field{Name: "code", Type: drogo.Int64, Nullable: true},
+		), Nullable: true},
+	}, nil)
+	assert.True(t, expected.Equal(source.GetSchema().Schema), source.GetSchema().String())
+}
+
+func TestJsonScan(t *testing.T) {
+	source := NewJsonDataSource(writeJson(t, "logs.json", logLines), Schema{}, 3)
+	batches, err := ReadAll(source.Scan(context.Background(), []string{"user", "level", "tags"}, 0, 0))
+	require.NoError(t, err)
+	require.Len(t, batches, 2)
+	assert.Equal(t, 3, batches[0].ColumnCount())
+	assert.Equal(t, []any{
+		map[string]any{"id": int64(7), "name": "ann", "admin": nil},
+		nil,
+		map[string]any{"id": int64(8), "name": nil, "admin": true},
+		nil,
+	}, column(batches, 0))
+	// a number in a string column keeps its JSON text
+	assert.Equal(t, []any{"info", "warn", "error", "5"}, column(batches, 1))
+	assert.Equal(t, []any{[]any{"a", "b"}, []any{}, nil, nil}, column(batches, 2))
+
+	batches, err = ReadAll(source.Scan(context.Background(), []string{"ts"}, 2, 0))
+	require.NoError(t, err)
+	assert.Equal(t, []any{1.0, 2.5}, column(batches, 0))
+}
+
+func TestJsonGzip(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "logs.json.gz")
+	f, err := os.Create(path)
+	require.NoError(t, err)
+	gz := gzip.NewWriter(f)
+	_, err = gz.Write([]byte(logLines))
+	require.NoError(t, err)
+	require.NoError(t, gz.Close())
+	require.NoError(t, f.Close())
+
+	ctx := &ExecutionContext{TargetPartitions: 2}
+	df := ctx.Json(path).
+		Filter(Gt(Col("ts"), Flt(2))).
+		Project([]LogicalExpr{Col("level")})
+	batches, err := df.Collect(context.Background())
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []any{"warn", "error"}, column(batches, 0))
+}
+
+func TestJsonErrors(t *testing.T) {
+	_, err := (&ExecutionContext{}).Json(filepath.Join(t.TempDir(), "missing.json")).Collect(context.Background())
+	assert.Error(t, err)
+
+	source := NewJsonDataSource(writeJson(t, "array.json", "[1,2]\n"), Schema{}, 0)
+	assert.ErrorContains(t, source.SchemaError(), "not an object")
+
+	source = NewJsonDataSource(writeJson(t, "bad.json", "{\"id\":1}\n{\"id\":\"x\"\n"), Schema{}, 0).WithSampleSize(1)
+	_, err = ReadAll(source.Scan(context.Background(), nil, 0, 0))
+	assert.ErrorContains(t, err, "line 2")
+}
+
+func TestJsonSampleSize(t *testing.T) {
+	path := writeJson(t, "ids.json", "{\"id\":1}\n{\"id\":2}\n\n{\"id\":1.5,\"late\":true}\n")
+	// the sample covers the file, so every line is considered
+	source := NewJsonDataSource(path, Schema{}, 1)
+	assert.Equal(t, []string{"id", "late"}, fieldNames(source.GetSchema()))
+	assert.Equal(t, drogo.Float64, source.GetSchema().Field(0).Type)
+
+	// integers seen in a partial sample are widened so later fractions can
+	// be read, and keys first seen after the sample are ignored
+	source = NewJsonDataSource(path, Schema{}, 1).WithSampleSize(2)
+	assert.Equal(t, []string{"id"}, fieldNames(source.GetSchema()))
+	assert.Equal(t, drogo.Float64, source.GetSchema().Field(0).Type)
+	batches, err := ReadAll(source.Scan(context.Background(), nil, 0, 0))
+	require.NoError(t, err)
+	assert.Equal(t, []any{1.0, 2.0, 1.5}, column(batches, 0))
+
+	// trailing blank lines do not make a sample partial
+	source = NewJsonDataSource(writeJson(t, "ints.json", "{\"id\":1}\n{\"id\":2}\n\n\n"), Schema{}, 0).WithSampleSize(2)
+	assert.Equal(t, drogo.Int64, source.GetSchema().Field(0).Type)
+	source = NewJsonDataSource(path, Schema{}, 0).WithSampleSize(-1)
+	assert.Equal(t, []string{"id", "late"}, fieldNames(source.GetSchema()))
+}
@@ -0,0 +1,548 @@
+package engine
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/apache/arrow/go/v12/arrow"
+	"github.com/briansterle/drogo"
+)
+
+// fileFormat opens the files of a listing table
+type fileFormat struct {
+	// extensions are the suffixes of the files read from directories
+	extensions []string
+	open       func(filename string, batchSize, partitions int) DataSource
+}
+
+var fileFormats = map[string]fileFormat{
+	"csv": {[]string{".csv"}, func(filename string, batchSize, partitions int) DataSource {
+		return NewCsvDataSource(filename, Schema{}, true, batchSize).WithPartitions(partitions)
+	}},
+	"json": {[]string{".json", ".jsonl", ".ndjson"}, func(filename string, batchSize, partitions int) DataSource {
+		return NewJsonDataSource(filename, Schema{}, batchSize)
+	}},
+	"parquet": {[]string{".parquet"}, func(filename string, batchSize, partitions int) DataSource {
+		return NewParquetDataSource([]string{filename}, batchSize).WithPartitions(partitions)
+	}},
+	"arrow": {[]string{".arrow", ".ipc", ".feather"}, func(filename string, batchSize, partitions int) DataSource {
+		return NewArrowIpcDataSource(filename).WithPartitions(partitions)
+	}},
+}
+
+// ListingTable reads every file of one format under a directory, or every
+// file matched by a glob pattern, as a single table. Files in subdirectories
+// are included, except hidden ones and those starting with an underscore.
+// CSV and JSON files may be compressed, as in events.csv.gz.
+//
+// Directories named key=value, as written by the PartitionBy write options,
+// add a column named key to the rows of the files below them. Partition
+// values are URL-unescaped, __HIVE_DEFAULT_PARTITION__ is null, and the
+// column's type is inferred from all of its values like a CSV column.
+// Files whose partition values cannot match the filters pushed down by the
+// optimizer are not read.
+//
+// The table's schema is the union of the files' columns followed by the
+// partition columns. A column missing from a file is null for its rows, and
+// a column with different numeric types in different files is widened to
+// int64 or float64.
+type ListingTable struct {
+	Path      string
+	Format    string
+	batchSize int
+	// partitions is the most partitions the files are spread over
+	partitions int
+	filters    []LogicalExpr
+	// listing is shared with the copies made by WithFilters so the files are
+	// only listed once
+	listing *listing
+}
+
+type listing struct {
+	once   sync.Once
+	files  []listedFile
+	schema Schema
+	// fileColumns is the number of columns read from the files; the
+	// partition columns follow them in the schema
+	fileColumns int
+	// units are the file partitions, which are dealt round-robin to the
+	// table's partitions
+	units []listingUnit
+	err   error
+}
+
+type listedFile struct {
+	source DataSource
+	// values are the file's partition values in the table's order
+	values []any
+}
+
+type listingUnit struct {
+	file      int
+	partition int
+}
+
+// NewListingTable returns a table of the csv, json, parquet or arrow files at
+// path, which is a directory, a glob pattern or a single file
+func NewListingTable(path, format string, batchSize int) *ListingTable {
+	return &ListingTable{Path: path, Format: format, batchSize: batchSize, partitions: 1, listing: &listing{}}
+}
+
+// WithPartitions allows the files to be scanned as up to n partitions
+func (t *ListingTable) WithPartitions(n int) *ListingTable {
+	t.partitions = n
+	return t
+}
+
+// WithFilters returns a copy of the table that skips files whose partition
+// values cannot match all of the filters. The filters are also passed on to
+// the files' sources if they accept them.
+func (t *ListingTable) WithFilters(filters []LogicalExpr) DataSource {
+	out := *t
+	out.filters = filters
+	return &out
+}
+
+// isListing reports whether path names more than a single file
+func isListing(path string) bool {
+	if hasGlob(path) {
+		return true
+	}
+	info, err := os.Stat(path)
+	return err == nil && info.IsDir()
+}
+
+func hasGlob(path string) bool {
+	return strings.ContainsAny(path, "*?[")
+}
+
+// listFiles returns the files at path in lexical order, and the directory
+// the partition directories are found below
+func listFiles(path string, extensions []string) (string, []string, error) {
+	matches := []string{path}
+	base := path
+	if hasGlob(path) {
+		var err error
+		if matches, err = filepath.Glob(path); err != nil {
+			return "", nil, err
+		}
+		// the partition directories start at the first pattern in the path
+		segments := strings.Split(filepath.ToSlash(path), "/")
+		for i, segment := range segments {
+			if hasGlob(segment) {
+				segments = segments[:i]
+				break
+			}
+		}
+		base = filepath.FromSlash(strings.Join(segments, "/"))
+		if base == "" && filepath.IsAbs(path) {
+			base = string(filepath.Separator)
+		}
+	}
+	files := []string{}
+	for _, match := range matches {
+		info, err := os.Stat(match)
+		if err != nil {
+			return "", nil, err
+		}
+		if match != path && isHidden(filepath.Base(match)) {
+			continue
+		}
+		if !info.IsDir() {
+			if match == path {
+				base = filepath.Dir(path)
+			}
+			files = append(files, match)
+			continue
+		}
+		err = filepath.WalkDir(match, func(name string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			if name != match && isHidden(d.Name()) {
+				if d.IsDir() {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+			if !d.IsDir() && hasExtension(trimCompression(name), extensions) {
+				files = append(files, name)
+			}
+			return nil
+		})
+		if err != nil {
+			return "", nil, err
+		}
+	}
+	if len(files) == 0 {
+		return "", nil, fmt.Errorf("no files found at %s", path)
+	}
+	sort.Strings(files)
+	return base, files, nil
+}
+
+// isHidden reports whether a file is skipped when listing, like the _SUCCESS
+// markers and _temporary directories left by other writers
+func isHidden(name string) bool {
+	return strings.HasPrefix(name, ".") || strings.HasPrefix(name, "_")
+}
+
+func hasExtension(name string, extensions []string) bool {
+	for _, ext := range extensions {
+		if strings.HasSuffix(name, ext) {
+			return true
+		}
+	}
+	return false
+}
+
+// partitionValues parses the key=value directories between base and the
+// file. Null values are returned as nil.
+func partitionValues(base, filename string) ([]string, []*string, error) {
+	rel, err := filepath.Rel(base, filepath.Dir(filename))
+	if err != nil {
+		return nil, nil, err
+	}
+	keys, values := []string{}, []*string{}
+	for _, segment := range strings.Split(filepath.ToSlash(rel), "/") {
+		key, value, ok := strings.Cut(segment, "=")
+		if !ok {
+			continue
+		}
+		keys = append(keys, key)
+		if value == hiveDefaultPartition {
+			values = append(values, nil)
+			continue
+		}
+		unescaped, err := url.PathUnescape(value)
+		if err != nil {
+			return nil, nil, fmt.Errorf("%s: partition %s: %w", filename, key, err)
+		}
+		values = append(values, &unescaped)
+	}
+	return keys, values, nil
+}
+
+// mergeFileTypes returns the type of a column that has types a and b in
+// different files
+func mergeFileTypes(a, b arrow.DataType) (arrow.DataType, bool) {
+	switch {
+	case arrow.TypeEqual(a, b):
+		return a, true
+	case arrow.IsSignedInteger(a.ID()) && arrow.IsSignedInteger(b.ID()):
+		return drogo.Int64, true
+	case (arrow.IsSignedInteger(a.ID()) || arrow.IsFloating(a.ID())) &&
+		(arrow.IsSignedInteger(b.ID()) || arrow.IsFloating(b.ID())):
+		return drogo.Float64, true
+	default:
+		return nil, false
+	}
+}
+
+// load lists the files, reads their schemas and parses their partition
+// values. Errors are kept and reported by SchemaError and by scans.
+func (t *ListingTable) load() *listing {
+	l := t.listing
+	l.once.Do(func() {
+		l.err = t.list(l)
+	})
+	return l
+}
+
+func (t *ListingTable) list(l *listing) error {
+	format, ok := fileFormats[t.Format]
+	if !ok {
+		return fmt.Errorf("unknown file format '%s'", t.Format)
+	}
+	base, filenames, err := listFiles(t.Path, format.extensions)
+	if err != nil {
+		return err
+	}
+
+	var keys []string
+	raw := make([][]*string, len(filenames))
+	fields := []arrow.Field{}
+	l.files = make([]listedFile, len(filenames))
+	for i, filename := range filenames {
+		fileKeys, values, err := partitionValues(base, filename)
+		if err != nil {
+			return err
+		}
+		if i == 0 {
+			keys = fileKeys
+		} else if strings.Join(fileKeys, "/") != strings.Join(keys, "/") {
+			return fmt.Errorf("%s: partition columns %v do not match %v", filename, fileKeys, keys)
+		}
+		raw[i] = values
+
+		source := format.open(filename, t.batchSize, t.partitions)
+		if loader, ok := source.(schemaLoader); ok {
+			if err := loader.SchemaError(); err != nil {
+				return err
+			}
+		}
+		for _, f := range source.GetSchema().Fields() {
+			j := fieldIndex(fields, f.Name)
+			if j < 0 {
+				f.Nullable = f.Nullable || i > 0
+				fields = append(fields, f)
+				continue
+			}
+			dtype, ok := mergeFileTypes(fields[j].Type, f.Type)
+			if !ok {
+				return fmt.Errorf("%s: column %s has type %s, expected %s", filename, f.Name, f.Type, fields[j].Type)
+			}
+			fields[j].Type = dtype
+			fields[j].Nullable = fields[j].Nullable || f.Nullable
+		}
+		// columns that earlier files have but this one lacks are null here
+		for j := range fields {
+			if len(source.GetSchema().FieldIndices(fields[j].Name)) == 0 {
+				fields[j].Nullable = true
+			}
+		}
+		l.files[i].source = source
+		for p := 0; p < source.Partitions(); p++ {
+			l.units = append(l.units, listingUnit{i, p})
+		}
+	}
+	l.fileColumns = len(fields)
+
+	for k, key := range keys {
+		if fieldIndex(fields, key) >= 0 {
+			return fmt.Errorf("partition column %s is also a column of the files", key)
+		}
+		values, nullable := []string{}, false
+		for _, fileValues := range raw {
+			if fileValues[k] == nil {
+				nullable = true
+			} else {
+				values = append(values, *fileValues[k])
+			}
+		}
+		dtype := inferType(values)
+		for i, fileValues := range raw {
+			var value any
+			if fileValues[k] != nil {
+				if value, err = parseCsvValue(*fileValues[k], dtype); err != nil {
+					return fmt.Errorf("%s: partition %s: %w", filenames[i], key, err)
+				}
+			}
+			l.files[i].values = append(l.files[i].values, value)
+		}
+		fields = append(fields, arrow.Field{Name: key, Type: dtype, Nullable: nullable})
+	}
+	l.schema = Schema{arrow.NewSchema(fields, nil)}
+	return nil
+}
+
+func fieldIndex(fields []arrow.Field, name string) int {
+	for i, f := range fields {
+		if f.Name == name {
+			return i
+		}
+	}
+	return -1
+}
+
+// GetSchema returns the merged schema of the files, or an empty schema if
+// they could not be listed or read
+func (t *ListingTable) GetSchema() Schema {
+	l := t.load()
+	if l.err != nil {
+		return Schema{arrow.NewSchema(nil, nil)}
+	}
+	return l.schema
+}
+
+// SchemaError is the error that prevented the files from being listed or
+// their schemas from being read
+func (t *ListingTable) SchemaError() error {
+	return t.load().err
+}
+
+func (t *ListingTable) Partitions() int {
+	l := t.load()
+	n := t.partitions
+	if len(l.units) < n {
+		n = len(l.units)
+	}
+	if n < 1 {
+		return 1
+	}
+	return n
+}
+
+// mayMatch reports whether the filters could be true for rows of the file,
+// judging only by its partition values
+func (t *ListingTable) mayMatch(file listedFile) bool {
+	l := t.listing
+	stats := func(name string) (any, any, bool, bool) {
+		i := l.schema.FieldIndices(name)
+		if len(i) == 0 || i[0] < l.fileColumns {
+			return nil, nil, false, false
+		}
+		v := file.values[i[0]-l.fileColumns]
+		return v, v, v == nil, true
+	}
+	for _, filter := range t.filters {
+		if !mayMatch(filter, stats) {
+			return false
+		}
+	}
+	return true
+}
+
+func (t *ListingTable) Scan(ctx context.Context, projection []string, fetch int, partition int) RecordBatchStream {
+	if err := t.SchemaError(); err != nil {
+		return &listingStream{err: err}
+	}
+	l := t.listing
+	schema := l.schema
+	if len(projection) > 0 {
+		schema = schema.Select(projection)
+	}
+	units := []listingUnit{}
+	for i := partition; i < len(l.units); i += t.Partitions() {
+		if t.mayMatch(l.files[l.units[i].file]) {
+			units = append(units, l.units[i])
+		}
+	}
+	return &listingStream{ctx: ctx, table: t, schema: schema, units: units, fetch: fetch}
+}
+
+type listingStream struct {
+	ctx    context.Context
+	table  *ListingTable
+	schema Schema
+	units  []listingUnit
+	fetch  int
+	read   int
+	// err is returned by Next when the table could not be loaded
+	err error
+	// input scans the current unit, whose file is file
+	input RecordBatchStream
+	file  listedFile
+}
+
+// open starts scanning the next unit, reading the projected columns that the
+// file has
+func (s *listingStream) open(unit listingUnit) {
+	s.file = s.table.listing.files[unit.file]
+	source := s.file.source
+	if filtered, ok := source.(FilteredDataSource); ok && len(s.table.filters) > 0 {
+		source = filtered.WithFilters(s.table.filters)
+	}
+	columns := []string{}
+	for _, f := range s.schema.Fields() {
+		if len(source.GetSchema().FieldIndices(f.Name)) > 0 {
+			columns = append(columns, f.Name)
+		}
+	}
+	if len(columns) == 0 {
+		// only partition columns are wanted, but the rows still need counting
+		columns = []string{source.GetSchema().Field(0).Name}
+	}
+	fetch := 0
+	if s.fetch > 0 {
+		fetch = s.fetch - s.read
+	}
+	s.input = source.Scan(s.ctx, columns, fetch, unit.partition)
+}
+
+func (s *listingStream) Next() (RecordBatch, error) {
+	if s.err != nil {
+		return RecordBatch{}, s.err
+	}
+	for {
+		if s.fetch > 0 && s.read >= s.fetch {
+			s.Close()
+			return RecordBatch{}, io.EOF
+		}
+		if s.input == nil {
+			if len(s.units) == 0 {
+				return RecordBatch{}, io.EOF
+			}
+			s.open(s.units[0])
+			s.units = s.units[1:]
+		}
+		batch, err := s.input.Next()
+		if err == io.EOF {
+			s.input.Close()
+			s.input = nil
+			continue
+		}
+		if err != nil {
+			s.Close()
+			return RecordBatch{}, err
+		}
+		if batch.RowCount() == 0 {
+			continue
+		}
+		s.read += batch.RowCount()
+		return s.complete(batch), nil
+	}
+}
+
+// complete converts a batch read from the current file to the stream's
+// schema, adding its partition values and nulls for the columns it lacks
+func (s *listingStream) complete(batch RecordBatch) RecordBatch {
+	l := s.table.listing
+	rows := batch.RowCount()
+	fields := make([]ColumnVector, len(s.schema.Fields()))
+	for i, f := range s.schema.Fields() {
+		index := l.schema.FieldIndices(f.Name)[0]
+		if index >= l.fileColumns {
+			fields[i] = repeatValue(f.Type, s.file.values[index-l.fileColumns], rows)
+			continue
+		}
+		j := batch.Schema.FieldIndices(f.Name)
+		if len(j) == 0 {
+			fields[i] = repeatValue(f.Type, nil, rows)
+			continue
+		}
+		fields[i] = widenColumn(batch.Field(j[0]), f.Type)
+	}
+	return RecordBatch{s.schema, fields}
+}
+
+func repeatValue(dtype arrow.DataType, value any, n int) ColumnVector {
+	values := make([]any, n)
+	for i := range values {
+		values[i] = value
+	}
+	return drogo.New(dtype, n, values)
+}
+
+// widenColumn converts a column to the int64 or float64 type it was widened
+// to by mergeFileTypes
+func widenColumn(column ColumnVector, dtype arrow.DataType) ColumnVector {
+	if arrow.TypeEqual(column.DataType(), dtype) {
+		return column
+	}
+	values := make([]any, column.Len())
+	for i := range values {
+		if v := column.GetValue(i); v != nil {
+			values[i] = castNumeric(v, dtype)
+		}
+	}
+	return drogo.New(dtype, len(values), values)
+}
+
+func (s *listingStream) Close() error {
+	s.units = nil
+	if s.input == nil {
+		return nil
+	}
+	err := s.input.Close()
+	s.input = nil
+	return err
+}
@@ -0,0 +1,145 @@
+package engine
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/apache/arrow/go/v12/arrow"
+	"github.com/briansterle/drogo"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// writeFiles creates files with the given contents below a temporary
+// directory, which it returns
+func writeFiles(t *testing.T, files map[string]string) string {
+	dir := t.TempDir()
+	for name, data := range files {
+		path := filepath.Join(dir, filepath.FromSlash(name))
+		require.NoError(t, os.MkdirAll(filepath.Dir(path), 0o755))
+		require.NoError(t, os.WriteFile(path, []byte(data), 0o644))
+	}
+	return dir
+}
+
+func TestListingHivePartitions(t *testing.T) {
+	ctx := &ExecutionContext{BatchSize: 10, TargetPartitions: 2}
+	dir := filepath.Join(t.TempDir(), "events")
+	df := ctx.Csv(writeEventsCsv(t, 30, 3)).
+		Project([]LogicalExpr{Col("amount"), Col("user"), Alias{Modulus(Col("amount"), Int(2)), "parity"}})
+	require.NoError(t, df.WriteCsv(context.Background(), dir, CsvWriteOptions{PartitionBy: []string{"user", "parity"}}))
+
+	events := ctx.Csv(dir)
+	expected := arrow.NewSchema([]arrow.Field{
+		{Name: "amount", Type: drogo.Int64, Nullable: true},
+		{Name: "user", Type: drogo.String},
+		{Name: "parity", Type: drogo.Int64},
+	}, nil)
+	assert.True(t, expected.Equal(events.Schema().Schema), events.Schema().String())
+
+	batches, err := events.Collect(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, sequence(30), int64Column(batches, 0))
+
+	batches, err = events.Filter(And(Eq(Col("user"), Str("user-1")), Eq(Col("parity"), Int(0)))).
+		Project([]LogicalExpr{Col("amount")}).
+		Collect(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, []int64{4, 10, 16, 22, 28}, int64Column(batches, 0))
+}
+
+func TestListingPruning(t *testing.T) {
+	dir := writeFiles(t, map[string]string{
+		"date=2026-10-01/region=eu/part-0.csv": "n\n1\n2\n",
+		"date=2026-10-01/region=us/part-0.csv": "n\n3\n",
+		"date=2026-10-02/region=eu/part-0.csv": "n\n4\n",
+		// the schema is inferred from the first rows, so only a scan would
+		// find the bad value
+		"date=2026-10-02/region=us/part-0.csv": "n\n1\n2\n3\n4\n5\n6\n7\n8\n9\n10\nbad\n",
+	})
+	table := NewListingTable(dir, "csv", 10)
+	require.NoError(t, table.SchemaError())
+	pruned := table.WithFilters([]LogicalExpr{
+		Eq(Col("region"), Str("eu")),
+		Or(Eq(Col("n"), Int(1)), Gt(Col("date"), Str("2026-10-01"))),
+	})
+	batches, err := ReadAll(pruned.Scan(context.Background(), []string{"date", "n"}, 0, 0))
+	require.NoError(t, err)
+	assert.Equal(t, []any{"2026-10-01", "2026-10-01", "2026-10-02"}, column(batches, 0))
+	assert.Equal(t, []int64{1, 2, 4}, int64Column(batches, 1))
+
+	// only partition columns are read
+	batches, err = ReadAll(pruned.Scan(context.Background(), []string{"region"}, 2, 0))
+	require.NoError(t, err)
+	assert.Equal(t, []any{"eu", "eu"}, column(batches, 0))
+}
+
+func TestListingGlob(t *testing.T) {
+	dir := writeFiles(t, map[string]string{
+		"a.csv":         "id,amount\n1,10\n",
+		"b.csv":         "id,amount,note\n2,2.5,x\n",
+		"c.txt":         "id\n3\n",
+		"_SUCCESS":      "",
+		".hidden.csv":   "id\n4\n",
+		"sub/d.csv":     "id\n5\n",
+		"key=%2F/e.csv": "id\n6\n",
+	})
+	df := (&ExecutionContext{TargetPartitions: 1}).Csv(filepath.Join(dir, "*.csv"))
+	expected := arrow.NewSchema([]arrow.Field{
+		{Name: "id", Type: drogo.Int64, Nullable: true},
+		{Name: "amount", Type: drogo.Float64, Nullable: true},
+		{Name: "note", Type: drogo.String, Nullable: true},
+	}, nil)
+	assert.True(t, expected.Equal(df.Schema().Schema), df.Schema().String())
+	batches, err := df.Collect(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, []any{int64(1), int64(2)}, column(batches, 0))
+	assert.Equal(t, []any{10.0, 2.5}, column(batches, 1))
+	assert.Equal(t, []any{nil, "x"}, column(batches, 2))
+
+	_, err = (&ExecutionContext{}).Csv(dir).Collect(context.Background())
+	assert.ErrorContains(t, err, "partition columns")
+
+	batches, err = (&ExecutionContext{}).Csv(filepath.Join(dir, "key=*")).Collect(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, []any{"/"}, column(batches, 1))
+
+	_, err = (&ExecutionContext{}).Csv(filepath.Join(dir, "*.missing")).Collect(context.Background())
+	assert.ErrorContains(t, err, "no files found")
+}
+
+func TestListingNullPartition(t *testing.T) {
+	dir := writeFiles(t, map[string]string{
+		"year=2025/part-0.json":                  `{"n": 1}` + "\n",
+		"year=__HIVE_DEFAULT_PARTITION__/a.json": `{"n": 2}` + "\n",
+	})
+	batches, err := (&ExecutionContext{}).Json(dir).
+		Filter(Eq(Col("year"), Int(2025))).
+		Collect(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, []any{int64(1)}, column(batches, 0))
+	assert.Equal(t, []any{int64(2025)}, column(batches, 1))
+
+	batches, err = (&ExecutionContext{}).Json(dir).Collect(context.Background())
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []any{int64(2025), nil}, column(batches, 1))
+}
+
+func TestListingParquet(t *testing.T) {
+	ctx := &ExecutionContext{BatchSize: 10, TargetPartitions: 4}
+	dir := filepath.Join(t.TempDir(), "events")
+	require.NoError(t, ctx.Csv(writeEventsCsv(t, 100, 4)).
+		WriteParquet(context.Background(), dir, ParquetWriteOptions{PartitionBy: []string{"user"}}))
+
+	require.NoError(t, ctx.RegisterParquet("events", dir))
+	events, err := ctx.Table("events")
+	require.NoError(t, err)
+	assert.Equal(t, 4, events.LogicalPlan().(Scan).Source.Partitions())
+	batches, err := events.Filter(And(Eq(Col("user"), Str("user-2")), Lt(Col("amount"), Int(20)))).
+		Project([]LogicalExpr{Col("amount")}).
+		Collect(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, []int64{2, 6, 10, 14, 18}, int64Column(batches, 0))
+}
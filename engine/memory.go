@@ -0,0 +1,220 @@
+package engine
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/briansterle/drogo"
+)
+
+// ResourcesExhaustedError is returned when a memory reservation would take a
+// pool past its limit
+type ResourcesExhaustedError struct {
+	Consumer  string
+	Requested int64
+	Reserved  int64
+	Limit     int64
+}
+
+func (e *ResourcesExhaustedError) Error() string {
+	return fmt.Sprintf("resources exhausted: %s failed to reserve %d bytes, %d of %d bytes already reserved",
+		e.Consumer, e.Requested, e.Reserved, e.Limit)
+}
+
+// MemoryPool tracks the memory reserved by running operators. Pools form a
+// tree: an ExecutionContext owns a session pool and each query gets a child
+// pool, so a reservation must fit within the query limit and the session
+// limit.
+//
+// The limit is a heuristic: operators reserve an estimate of the rows they
+// buffer (sorted rows, aggregate groups, queued batches). Arrays are
+// allocated from drogo.DefaultAllocator, or the allocator an operator passes
+// to drogo.NewWithAllocator, and the batches flowing between operators are
+// not reserved, so actual memory use can exceed the limit.
+type MemoryPool struct {
+	parent   *MemoryPool
+	limit    int64
+	mu       sync.Mutex
+	reserved int64
+	peak     int64
+}
+
+// NewMemoryPool creates a pool that allows at most limit bytes to be reserved.
+// A limit of zero means no limit.
+func NewMemoryPool(limit int64) *MemoryPool {
+	return &MemoryPool{limit: limit}
+}
+
+// NewChild creates a pool whose reservations also count against p
+func (p *MemoryPool) NewChild(limit int64) *MemoryPool {
+	return &MemoryPool{parent: p, limit: limit}
+}
+
+func (p *MemoryPool) Limit() int64 {
+	return p.limit
+}
+
+// Reserved is the number of bytes currently reserved from the pool
+func (p *MemoryPool) Reserved() int64 {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.reserved
+}
+
+// Peak is the largest number of bytes that have been reserved at once
+func (p *MemoryPool) Peak() int64 {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.peak
+}
+
+func (p *MemoryPool) tryGrow(consumer string, n int64) error {
+	p.mu.Lock()
+	if p.limit > 0 && p.reserved+n > p.limit {
+		err := &ResourcesExhaustedError{consumer, n, p.reserved, p.limit}
+		p.mu.Unlock()
+		return err
+	}
+	p.reserved += n
+	p.mu.Unlock()
+
+	if p.parent != nil {
+		if err := p.parent.tryGrow(consumer, n); err != nil {
+			p.mu.Lock()
+			p.reserved -= n
+			p.mu.Unlock()
+			return err
+		}
+	}
+
+	p.mu.Lock()
+	if p.reserved > p.peak {
+		p.peak = p.reserved
+	}
+	p.mu.Unlock()
+	return nil
+}
+
+func (p *MemoryPool) shrink(n int64) {
+	p.mu.Lock()
+	p.reserved -= n
+	p.mu.Unlock()
+	if p.parent != nil {
+		p.parent.shrink(n)
+	}
+}
+
+// MemoryConsumer is implemented by operators that can free memory by
+// spilling their state to disk. Spill is called when the consumer's own
+// reservation cannot grow, and must shrink that reservation.
+type MemoryConsumer interface {
+	Spill() error
+}
+
+// MemoryReservation is the memory held by a single operator
+type MemoryReservation struct {
+	pool     *MemoryPool
+	consumer string
+	spiller  MemoryConsumer
+	size     int64
+	// metrics are those of the operator holding the reservation, if any
+	metrics *Metrics
+}
+
+// NewReservation creates an empty reservation for the named consumer
+func (p *MemoryPool) NewReservation(consumer string) *MemoryReservation {
+	return &MemoryReservation{pool: p, consumer: consumer}
+}
+
+// NewSpillableReservation creates a reservation for a consumer that spills to
+// disk instead of failing when the pool is exhausted
+func (p *MemoryPool) NewSpillableReservation(consumer string, spiller MemoryConsumer) *MemoryReservation {
+	return &MemoryReservation{pool: p, consumer: consumer, spiller: spiller}
+}
+
+func (r *MemoryReservation) Size() int64 {
+	return r.size
+}
+
+// TryGrow reserves n more bytes. If the pool is exhausted a spillable
+// consumer is asked to spill once before the error is returned.
+func (r *MemoryReservation) TryGrow(n int64) error {
+	err := r.pool.tryGrow(r.consumer, n)
+	if err != nil && r.spiller != nil {
+		if err := r.spiller.Spill(); err != nil {
+			return err
+		}
+		err = r.pool.tryGrow(r.consumer, n)
+	}
+	if err != nil {
+		return err
+	}
+	r.size += n
+	r.metrics.growMemory(n)
+	return nil
+}
+
+// Shrink releases n bytes back to the pool
+func (r *MemoryReservation) Shrink(n int64) {
+	if n > r.size {
+		n = r.size
+	}
+	r.pool.shrink(n)
+	r.size -= n
+	r.metrics.shrinkMemory(n)
+}
+
+// Free releases everything held by the reservation
+func (r *MemoryReservation) Free() {
+	r.Shrink(r.size)
+}
+
+// TaskContext carries the state that is shared by all operators executing a
+// single query
+type TaskContext struct {
+	Memory *MemoryPool
+	// TempDir is where operators spill to disk. Empty means os.TempDir.
+	TempDir string
+}
+
+// NewTaskContext returns a context with an unlimited memory pool
+func NewTaskContext() *TaskContext {
+	return &TaskContext{Memory: NewMemoryPool(0)}
+}
+
+// estimateSize approximates the memory held by values buffered as a row: an
+// interface header per value plus the bytes of strings
+func estimateSize(values []any) int64 {
+	size := int64(16 * len(values))
+	for _, v := range values {
+		if s, ok := v.(string); ok {
+			size += int64(len(s))
+		}
+	}
+	return size
+}
+
+// estimateBatchSize approximates the memory held by a batch: the arrow
+// buffers of materialized columns, the keys and distinct values of
+// dictionary-encoded ones, and per value estimates for other vectors
+func estimateBatchSize(batch RecordBatch) int64 {
+	var size int64
+	for _, f := range batch.Fields {
+		if arr, ok := f.(drogo.Array); ok {
+			for _, buf := range arr.ArrowArray().Data().Buffers() {
+				if buf != nil {
+					size += int64(buf.Len())
+				}
+			}
+			continue
+		}
+		if dict, ok := f.(DictionaryVector); ok {
+			size += 4*int64(len(dict.Keys)) + estimateBatchSize(RecordBatch{Fields: []ColumnVector{dict.Values}})
+			continue
+		}
+		for i := 0; i < f.Len(); i++ {
+			size += estimateSize([]any{f.GetValue(i)})
+		}
+	}
+	return size
+}
@@ -0,0 +1,72 @@
+package engine
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMemoryPoolLimits(t *testing.T) {
+	session := NewMemoryPool(100)
+	query := session.NewChild(60)
+
+	r := query.NewReservation("test")
+	require.NoError(t, r.TryGrow(50))
+	assert.Equal(t, int64(50), session.Reserved())
+
+	err := r.TryGrow(20)
+	var exhausted *ResourcesExhaustedError
+	require.True(t, errors.As(err, &exhausted))
+	assert.Equal(t, "test", exhausted.Consumer)
+	assert.Equal(t, int64(60), exhausted.Limit)
+
+	// the query limit allows it but the session does not
+	other := session.NewChild(0).NewReservation("other")
+	require.NoError(t, other.TryGrow(40))
+	require.Error(t, other.TryGrow(20))
+	assert.Equal(t, int64(90), session.Reserved(), "failed reservations should be rolled back")
+
+	r.Free()
+	other.Free()
+	assert.Equal(t, int64(0), session.Reserved())
+	assert.Equal(t, int64(90), session.Peak())
+}
+
+type testSpiller struct {
+	reservation *MemoryReservation
+	spills      int
+}
+
+func (s *testSpiller) Spill() error {
+	s.spills++
+	s.reservation.Free()
+	return nil
+}
+
+func TestSpillableReservation(t *testing.T) {
+	pool := NewMemoryPool(100)
+	spiller := &testSpiller{}
+	spiller.reservation = pool.NewSpillableReservation("spiller", spiller)
+
+	require.NoError(t, spiller.reservation.TryGrow(80))
+	require.NoError(t, spiller.reservation.TryGrow(80))
+	assert.Equal(t, 1, spiller.spills)
+	assert.Equal(t, int64(80), pool.Reserved())
+}
+
+func TestQueryMemoryLimitExceeded(t *testing.T) {
+	ctx := &ExecutionContext{QueryMemoryLimit: 256}
+	df := ctx.Csv("testdata/employees.csv").
+		Sort([]SortExpr{Asc(Col("id"))})
+
+	stream, err := ctx.Execute(context.Background(), df)
+	require.NoError(t, err)
+	_, err = ReadAll(stream)
+	var exhausted *ResourcesExhaustedError
+	require.True(t, errors.As(err, &exhausted))
+	assert.Equal(t, "SortExec", exhausted.Consumer)
+	assert.Equal(t, int64(0), ctx.MemoryPool().Reserved(), "memory should be released on close")
+}
@@ -0,0 +1,66 @@
+package engine
+
+import (
+	"context"
+	"fmt"
+)
+
+// MemTable is a data source over record batches that are already in memory.
+// The batches are dealt round-robin to the table's partitions.
+type MemTable struct {
+	Schema     Schema
+	batches    []RecordBatch
+	partitions int
+}
+
+// NewMemTable returns a table of the batches, which must all have the
+// table's column names and types
+func NewMemTable(schema Schema, batches []RecordBatch) (*MemTable, error) {
+	for i, batch := range batches {
+		if !sameColumns(schema, batch.Schema) {
+			return nil, fmt.Errorf("batch %d has schema %s, expected %s", i, batch.Schema, schema)
+		}
+	}
+	return &MemTable{Schema: schema, batches: batches, partitions: 1}, nil
+}
+
+// WithPartitions allows the batches to be scanned as up to n partitions
+func (t *MemTable) WithPartitions(n int) *MemTable {
+	t.partitions = n
+	return t
+}
+
+func (t *MemTable) GetSchema() Schema {
+	return t.Schema
+}
+
+func (t *MemTable) Partitions() int {
+	n := t.partitions
+	if len(t.batches) < n {
+		n = len(t.batches)
+	}
+	if n < 1 {
+		return 1
+	}
+	return n
+}
+
+func (t *MemTable) Scan(ctx context.Context, projection []string, fetch int, partition int) RecordBatchStream {
+	schema := t.Schema
+	if len(projection) > 0 {
+		schema = schema.Select(projection)
+	}
+	indices := make([]int, len(schema.Fields()))
+	for i, f := range schema.Fields() {
+		indices[i] = t.Schema.FieldIndices(f.Name)[0]
+	}
+	batches := []RecordBatch{}
+	for i := partition; i < len(t.batches); i += t.Partitions() {
+		batches = append(batches, selectColumns(t.batches[i], schema, indices))
+	}
+	if fetch <= 0 {
+		fetch = -1
+	}
+	// the limit also stops the scan once ctx is done
+	return &limitStream{ctx: ctx, input: NewRecordBatchStream(batches...), fetch: fetch}
+}
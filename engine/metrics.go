@@ -0,0 +1,210 @@
+package engine
+
+import (
+	"fmt"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// Metrics are the runtime statistics of a physical operator, added up over
+// every partition it executes. The QueryPlanner gives each operator it
+// creates its own Metrics. Operators built by hand have nil Metrics, which
+// record nothing.
+type Metrics struct {
+	outputRows    atomic.Int64
+	outputBatches atomic.Int64
+	// elapsed is the time spent in the operator's Next calls, including the
+	// time its inputs took to produce their batches
+	elapsed atomic.Int64
+	// memory is the memory reserved by all partitions of the operator
+	memory       atomic.Int64
+	memoryPeak   atomic.Int64
+	spilledBytes atomic.Int64
+	spillCount   atomic.Int64
+}
+
+func NewMetrics() *Metrics {
+	return &Metrics{}
+}
+
+func (m *Metrics) OutputRows() int64 {
+	if m == nil {
+		return 0
+	}
+	return m.outputRows.Load()
+}
+
+func (m *Metrics) OutputBatches() int64 {
+	if m == nil {
+		return 0
+	}
+	return m.outputBatches.Load()
+}
+
+// Elapsed is the time the operator took to produce its output, including
+// the time its inputs took
+func (m *Metrics) Elapsed() time.Duration {
+	if m == nil {
+		return 0
+	}
+	return time.Duration(m.elapsed.Load())
+}
+
+// MemoryPeak is the most memory the operator's partitions had reserved at
+// once
+func (m *Metrics) MemoryPeak() int64 {
+	if m == nil {
+		return 0
+	}
+	return m.memoryPeak.Load()
+}
+
+// SpilledBytes is the number of bytes the operator wrote to spill files
+func (m *Metrics) SpilledBytes() int64 {
+	if m == nil {
+		return 0
+	}
+	return m.spilledBytes.Load()
+}
+
+func (m *Metrics) SpillCount() int64 {
+	if m == nil {
+		return 0
+	}
+	return m.spillCount.Load()
+}
+
+// record wraps an operator's output stream to count its rows and batches and
+// time its Next calls
+func (m *Metrics) record(stream RecordBatchStream) RecordBatchStream {
+	if m == nil {
+		return stream
+	}
+	return &meteredStream{stream, m}
+}
+
+func (m *Metrics) growMemory(n int64) {
+	if m == nil {
+		return
+	}
+	reserved := m.memory.Add(n)
+	for {
+		peak := m.memoryPeak.Load()
+		if reserved <= peak || m.memoryPeak.CompareAndSwap(peak, reserved) {
+			return
+		}
+	}
+}
+
+func (m *Metrics) shrinkMemory(n int64) {
+	if m != nil {
+		m.memory.Add(-n)
+	}
+}
+
+func (m *Metrics) recordSpill(bytes int64) {
+	if m != nil {
+		m.spilledBytes.Add(bytes)
+		m.spillCount.Add(1)
+	}
+}
+
+type meteredStream struct {
+	input   RecordBatchStream
+	metrics *Metrics
+}
+
+func (s *meteredStream) Next() (RecordBatch, error) {
+	start := time.Now()
+	batch, err := s.input.Next()
+	s.metrics.elapsed.Add(int64(time.Since(start)))
+	if err == nil {
+		s.metrics.outputRows.Add(int64(batch.RowCount()))
+		s.metrics.outputBatches.Add(1)
+	}
+	return batch, err
+}
+
+func (s *meteredStream) Close() error {
+	return s.input.Close()
+}
+
+// FormatPhysical renders a physical plan as an indented tree, like Format
+// does for logical plans
+func FormatPhysical(plan PhysicalPlan, indent int) string {
+	return formatPhysical(plan, indent, false)
+}
+
+// FormatMetrics renders a physical plan that has been executed with the
+// metrics of each operator. The input rows of an operator are the output
+// rows of its inputs, and its compute time excludes the time they took.
+func FormatMetrics(plan PhysicalPlan, indent int) string {
+	return formatPhysical(plan, indent, true)
+}
+
+func formatPhysical(plan PhysicalPlan, indent int, metrics bool) string {
+	var sb strings.Builder
+	sb.WriteString(strings.Repeat("\t", indent))
+	sb.WriteString(fmt.Sprint(plan))
+	if metrics {
+		m := operatorMetrics(plan)
+		values := []string{fmt.Sprintf("output_rows=%d", m.OutputRows)}
+		if len(plan.Children()) > 0 {
+			values = append(values, fmt.Sprintf("input_rows=%d", m.InputRows))
+		}
+		values = append(values,
+			fmt.Sprintf("output_batches=%d", m.OutputBatches),
+			fmt.Sprintf("elapsed_compute=%s", time.Duration(m.ElapsedCompute)),
+			fmt.Sprintf("memory_peak=%d", m.MemoryPeak),
+			fmt.Sprintf("spilled_bytes=%d", m.SpilledBytes),
+		)
+		if m.SpillCount > 0 {
+			values = append(values, fmt.Sprintf("spill_count=%d", m.SpillCount))
+		}
+		sb.WriteString(", metrics=[" + strings.Join(values, ", ") + "]")
+	}
+	sb.WriteRune('\n')
+	for _, child := range plan.Children() {
+		sb.WriteString(formatPhysical(child, indent+1, metrics))
+	}
+	return sb.String()
+}
+
+// OperatorMetrics are the metrics of one operator as they are rendered, with
+// the input rows and compute time derived from the operator's inputs
+type OperatorMetrics struct {
+	OutputRows    int64 `json:"output_rows"`
+	InputRows     int64 `json:"input_rows"`
+	OutputBatches int64 `json:"output_batches"`
+	// ElapsedCompute is the time in nanoseconds the operator spent on its
+	// own work, excluding the time its inputs took
+	ElapsedCompute int64 `json:"elapsed_compute_ns"`
+	MemoryPeak     int64 `json:"memory_peak"`
+	SpilledBytes   int64 `json:"spilled_bytes"`
+	SpillCount     int64 `json:"spill_count"`
+}
+
+func operatorMetrics(plan PhysicalPlan) OperatorMetrics {
+	m := plan.Metrics()
+	elapsed := m.Elapsed()
+	var inputRows int64
+	for _, child := range plan.Children() {
+		inputRows += child.Metrics().OutputRows()
+		elapsed -= child.Metrics().Elapsed()
+	}
+	if elapsed < 0 {
+		// inputs that run on their own goroutines can take longer than the
+		// operator spends waiting for them
+		elapsed = 0
+	}
+	return OperatorMetrics{
+		OutputRows:     m.OutputRows(),
+		InputRows:      inputRows,
+		OutputBatches:  m.OutputBatches(),
+		ElapsedCompute: int64(elapsed),
+		MemoryPeak:     m.MemoryPeak(),
+		SpilledBytes:   m.SpilledBytes(),
+		SpillCount:     m.SpillCount(),
+	}
+}
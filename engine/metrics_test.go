@@ -0,0 +1,76 @@
+package engine
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// findExec returns the first operator of the plan, in depth first order,
+// whose description starts with prefix
+func findExec(plan PhysicalPlan, prefix string) PhysicalPlan {
+	if strings.HasPrefix(FormatPhysical(plan, 0), prefix) {
+		return plan
+	}
+	for _, child := range plan.Children() {
+		if found := findExec(child, prefix); found != nil {
+			return found
+		}
+	}
+	return nil
+}
+
+func TestExplain(t *testing.T) {
+	ec := sqlContext(t)
+	rows := sqlRows(t, ec, "EXPLAIN SELECT state, SUM(salary) FROM employees WHERE id > 1 GROUP BY state ORDER BY 2 DESC LIMIT 2")
+	require.Len(t, rows, 3)
+	assert.Equal(t, []any{"logical_plan", "optimized_logical_plan", "physical_plan"}, []any{rows[0][0], rows[1][0], rows[2][0]})
+	assert.Contains(t, rows[0][1], "Scan: employees; projection=None")
+	assert.Contains(t, rows[1][1], "Sort: #SUM(salary) DESC; fetch=2")
+	assert.Contains(t, rows[2][1], "\t\tCoalescePartitionsExec\n\t\t\tTopKExec: [#1 DESC], k=2\n")
+	assert.Contains(t, rows[2][1], "ScanExec: columns=id,state,salary\n")
+}
+
+func TestExplainAnalyze(t *testing.T) {
+	ec := sqlContext(t)
+	rows := sqlRows(t, ec, "EXPLAIN ANALYZE SELECT state, SUM(salary) FROM employees WHERE id > 1 GROUP BY state ORDER BY 2 DESC LIMIT 2")
+	require.Len(t, rows, 1)
+	assert.Equal(t, "physical_plan_with_metrics", rows[0][0])
+	plan := rows[0][1].(string)
+	assert.Contains(t, plan, "LimitExec: skip=0, fetch=2, metrics=[output_rows=2, input_rows=2, output_batches=1, elapsed_compute=")
+	assert.Contains(t, plan, "SelectionExec: #0 > 1, metrics=[output_rows=7, input_rows=8, ")
+	assert.Contains(t, plan, "ScanExec: columns=id,state,salary, metrics=[output_rows=8, output_batches=3, ")
+
+	df, err := ec.Table("employees")
+	require.NoError(t, err)
+	_, physicalPlan, err := ec.plan(context.Background(), df.Sort([]SortExpr{Desc(Col("salary"))}))
+	require.NoError(t, err)
+	stream := ec.execute(context.Background(), func() {}, physicalPlan)
+	_, err = ReadAll(stream)
+	require.NoError(t, err)
+	sort := findExec(physicalPlan, "SortExec").Metrics()
+	assert.Equal(t, int64(8), sort.OutputRows())
+	assert.Greater(t, sort.MemoryPeak(), int64(0))
+	assert.Greater(t, sort.Elapsed(), findExec(physicalPlan, "ScanExec").Metrics().Elapsed())
+	assert.Zero(t, sort.SpilledBytes())
+}
+
+func TestSpillMetrics(t *testing.T) {
+	ec := &ExecutionContext{QueryMemoryLimit: 64 * 1024, TempDir: t.TempDir(), TargetPartitions: 1}
+	df := ec.Csv(writeEventsCsv(t, 20000, 2000)).
+		Aggregate([]LogicalExpr{Col("user")}, []AggregateExpr{Sum(Col("amount"))})
+	_, physicalPlan, err := ec.plan(context.Background(), df)
+	require.NoError(t, err)
+	_, err = ReadAll(ec.execute(context.Background(), func() {}, physicalPlan))
+	require.NoError(t, err)
+
+	aggregate := physicalPlan.Metrics()
+	assert.Equal(t, int64(2000), aggregate.OutputRows())
+	assert.Greater(t, aggregate.SpillCount(), int64(0))
+	assert.Greater(t, aggregate.SpilledBytes(), int64(0))
+	assert.LessOrEqual(t, aggregate.MemoryPeak(), int64(64*1024))
+	assert.Contains(t, FormatMetrics(physicalPlan, 0), ", spill_count=")
+}
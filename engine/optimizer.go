@@ -0,0 +1,306 @@
+package engine
+
+// OptimizerRule rewrites a logical plan into an equivalent, cheaper one
+type OptimizerRule interface {
+	Optimize(plan LogicalPlan) LogicalPlan
+}
+
+type Optimizer struct {
+	Rules []OptimizerRule
+}
+
+func NewOptimizer() Optimizer {
+	return Optimizer{[]OptimizerRule{FilterPushDownRule{}, LimitPushDownRule{}, ProjectionPushDownRule{}}}
+}
+
+func (o Optimizer) Optimize(plan LogicalPlan) LogicalPlan {
+	for _, rule := range o.Rules {
+		plan = rule.Optimize(plan)
+	}
+	return plan
+}
+
+// withChildren returns a copy of plan with its inputs replaced
+func withChildren(plan LogicalPlan, children []LogicalPlan) LogicalPlan {
+	switch p := plan.(type) {
+	case Projection:
+		p.Input = children[0]
+		return p
+	case Selection:
+		p.Input = children[0]
+		return p
+	case Aggregate:
+		p.Input = children[0]
+		return p
+	case Limit:
+		p.Input = children[0]
+		return p
+	case Sort:
+		p.Input = children[0]
+		return p
+	case Unnest:
+		p.Input = children[0]
+		return p
+	default:
+		return plan
+	}
+}
+
+// optimizeChildren applies a rule to each input of plan
+func optimizeChildren(rule OptimizerRule, plan LogicalPlan) LogicalPlan {
+	children := plan.Children()
+	if len(children) == 0 {
+		return plan
+	}
+	optimized := make([]LogicalPlan, len(children))
+	for i, child := range children {
+		optimized[i] = rule.Optimize(child)
+	}
+	return withChildren(plan, optimized)
+}
+
+// LimitPushDownRule moves limits as close to the data as possible so that
+// less data is read and processed:
+//
+//   - adjacent limits are merged into one
+//   - a limit is moved below a projection, which never changes the row count
+//   - a limit above a sort sets the sort's Fetch so it can be planned as a
+//     top-k operator
+//   - a limit above a scan sets the scan's Fetch so the data source can stop
+//     reading early
+//
+// In the last two cases the limit itself is kept since the sort or scan still
+// produces Skip+Fetch rows.
+type LimitPushDownRule struct{}
+
+func (r LimitPushDownRule) Optimize(plan LogicalPlan) LogicalPlan {
+	limit, ok := plan.(Limit)
+	if !ok {
+		return optimizeChildren(r, plan)
+	}
+
+	// number of input rows the limit needs, or zero when it needs them all
+	needed := 0
+	if limit.Fetch > 0 {
+		needed = limit.Skip + limit.Fetch
+	}
+
+	switch input := limit.Input.(type) {
+	case Limit:
+		return r.Optimize(mergeLimits(limit, input))
+	case Projection:
+		input.Input = r.Optimize(Limit{input.Input, limit.Skip, limit.Fetch})
+		return input
+	case Sort:
+		if needed > 0 && (input.Fetch == 0 || needed < input.Fetch) {
+			input.Fetch = needed
+		}
+		limit.Input = optimizeChildren(r, input)
+		return limit
+	case Scan:
+		if needed > 0 && (input.Fetch == 0 || needed < input.Fetch) {
+			input.Fetch = needed
+		}
+		limit.Input = input
+		return limit
+	default:
+		return optimizeChildren(r, limit)
+	}
+}
+
+// mergeLimits combines outer(inner(input)) into a single limit
+func mergeLimits(outer, inner Limit) Limit {
+	fetch := inner.Fetch
+	if fetch >= 0 {
+		fetch -= outer.Skip
+		if fetch < 0 {
+			fetch = 0
+		}
+	}
+	if outer.Fetch >= 0 && (fetch < 0 || outer.Fetch < fetch) {
+		fetch = outer.Fetch
+	}
+	return Limit{inner.Input, inner.Skip + outer.Skip, fetch}
+}
+
+// FilterPushDownRule copies the predicates of filters directly above a scan
+// into the scan's Filters when its data source can use them to skip data.
+// The filters are kept since the source may still return rows that do not
+// match, unless the source evaluates them exactly.
+type FilterPushDownRule struct{}
+
+func (r FilterPushDownRule) Optimize(plan LogicalPlan) LogicalPlan {
+	selection, ok := plan.(Selection)
+	if !ok {
+		return optimizeChildren(r, plan)
+	}
+	// look through a stack of filters for the scan below them
+	predicates := splitConjunction(selection.Expr)
+	input := selection.Input
+	for {
+		inner, ok := input.(Selection)
+		if !ok {
+			break
+		}
+		predicates = append(predicates, splitConjunction(inner.Expr)...)
+		input = inner.Input
+	}
+	scan, ok := input.(Scan)
+	if !ok {
+		return optimizeChildren(r, plan)
+	}
+	if _, ok := scan.Source.(FilteredDataSource); !ok {
+		return plan
+	}
+	scan.Filters = append(append([]LogicalExpr{}, scan.Filters...), predicates...)
+	exact, ok := scan.Source.(ExactFilteredDataSource)
+	if !ok {
+		return replaceScan(plan, scan)
+	}
+	remaining := []LogicalExpr{}
+	for _, p := range predicates {
+		if !exact.ExactFilter(p) {
+			remaining = append(remaining, p)
+		}
+	}
+	if len(remaining) == 0 {
+		return scan
+	}
+	expr := remaining[0]
+	for _, p := range remaining[1:] {
+		expr = And(expr, p)
+	}
+	return Selection{scan, expr}
+}
+
+// splitConjunction returns the operands of a chain of ANDs
+func splitConjunction(expr LogicalExpr) []LogicalExpr {
+	if e, ok := expr.(BooleanBinaryExpr); ok && e.Op == "AND" {
+		return append(splitConjunction(e.L), splitConjunction(e.R)...)
+	}
+	return []LogicalExpr{expr}
+}
+
+// replaceScan swaps the scan at the bottom of a single-input plan for scan
+func replaceScan(plan LogicalPlan, scan Scan) LogicalPlan {
+	if _, ok := plan.(Scan); ok {
+		return scan
+	}
+	return withChildren(plan, []LogicalPlan{replaceScan(plan.Children()[0], scan)})
+}
+
+// ProjectionPushDownRule sets the projection of each scan to the columns the
+// plan above it uses, so that the data source can skip reading the others
+type ProjectionPushDownRule struct{}
+
+func (r ProjectionPushDownRule) Optimize(plan LogicalPlan) LogicalPlan {
+	return pushProjection(plan, nil)
+}
+
+// pushProjection narrows the scans below plan, of whose output only the
+// required columns are used. A nil set means every column is used.
+func pushProjection(plan LogicalPlan, required map[string]bool) LogicalPlan {
+	switch p := plan.(type) {
+	case Scan:
+		if required == nil || len(p.Projection) > 0 {
+			return p
+		}
+		projection := []string{}
+		for _, f := range p.Source.GetSchema().Fields() {
+			if required[f.Name] {
+				projection = append(projection, f.Name)
+			}
+		}
+		// with no columns used the scan still has to produce the rows
+		if len(projection) > 0 {
+			p.Projection = projection
+		}
+		return p
+	case Projection:
+		required = exprColumns(p.Expr...)
+	case Aggregate:
+		exprs := append([]LogicalExpr{}, p.GroupExpr...)
+		for _, e := range p.AggregateExpr {
+			if e.Expr != nil {
+				exprs = append(exprs, e.Expr)
+			}
+		}
+		required = exprColumns(exprs...)
+	case Selection:
+		required = unionColumns(required, exprColumns(p.Expr))
+	case Sort:
+		exprs := make([]LogicalExpr, len(p.Expr))
+		for i, e := range p.Expr {
+			exprs[i] = e.Expr
+		}
+		required = unionColumns(required, exprColumns(exprs...))
+	case Unnest:
+		required = unionColumns(required, map[string]bool{p.Column: true})
+	case Limit:
+	default:
+		required = nil
+	}
+	children := plan.Children()
+	optimized := make([]LogicalPlan, len(children))
+	for i, child := range children {
+		optimized[i] = pushProjection(child, required)
+	}
+	return withChildren(plan, optimized)
+}
+
+// exprColumns returns the names of the columns the expressions refer to, or
+// nil if they contain an expression it does not know
+func exprColumns(exprs ...LogicalExpr) map[string]bool {
+	columns := map[string]bool{}
+	var visit func(expr LogicalExpr) bool
+	visit = func(expr LogicalExpr) bool {
+		switch e := expr.(type) {
+		case Column:
+			columns[e.name] = true
+		case LiteralString, LiteralInt64, LiteralFloat64:
+		case Alias:
+			return visit(e.Expr)
+		case BooleanBinaryExpr:
+			return visit(e.L) && visit(e.R)
+		case MathExpr:
+			return visit(e.L) && visit(e.R)
+		case ScalarFunction:
+			for _, arg := range e.Args {
+				if !visit(arg) {
+					return false
+				}
+			}
+		case StringFunction:
+			for _, arg := range e.Args {
+				if !visit(arg) {
+					return false
+				}
+			}
+		case LikeExpr:
+			return visit(e.Expr) && visit(e.Pattern)
+		default:
+			return false
+		}
+		return true
+	}
+	for _, expr := range exprs {
+		if !visit(expr) {
+			return nil
+		}
+	}
+	return columns
+}
+
+func unionColumns(a, b map[string]bool) map[string]bool {
+	if a == nil || b == nil {
+		return nil
+	}
+	out := map[string]bool{}
+	for c := range a {
+		out[c] = true
+	}
+	for c := range b {
+		out[c] = true
+	}
+	return out
+}
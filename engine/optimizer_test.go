@@ -0,0 +1,107 @@
+package engine
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLimitPushDownThroughProjection(t *testing.T) {
+	ctx := &ExecutionContext{}
+	plan := ctx.Csv("employees.csv").
+		Project([]LogicalExpr{Col("id"), Col("first_name")}).
+		Limit(10).
+		LogicalPlan()
+
+	actual := Format(NewOptimizer().Optimize(plan), 0)
+	expected := `Projection: #id, #first_name
+	Limit: skip=0, fetch=10
+		Scan: employees.csv; projection=None; fetch=10
+`
+	assert.Equal(t, expected, actual, "plan should equal")
+}
+
+func TestLimitPushDownMergesLimits(t *testing.T) {
+	ctx := &ExecutionContext{}
+	plan := ctx.Csv("employees.csv").
+		Limit(10).
+		Offset(5).
+		LogicalPlan()
+
+	actual := Format(NewOptimizer().Optimize(plan), 0)
+	expected := `Limit: skip=5, fetch=5
+	Scan: employees.csv; projection=None; fetch=10
+`
+	assert.Equal(t, expected, actual, "plan should equal")
+}
+
+func TestLimitPushDownIntoSort(t *testing.T) {
+	ctx := &ExecutionContext{}
+	plan := ctx.Csv("employees.csv").
+		Sort([]SortExpr{Desc(Col("salary"))}).
+		Offset(2).
+		Limit(3).
+		LogicalPlan()
+
+	actual := Format(NewOptimizer().Optimize(plan), 0)
+	expected := `Limit: skip=2, fetch=3
+	Sort: #salary DESC; fetch=5
+		Scan: employees.csv; projection=None
+`
+	assert.Equal(t, expected, actual, "plan should equal")
+}
+
+func TestLimitNotPushedThroughFilter(t *testing.T) {
+	ctx := &ExecutionContext{}
+	plan := ctx.Csv("employees.csv").
+		Filter(Eq(Col("state"), Str("CO"))).
+		Limit(1).
+		LogicalPlan()
+
+	actual := Format(NewOptimizer().Optimize(plan), 0)
+	expected := `Limit: skip=0, fetch=1
+	Filter: #state = 'CO'
+		Scan: employees.csv; projection=None
+`
+	assert.Equal(t, expected, actual, "plan should equal")
+}
+
+func TestFilterPushDownIntoScan(t *testing.T) {
+	ctx := &ExecutionContext{}
+	plan := ctx.Parquet("events.parquet").
+		Filter(Gt(Col("id"), Int(5))).
+		Filter(And(Lt(Col("id"), Int(10)), Eq(Col("name"), Str("a")))).
+		LogicalPlan()
+
+	actual := Format(NewOptimizer().Optimize(plan), 0)
+	expected := `Filter: #id < 10 AND #name = 'a'
+	Filter: #id > 5
+		Scan: events.parquet; projection=None; filters=[#id < 10 #name = 'a' #id > 5]
+`
+	assert.Equal(t, expected, actual, "plan should equal")
+
+	// CSV sources cannot skip data, so their scans are left alone
+	plan = ctx.Csv("employees.csv").Filter(Gt(Col("id"), Int(5))).LogicalPlan()
+	assert.Equal(t, Format(plan, 0), Format(NewOptimizer().Optimize(plan), 0))
+}
+
+func TestProjectionPushDown(t *testing.T) {
+	ctx := &ExecutionContext{}
+	plan := ctx.Csv("testdata/employees.csv").
+		Filter(Eq(Col("state"), Str("CO"))).
+		Sort([]SortExpr{Desc(Col("salary"))}).
+		Project([]LogicalExpr{Col("first_name"), Alias{Multiply(Col("salary"), Flt(0.1)), "bonus"}}).
+		LogicalPlan()
+
+	actual := Format(NewOptimizer().Optimize(plan), 0)
+	expected := `Projection: #first_name, #salary * 0.1 as bonus
+	Sort: #salary DESC
+		Filter: #state = 'CO'
+			Scan: testdata/employees.csv; projection=[first_name state salary]
+`
+	assert.Equal(t, expected, actual, "plan should equal")
+
+	// every column of a plan without a projection is used
+	plan = ctx.Csv("testdata/employees.csv").Filter(Eq(Col("state"), Str("CO"))).LogicalPlan()
+	assert.Equal(t, Format(plan, 0), Format(NewOptimizer().Optimize(plan), 0))
+}
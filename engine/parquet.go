@@ -0,0 +1,538 @@
+package engine
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+
+	"github.com/apache/arrow/go/v12/arrow"
+	"github.com/apache/arrow/go/v12/arrow/memory"
+	"github.com/apache/arrow/go/v12/parquet/file"
+	"github.com/apache/arrow/go/v12/parquet/metadata"
+	"github.com/apache/arrow/go/v12/parquet/pqarrow"
+	"github.com/briansterle/drogo"
+)
+
+// ParquetDataSource reads one or more Parquet files that share a schema. The
+// schema comes from the first file's metadata. Only the projected columns are
+// decoded, and row groups whose min/max statistics show they cannot match the
+// filters pushed down by the optimizer are skipped.
+//
+// Row groups are the unit of parallelism: they are dealt round-robin to the
+// source's partitions.
+type ParquetDataSource struct {
+	Filenames []string
+	batchSize int
+	// partitions is the most partitions the row groups are spread over
+	partitions int
+	filters    []LogicalExpr
+	// meta is shared with the copies made by WithFilters so the files'
+	// metadata is only read once
+	meta *parquetMetadata
+}
+
+type parquetMetadata struct {
+	once   sync.Once
+	schema Schema
+	// rowGroups is the number of row groups in each file
+	rowGroups []int
+	err       error
+}
+
+func NewParquetDataSource(filenames []string, batchSize int) *ParquetDataSource {
+	return &ParquetDataSource{Filenames: filenames, batchSize: batchSize, partitions: 1, meta: &parquetMetadata{}}
+}
+
+// WithPartitions allows the row groups to be scanned as up to n partitions
+func (ds *ParquetDataSource) WithPartitions(n int) *ParquetDataSource {
+	ds.partitions = n
+	return ds
+}
+
+// WithFilters returns a copy of the source that skips row groups which
+// cannot match all of the filters
+func (ds *ParquetDataSource) WithFilters(filters []LogicalExpr) DataSource {
+	out := *ds
+	out.filters = filters
+	return &out
+}
+
+// load reads the metadata of every file. If a file cannot be read or its
+// schema differs from the first file's, the error is kept and reported by
+// SchemaError and by scans of the source.
+func (ds *ParquetDataSource) load() *parquetMetadata {
+	meta := ds.meta
+	meta.once.Do(func() {
+		if len(ds.Filenames) == 0 {
+			meta.err = errors.New("no parquet files given")
+			return
+		}
+		meta.rowGroups = make([]int, len(ds.Filenames))
+		for i, filename := range ds.Filenames {
+			rdr, err := file.OpenParquetFile(filename, false)
+			if err != nil {
+				meta.err = fmt.Errorf("%s: %w", filename, err)
+				return
+			}
+			schema, err := parquetSchema(rdr)
+			meta.rowGroups[i] = rdr.NumRowGroups()
+			rdr.Close()
+			if err != nil {
+				meta.err = fmt.Errorf("%s: %w", filename, err)
+				return
+			}
+			if i == 0 {
+				meta.schema = schema
+			} else if !sameColumns(meta.schema, schema) {
+				meta.err = fmt.Errorf("%s: schema %s does not match %s", filename, schema, meta.schema)
+				return
+			}
+		}
+	})
+	return meta
+}
+
+// parquetSchema converts the file's schema to arrow, dropping the parquet
+// specific field metadata
+func parquetSchema(rdr *file.Reader) (Schema, error) {
+	fr, err := pqarrow.NewFileReader(rdr, pqarrow.ArrowReadProperties{}, memory.DefaultAllocator)
+	if err != nil {
+		return Schema{}, err
+	}
+	schema, err := fr.Schema()
+	if err != nil {
+		return Schema{}, err
+	}
+	fields := make([]arrow.Field, len(schema.Fields()))
+	for i, f := range schema.Fields() {
+		fields[i] = arrow.Field{Name: f.Name, Type: f.Type, Nullable: f.Nullable}
+	}
+	return Schema{arrow.NewSchema(fields, nil)}, nil
+}
+
+// sameColumns reports whether two schemas have the same column names and types
+func sameColumns(a, b Schema) bool {
+	if len(a.Fields()) != len(b.Fields()) {
+		return false
+	}
+	for i, f := range a.Fields() {
+		g := b.Field(i)
+		if f.Name != g.Name || !arrow.TypeEqual(f.Type, g.Type) {
+			return false
+		}
+	}
+	return true
+}
+
+// GetSchema returns the schema of the first file, or an empty schema if the
+// files could not be read
+func (ds *ParquetDataSource) GetSchema() Schema {
+	meta := ds.load()
+	if meta.err != nil {
+		return Schema{arrow.NewSchema(nil, nil)}
+	}
+	return meta.schema
+}
+
+// SchemaError is the error that prevented the files' metadata from being read
+func (ds *ParquetDataSource) SchemaError() error {
+	return ds.load().err
+}
+
+func (ds *ParquetDataSource) Partitions() int {
+	total := 0
+	for _, n := range ds.load().rowGroups {
+		total += n
+	}
+	n := ds.partitions
+	if total < n {
+		n = total
+	}
+	if n < 1 {
+		return 1
+	}
+	return n
+}
+
+func (ds *ParquetDataSource) getBatchSize() int {
+	if ds.batchSize <= 0 {
+		return defaultBatchSize
+	}
+	return ds.batchSize
+}
+
+// parquetFileScan is the row groups of one file that a partition reads
+type parquetFileScan struct {
+	filename  string
+	rowGroups []int
+}
+
+// assignRowGroups returns the row groups of each file that belong to partition
+func (ds *ParquetDataSource) assignRowGroups(partition int) []parquetFileScan {
+	n := ds.Partitions()
+	scans := []parquetFileScan{}
+	unit := 0
+	for i, count := range ds.load().rowGroups {
+		scan := parquetFileScan{filename: ds.Filenames[i]}
+		for rg := 0; rg < count; rg++ {
+			if unit%n == partition {
+				scan.rowGroups = append(scan.rowGroups, rg)
+			}
+			unit++
+		}
+		if len(scan.rowGroups) > 0 {
+			scans = append(scans, scan)
+		}
+	}
+	return scans
+}
+
+func (ds *ParquetDataSource) Scan(ctx context.Context, projection []string, fetch int, partition int) RecordBatchStream {
+	if err := ds.SchemaError(); err != nil {
+		return &parquetStream{err: err}
+	}
+	schema := ds.GetSchema()
+	if len(projection) > 0 {
+		schema = schema.Select(projection)
+	}
+	for _, f := range schema.Fields() {
+		if !drogoType(f.Type) {
+			return &parquetStream{err: fmt.Errorf("column %s: unsupported type %s", f.Name, f.Type)}
+		}
+	}
+	return &parquetStream{ctx: ctx, source: ds, schema: schema, files: ds.assignRowGroups(partition), fetch: fetch}
+}
+
+// drogoType reports whether drogo.Array can hold values of the arrow type
+func drogoType(dtype arrow.DataType) bool {
+	switch dtype.ID() {
+	case arrow.BOOL, arrow.INT8, arrow.INT16, arrow.INT32, arrow.INT64,
+		arrow.FLOAT32, arrow.FLOAT64, arrow.STRING:
+		return true
+	case arrow.LIST:
+		return drogoType(dtype.(*arrow.ListType).Elem())
+	case arrow.STRUCT:
+		for _, f := range dtype.(*arrow.StructType).Fields() {
+			if !drogoType(f.Type) {
+				return false
+			}
+		}
+		return true
+	default:
+		return false
+	}
+}
+
+type parquetStream struct {
+	ctx    context.Context
+	source *ParquetDataSource
+	schema Schema
+	files  []parquetFileScan
+	fetch  int
+	read   int
+	// err is returned by Next when the stream could not be opened
+	err    error
+	file   *file.Reader
+	reader pqarrow.RecordReader
+}
+
+// open starts reading the next file's row groups that may match the filters.
+// It returns false once there are no files left.
+func (s *parquetStream) open() (bool, error) {
+	for len(s.files) > 0 {
+		scan := s.files[0]
+		s.files = s.files[1:]
+		rdr, err := file.OpenParquetFile(scan.filename, false)
+		if err != nil {
+			return false, err
+		}
+		fr, err := pqarrow.NewFileReader(rdr, pqarrow.ArrowReadProperties{BatchSize: int64(s.source.getBatchSize())}, memory.DefaultAllocator)
+		if err != nil {
+			rdr.Close()
+			return false, err
+		}
+		rowGroups := []int{}
+		for _, rg := range scan.rowGroups {
+			if s.source.mayMatch(fr, rdr.MetaData().RowGroup(rg)) {
+				rowGroups = append(rowGroups, rg)
+			}
+		}
+		if len(rowGroups) == 0 {
+			rdr.Close()
+			continue
+		}
+		columns := []int{}
+		for _, f := range s.schema.Fields() {
+			i := s.source.GetSchema().FieldIndices(f.Name)[0]
+			columns = append(columns, leafColumns(fr.Manifest.Fields[i])...)
+		}
+		reader, err := fr.GetRecordReader(s.ctx, columns, rowGroups)
+		if err != nil {
+			rdr.Close()
+			return false, fmt.Errorf("%s: %w", scan.filename, err)
+		}
+		s.file, s.reader = rdr, reader
+		return true, nil
+	}
+	return false, nil
+}
+
+// leafColumns returns the parquet column indices that make up an arrow field
+func leafColumns(field pqarrow.SchemaField) []int {
+	if field.IsLeaf() {
+		return []int{field.ColIndex}
+	}
+	columns := []int{}
+	for _, child := range field.Children {
+		columns = append(columns, leafColumns(child)...)
+	}
+	return columns
+}
+
+func (s *parquetStream) Next() (RecordBatch, error) {
+	if s.err != nil {
+		return RecordBatch{}, s.err
+	}
+	for {
+		if s.source == nil || (s.fetch > 0 && s.read >= s.fetch) {
+			s.Close()
+			return RecordBatch{}, io.EOF
+		}
+		if err := s.ctx.Err(); err != nil {
+			s.Close()
+			return RecordBatch{}, err
+		}
+		if s.reader == nil {
+			ok, err := s.open()
+			if err != nil {
+				s.Close()
+				return RecordBatch{}, err
+			}
+			if !ok {
+				s.Close()
+				return RecordBatch{}, io.EOF
+			}
+		}
+		if !s.reader.Next() {
+			err := s.reader.Err()
+			s.closeFile()
+			if err != nil && err != io.EOF {
+				s.Close()
+				return RecordBatch{}, err
+			}
+			continue
+		}
+		record := s.reader.Record()
+		if record.NumRows() == 0 {
+			continue
+		}
+		// the reader releases the record on the next call, so keep our own
+		// reference to the columns
+		record.Retain()
+		rows := int(record.NumRows())
+		if s.fetch > 0 && s.fetch-s.read < rows {
+			rows = s.fetch - s.read
+			record = record.NewSlice(0, int64(rows))
+		}
+		s.read += rows
+		fields := make([]ColumnVector, record.NumCols())
+		for i, column := range record.Columns() {
+			fields[i] = drogo.FromArrow(column)
+		}
+		return RecordBatch{s.schema, fields}, nil
+	}
+}
+
+func (s *parquetStream) closeFile() {
+	if s.reader != nil {
+		s.reader.Release()
+		s.reader = nil
+	}
+	if s.file != nil {
+		s.file.Close()
+		s.file = nil
+	}
+}
+
+func (s *parquetStream) Close() error {
+	s.closeFile()
+	s.source = nil
+	s.files = nil
+	return nil
+}
+
+// mayMatch reports whether any row of the row group could satisfy all of
+// the source's filters, judging by the column statistics
+func (ds *ParquetDataSource) mayMatch(fr *pqarrow.FileReader, rowGroup *metadata.RowGroupMetaData) bool {
+	stats := func(name string) (min, max any, allNull, ok bool) {
+		indices := ds.GetSchema().FieldIndices(name)
+		if len(indices) == 0 {
+			return nil, nil, false, false
+		}
+		field := fr.Manifest.Fields[indices[0]]
+		if !field.IsLeaf() {
+			return nil, nil, false, false
+		}
+		chunk, err := rowGroup.ColumnChunk(field.ColIndex)
+		if err != nil {
+			return nil, nil, false, false
+		}
+		if set, err := chunk.StatsSet(); err != nil || !set {
+			return nil, nil, false, false
+		}
+		s, err := chunk.Statistics()
+		if err != nil || s == nil {
+			return nil, nil, false, false
+		}
+		if s.HasNullCount() && s.NullCount() == rowGroup.NumRows() {
+			return nil, nil, true, true
+		}
+		if !s.HasMinMax() {
+			return nil, nil, false, false
+		}
+		min, max, ok = statisticsRange(s, field.Field.Type)
+		return min, max, false, ok
+	}
+	for _, filter := range ds.filters {
+		if !mayMatch(filter, stats) {
+			return false
+		}
+	}
+	return true
+}
+
+// statisticsRange returns a column chunk's min and max as the Go values
+// drogo uses for the arrow type
+func statisticsRange(s metadata.TypedStatistics, dtype arrow.DataType) (any, any, bool) {
+	switch s := s.(type) {
+	case *metadata.BooleanStatistics:
+		return s.Min(), s.Max(), true
+	case *metadata.Int32Statistics:
+		if !arrow.IsSignedInteger(dtype.ID()) {
+			return nil, nil, false
+		}
+		return int64(s.Min()), int64(s.Max()), true
+	case *metadata.Int64Statistics:
+		if dtype.ID() != arrow.INT64 {
+			return nil, nil, false
+		}
+		return s.Min(), s.Max(), true
+	case *metadata.Float32Statistics:
+		return float64(s.Min()), float64(s.Max()), true
+	case *metadata.Float64Statistics:
+		return s.Min(), s.Max(), true
+	case *metadata.ByteArrayStatistics:
+		if dtype.ID() != arrow.STRING {
+			return nil, nil, false
+		}
+		return string(s.Min()), string(s.Max()), true
+	default:
+		return nil, nil, false
+	}
+}
+
+// columnStats looks up the value range of a column. allNull is true when
+// every value is null, and ok is false when nothing is known about it.
+type columnStats func(name string) (min, max any, allNull, ok bool)
+
+// mayMatch reports whether a filter could be true for some row whose columns
+// lie within the ranges given by stats. It only returns false when that is
+// certain, so filters it does not understand always may match.
+func mayMatch(filter LogicalExpr, stats columnStats) bool {
+	e, ok := filter.(BooleanBinaryExpr)
+	if !ok {
+		return true
+	}
+	switch e.Op {
+	case "AND":
+		return mayMatch(e.L, stats) && mayMatch(e.R, stats)
+	case "OR":
+		return mayMatch(e.L, stats) || mayMatch(e.R, stats)
+	}
+	col, lit, op := e.L, e.R, e.Op
+	if _, ok := col.(Column); !ok {
+		col, lit, op = e.R, e.L, flipComparison(e.Op)
+	}
+	c, ok := col.(Column)
+	if !ok {
+		return true
+	}
+	value, ok := literalValue(lit)
+	if !ok {
+		return true
+	}
+	min, max, allNull, ok := stats(c.name)
+	if !ok {
+		return true
+	}
+	if allNull {
+		// comparisons with null are never true
+		return false
+	}
+	if !comparableValues(min, value) {
+		return true
+	}
+	switch op {
+	case "=":
+		return compareValues(min, value) <= 0 && compareValues(max, value) >= 0
+	case "!=":
+		return !(compareValues(min, value) == 0 && compareValues(max, value) == 0)
+	case "<":
+		return compareValues(min, value) < 0
+	case "<=":
+		return compareValues(min, value) <= 0
+	case ">":
+		return compareValues(max, value) > 0
+	case ">=":
+		return compareValues(max, value) >= 0
+	default:
+		return true
+	}
+}
+
+// flipComparison returns the operator that gives the same result with the
+// operands swapped
+func flipComparison(op string) string {
+	switch op {
+	case "<":
+		return ">"
+	case "<=":
+		return ">="
+	case ">":
+		return "<"
+	case ">=":
+		return "<="
+	default:
+		return op
+	}
+}
+
+func literalValue(expr LogicalExpr) (any, bool) {
+	switch e := expr.(type) {
+	case LiteralString:
+		return e.Str, true
+	case LiteralInt64:
+		return e.n, true
+	case LiteralFloat64:
+		return e.n, true
+	default:
+		return nil, false
+	}
+}
+
+// comparableValues reports whether compareValues can order a and b
+func comparableValues(a, b any) bool {
+	switch a.(type) {
+	case string:
+		_, ok := b.(string)
+		return ok
+	case bool:
+		_, ok := b.(bool)
+		return ok
+	}
+	_, okA := toFloat64(a)
+	_, okB := toFloat64(b)
+	return okA && okB
+}
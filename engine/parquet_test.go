@@ -0,0 +1,166 @@
+package engine
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/apache/arrow/go/v12/arrow"
+	"github.com/apache/arrow/go/v12/arrow/array"
+	"github.com/apache/arrow/go/v12/arrow/memory"
+	"github.com/apache/arrow/go/v12/parquet"
+	"github.com/apache/arrow/go/v12/parquet/pqarrow"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// writeEventsParquet writes rows id=first..first+n-1, name=user<id%10>,
+// amount=id*1.5 in row groups of rowGroupSize rows
+func writeEventsParquet(t *testing.T, first, n, rowGroupSize int) string {
+	schema := arrow.NewSchema([]arrow.Field{
+		{Name: "id", Type: arrow.PrimitiveTypes.Int64},
+		{Name: "name", Type: arrow.BinaryTypes.String},
+		{Name: "amount", Type: arrow.PrimitiveTypes.Float64, Nullable: true},
+	}, nil)
+	path := filepath.Join(t.TempDir(), "events.parquet")
+	f, err := os.Create(path)
+	require.NoError(t, err)
+	props := parquet.NewWriterProperties(parquet.WithMaxRowGroupLength(int64(rowGroupSize)))
+	w, err := pqarrow.NewFileWriter(schema, f, props, pqarrow.DefaultWriterProps())
+	require.NoError(t, err)
+
+	b := array.NewRecordBuilder(memory.DefaultAllocator, schema)
+	defer b.Release()
+	for i := first; i < first+n; i++ {
+		b.Field(0).(*array.Int64Builder).Append(int64(i))
+		b.Field(1).(*array.StringBuilder).Append("user" + string(rune('0'+i%10)))
+		b.Field(2).(*array.Float64Builder).Append(float64(i) * 1.5)
+	}
+	record := b.NewRecord()
+	defer record.Release()
+	require.NoError(t, w.WriteBuffered(record))
+	require.NoError(t, w.Close())
+	return path
+}
+
+func TestParquetSchemaAndProjection(t *testing.T) {
+	path := writeEventsParquet(t, 0, 250, 100)
+	source := NewParquetDataSource([]string{path}, 64)
+	require.NoError(t, source.SchemaError())
+	assert.Equal(t, "id", source.GetSchema().Field(0).Name)
+	assert.Equal(t, arrow.BinaryTypes.String, source.GetSchema().Field(1).Type)
+
+	batches, err := ReadAll(source.Scan(context.Background(), []string{"amount", "id"}, 0, 0))
+	require.NoError(t, err)
+	require.NotEmpty(t, batches)
+	assert.Equal(t, 2, batches[0].ColumnCount())
+	assert.Equal(t, "amount", batches[0].Schema.Field(0).Name)
+	assert.Equal(t, sequence(250), int64Column(batches, 1))
+	assert.Equal(t, 4.5, batches[0].Field(0).GetValue(3))
+}
+
+func TestParquetFetch(t *testing.T) {
+	path := writeEventsParquet(t, 0, 1000, 100)
+	source := NewParquetDataSource([]string{path}, 64)
+	batches, err := ReadAll(source.Scan(context.Background(), []string{"id"}, 130, 0))
+	require.NoError(t, err)
+	assert.Equal(t, sequence(130), int64Column(batches, 0))
+}
+
+func TestParquetMultipleFilesAndPartitions(t *testing.T) {
+	a := writeEventsParquet(t, 0, 300, 100)
+	b := writeEventsParquet(t, 300, 200, 50)
+	ctx := ExecutionContext{TargetPartitions: 3}
+	df := ctx.Parquet(a, b)
+	require.NoError(t, df.LogicalPlan().(Scan).Source.(*ParquetDataSource).SchemaError())
+	assert.Equal(t, 3, df.LogicalPlan().(Scan).Source.Partitions())
+
+	batches, err := df.Project([]LogicalExpr{Col("id")}).Collect(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, sequence(500), int64Column(batches, 0))
+}
+
+func TestParquetInvalidFiles(t *testing.T) {
+	events := writeEventsParquet(t, 0, 10, 10)
+	ctx := ExecutionContext{}
+	assert.Error(t, ctx.RegisterParquet("events", events, writeEventsCsv(t, 10, 2)))
+	_, err := ctx.Table("events")
+	assert.Error(t, err)
+
+	_, err = ctx.Parquet(filepath.Join(t.TempDir(), "missing.parquet")).Collect(context.Background())
+	assert.Error(t, err)
+
+	// a file with different columns
+	schema := arrow.NewSchema([]arrow.Field{{Name: "id", Type: arrow.PrimitiveTypes.Int32}}, nil)
+	other := filepath.Join(t.TempDir(), "other.parquet")
+	f, err := os.Create(other)
+	require.NoError(t, err)
+	w, err := pqarrow.NewFileWriter(schema, f, nil, pqarrow.DefaultWriterProps())
+	require.NoError(t, err)
+	require.NoError(t, w.Close())
+	err = ctx.RegisterParquet("events", events, other)
+	assert.ErrorContains(t, err, "does not match")
+}
+
+func TestParquetRowGroupPruning(t *testing.T) {
+	path := writeEventsParquet(t, 0, 1000, 100)
+	ctx := ExecutionContext{TargetPartitions: 1}
+	require.NoError(t, ctx.RegisterParquet("events", path))
+	events, err := ctx.Table("events")
+	require.NoError(t, err)
+
+	df := events.Filter(And(GtEq(Col("id"), Int(950)), Neq(Col("name"), Str("x"))))
+	plan := NewOptimizer().Optimize(df.LogicalPlan())
+	scan := plan.Children()[0].(Scan)
+	assert.Len(t, scan.Filters, 2)
+
+	// only the last row group can match, so the scan reads no other rows
+	source := scan.Source.(FilteredDataSource).WithFilters(scan.Filters)
+	scanned, err := ReadAll(source.Scan(context.Background(), []string{"id"}, 0, 0))
+	require.NoError(t, err)
+	assert.Equal(t, int64(900), int64Column(scanned, 0)[0])
+	assert.Len(t, int64Column(scanned, 0), 100)
+
+	batches, err := df.Collect(context.Background())
+	require.NoError(t, err)
+	ids := int64Column(batches, 0)
+	require.Len(t, ids, 50)
+	assert.Equal(t, int64(950), ids[0])
+}
+
+func TestMayMatch(t *testing.T) {
+	stats := func(name string) (any, any, bool, bool) {
+		switch name {
+		case "id":
+			return int64(10), int64(20), false, true
+		case "name":
+			return "b", "d", false, true
+		case "empty":
+			return nil, nil, true, true
+		}
+		return nil, nil, false, false
+	}
+	cases := []struct {
+		filter LogicalExpr
+		want   bool
+	}{
+		{Eq(Col("id"), Int(15)), true},
+		{Eq(Col("id"), Int(25)), false},
+		{Lt(Col("id"), Int(10)), false},
+		{LtEq(Col("id"), Int(10)), true},
+		{Gt(Col("id"), Flt(20.5)), false},
+		{Gt(Int(5), Col("id")), false},
+		{Neq(Col("id"), Int(15)), true},
+		{GtEq(Col("name"), Str("e")), false},
+		{Eq(Col("name"), Str("c")), true},
+		{Or(Eq(Col("id"), Int(1)), Eq(Col("name"), Str("c"))), true},
+		{And(Eq(Col("id"), Int(15)), Eq(Col("name"), Str("a"))), false},
+		{Eq(Col("empty"), Int(1)), false},
+		{Eq(Col("unknown"), Int(1)), true},
+		{Eq(Col("id"), Str("x")), true},
+	}
+	for _, c := range cases {
+		assert.Equal(t, c.want, mayMatch(c.filter, stats), c.filter.String())
+	}
+}
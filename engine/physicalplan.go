@@ -1,7 +1,12 @@
 package engine
 
 import (
+	"container/heap"
+	"context"
 	"fmt"
+	"io"
+	"math"
+	"sort"
 	"strconv"
 	"strings"
 
@@ -9,10 +14,23 @@ import (
 	"github.com/briansterle/drogo"
 )
 
+// PhysicalPlan produces its output as one or more partitions, each of which
+// is executed separately and possibly concurrently. Operators process each
+// partition of their input independently unless noted otherwise, so an
+// operator that needs to see all rows at once must be planned above a
+// CoalescePartitionsExec.
+//
+// Execution stops with the context's error once ctx is done. Every operator
+// checks for cancellation between batches, and the caller must still Close
+// the stream to release its resources.
 type PhysicalPlan interface {
 	GetSchema() Schema
-	Execute() []RecordBatch // should return an iterator?
+	OutputPartitions() int
+	Execute(ctx context.Context, task *TaskContext, partition int) RecordBatchStream
 	Children() []PhysicalPlan
+	// Metrics returns the statistics the operator collects as it executes,
+	// or nil if it collects none
+	Metrics() *Metrics
 }
 
 type Expression interface {
@@ -20,6 +38,27 @@ type Expression interface {
 	String() string
 }
 
+// evaluationError is raised by an expression that fails while it is
+// evaluated, since Evaluate has no error result
+type evaluationError struct {
+	err error
+}
+
+// evaluate evaluates an expression for an operator, returning the error of
+// any expression in it that fails instead of panicking
+func evaluate(e Expression, input RecordBatch) (v ColumnVector, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			failure, ok := r.(evaluationError)
+			if !ok {
+				panic(r)
+			}
+			err = failure.err
+		}
+	}()
+	return e.Evaluate(input), nil
+}
+
 type ColumnExpression struct {
 	i int
 }
@@ -64,97 +103,298 @@ type LiteralStringExpression struct {
 	value string
 }
 
+func (lit LiteralStringExpression) String() string {
+	return fmt.Sprintf("'%s'", lit.value)
+}
+
 func (lit LiteralStringExpression) Evaluate(input RecordBatch) ColumnVector {
 	return LiteralValueVector{drogo.String, lit.value, input.RowCount()}
 }
 
-type BinaryExpression struct {
-	l Expression
-	r Expression
-	BinaryExpressionEvaluator
+// evaluateBinary evaluates both operands of a binary expression and checks
+// they line up row for row
+func evaluateBinary(l, r Expression, input RecordBatch) (ColumnVector, ColumnVector) {
+	ll := l.Evaluate(input)
+	rr := r.Evaluate(input)
+	if ll.Len() != rr.Len() {
+		panic("Binary expression operands do not have the same size")
+	}
+	return ll, rr
 }
 
-type BinaryExpressionEvaluator interface {
-	Evaluate(input RecordBatch) ColumnVector
-	evaluate(l, r ColumnVector) ColumnVector
+// BooleanExpression covers comparisons and the logical AND/OR operators.
+// Numeric operands of different types are compared by value, and any
+// comparison involving null is null.
+type BooleanExpression struct {
+	l  Expression
+	r  Expression
+	op string
 }
 
-func (e BinaryExpression) Evaluate(input RecordBatch) ColumnVector {
-	ll := e.l.Evaluate(input)
-	rr := e.r.Evaluate(input)
-	if ll.Len() != rr.Len() {
-		panic("Binary expression operands do not have the same size")
+func (e BooleanExpression) Evaluate(input RecordBatch) ColumnVector {
+	ll, rr := evaluateBinary(e.l, e.r, input)
+	values := make([]any, ll.Len())
+	for i := range values {
+		values[i] = e.evaluate(ll.GetValue(i), rr.GetValue(i))
 	}
-	if ll.DataType() != rr.DataType() {
-		panic("Binary expression operands do not have the same type")
+	return drogo.New(drogo.Boolean, len(values), values)
+}
+
+func (e BooleanExpression) evaluate(l, r any) any {
+	switch e.op {
+	case "AND":
+		if l == false || r == false {
+			return false
+		}
+		if l == nil || r == nil {
+			return nil
+		}
+		return true
+	case "OR":
+		if l == true || r == true {
+			return true
+		}
+		if l == nil || r == nil {
+			return nil
+		}
+		return false
+	}
+	if l == nil || r == nil {
+		return nil
+	}
+	c := compareValues(l, r)
+	switch e.op {
+	case "=":
+		return c == 0
+	case "!=":
+		return c != 0
+	case ">":
+		return c > 0
+	case ">=":
+		return c >= 0
+	case "<":
+		return c < 0
+	case "<=":
+		return c <= 0
+	default:
+		panic("unsupported boolean operator " + e.op)
 	}
-	return e.evaluate(ll, rr)
 }
 
-func (e BinaryExpression) evaluate(l, r ColumnVector) ColumnVector {
-	return e.BinaryExpressionEvaluator.evaluate(l, r)
+func (e BooleanExpression) String() string {
+	return e.l.String() + " " + e.op + " " + e.r.String()
 }
 
+// MathExpression applies an arithmetic operator after coercing both operands
+// to the result type chosen by the logical plan. Integer division or modulus
+// by zero produces null.
 type MathExpression struct {
-	l Expression
-	r Expression
-	MathExpressionEvaluator
+	l         Expression
+	r         Expression
+	op        string
+	arrowType arrow.DataType
 }
 
-type MathExpressionEvaluator interface {
-	Expression
-	evaluate(l any, r any, arrowType arrow.DataType) any
+func (e MathExpression) Evaluate(input RecordBatch) ColumnVector {
+	ll, rr := evaluateBinary(e.l, e.r, input)
+	values := make([]any, ll.Len())
+	for i := range values {
+		values[i] = e.evaluate(ll.GetValue(i), rr.GetValue(i))
+	}
+	return drogo.New(e.arrowType, len(values), values)
 }
 
-func (e MathExpression) Evaluate(l ColumnVector, r ColumnVector) ColumnVector {
-	values := make([]any, l.Len())
-	for i := 0; i < l.Len(); i++ {
-		value := e.evaluate(l.GetValue(i), r.GetValue(i), l.DataType())
-		values[i] = value
+func (e MathExpression) evaluate(l, r any) any {
+	if l == nil || r == nil {
+		return nil
 	}
+	if arrow.IsFloating(e.arrowType.ID()) {
+		a, _ := toFloat64(l)
+		b, _ := toFloat64(r)
+		var v float64
+		switch e.op {
+		case "+":
+			v = a + b
+		case "-":
+			v = a - b
+		case "*":
+			v = a * b
+		case "/":
+			v = a / b
+		case "%":
+			v = math.Mod(a, b)
+		default:
+			panic("unsupported math operator " + e.op)
+		}
+		return castNumeric(v, e.arrowType)
+	}
+	a, _ := toInt64(l)
+	b, _ := toInt64(r)
+	var v int64
+	switch e.op {
+	case "+":
+		v = a + b
+	case "-":
+		v = a - b
+	case "*":
+		v = a * b
+	case "/":
+		if b == 0 {
+			return nil
+		}
+		v = a / b
+	case "%":
+		if b == 0 {
+			return nil
+		}
+		v = a % b
+	default:
+		panic("unsupported math operator " + e.op)
+	}
+	return castNumeric(v, e.arrowType)
+}
 
-	return drogo.New(l.DataType(), l.Len(), values)
+func (e MathExpression) String() string {
+	return e.l.String() + " " + e.op + " " + e.r.String()
 }
 
-type AddExpression struct {
-	MathExpression
+func toInt64(v any) (int64, bool) {
+	switch n := v.(type) {
+	case int8:
+		return int64(n), true
+	case int16:
+		return int64(n), true
+	case int32:
+		return int64(n), true
+	case int64:
+		return n, true
+	case float32:
+		return int64(n), true
+	case float64:
+		return int64(n), true
+	default:
+		return 0, false
+	}
 }
 
-func (e AddExpression) Evaluate(l any, r any, arrowType arrow.DataType) any {
-	switch arrowType {
-	case drogo.Int64:
-		return l.(int64) + r.(int64)
-	case drogo.Int32:
-		return l.(int32) + r.(int32)
-	case drogo.Int16:
-		return l.(int16) + r.(int16)
-	case drogo.Int8:
-		return l.(int8) + r.(int8)
-	case drogo.Float64:
-		return l.(float64) + r.(float64)
-	case drogo.Float32:
-		return l.(float32) + r.(float32)
+func toFloat64(v any) (float64, bool) {
+	switch n := v.(type) {
+	case int8:
+		return float64(n), true
+	case int16:
+		return float64(n), true
+	case int32:
+		return float64(n), true
+	case int64:
+		return float64(n), true
+	case float32:
+		return float64(n), true
+	case float64:
+		return n, true
 	default:
-		panic("unsupported type")
+		return 0, false
 	}
 }
 
-func (e AddExpression) String() string {
-	return e.l.String() + "+" + e.r.String()
+// castNumeric converts an int64 or float64 into the Go type drogo.New expects
+// for the arrow type
+func castNumeric(v any, arrowType arrow.DataType) any {
+	if arrow.IsFloating(arrowType.ID()) {
+		f, _ := toFloat64(v)
+		if arrowType.ID() == arrow.FLOAT32 {
+			return float32(f)
+		}
+		return f
+	}
+	n, _ := toInt64(v)
+	switch arrowType.ID() {
+	case arrow.INT8:
+		return int8(n)
+	case arrow.INT16:
+		return int16(n)
+	case arrow.INT32:
+		return int32(n)
+	default:
+		return n
+	}
+}
+
+// compareValues orders two non-null values of compatible types. Integers are
+// compared exactly, mixed integer and float operands as float64.
+func compareValues(l, r any) int {
+	switch a := l.(type) {
+	case string:
+		return strings.Compare(a, r.(string))
+	case bool:
+		b := r.(bool)
+		if a == b {
+			return 0
+		}
+		if !a {
+			return -1
+		}
+		return 1
+	}
+	if isInteger(l) && isInteger(r) {
+		a, _ := toInt64(l)
+		b, _ := toInt64(r)
+		return compareOrdered(a, b)
+	}
+	a, okl := toFloat64(l)
+	b, okr := toFloat64(r)
+	if !okl || !okr {
+		panic(fmt.Sprintf("cannot compare %T with %T", l, r))
+	}
+	return compareOrdered(a, b)
 }
 
+func isInteger(v any) bool {
+	switch v.(type) {
+	case int8, int16, int32, int64:
+		return true
+	default:
+		return false
+	}
+}
+
+func compareOrdered[T int64 | float64](a, b T) int {
+	if a < b {
+		return -1
+	}
+	if a > b {
+		return 1
+	}
+	return 0
+}
+
+// AggregateExpression is the physical form of an aggregate function. Its
+// accumulators can export their intermediate state as a row of values
+// described by StateFields, which is how partial aggregates are spilled to
+// disk and merged back.
 type AggregateExpression interface {
 	InputExpression() Expression
 	CreateAccumulator() Accumulator
+	StateFields() []arrow.Field
+	String() string
 }
 
 type Accumulator interface {
 	Accumulate(value any)
 	FinalValue() any
+	// State returns the intermediate state, one value per state field
+	State() []any
+	// Merge folds in the intermediate state of another accumulator
+	Merge(state []any)
+}
+
+// stateField names a state field after the aggregate it belongs to
+func stateField(e AggregateExpression, name string, dataType arrow.DataType) arrow.Field {
+	return arrow.Field{Name: fmt.Sprintf("%s[%s]", e, name), Type: dataType, Nullable: true}
 }
 
 type MaxExpression struct {
-	expr Expression
+	expr     Expression
+	dataType arrow.DataType
 }
 
 // impl aggregate expression
@@ -166,6 +406,10 @@ func (e MaxExpression) CreateAccumulator() Accumulator {
 	return &MaxAccumulator{}
 }
 
+func (e MaxExpression) StateFields() []arrow.Field {
+	return []arrow.Field{stateField(e, "max", e.dataType)}
+}
+
 func (e MaxExpression) String() string {
 	return "MAX(" + e.expr.String() + ")"
 }
@@ -175,6 +419,9 @@ type MaxAccumulator struct {
 }
 
 func (a *MaxAccumulator) Accumulate(value any) {
+	if value == nil {
+		return
+	}
 	if a.value == nil {
 		a.value = value
 		return
@@ -204,6 +451,10 @@ func (a *MaxAccumulator) Accumulate(value any) {
 		if a.value.(float32) < value.(float32) {
 			a.value = value
 		}
+	case string:
+		if a.value.(string) < value.(string) {
+			a.value = value
+		}
 	default:
 		panic("unsupported type")
 	}
@@ -213,20 +464,366 @@ func (a *MaxAccumulator) FinalValue() any {
 	return a.value
 }
 
-// todo implement other physical expressions
+func (a *MaxAccumulator) State() []any {
+	return []any{a.value}
+}
+
+func (a *MaxAccumulator) Merge(state []any) {
+	a.Accumulate(state[0])
+}
+
+type MinExpression struct {
+	expr     Expression
+	dataType arrow.DataType
+}
+
+func (e MinExpression) InputExpression() Expression {
+	return e.expr
+}
+
+func (e MinExpression) CreateAccumulator() Accumulator {
+	return &MinAccumulator{}
+}
+
+func (e MinExpression) StateFields() []arrow.Field {
+	return []arrow.Field{stateField(e, "min", e.dataType)}
+}
+
+func (e MinExpression) String() string {
+	return "MIN(" + e.expr.String() + ")"
+}
+
+type MinAccumulator struct {
+	value any
+}
+
+func (a *MinAccumulator) Accumulate(value any) {
+	if value == nil {
+		return
+	}
+	if a.value == nil || compareValues(value, a.value) < 0 {
+		a.value = value
+	}
+}
+
+func (a *MinAccumulator) FinalValue() any {
+	return a.value
+}
+
+func (a *MinAccumulator) State() []any {
+	return []any{a.value}
+}
+
+func (a *MinAccumulator) Merge(state []any) {
+	a.Accumulate(state[0])
+}
+
+type SumExpression struct {
+	expr     Expression
+	dataType arrow.DataType
+}
+
+func (e SumExpression) InputExpression() Expression {
+	return e.expr
+}
+
+func (e SumExpression) CreateAccumulator() Accumulator {
+	return &SumAccumulator{}
+}
+
+func (e SumExpression) StateFields() []arrow.Field {
+	return []arrow.Field{stateField(e, "sum", e.dataType)}
+}
+
+func (e SumExpression) String() string {
+	return "SUM(" + e.expr.String() + ")"
+}
+
+// SumAccumulator adds values in the type of its input
+type SumAccumulator struct {
+	value any
+}
+
+func (a *SumAccumulator) Accumulate(value any) {
+	if value == nil {
+		return
+	}
+	if a.value == nil {
+		a.value = value
+		return
+	}
+	switch v := value.(type) {
+	case int8:
+		a.value = a.value.(int8) + v
+	case int16:
+		a.value = a.value.(int16) + v
+	case int32:
+		a.value = a.value.(int32) + v
+	case int64:
+		a.value = a.value.(int64) + v
+	case float32:
+		a.value = a.value.(float32) + v
+	case float64:
+		a.value = a.value.(float64) + v
+	default:
+		panic("unsupported type")
+	}
+}
+
+func (a *SumAccumulator) FinalValue() any {
+	return a.value
+}
+
+func (a *SumAccumulator) State() []any {
+	return []any{a.value}
+}
+
+func (a *SumAccumulator) Merge(state []any) {
+	a.Accumulate(state[0])
+}
+
+type AvgExpression struct {
+	expr Expression
+}
+
+func (e AvgExpression) InputExpression() Expression {
+	return e.expr
+}
+
+func (e AvgExpression) CreateAccumulator() Accumulator {
+	return &AvgAccumulator{}
+}
+
+func (e AvgExpression) StateFields() []arrow.Field {
+	return []arrow.Field{
+		stateField(e, "sum", arrow.PrimitiveTypes.Float64),
+		stateField(e, "count", arrow.PrimitiveTypes.Int64),
+	}
+}
+
+func (e AvgExpression) String() string {
+	return "AVG(" + e.expr.String() + ")"
+}
+
+type AvgAccumulator struct {
+	sum   float64
+	count int64
+}
+
+func (a *AvgAccumulator) Accumulate(value any) {
+	if value == nil {
+		return
+	}
+	v, ok := toFloat64(value)
+	if !ok {
+		panic("unsupported type")
+	}
+	a.sum += v
+	a.count++
+}
+
+func (a *AvgAccumulator) FinalValue() any {
+	if a.count == 0 {
+		return nil
+	}
+	return a.sum / float64(a.count)
+}
+
+func (a *AvgAccumulator) State() []any {
+	return []any{a.sum, a.count}
+}
+
+func (a *AvgAccumulator) Merge(state []any) {
+	a.sum += state[0].(float64)
+	a.count += state[1].(int64)
+}
+
+type CountExpression struct {
+	expr Expression
+}
+
+func (e CountExpression) InputExpression() Expression {
+	return e.expr
+}
+
+func (e CountExpression) CreateAccumulator() Accumulator {
+	return &CountAccumulator{}
+}
+
+func (e CountExpression) StateFields() []arrow.Field {
+	return []arrow.Field{stateField(e, "count", arrow.PrimitiveTypes.Int64)}
+}
+
+func (e CountExpression) String() string {
+	return "COUNT(" + e.expr.String() + ")"
+}
+
+// CountAccumulator counts non-null values
+type CountAccumulator struct {
+	count int64
+}
+
+func (a *CountAccumulator) Accumulate(value any) {
+	if value != nil {
+		a.count++
+	}
+}
+
+func (a *CountAccumulator) FinalValue() any {
+	return a.count
+}
+
+func (a *CountAccumulator) State() []any {
+	return []any{a.count}
+}
+
+func (a *CountAccumulator) Merge(state []any) {
+	a.count += state[0].(int64)
+}
+
+// sliceVector is a zero-copy view of length values of v starting at offset
+type sliceVector struct {
+	v      ColumnVector
+	offset int
+	length int
+}
+
+func (s sliceVector) DataType() arrow.DataType {
+	return s.v.DataType()
+}
+
+func (s sliceVector) GetValue(i int) any {
+	if i < 0 || i >= s.length {
+		panic(fmt.Sprintf("index out of bounds %d vecsize: %d", i, s.length))
+	}
+	return s.v.GetValue(s.offset + i)
+}
+
+func (s sliceVector) Len() int {
+	return s.length
+}
+
+// Slice returns a view of length rows of the batch starting at offset
+func (r *RecordBatch) Slice(offset, length int) RecordBatch {
+	fields := make([]ColumnVector, len(r.Fields))
+	for i, f := range r.Fields {
+		fields[i] = sliceVector{f, offset, length}
+	}
+	return RecordBatch{r.Schema, fields}
+}
+
+// Take copies the rows at the given indices into a new batch
+func (r *RecordBatch) Take(indices []int) RecordBatch {
+	fields := make([]ColumnVector, len(r.Fields))
+	for i, f := range r.Fields {
+		values := make([]any, len(indices))
+		for j, row := range indices {
+			values[j] = f.GetValue(row)
+		}
+		fields[i] = drogo.New(f.DataType(), len(values), values)
+	}
+	return RecordBatch{r.Schema, fields}
+}
+
+// mapStream applies f to each batch of its input
+type mapStream struct {
+	ctx   context.Context
+	input RecordBatchStream
+	f     func(RecordBatch) (RecordBatch, error)
+}
+
+func (s *mapStream) Next() (RecordBatch, error) {
+	if err := s.ctx.Err(); err != nil {
+		return RecordBatch{}, err
+	}
+	batch, err := s.input.Next()
+	if err != nil {
+		return batch, err
+	}
+	return s.f(batch)
+}
+
+func (s *mapStream) Close() error {
+	return s.input.Close()
+}
+
+// blockingStream computes all of its output the first time it is polled.
+// Operators such as sort and aggregate use it since they must see their
+// entire input before producing anything. The memory they reserve while
+// buffering is held until the stream is closed.
+type blockingStream struct {
+	input       RecordBatchStream
+	reservation *MemoryReservation
+	compute     func(input RecordBatchStream, reservation *MemoryReservation) ([]RecordBatch, error)
+	output      RecordBatchStream
+}
+
+func (s *blockingStream) Next() (RecordBatch, error) {
+	if s.output == nil {
+		output, err := s.compute(s.input, s.reservation)
+		s.input.Close()
+		if err != nil {
+			return RecordBatch{}, err
+		}
+		s.output = NewRecordBatchStream(output...)
+	}
+	return s.output.Next()
+}
+
+func (s *blockingStream) Close() error {
+	if s.output != nil {
+		s.output.Close()
+	}
+	s.reservation.Free()
+	return s.input.Close()
+}
+
+// forEachBatch calls f with each batch of the stream until the stream is
+// exhausted, either returns an error or ctx is done
+func forEachBatch(ctx context.Context, stream RecordBatchStream, f func(RecordBatch) error) error {
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		batch, err := stream.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if err := f(batch); err != nil {
+			return err
+		}
+	}
+}
 
 // ScanExec is a PhysicalPlan that simply delegates to a datasource
 type ScanExec struct {
 	DataSource DataSource
 	Projection []string
+	// Fetch is passed to the data source so it can stop reading early
+	Fetch   int
+	metrics *Metrics
 }
 
-func (s ScanExec) Schema() Schema {
+func (s ScanExec) GetSchema() Schema {
+	if len(s.Projection) == 0 {
+		return s.DataSource.GetSchema()
+	}
 	return s.DataSource.GetSchema().Select(s.Projection)
 }
 
-func (s ScanExec) Execute() []RecordBatch {
-	return s.DataSource.Scan(s.Projection)
+func (s ScanExec) Execute(ctx context.Context, task *TaskContext, partition int) RecordBatchStream {
+	return s.metrics.record(s.DataSource.Scan(ctx, s.Projection, s.Fetch, partition))
+}
+
+func (s ScanExec) Metrics() *Metrics {
+	return s.metrics
+}
+
+func (s ScanExec) OutputPartitions() int {
+	return s.DataSource.Partitions()
 }
 
 func (s ScanExec) Children() []PhysicalPlan {
@@ -234,16 +831,25 @@ func (s ScanExec) Children() []PhysicalPlan {
 }
 
 func (s ScanExec) String() string {
-	return "ScanExec: schema=" + s.Schema().String() +
-		", projection=" + strings.Join(s.Projection, ",")
+	fields := s.GetSchema().Fields()
+	names := make([]string, len(fields))
+	for i, f := range fields {
+		names[i] = f.Name
+	}
+	str := "ScanExec: columns=" + strings.Join(names, ",")
+	if s.Fetch > 0 {
+		str += ", fetch=" + strconv.Itoa(s.Fetch)
+	}
+	return str
 }
 
 // ProjectionExec simply evaluates the projection expressions and produces
 // a record batch with the derived columns
 type ProjectionExec struct {
-	Input  PhysicalPlan
-	Schema Schema
-	Exprs  []Expression
+	Input   PhysicalPlan
+	Schema  Schema
+	Exprs   []Expression
+	metrics *Metrics
 }
 
 func (p ProjectionExec) String() string {
@@ -254,18 +860,30 @@ func (p ProjectionExec) GetSchema() Schema {
 	return p.Schema
 }
 
-func (p ProjectionExec) Execute() []RecordBatch {
-	input := p.Input.Execute()
-	output := make([]RecordBatch, len(input))
+func (p ProjectionExec) OutputPartitions() int {
+	return p.Input.OutputPartitions()
+}
 
-	for i, batch := range input {
+func (p ProjectionExec) Children() []PhysicalPlan {
+	return []PhysicalPlan{p.Input}
+}
+
+func (p ProjectionExec) Execute(ctx context.Context, task *TaskContext, partition int) RecordBatchStream {
+	return p.metrics.record(&mapStream{ctx, p.Input.Execute(ctx, task, partition), func(batch RecordBatch) (RecordBatch, error) {
 		columns := make([]ColumnVector, len(p.Exprs))
 		for j, expr := range p.Exprs {
-			columns[j] = expr.Evaluate(batch)
+			v, err := evaluate(expr, batch)
+			if err != nil {
+				return RecordBatch{}, err
+			}
+			columns[j] = v
 		}
-		output[i] = RecordBatch{p.Schema, columns}
-	}
-	return output
+		return RecordBatch{p.Schema, columns}, nil
+	}})
+}
+
+func (p ProjectionExec) Metrics() *Metrics {
+	return p.metrics
 }
 
 /*
@@ -283,40 +901,392 @@ optimized for cases where the bit vector contains all ones or all zeros to
 avoid overhead of copying data to new vectors.
 */
 type SelectionExec struct {
-	Input PhysicalPlan
-	Expr  Expression
+	Input   PhysicalPlan
+	Expr    Expression
+	metrics *Metrics
 }
 
 func (s SelectionExec) GetSchema() Schema {
 	return s.Input.GetSchema()
 }
 
+func (s SelectionExec) OutputPartitions() int {
+	return s.Input.OutputPartitions()
+}
+
 func (s SelectionExec) Children() []PhysicalPlan {
 	return []PhysicalPlan{s.Input}
 }
 
-func (s SelectionExec) Execute() []RecordBatch {
-	input := s.Input.Execute()
-	output := make([]RecordBatch, len(input))
-	for i, batch := range input {
-		result := s.Expr.Evaluate(batch)
+func (s SelectionExec) String() string {
+	return fmt.Sprintf("SelectionExec: %s", s.Expr)
+}
+
+func (s SelectionExec) Execute(ctx context.Context, task *TaskContext, partition int) RecordBatchStream {
+	return s.metrics.record(&mapStream{ctx, s.Input.Execute(ctx, task, partition), func(batch RecordBatch) (RecordBatch, error) {
+		result, err := evaluate(s.Expr, batch)
+		if err != nil {
+			return RecordBatch{}, err
+		}
 		schema := batch.Schema
 		columnCount := len(schema.Fields())
 		filtered := make([]ColumnVector, len(batch.Fields))
 		for j := 0; j < columnCount; j++ {
 			filtered[j] = filter(batch.Fields[j], result)
 		}
-		output[i] = RecordBatch{batch.Schema, filtered}
-	}
-	return output
+		return RecordBatch{batch.Schema, filtered}, nil
+	}})
+}
+
+func (s SelectionExec) Metrics() *Metrics {
+	return s.metrics
 }
 
 func filter(v ColumnVector, selection ColumnVector) ColumnVector {
 	var filteredVector []any
 	for i := 0; i < selection.Len(); i++ {
-		if selection.GetValue(i).(bool) {
+		if selection.GetValue(i) == true {
 			filteredVector = append(filteredVector, v.GetValue(i))
 		}
 	}
 	return drogo.New(v.DataType(), len(filteredVector), filteredVector)
 }
+
+// LimitExec skips the first Skip rows of its input and then passes through
+// at most Fetch rows, or all remaining rows when Fetch is negative. It stops
+// pulling from and closes its input as soon as it has enough rows.
+type LimitExec struct {
+	Input   PhysicalPlan
+	Skip    int
+	Fetch   int
+	metrics *Metrics
+}
+
+func (l LimitExec) GetSchema() Schema {
+	return l.Input.GetSchema()
+}
+
+func (l LimitExec) OutputPartitions() int {
+	return l.Input.OutputPartitions()
+}
+
+func (l LimitExec) Children() []PhysicalPlan {
+	return []PhysicalPlan{l.Input}
+}
+
+func (l LimitExec) String() string {
+	return fmt.Sprintf("LimitExec: skip=%d, fetch=%d", l.Skip, l.Fetch)
+}
+
+func (l LimitExec) Execute(ctx context.Context, task *TaskContext, partition int) RecordBatchStream {
+	return l.metrics.record(&limitStream{ctx: ctx, input: l.Input.Execute(ctx, task, partition), skip: l.Skip, fetch: l.Fetch})
+}
+
+func (l LimitExec) Metrics() *Metrics {
+	return l.metrics
+}
+
+type limitStream struct {
+	ctx   context.Context
+	input RecordBatchStream
+	skip  int
+	fetch int
+	done  bool
+}
+
+func (s *limitStream) Next() (RecordBatch, error) {
+	for !s.done {
+		if err := s.ctx.Err(); err != nil {
+			return RecordBatch{}, err
+		}
+		if s.fetch == 0 {
+			s.done = true
+			s.input.Close()
+			break
+		}
+		batch, err := s.input.Next()
+		if err != nil {
+			return batch, err
+		}
+		rows := batch.RowCount()
+		if s.skip >= rows {
+			s.skip -= rows
+			continue
+		}
+		offset := s.skip
+		s.skip = 0
+		length := rows - offset
+		if s.fetch >= 0 && s.fetch < length {
+			length = s.fetch
+		}
+		if s.fetch > 0 {
+			s.fetch -= length
+		}
+		if offset == 0 && length == rows {
+			return batch, nil
+		}
+		return batch.Slice(offset, length), nil
+	}
+	return RecordBatch{}, io.EOF
+}
+
+func (s *limitStream) Close() error {
+	s.done = true
+	return s.input.Close()
+}
+
+// SortExpression is the physical counterpart of SortExpr
+type SortExpression struct {
+	Expr Expression
+	Asc  bool
+}
+
+func (e SortExpression) String() string {
+	if e.Asc {
+		return e.Expr.String() + " ASC"
+	}
+	return e.Expr.String() + " DESC"
+}
+
+// compareSortKeys orders two rows by their evaluated sort keys. Nulls are
+// greater than any other value, so they come last when ascending.
+func compareSortKeys(exprs []SortExpression, a, b []any) int {
+	for i, e := range exprs {
+		var c int
+		switch {
+		case a[i] == nil && b[i] == nil:
+			c = 0
+		case a[i] == nil:
+			c = 1
+		case b[i] == nil:
+			c = -1
+		default:
+			c = compareValues(a[i], b[i])
+		}
+		if c != 0 {
+			if !e.Asc {
+				return -c
+			}
+			return c
+		}
+	}
+	return 0
+}
+
+// sortRow is a row that has been buffered by a sort operator, along with its
+// evaluated sort keys
+type sortRow struct {
+	keys   []any
+	values []any
+	// ordinal is the row's position in the input, used by TopKExec to break
+	// ties the same way a stable sort would
+	ordinal int
+}
+
+func (r sortRow) size() int64 {
+	return estimateSize(r.keys) + estimateSize(r.values)
+}
+
+func evaluateSortKeys(exprs []SortExpression, batch RecordBatch) ([]ColumnVector, error) {
+	keys := make([]ColumnVector, len(exprs))
+	for i, e := range exprs {
+		v, err := evaluate(e.Expr, batch)
+		if err != nil {
+			return nil, err
+		}
+		keys[i] = v
+	}
+	return keys, nil
+}
+
+func rowValues(vectors []ColumnVector, row int) []any {
+	values := make([]any, len(vectors))
+	for i, v := range vectors {
+		values[i] = v.GetValue(row)
+	}
+	return values
+}
+
+// rowsToBatches copies buffered rows back into columnar batches
+func rowsToBatches(schema Schema, rows []sortRow, batchSize int) []RecordBatch {
+	batches := []RecordBatch{}
+	for start := 0; start < len(rows); start += batchSize {
+		end := start + batchSize
+		if end > len(rows) {
+			end = len(rows)
+		}
+		fields := make([]ColumnVector, len(schema.Fields()))
+		for i, f := range schema.Fields() {
+			values := make([]any, end-start)
+			for j, row := range rows[start:end] {
+				values[j] = row.values[i]
+			}
+			fields[i] = drogo.New(f.Type, len(values), values)
+		}
+		batches = append(batches, RecordBatch{schema, fields})
+	}
+	return batches
+}
+
+// SortExec buffers its whole input and emits it in sorted order
+type SortExec struct {
+	Input    PhysicalPlan
+	SortExpr []SortExpression
+	metrics  *Metrics
+}
+
+func (s SortExec) GetSchema() Schema {
+	return s.Input.GetSchema()
+}
+
+func (s SortExec) OutputPartitions() int {
+	return s.Input.OutputPartitions()
+}
+
+func (s SortExec) Children() []PhysicalPlan {
+	return []PhysicalPlan{s.Input}
+}
+
+func (s SortExec) String() string {
+	return fmt.Sprintf("SortExec: %s", s.SortExpr)
+}
+
+func (s SortExec) Execute(ctx context.Context, task *TaskContext, partition int) RecordBatchStream {
+	reservation := task.Memory.NewReservation("SortExec")
+	reservation.metrics = s.metrics
+	return s.metrics.record(&blockingStream{s.Input.Execute(ctx, task, partition), reservation, func(input RecordBatchStream, reservation *MemoryReservation) ([]RecordBatch, error) {
+		rows := []sortRow{}
+		err := forEachBatch(ctx, input, func(batch RecordBatch) error {
+			var grow int64
+			keys, err := evaluateSortKeys(s.SortExpr, batch)
+			if err != nil {
+				return err
+			}
+			for row := 0; row < batch.RowCount(); row++ {
+				r := sortRow{keys: rowValues(keys, row), values: rowValues(batch.Fields, row)}
+				rows = append(rows, r)
+				grow += r.size()
+			}
+			return reservation.TryGrow(grow)
+		})
+		if err != nil {
+			return nil, err
+		}
+		sort.SliceStable(rows, func(i, j int) bool {
+			return compareSortKeys(s.SortExpr, rows[i].keys, rows[j].keys) < 0
+		})
+		return rowsToBatches(s.GetSchema(), rows, defaultBatchSize), nil
+	}, nil})
+}
+
+func (s SortExec) Metrics() *Metrics {
+	return s.metrics
+}
+
+// TopKExec is a fused Sort and Limit that only keeps the first K rows of the
+// sorted output. Rows are held in a bounded heap whose root is the greatest
+// row kept so far, so each input row costs at most O(log K) and memory stays
+// proportional to K rather than the input size.
+type TopKExec struct {
+	Input    PhysicalPlan
+	SortExpr []SortExpression
+	K        int
+	metrics  *Metrics
+}
+
+func (t TopKExec) GetSchema() Schema {
+	return t.Input.GetSchema()
+}
+
+func (t TopKExec) OutputPartitions() int {
+	return t.Input.OutputPartitions()
+}
+
+func (t TopKExec) Children() []PhysicalPlan {
+	return []PhysicalPlan{t.Input}
+}
+
+func (t TopKExec) String() string {
+	return fmt.Sprintf("TopKExec: %s, k=%d", t.SortExpr, t.K)
+}
+
+func (t TopKExec) Execute(ctx context.Context, task *TaskContext, partition int) RecordBatchStream {
+	reservation := task.Memory.NewReservation("TopKExec")
+	reservation.metrics = t.metrics
+	return t.metrics.record(&blockingStream{t.Input.Execute(ctx, task, partition), reservation, func(input RecordBatchStream, reservation *MemoryReservation) ([]RecordBatch, error) {
+		h := &topKHeap{exprs: t.SortExpr}
+		ordinal := 0
+		err := forEachBatch(ctx, input, func(batch RecordBatch) error {
+			// net change in the size of the rows held by the heap
+			var grow int64
+			keys, err := evaluateSortKeys(t.SortExpr, batch)
+			if err != nil {
+				return err
+			}
+			for row := 0; row < batch.RowCount(); row++ {
+				rowKeys := rowValues(keys, row)
+				ordinal++
+				if h.Len() < t.K {
+					r := sortRow{rowKeys, rowValues(batch.Fields, row), ordinal}
+					heap.Push(h, r)
+					grow += r.size()
+				} else if t.K > 0 && compareSortKeys(t.SortExpr, rowKeys, h.rows[0].keys) < 0 {
+					// a row that ties with the root came later, so it loses
+					r := sortRow{rowKeys, rowValues(batch.Fields, row), ordinal}
+					grow += r.size() - h.rows[0].size()
+					h.rows[0] = r
+					heap.Fix(h, 0)
+				}
+			}
+			if grow < 0 {
+				reservation.Shrink(-grow)
+				return nil
+			}
+			return reservation.TryGrow(grow)
+		})
+		if err != nil {
+			return nil, err
+		}
+		rows := make([]sortRow, h.Len())
+		for i := len(rows) - 1; i >= 0; i-- {
+			rows[i] = heap.Pop(h).(sortRow)
+		}
+		return rowsToBatches(t.GetSchema(), rows, defaultBatchSize), nil
+	}, nil})
+}
+
+func (t TopKExec) Metrics() *Metrics {
+	return t.metrics
+}
+
+// topKHeap is a max-heap of rows by sort order, then input order
+type topKHeap struct {
+	exprs []SortExpression
+	rows  []sortRow
+}
+
+func (h *topKHeap) Len() int {
+	return len(h.rows)
+}
+
+func (h *topKHeap) Less(i, j int) bool {
+	a, b := h.rows[i], h.rows[j]
+	if c := compareSortKeys(h.exprs, a.keys, b.keys); c != 0 {
+		return c > 0
+	}
+	return a.ordinal > b.ordinal
+}
+
+func (h *topKHeap) Swap(i, j int) {
+	h.rows[i], h.rows[j] = h.rows[j], h.rows[i]
+}
+
+func (h *topKHeap) Push(x any) {
+	h.rows = append(h.rows, x.(sortRow))
+}
+
+func (h *topKHeap) Pop() any {
+	row := h.rows[len(h.rows)-1]
+	h.rows = h.rows[:len(h.rows)-1]
+	return row
+}
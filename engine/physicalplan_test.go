@@ -0,0 +1,188 @@
+package engine
+
+import (
+	"context"
+	"os"
+	"sync/atomic"
+	"testing"
+
+	"github.com/briansterle/drogo"
+	"github.com/briansterle/drogo/util"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// countingSource serves a fixed number of single-row batches and records how
+// many were pulled
+type countingSource struct {
+	schema Schema
+	rows   int
+	pulled int
+	closed atomic.Bool
+}
+
+func (s *countingSource) GetSchema() Schema {
+	return s.schema
+}
+
+func (s *countingSource) Partitions() int {
+	return 1
+}
+
+func (s *countingSource) Scan(ctx context.Context, projection []string, fetch int, partition int) RecordBatchStream {
+	batches := make([]RecordBatch, s.rows)
+	for i := range batches {
+		v := drogo.New(drogo.Int64, 1, util.SliceToAny([]int64{int64(i)}))
+		batches[i] = RecordBatch{s.schema, []ColumnVector{v}}
+	}
+	return &countingStream{s, NewRecordBatchStream(batches...)}
+}
+
+type countingStream struct {
+	source *countingSource
+	RecordBatchStream
+}
+
+func (s *countingStream) Next() (RecordBatch, error) {
+	batch, err := s.RecordBatchStream.Next()
+	if err == nil {
+		s.source.pulled++
+	}
+	return batch, err
+}
+
+func (s *countingStream) Close() error {
+	s.source.closed.Store(true)
+	return s.RecordBatchStream.Close()
+}
+
+func column(batches []RecordBatch, i int) []any {
+	values := []any{}
+	for _, batch := range batches {
+		for row := 0; row < batch.RowCount(); row++ {
+			values = append(values, batch.Field(i).GetValue(row))
+		}
+	}
+	return values
+}
+
+func TestLimitExecStopsPulling(t *testing.T) {
+	source := &countingSource{rows: 100}
+	limit := LimitExec{ScanExec{source, []string{}, 0, nil}, 3, 2, nil}
+
+	batches, err := ReadAll(limit.Execute(context.Background(), NewTaskContext(), 0))
+	require.NoError(t, err)
+	assert.Equal(t, []any{int64(3), int64(4)}, column(batches, 0))
+	assert.Equal(t, 5, source.pulled, "should only pull skip+fetch rows")
+	assert.True(t, source.closed.Load(), "input should be closed")
+}
+
+func TestCsvScanWithFetch(t *testing.T) {
+	source := NewCsvDataSource("testdata/employees.csv", Schema{}, true, 2)
+	batches, err := ReadAll(source.Scan(context.Background(), []string{"id", "salary"}, 3, 0))
+	require.NoError(t, err)
+	assert.Equal(t, []any{int64(1), int64(2), int64(3)}, column(batches, 0))
+	assert.Equal(t, []any{int64(12000), int64(10000), int64(11500)}, column(batches, 1))
+}
+
+func TestTopKMatchesSort(t *testing.T) {
+	ctx := &ExecutionContext{BatchSize: 3}
+	df := ctx.Csv("testdata/employees.csv").
+		Sort([]SortExpr{Desc(Col("salary")), Asc(Col("id"))}).
+		Project([]LogicalExpr{Col("id")})
+
+	stream, err := ctx.Execute(context.Background(), df)
+	require.NoError(t, err)
+	sorted, err := ReadAll(stream)
+	require.NoError(t, err)
+
+	stream, err = ctx.Execute(context.Background(), df.Offset(1).Limit(3))
+	require.NoError(t, err)
+	topK, err := ReadAll(stream)
+	require.NoError(t, err)
+
+	assert.Equal(t, []any{int64(6), int64(7), int64(1), int64(3), int64(4), int64(2), int64(5), int64(8)}, column(sorted, 0))
+	assert.Equal(t, column(sorted, 0)[1:4], column(topK, 0))
+}
+
+func TestTopKWholeInput(t *testing.T) {
+	ctx := &ExecutionContext{}
+	df := ctx.Csv("testdata/employees.csv").
+		Sort([]SortExpr{Asc(Col("state"))}).
+		Limit(8)
+
+	stream, err := ctx.Execute(context.Background(), df)
+	require.NoError(t, err)
+	batches, err := ReadAll(stream)
+	require.NoError(t, err)
+	// the empty state is an empty string rather than null, so it sorts first
+	assert.Equal(t, []any{"", "CA", "CA", "CO", "CO", "OH", "OH", "OR"}, column(batches, 3))
+}
+
+func TestExecuteAggregate(t *testing.T) {
+	ctx := &ExecutionContext{}
+	df := ctx.Csv("testdata/employees.csv").
+		Filter(Neq(Col("state"), Str(""))).
+		Aggregate([]LogicalExpr{Col("state")}, []AggregateExpr{Max(Col("salary")), Count(Col("id")), Avg(Col("salary"))})
+
+	stream, err := ctx.Execute(context.Background(), df)
+	require.NoError(t, err)
+	batches, err := ReadAll(stream)
+	require.NoError(t, err)
+	assert.Equal(t, []any{"CA", "CO", "OH", "OR"}, column(batches, 0))
+	assert.Equal(t, []any{int64(12000), int64(11500), int64(14000), int64(13000)}, column(batches, 1))
+	assert.Equal(t, []any{int64(2), int64(2), int64(2), int64(1)}, column(batches, 2))
+	assert.Equal(t, []any{10000.0, 10750.0, 11750.0, 13000.0}, column(batches, 3))
+}
+
+func TestMissingCsvFile(t *testing.T) {
+	ctx := &ExecutionContext{}
+	_, err := ctx.Execute(context.Background(), ctx.Csv("testdata/nope.csv").Filter(Eq(Col("state"), Str("CO"))))
+	require.Error(t, err)
+	assert.ErrorIs(t, err, os.ErrNotExist)
+
+	source := NewCsvDataSource("testdata/nope.csv", Schema{}, true, 0)
+	_, err = ReadAll(source.Scan(context.Background(), nil, 0, 0))
+	assert.ErrorIs(t, err, os.ErrNotExist)
+}
+
+func TestTopKTiesMatchStableSort(t *testing.T) {
+	// salary / 10000 is 0 or 1, so most rows tie at the k boundary
+	ctx := &ExecutionContext{BatchSize: 2, TargetPartitions: 1}
+	df := ctx.Csv("testdata/employees.csv").
+		Sort([]SortExpr{Asc(Divide(Col("salary"), Int(10000)))}).
+		Project([]LogicalExpr{Col("id")})
+
+	stream, err := ctx.Execute(context.Background(), df)
+	require.NoError(t, err)
+	sorted, err := ReadAll(stream)
+	require.NoError(t, err)
+
+	for k := 1; k <= 8; k++ {
+		stream, err := ctx.Execute(context.Background(), df.Limit(k))
+		require.NoError(t, err)
+		topK, err := ReadAll(stream)
+		require.NoError(t, err)
+		assert.Equal(t, column(sorted, 0)[:k], column(topK, 0), "k=%d", k)
+	}
+}
+
+func TestAggregateEmptyInput(t *testing.T) {
+	ctx := &ExecutionContext{}
+	empty := ctx.Csv("testdata/employees.csv").Filter(Eq(Col("state"), Str("TX")))
+	aggregates := []AggregateExpr{Count(Col("id")), Sum(Col("salary")), Max(Col("salary"))}
+
+	stream, err := ctx.Execute(context.Background(), empty.Aggregate([]LogicalExpr{}, aggregates))
+	require.NoError(t, err)
+	batches, err := ReadAll(stream)
+	require.NoError(t, err)
+	assert.Equal(t, []any{int64(0)}, column(batches, 0))
+	assert.Equal(t, []any{nil}, column(batches, 1))
+	assert.Equal(t, []any{nil}, column(batches, 2))
+
+	stream, err = ctx.Execute(context.Background(), empty.Aggregate([]LogicalExpr{Col("state")}, aggregates))
+	require.NoError(t, err)
+	batches, err = ReadAll(stream)
+	require.NoError(t, err)
+	assert.Empty(t, column(batches, 0))
+}
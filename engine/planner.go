@@ -0,0 +1,255 @@
+package engine
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/apache/arrow/go/v12/arrow"
+)
+
+// QueryPlanner translates logical plans into physical plans that can be
+// executed. Scans run with as many partitions as their data source offers,
+// aggregates run in two phases across partitions, and other operators that
+// need all of their input in one place are planned above a
+// CoalescePartitionsExec.
+type QueryPlanner struct {
+	// TargetPartitions is the parallelism to aim for. Single partition scans
+	// are spread over this many partitions before filters and projections.
+	TargetPartitions int
+}
+
+// parallelize round-robins the output of a single partition scan so the
+// operators above it can run in parallel
+func (qp QueryPlanner) parallelize(input PhysicalPlan) PhysicalPlan {
+	if _, ok := input.(ScanExec); ok && qp.TargetPartitions > 1 && input.OutputPartitions() == 1 {
+		return NewRepartitionExec(input, RoundRobinPartitioning(qp.TargetPartitions))
+	}
+	return input
+}
+
+func coalesce(input PhysicalPlan) PhysicalPlan {
+	if input.OutputPartitions() == 1 {
+		return input
+	}
+	return CoalescePartitionsExec{input, NewMetrics()}
+}
+
+// CreatePhysicalPlan plans the logical plan and its inputs, giving up with
+// the context's error once ctx is done
+func (qp QueryPlanner) CreatePhysicalPlan(ctx context.Context, plan LogicalPlan) (PhysicalPlan, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	switch p := plan.(type) {
+	case Scan:
+		if err := loadSchema(ctx, p.Source); err != nil {
+			return nil, err
+		}
+		source := p.Source
+		if filtered, ok := source.(FilteredDataSource); ok && len(p.Filters) > 0 {
+			source = filtered.WithFilters(p.Filters)
+		}
+		return ScanExec{source, p.Projection, p.Fetch, NewMetrics()}, nil
+	case Selection:
+		input, err := qp.CreatePhysicalPlan(ctx, p.Input)
+		if err != nil {
+			return nil, err
+		}
+		expr, err := qp.CreatePhysicalExpr(p.Expr, p.Input)
+		if err != nil {
+			return nil, err
+		}
+		return SelectionExec{qp.parallelize(input), expr, NewMetrics()}, nil
+	case Projection:
+		input, err := qp.CreatePhysicalPlan(ctx, p.Input)
+		if err != nil {
+			return nil, err
+		}
+		exprs, err := qp.createPhysicalExprs(p.Expr, p.Input)
+		if err != nil {
+			return nil, err
+		}
+		return ProjectionExec{qp.parallelize(input), p.Schema(), exprs, NewMetrics()}, nil
+	case Aggregate:
+		input, err := qp.CreatePhysicalPlan(ctx, p.Input)
+		if err != nil {
+			return nil, err
+		}
+		groupExpr, err := qp.createPhysicalExprs(p.GroupExpr, p.Input)
+		if err != nil {
+			return nil, err
+		}
+		aggregateExpr := make([]AggregateExpression, len(p.AggregateExpr))
+		for i, e := range p.AggregateExpr {
+			expr, err := qp.CreatePhysicalExpr(e.Expr, p.Input)
+			if err != nil {
+				return nil, err
+			}
+			if e.Func != nil {
+				if err := e.check(p.Input); err != nil {
+					return nil, err
+				}
+				aggregateExpr[i] = AggregateUDFExpression{e.Func, expr}
+				continue
+			}
+			dataType := e.Expr.ToField(p.Input).Type
+			switch e.Name {
+			case "SUM":
+				aggregateExpr[i] = SumExpression{expr, dataType}
+			case "MIN":
+				aggregateExpr[i] = MinExpression{expr, dataType}
+			case "MAX":
+				aggregateExpr[i] = MaxExpression{expr, dataType}
+			case "AVG":
+				aggregateExpr[i] = AvgExpression{expr}
+			case "COUNT":
+				aggregateExpr[i] = CountExpression{expr}
+			default:
+				return nil, fmt.Errorf("unsupported aggregate function: %s", e.Name)
+			}
+		}
+		input = qp.parallelize(input)
+		if input.OutputPartitions() == 1 {
+			return HashAggregateExec{input, groupExpr, aggregateExpr, p.Schema(), AggregateSingle, NewMetrics()}, nil
+		}
+		// each partition is aggregated on its own, then the partial results
+		// for a group are brought together and merged
+		partial := HashAggregateExec{input, groupExpr, aggregateExpr, p.Schema(), AggregatePartial, NewMetrics()}
+		finalGroupExpr := make([]Expression, len(groupExpr))
+		for i := range finalGroupExpr {
+			finalGroupExpr[i] = ColumnExpression{i}
+		}
+		var finalInput PhysicalPlan = CoalescePartitionsExec{partial, NewMetrics()}
+		if len(groupExpr) > 0 {
+			finalInput = NewRepartitionExec(partial, HashPartitioning(input.OutputPartitions(), finalGroupExpr))
+		}
+		return HashAggregateExec{finalInput, finalGroupExpr, aggregateExpr, p.Schema(), AggregateFinal, NewMetrics()}, nil
+	case Limit:
+		input, err := qp.CreatePhysicalPlan(ctx, p.Input)
+		if err != nil {
+			return nil, err
+		}
+		return LimitExec{coalesce(input), p.Skip, p.Fetch, NewMetrics()}, nil
+	case Sort:
+		input, err := qp.CreatePhysicalPlan(ctx, p.Input)
+		if err != nil {
+			return nil, err
+		}
+		sortExpr := make([]SortExpression, len(p.Expr))
+		for i, e := range p.Expr {
+			expr, err := qp.CreatePhysicalExpr(e.Expr, p.Input)
+			if err != nil {
+				return nil, err
+			}
+			sortExpr[i] = SortExpression{expr, e.Asc}
+		}
+		if p.Fetch > 0 {
+			// each partition's top k rows are merged by a final top k
+			if input.OutputPartitions() > 1 {
+				input = CoalescePartitionsExec{TopKExec{input, sortExpr, p.Fetch, NewMetrics()}, NewMetrics()}
+			}
+			return TopKExec{input, sortExpr, p.Fetch, NewMetrics()}, nil
+		}
+		return SortExec{coalesce(input), sortExpr, NewMetrics()}, nil
+	case Unnest:
+		input, err := qp.CreatePhysicalPlan(ctx, p.Input)
+		if err != nil {
+			return nil, err
+		}
+		indices := p.Input.Schema().FieldIndices(p.Column)
+		if len(indices) == 0 {
+			return nil, fmt.Errorf("no column named '%s'", p.Column)
+		}
+		if dtype := input.GetSchema().Field(indices[0]).Type; dtype.ID() != arrow.LIST {
+			return nil, fmt.Errorf("cannot unnest column '%s' of type %s", p.Column, dtype)
+		}
+		return UnnestExec{input, indices[0], unnestSchema(input.GetSchema(), indices[0]), NewMetrics()}, nil
+	default:
+		return nil, fmt.Errorf("unsupported logical plan: %s", plan)
+	}
+}
+
+func (qp QueryPlanner) createPhysicalExprs(exprs []LogicalExpr, input LogicalPlan) ([]Expression, error) {
+	out := make([]Expression, len(exprs))
+	for i, e := range exprs {
+		expr, err := qp.CreatePhysicalExpr(e, input)
+		if err != nil {
+			return nil, err
+		}
+		out[i] = expr
+	}
+	return out, nil
+}
+
+func (qp QueryPlanner) CreatePhysicalExpr(expr LogicalExpr, input LogicalPlan) (Expression, error) {
+	switch e := expr.(type) {
+	case Column:
+		indices := input.Schema().FieldIndices(e.name)
+		if len(indices) == 0 {
+			return nil, fmt.Errorf("no column named '%s'", e.name)
+		}
+		return ColumnExpression{indices[0]}, nil
+	case LiteralString:
+		return LiteralStringExpression{e.Str}, nil
+	case LiteralInt64:
+		return LiteralInt64Expression{e.n}, nil
+	case LiteralFloat64:
+		return LiteralFloat64Expression{e.n}, nil
+	case Alias:
+		// aliases only affect the schema, so the expression is evaluated as is
+		return qp.CreatePhysicalExpr(e.Expr, input)
+	case BooleanBinaryExpr:
+		l, err := qp.CreatePhysicalExpr(e.L, input)
+		if err != nil {
+			return nil, err
+		}
+		r, err := qp.CreatePhysicalExpr(e.R, input)
+		if err != nil {
+			return nil, err
+		}
+		return BooleanExpression{l, r, e.Op}, nil
+	case MathExpr:
+		l, err := qp.CreatePhysicalExpr(e.L, input)
+		if err != nil {
+			return nil, err
+		}
+		r, err := qp.CreatePhysicalExpr(e.R, input)
+		if err != nil {
+			return nil, err
+		}
+		return MathExpression{l, r, e.Op, e.ToField(input).Type}, nil
+	case ScalarFunction:
+		if err := e.check(input); err != nil {
+			return nil, err
+		}
+		args, err := qp.createPhysicalExprs(e.Args, input)
+		if err != nil {
+			return nil, err
+		}
+		return ScalarFunctionExpression{e.Func, args}, nil
+	case StringFunction:
+		if err := e.check(input); err != nil {
+			return nil, err
+		}
+		args, err := qp.createPhysicalExprs(e.Args, input)
+		if err != nil {
+			return nil, err
+		}
+		return newStringFunctionExpression(e.Name, args)
+	case LikeExpr:
+		if err := e.check(input); err != nil {
+			return nil, err
+		}
+		expr, err := qp.CreatePhysicalExpr(e.Expr, input)
+		if err != nil {
+			return nil, err
+		}
+		pattern, err := qp.CreatePhysicalExpr(e.Pattern, input)
+		if err != nil {
+			return nil, err
+		}
+		return newLikeExpression(expr, pattern, e.Negated, e.CaseInsensitive, e.Similar)
+	default:
+		return nil, fmt.Errorf("unsupported logical expression: %s", expr)
+	}
+}
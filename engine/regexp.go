@@ -0,0 +1,137 @@
+package engine
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// compileRegexp compiles a regular expression with Postgres style flags: i
+// for case insensitive matching, c for case sensitive, m for multi-line
+// mode, s to let . match newlines and g, which makes regexp_replace replace
+// every match and is otherwise ignored
+func compileRegexp(pattern, flags string) (*regexp.Regexp, error) {
+	caseInsensitive, multiLine, dotAll := false, false, false
+	for _, f := range flags {
+		switch f {
+		case 'i':
+			caseInsensitive = true
+		case 'c':
+			caseInsensitive = false
+		case 'm':
+			multiLine = true
+		case 's':
+			dotAll = true
+		case 'g':
+		default:
+			return nil, fmt.Errorf("invalid regular expression flag '%c'", f)
+		}
+	}
+	prefix := ""
+	if caseInsensitive {
+		prefix += "i"
+	}
+	if multiLine {
+		prefix += "m"
+	}
+	if dotAll {
+		prefix += "s"
+	}
+	expr := pattern
+	if prefix != "" {
+		expr = "(?" + prefix + ")" + pattern
+	}
+	re, err := regexp.Compile(expr)
+	if err != nil {
+		return nil, fmt.Errorf("invalid regular expression '%s'", pattern)
+	}
+	return re, nil
+}
+
+// regexpLike implements regexp_like(string, pattern [, flags]), which is
+// true if the pattern matches any part of the string
+func regexpLike(re *regexp.Regexp, args []any) any {
+	return re.MatchString(args[0].(string))
+}
+
+// regexpMatch implements regexp_match(string, pattern [, flags]), which
+// returns the capture groups of the first match, or the whole match if the
+// pattern has no groups. Groups that did not take part in the match are
+// null, as is the result if nothing matches.
+func regexpMatch(re *regexp.Regexp, args []any) any {
+	s := args[0].(string)
+	loc := re.FindStringSubmatchIndex(s)
+	if loc == nil {
+		return nil
+	}
+	if re.NumSubexp() == 0 {
+		return []any{s[loc[0]:loc[1]]}
+	}
+	groups := make([]any, re.NumSubexp())
+	for i := range groups {
+		if start := loc[2*i+2]; start >= 0 {
+			groups[i] = s[start:loc[2*i+3]]
+		}
+	}
+	return groups
+}
+
+// regexpReplace implements regexp_replace(string, pattern, replacement
+// [, flags]), which replaces the first match, or every match with the g
+// flag. In the replacement \1 to \9 stand for capture groups and \& for the
+// whole match.
+func regexpReplace(re *regexp.Regexp, args []any) any {
+	s, template := args[0].(string), replacementTemplate(args[2].(string))
+	if len(args) > 3 && strings.ContainsRune(args[3].(string), 'g') {
+		return re.ReplaceAllString(s, template)
+	}
+	loc := re.FindStringSubmatchIndex(s)
+	if loc == nil {
+		return s
+	}
+	return s[:loc[0]] + string(re.ExpandString(nil, template, s, loc)) + s[loc[1]:]
+}
+
+// replacementTemplate translates a replacement with backslash references to
+// the template syntax of the regexp package
+func replacementTemplate(replacement string) string {
+	var sb strings.Builder
+	for i := 0; i < len(replacement); i++ {
+		c := replacement[i]
+		switch {
+		case c == '$':
+			sb.WriteString("$$")
+		case c == '\\' && i+1 < len(replacement):
+			i++
+			switch next := replacement[i]; {
+			case next >= '0' && next <= '9':
+				sb.WriteString("${" + string(next) + "}")
+			case next == '&':
+				sb.WriteString("${0}")
+			case next == '$':
+				sb.WriteString("$$")
+			default:
+				sb.WriteByte(next)
+			}
+		default:
+			sb.WriteByte(c)
+		}
+	}
+	return sb.String()
+}
+
+// regexpExtract implements regexp_extract(string, pattern [, group]), which
+// returns a capture group of the first match, or the whole match for group
+// 0. It is null if nothing matches or the group does not exist or did not
+// take part in the match.
+func regexpExtract(re *regexp.Regexp, args []any) any {
+	s, group := args[0].(string), int64(0)
+	if len(args) > 2 {
+		group = args[2].(int64)
+	}
+	loc := re.FindStringSubmatchIndex(s)
+	if loc == nil || group < 0 || group > int64(re.NumSubexp()) || loc[2*group] < 0 {
+		return nil
+	}
+	return s[loc[2*group]:loc[2*group+1]]
+}
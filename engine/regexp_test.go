@@ -0,0 +1,153 @@
+package engine
+
+import (
+	"context"
+	"testing"
+
+	"github.com/apache/arrow/go/v12/arrow"
+	"github.com/briansterle/drogo"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRegexpFunctions(t *testing.T) {
+	for _, test := range []struct {
+		name string
+		args []any
+		want any
+	}{
+		{"regexp_like", []any{"Hello", "^h"}, false},
+		{"regexp_like", []any{"Hello", "^h", "i"}, true},
+		{"regexp_like", []any{"a\nb", "^b$"}, false},
+		{"regexp_like", []any{"a\nb", "^b$", "m"}, true},
+		{"regexp_like", []any{"a\nb", "a.b", "s"}, true},
+		{"regexp_like", []any{"abc", "B", "ic"}, false},
+		{"regexp_match", []any{"foobarbaz", "ba."}, []any{"bar"}},
+		{"regexp_match", []any{"key=value", `(\w+)=(\w+)`}, []any{"key", "value"}},
+		{"regexp_match", []any{"ac", "a(b)?(c)"}, []any{nil, "c"}},
+		{"regexp_match", []any{"abc", "x"}, nil},
+		{"regexp_replace", []any{"banana", "a", "o"}, "bonana"},
+		{"regexp_replace", []any{"banana", "a", "o", "g"}, "bonono"},
+		{"regexp_replace", []any{"John Smith", `(\w+) (\w+)`, `\2, \1`}, "Smith, John"},
+		{"regexp_replace", []any{"abc", "b", `[\&]`}, "a[b]c"},
+		{"regexp_replace", []any{"abc", "B", `$1\\`, "gi"}, `a$1\c`},
+		{"regexp_replace", []any{"abc", "x", "y"}, "abc"},
+		{"regexp_extract", []any{"2024-05-17", `(\d+)-(\d+)-(\d+)`, int64(2)}, "05"},
+		{"regexp_extract", []any{"2024-05-17", `\d+`}, "2024"},
+		{"regexp_extract", []any{"2024-05-17", `(\d+)`, int64(2)}, nil},
+		{"regexp_extract", []any{"ac", "a(b)?c", int64(1)}, nil},
+		{"regexp_extract", []any{"abc", "x"}, nil},
+		{"regexp_like", []any{nil, "a"}, nil},
+		// invalid patterns that are not literals make the result null
+		{"regexp_like", []any{"abc", "("}, nil},
+		{"regexp_match", []any{"abc", "a", "q"}, nil},
+	} {
+		f := stringFunctions[test.name]
+		args := make([]ColumnVector, len(test.args))
+		for i, arg := range test.args {
+			dtype := arrow.DataType(drogo.String)
+			if _, ok := arg.(int64); ok {
+				dtype = drogo.Int64
+			}
+			args[i] = LiteralValueVector{dtype, arg, 1}
+		}
+		assert.Equal(t, test.want, f.evaluate(args, 1, nil).GetValue(0), "%s%v", test.name, test.args)
+	}
+}
+
+func TestRegexpExpression(t *testing.T) {
+	schema := Schema{arrow.NewSchema([]arrow.Field{{Name: "s", Type: drogo.String}, {Name: "p", Type: drogo.String}}, nil)}
+	batch := RecordBatch{schema, []ColumnVector{
+		drogo.New(drogo.String, 4, []any{"a1", "b22", "c", nil}),
+		drogo.New(drogo.String, 4, []any{`\d+`, "[", `[a-z]`, `\d`}),
+	}}
+	values := func(v ColumnVector) []any {
+		out := make([]any, v.Len())
+		for i := range out {
+			out[i] = v.GetValue(i)
+		}
+		return out
+	}
+
+	// literal patterns are compiled when the expression is created
+	e, err := newStringFunctionExpression("regexp_extract", []Expression{ColumnExpression{0}, LiteralStringExpression{`\d+`}})
+	require.NoError(t, err)
+	assert.NotNil(t, e.re)
+	assert.Equal(t, []any{"1", "22", nil, nil}, values(e.Evaluate(batch)))
+	e, err = newStringFunctionExpression("regexp_match", []Expression{ColumnExpression{0}, ColumnExpression{1}})
+	require.NoError(t, err)
+	assert.Nil(t, e.re)
+	assert.Equal(t, []any{[]any{"1"}, nil, []any{"c"}, nil}, values(e.Evaluate(batch)))
+
+	dict := DictionaryVector{[]int32{1, 0, -1, 0}, drogo.New(drogo.String, 2, []any{"x-y", "ab"})}
+	batch = RecordBatch{schema, []ColumnVector{dict, dict}}
+	e, err = newStringFunctionExpression("regexp_replace", []Expression{ColumnExpression{0}, LiteralStringExpression{"(.)(.)"}, LiteralStringExpression{`\2\1`}})
+	require.NoError(t, err)
+	result := e.Evaluate(batch)
+	require.IsType(t, DictionaryVector{}, result)
+	assert.Equal(t, []any{"ba", "-xy", nil, "-xy"}, values(result))
+
+	_, err = newStringFunctionExpression("regexp_like", []Expression{ColumnExpression{0}, LiteralStringExpression{"a"}, LiteralStringExpression{"x"}})
+	assert.EqualError(t, err, "function regexp_like: invalid regular expression flag 'x'")
+}
+
+func TestRegexpSql(t *testing.T) {
+	ec := sqlContext(t)
+	rows := sqlRows(t, ec, `SELECT id, regexp_extract(job_title, '([A-Z])(\w*)', 2), regexp_replace(last_name, '[aeiou]', '*', 'g'),
+		regexp_like(first_name, '^g', 'i'), regexp_match(job_title, '(\w+) (\w+)')
+		FROM employees WHERE last_name SIMILAR TO '%(o|a)(p|n)%' ORDER BY id`)
+	assert.Equal(t, [][]any{
+		{int64(1), "anager", "H*pk*ns", false, nil},
+		{int64(2), "river", "L*ngf*rd", true, nil},
+		{int64(5), "nalyst", "Byr*n", false, nil},
+		{int64(6), "anager", "H*pp*r", true, nil},
+		{int64(8), "river", "Th*mps*n", false, nil},
+	}, rows)
+	rows = sqlRows(t, ec, "SELECT regexp_match(job_title, '(\\w+) (\\w+)') FROM employees WHERE id = 3")
+	assert.Equal(t, [][]any{{[]any{"Software", "Engineer"}}}, rows)
+	rows = sqlRows(t, ec, "SELECT id FROM employees WHERE state NOT SIMILAR TO '(C|O)_' ORDER BY id")
+	assert.Equal(t, [][]any{{int64(4)}}, rows)
+	rows = sqlRows(t, ec, "SELECT COUNT(*) FROM employees WHERE first_name SIMILAR TO '[A-G]%' AND regexp_like(job_title, '^(manager|driver)$', 'i')")
+	assert.Equal(t, [][]any{{int64(3)}}, rows)
+
+	for _, test := range []struct{ sql, err string }{
+		{"SELECT regexp_like(first_name, '(') FROM employees", "function regexp_like: invalid regular expression '('"},
+		{"SELECT regexp_replace(first_name, 'a', 'b', 'z') FROM employees", "function regexp_replace: invalid regular expression flag 'z'"},
+		{"SELECT regexp_extract(first_name, 'a', 'b') FROM employees", "function regexp_extract expects argument 3 to be int64, got utf8: regexp_extract(#first_name, 'a', 'b')"},
+		{"SELECT id FROM employees WHERE first_name SIMILAR TO '(a'", "SIMILAR TO: invalid pattern '(a'"},
+		{"SELECT id FROM employees WHERE id NOT SIMILAR TO '1'", "NOT SIMILAR TO needs string operands: #id NOT SIMILAR TO '1'"},
+	} {
+		_, err := ec.Sql(context.Background(), test.sql)
+		assert.EqualError(t, err, test.err, test.sql)
+	}
+}
+
+func TestSimilarTo(t *testing.T) {
+	for _, test := range []struct {
+		pattern string
+		matches []string
+		misses  []string
+	}{
+		{"%(b|d)%", []string{"abc", "d"}, []string{"ace"}},
+		{"a+b?", []string{"a", "aaab"}, []string{"", "abb"}},
+		{"[0-9]{2,3}", []string{"12", "123"}, []string{"1", "1234"}},
+		{"a|bc", []string{"a", "bc"}, []string{"abc"}},
+		{"a.c", []string{"a.c"}, []string{"abc"}},
+		{`\%_`, []string{"%x"}, []string{"ax"}},
+		{"[%_]", []string{"%", "_"}, []string{"a"}},
+	} {
+		re, err := compileLike(test.pattern, true, false)
+		require.NoError(t, err)
+		for _, s := range test.matches {
+			assert.True(t, re.MatchString(s), "%s SIMILAR TO %s", s, test.pattern)
+		}
+		for _, s := range test.misses {
+			assert.False(t, re.MatchString(s), "%s SIMILAR TO %s", s, test.pattern)
+		}
+	}
+	_, err := compileLike("a)", true, false)
+	assert.EqualError(t, err, "invalid pattern 'a)'")
+
+	df := (&ExecutionContext{}).Csv("testdata/employees.csv").Filter(SimilarTo(Col("state"), Str("C(A|O)")))
+	assert.Contains(t, Format(df.LogicalPlan(), 0), "#state SIMILAR TO 'C(A|O)'")
+}
@@ -0,0 +1,191 @@
+package engine
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// PlanNode is a structured description of one node of a logical or physical
+// plan and its inputs, used to render plans as JSON and Graphviz DOT
+type PlanNode struct {
+	// Type is the kind of node, such as Projection or HashAggregateExec
+	Type string `json:"type"`
+	// Description is the node's text rendering without its type
+	Description string           `json:"description,omitempty"`
+	Expressions []string         `json:"expressions,omitempty"`
+	Schema      []PlanField      `json:"schema"`
+	Metrics     *OperatorMetrics `json:"metrics,omitempty"`
+	Children    []PlanNode       `json:"children"`
+}
+
+type PlanField struct {
+	Name     string `json:"name"`
+	Type     string `json:"type"`
+	Nullable bool   `json:"nullable"`
+}
+
+// DescribePlan returns the structure of a logical plan
+func DescribePlan(plan LogicalPlan) PlanNode {
+	node := planNode(plan.String(), plan.Schema())
+	node.Expressions = logicalExpressions(plan)
+	for _, child := range plan.Children() {
+		node.Children = append(node.Children, DescribePlan(child))
+	}
+	return node
+}
+
+// DescribePhysicalPlan returns the structure of a physical plan, with the
+// metrics of each operator if metrics is set
+func DescribePhysicalPlan(plan PhysicalPlan, metrics bool) PlanNode {
+	node := planNode(fmt.Sprint(plan), plan.GetSchema())
+	node.Expressions = physicalExpressions(plan)
+	if metrics {
+		m := operatorMetrics(plan)
+		node.Metrics = &m
+	}
+	for _, child := range plan.Children() {
+		node.Children = append(node.Children, DescribePhysicalPlan(child, metrics))
+	}
+	return node
+}
+
+// planNode splits a node's text rendering, "Type: description", and
+// describes its schema
+func planNode(str string, schema Schema) PlanNode {
+	node := PlanNode{Type: str, Schema: []PlanField{}, Children: []PlanNode{}}
+	if i := strings.Index(str, ": "); i >= 0 {
+		node.Type, node.Description = str[:i], str[i+2:]
+	}
+	for _, f := range schema.Fields() {
+		node.Schema = append(node.Schema, PlanField{f.Name, f.Type.String(), f.Nullable})
+	}
+	return node
+}
+
+func logicalExpressions(plan LogicalPlan) []string {
+	var exprs []string
+	switch p := plan.(type) {
+	case Scan:
+		for _, e := range p.Filters {
+			exprs = append(exprs, e.String())
+		}
+	case Projection:
+		for _, e := range p.Expr {
+			exprs = append(exprs, e.String())
+		}
+	case Selection:
+		exprs = append(exprs, p.Expr.String())
+	case Aggregate:
+		for _, e := range p.GroupExpr {
+			exprs = append(exprs, e.String())
+		}
+		for i := range p.AggregateExpr {
+			exprs = append(exprs, p.AggregateExpr[i].String())
+		}
+	case Sort:
+		for _, e := range p.Expr {
+			exprs = append(exprs, e.String())
+		}
+	}
+	return exprs
+}
+
+func physicalExpressions(plan PhysicalPlan) []string {
+	var exprs []string
+	switch p := plan.(type) {
+	case ProjectionExec:
+		for _, e := range p.Exprs {
+			exprs = append(exprs, e.String())
+		}
+	case SelectionExec:
+		exprs = append(exprs, p.Expr.String())
+	case SortExec:
+		for _, e := range p.SortExpr {
+			exprs = append(exprs, e.String())
+		}
+	case TopKExec:
+		for _, e := range p.SortExpr {
+			exprs = append(exprs, e.String())
+		}
+	case HashAggregateExec:
+		for _, e := range p.GroupExpr {
+			exprs = append(exprs, e.String())
+		}
+		for _, e := range p.AggregateExpr {
+			exprs = append(exprs, e.String())
+		}
+	case *RepartitionExec:
+		for _, e := range p.Partitioning.HashExpr {
+			exprs = append(exprs, e.String())
+		}
+	}
+	return exprs
+}
+
+// FormatJSON renders a logical plan as an indented JSON document of
+// PlanNodes
+func FormatJSON(plan LogicalPlan) string {
+	return formatJSON(DescribePlan(plan))
+}
+
+// FormatPhysicalJSON renders a physical plan as an indented JSON document of
+// PlanNodes, including each operator's metrics if metrics is set
+func FormatPhysicalJSON(plan PhysicalPlan, metrics bool) string {
+	return formatJSON(DescribePhysicalPlan(plan, metrics))
+}
+
+func formatJSON(node PlanNode) string {
+	// a PlanNode only holds strings, numbers and booleans
+	b, err := json.MarshalIndent(node, "", "  ")
+	if err != nil {
+		panic(err)
+	}
+	return string(b) + "\n"
+}
+
+// FormatDot renders a logical plan as a Graphviz DOT digraph with a box per
+// node and edges pointing in the direction data flows
+func FormatDot(plan LogicalPlan) string {
+	return formatDot(DescribePlan(plan))
+}
+
+// FormatPhysicalDot renders a physical plan as a Graphviz DOT digraph,
+// including each operator's metrics if metrics is set
+func FormatPhysicalDot(plan PhysicalPlan, metrics bool) string {
+	return formatDot(DescribePhysicalPlan(plan, metrics))
+}
+
+func formatDot(root PlanNode) string {
+	var sb strings.Builder
+	sb.WriteString("digraph plan {\n")
+	sb.WriteString("\tnode [shape=box, fontname=\"monospace\"];\n")
+	id := 0
+	var visit func(node PlanNode) int
+	visit = func(node PlanNode) int {
+		n := id
+		id++
+		lines := []string{node.Type}
+		if node.Description != "" {
+			lines = append(lines, node.Description)
+		}
+		if m := node.Metrics; m != nil {
+			lines = append(lines, fmt.Sprintf("output_rows=%d, output_batches=%d", m.OutputRows, m.OutputBatches))
+			lines = append(lines, fmt.Sprintf("memory_peak=%d, spilled_bytes=%d", m.MemoryPeak, m.SpilledBytes))
+		}
+		fmt.Fprintf(&sb, "\tn%d [label=\"%s\"];\n", n, dotEscape(strings.Join(lines, "\n")))
+		for _, child := range node.Children {
+			fmt.Fprintf(&sb, "\tn%d -> n%d;\n", visit(child), n)
+		}
+		return n
+	}
+	visit(root)
+	sb.WriteString("}\n")
+	return sb.String()
+}
+
+// dotEscape quotes text for a DOT label, where \l ends a left aligned line
+func dotEscape(text string) string {
+	text = strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(text)
+	return strings.ReplaceAll(text, "\n", `\l`) + `\l`
+}
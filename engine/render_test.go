@@ -0,0 +1,71 @@
+package engine
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFormatDot(t *testing.T) {
+	ctx := &ExecutionContext{}
+	plan := ctx.Csv("testdata/employees.csv").
+		Filter(Eq(Col("state"), Str(`"CO"`))).
+		Project([]LogicalExpr{Col("id"), Col("first_name")}).
+		LogicalPlan()
+
+	expected := `digraph plan {
+	node [shape=box, fontname="monospace"];
+	n0 [label="Projection\l#id, #first_name\l"];
+	n1 [label="Filter\l#state = '\"CO\"'\l"];
+	n2 [label="Scan\ltestdata/employees.csv; projection=None\l"];
+	n2 -> n1;
+	n1 -> n0;
+}
+`
+	assert.Equal(t, expected, FormatDot(plan))
+}
+
+func TestFormatJSON(t *testing.T) {
+	ctx := &ExecutionContext{}
+	plan := ctx.Csv("testdata/employees.csv").
+		Aggregate([]LogicalExpr{Col("state")}, []AggregateExpr{Max(Col("salary"))}).
+		LogicalPlan()
+
+	var node PlanNode
+	require.NoError(t, json.Unmarshal([]byte(FormatJSON(plan)), &node))
+	assert.Equal(t, DescribePlan(plan), node)
+	assert.Equal(t, "Aggregate", node.Type)
+	assert.Equal(t, []string{"#state", "MAX(#salary)"}, node.Expressions)
+	assert.Equal(t, []PlanField{{"state", "utf8", true}, {"MAX", "int64", false}}, node.Schema)
+	require.Len(t, node.Children, 1)
+	assert.Equal(t, "Scan", node.Children[0].Type)
+	assert.Empty(t, node.Children[0].Children)
+}
+
+func TestFormatPhysicalJSONMetrics(t *testing.T) {
+	ec := sqlContext(t)
+	df, err := ec.Sql(context.Background(), "SELECT first_name FROM employees WHERE id > 1 ORDER BY salary LIMIT 3")
+	require.NoError(t, err)
+	_, physicalPlan, err := ec.plan(context.Background(), df)
+	require.NoError(t, err)
+	_, err = ReadAll(ec.execute(context.Background(), func() {}, physicalPlan))
+	require.NoError(t, err)
+
+	var node PlanNode
+	require.NoError(t, json.Unmarshal([]byte(FormatPhysicalJSON(physicalPlan, true)), &node))
+	assert.Equal(t, DescribePhysicalPlan(physicalPlan, true), node)
+	assert.Equal(t, "ProjectionExec", node.Type)
+	assert.Equal(t, int64(3), node.Metrics.OutputRows)
+	for node.Type != "SelectionExec" {
+		require.NotEmpty(t, node.Children)
+		node = node.Children[0]
+	}
+	assert.Equal(t, []string{"#0 > 1"}, node.Expressions)
+	assert.Equal(t, OperatorMetrics{OutputRows: 7, InputRows: 8}, OperatorMetrics{OutputRows: node.Metrics.OutputRows, InputRows: node.Metrics.InputRows})
+
+	assert.Contains(t, FormatPhysicalDot(physicalPlan, true), `SelectionExec\l#0 > 1\loutput_rows=7, output_batches=`)
+	assert.NotContains(t, FormatPhysicalJSON(physicalPlan, false), "metrics")
+}
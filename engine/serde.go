@@ -0,0 +1,1332 @@
+package engine
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+
+	"github.com/apache/arrow/go/v12/arrow"
+	"github.com/apache/arrow/go/v12/arrow/ipc"
+	"github.com/briansterle/drogo"
+)
+
+// PlanCodec serializes logical and physical plans to JSON so a plan can be
+// built in one process and executed in another. Every plan node, expression
+// and data source is written as a JSON object whose "type" key names the
+// codec that reads it back, so the "type" key is reserved in the objects
+// codecs encode.
+//
+// Custom types are added to the codec's registries with Register. Data
+// sources that read from a database refer to it by a name registered with
+// RegisterDatabase, on both sides, and user-defined functions by the name
+// they are registered with RegisterFunction and RegisterAggregateFunction.
+type PlanCodec struct {
+	LogicalPlans  *CodecRegistry[LogicalPlan]
+	LogicalExprs  *CodecRegistry[LogicalExpr]
+	PhysicalPlans *CodecRegistry[PhysicalPlan]
+	Expressions   *CodecRegistry[Expression]
+	Aggregates    *CodecRegistry[AggregateExpression]
+	DataSources   *CodecRegistry[DataSource]
+
+	databases  map[string]*sql.DB
+	functions  map[string]*ScalarUDF
+	aggregates map[string]*AggregateUDF
+}
+
+// CodecRegistry maps the names of serialized types to the codecs of one kind
+// of value, such as logical plans
+type CodecRegistry[T any] struct {
+	kind   string
+	codecs map[string]codec[T]
+	names  map[reflect.Type]string
+}
+
+type codec[T any] struct {
+	encode func(c *PlanCodec, value T) (any, error)
+	decode func(c *PlanCodec, data json.RawMessage) (T, error)
+}
+
+func newCodecRegistry[T any](kind string) *CodecRegistry[T] {
+	return &CodecRegistry[T]{kind: kind, codecs: map[string]codec[T]{}, names: map[reflect.Type]string{}}
+}
+
+// Register adds the codec of type N to a registry under name. encode returns
+// the JSON form S of a value, which must marshal to an object, and decode
+// rebuilds the value from it. Both are given the PlanCodec so they can
+// serialize the plans and expressions the value holds.
+func Register[T, N, S any](r *CodecRegistry[T], name string, encode func(c *PlanCodec, value N) (S, error), decode func(c *PlanCodec, data S) (N, error)) {
+	var zero N
+	if _, ok := any(zero).(T); !ok {
+		panic(fmt.Sprintf("%T is not a %s", zero, r.kind))
+	}
+	r.codecs[name] = codec[T]{
+		encode: func(c *PlanCodec, value T) (any, error) {
+			return encode(c, any(value).(N))
+		},
+		decode: func(c *PlanCodec, data json.RawMessage) (T, error) {
+			var s S
+			if err := json.Unmarshal(data, &s); err != nil {
+				var zero T
+				return zero, fmt.Errorf("cannot decode %s %s: %w", r.kind, name, err)
+			}
+			value, err := decode(c, s)
+			return any(value).(T), err
+		},
+	}
+	r.names[reflect.TypeOf(zero)] = name
+}
+
+func (r *CodecRegistry[T]) encode(c *PlanCodec, value T) (json.RawMessage, error) {
+	name, ok := r.names[reflect.TypeOf(value)]
+	if !ok {
+		return nil, fmt.Errorf("no codec registered for %s %T", r.kind, value)
+	}
+	fields, err := r.codecs[name].encode(c, value)
+	if err != nil {
+		return nil, err
+	}
+	data, err := json.Marshal(fields)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) < 2 || data[0] != '{' {
+		return nil, fmt.Errorf("codec for %s %s must encode an object", r.kind, name)
+	}
+	typ, _ := json.Marshal(name)
+	out := append([]byte(`{"type":`), typ...)
+	if len(data) > 2 {
+		out = append(out, ',')
+	}
+	return append(out, data[1:]...), nil
+}
+
+func (r *CodecRegistry[T]) decode(c *PlanCodec, data json.RawMessage) (T, error) {
+	var zero T
+	var header struct {
+		Type string `json:"type"`
+	}
+	if err := json.Unmarshal(data, &header); err != nil {
+		return zero, fmt.Errorf("cannot decode %s: %w", r.kind, err)
+	}
+	codec, ok := r.codecs[header.Type]
+	if !ok {
+		return zero, fmt.Errorf("unknown %s type '%s'", r.kind, header.Type)
+	}
+	return codec.decode(c, data)
+}
+
+func encodeAll[T any](c *PlanCodec, r *CodecRegistry[T], values []T) ([]json.RawMessage, error) {
+	out := make([]json.RawMessage, len(values))
+	for i, value := range values {
+		data, err := r.encode(c, value)
+		if err != nil {
+			return nil, err
+		}
+		out[i] = data
+	}
+	return out, nil
+}
+
+func decodeAll[T any](c *PlanCodec, r *CodecRegistry[T], data []json.RawMessage) ([]T, error) {
+	out := make([]T, len(data))
+	for i, d := range data {
+		value, err := r.decode(c, d)
+		if err != nil {
+			return nil, err
+		}
+		out[i] = value
+	}
+	return out, nil
+}
+
+// NewPlanCodec returns a codec for the engine's own plan nodes, expressions
+// and data sources
+func NewPlanCodec() *PlanCodec {
+	c := &PlanCodec{
+		LogicalPlans:  newCodecRegistry[LogicalPlan]("logical plan"),
+		LogicalExprs:  newCodecRegistry[LogicalExpr]("logical expression"),
+		PhysicalPlans: newCodecRegistry[PhysicalPlan]("physical plan"),
+		Expressions:   newCodecRegistry[Expression]("expression"),
+		Aggregates:    newCodecRegistry[AggregateExpression]("aggregate expression"),
+		DataSources:   newCodecRegistry[DataSource]("data source"),
+		databases:     map[string]*sql.DB{},
+		functions:     map[string]*ScalarUDF{},
+		aggregates:    map[string]*AggregateUDF{},
+	}
+	registerLogicalPlans(c.LogicalPlans)
+	registerLogicalExprs(c.LogicalExprs)
+	registerPhysicalPlans(c.PhysicalPlans)
+	registerExpressions(c.Expressions)
+	registerAggregates(c.Aggregates)
+	registerDataSources(c.DataSources)
+	return c
+}
+
+// RegisterDatabase names a database that SQL data sources read from
+func (c *PlanCodec) RegisterDatabase(name string, db *sql.DB) {
+	c.databases[name] = db
+}
+
+// RegisterFunction makes a scalar function available to decoded plans that
+// call it by name
+func (c *PlanCodec) RegisterFunction(udf *ScalarUDF) {
+	c.functions[strings.ToLower(udf.Name)] = udf
+}
+
+// RegisterAggregateFunction makes an aggregate function available to
+// decoded plans that call it by name
+func (c *PlanCodec) RegisterAggregateFunction(udaf *AggregateUDF) {
+	c.aggregates[strings.ToLower(udaf.Name)] = udaf
+}
+
+func (c *PlanCodec) aggregateFunction(name string) (*AggregateUDF, error) {
+	udaf, ok := c.aggregates[strings.ToLower(name)]
+	if !ok {
+		return nil, fmt.Errorf("unknown aggregate function '%s'", name)
+	}
+	return udaf, nil
+}
+
+func (c *PlanCodec) function(name string) (*ScalarUDF, error) {
+	udf, ok := c.functions[strings.ToLower(name)]
+	if !ok {
+		return nil, fmt.Errorf("unknown function '%s'", name)
+	}
+	return udf, nil
+}
+
+func (c *PlanCodec) EncodeLogicalPlan(plan LogicalPlan) (json.RawMessage, error) {
+	return c.LogicalPlans.encode(c, plan)
+}
+
+func (c *PlanCodec) DecodeLogicalPlan(data json.RawMessage) (LogicalPlan, error) {
+	return c.LogicalPlans.decode(c, data)
+}
+
+func (c *PlanCodec) EncodeLogicalExpr(expr LogicalExpr) (json.RawMessage, error) {
+	return c.LogicalExprs.encode(c, expr)
+}
+
+func (c *PlanCodec) DecodeLogicalExpr(data json.RawMessage) (LogicalExpr, error) {
+	return c.LogicalExprs.decode(c, data)
+}
+
+func (c *PlanCodec) EncodePhysicalPlan(plan PhysicalPlan) (json.RawMessage, error) {
+	return c.PhysicalPlans.encode(c, plan)
+}
+
+// DecodePhysicalPlan reads a physical plan back. Its operators collect
+// metrics as those created by the QueryPlanner do.
+func (c *PlanCodec) DecodePhysicalPlan(data json.RawMessage) (PhysicalPlan, error) {
+	return c.PhysicalPlans.decode(c, data)
+}
+
+func (c *PlanCodec) EncodeExpression(expr Expression) (json.RawMessage, error) {
+	return c.Expressions.encode(c, expr)
+}
+
+func (c *PlanCodec) DecodeExpression(data json.RawMessage) (Expression, error) {
+	return c.Expressions.decode(c, data)
+}
+
+func (c *PlanCodec) EncodeDataSource(source DataSource) (json.RawMessage, error) {
+	return c.DataSources.encode(c, source)
+}
+
+func (c *PlanCodec) DecodeDataSource(data json.RawMessage) (DataSource, error) {
+	return c.DataSources.decode(c, data)
+}
+
+// schemaJSON is the serialized form of a schema. A nil schema, such as that
+// of a source that infers its schema when it is first read, is null.
+type schemaJSON []fieldJSON
+
+type fieldJSON struct {
+	Name     string   `json:"name"`
+	Type     typeJSON `json:"data_type"`
+	Nullable bool     `json:"nullable"`
+}
+
+type typeJSON struct {
+	Name      string      `json:"name"`
+	Unit      string      `json:"unit,omitempty"`
+	TimeZone  string      `json:"timezone,omitempty"`
+	Precision int32       `json:"precision,omitempty"`
+	Scale     int32       `json:"scale,omitempty"`
+	Fields    []fieldJSON `json:"fields,omitempty"`
+}
+
+// serializableTypes are the types without parameters, by name
+var serializableTypes = map[string]arrow.DataType{}
+
+func init() {
+	for _, dtype := range []arrow.DataType{
+		arrow.Null, arrow.FixedWidthTypes.Boolean,
+		arrow.PrimitiveTypes.Int8, arrow.PrimitiveTypes.Int16, arrow.PrimitiveTypes.Int32, arrow.PrimitiveTypes.Int64,
+		arrow.PrimitiveTypes.Uint8, arrow.PrimitiveTypes.Uint16, arrow.PrimitiveTypes.Uint32, arrow.PrimitiveTypes.Uint64,
+		arrow.FixedWidthTypes.Float16, arrow.PrimitiveTypes.Float32, arrow.PrimitiveTypes.Float64,
+		arrow.PrimitiveTypes.Date32, arrow.PrimitiveTypes.Date64,
+		arrow.BinaryTypes.Binary, arrow.BinaryTypes.String, arrow.BinaryTypes.LargeBinary, arrow.BinaryTypes.LargeString,
+	} {
+		serializableTypes[dtype.Name()] = dtype
+	}
+}
+
+var timeUnits = []arrow.TimeUnit{arrow.Second, arrow.Millisecond, arrow.Microsecond, arrow.Nanosecond}
+
+func encodeSchema(schema Schema) (schemaJSON, error) {
+	if schema.Schema == nil {
+		return nil, nil
+	}
+	return encodeFields(schema.Fields())
+}
+
+func decodeSchema(s schemaJSON) (Schema, error) {
+	if s == nil {
+		return Schema{}, nil
+	}
+	fields, err := decodeFields(s)
+	if err != nil {
+		return Schema{}, err
+	}
+	return Schema{arrow.NewSchema(fields, nil)}, nil
+}
+
+func encodeFields(fields []arrow.Field) ([]fieldJSON, error) {
+	out := make([]fieldJSON, len(fields))
+	for i, f := range fields {
+		dtype, err := encodeType(f.Type)
+		if err != nil {
+			return nil, err
+		}
+		out[i] = fieldJSON{f.Name, dtype, f.Nullable}
+	}
+	return out, nil
+}
+
+func decodeFields(fields []fieldJSON) ([]arrow.Field, error) {
+	out := make([]arrow.Field, len(fields))
+	for i, f := range fields {
+		dtype, err := decodeType(f.Type)
+		if err != nil {
+			return nil, err
+		}
+		out[i] = arrow.Field{Name: f.Name, Type: dtype, Nullable: f.Nullable}
+	}
+	return out, nil
+}
+
+func encodeType(dtype arrow.DataType) (typeJSON, error) {
+	if t, ok := serializableTypes[dtype.Name()]; ok && arrow.TypeEqual(t, dtype) {
+		return typeJSON{Name: dtype.Name()}, nil
+	}
+	switch t := dtype.(type) {
+	case *arrow.TimestampType:
+		return typeJSON{Name: t.Name(), Unit: t.Unit.String(), TimeZone: t.TimeZone}, nil
+	case *arrow.Time32Type:
+		return typeJSON{Name: t.Name(), Unit: t.Unit.String()}, nil
+	case *arrow.Time64Type:
+		return typeJSON{Name: t.Name(), Unit: t.Unit.String()}, nil
+	case *arrow.DurationType:
+		return typeJSON{Name: t.Name(), Unit: t.Unit.String()}, nil
+	case *arrow.Decimal128Type:
+		return typeJSON{Name: t.Name(), Precision: t.Precision, Scale: t.Scale}, nil
+	case *arrow.ListType:
+		fields, err := encodeFields([]arrow.Field{t.ElemField()})
+		return typeJSON{Name: t.Name(), Fields: fields}, err
+	case *arrow.StructType:
+		fields, err := encodeFields(t.Fields())
+		return typeJSON{Name: t.Name(), Fields: fields}, err
+	}
+	return typeJSON{}, fmt.Errorf("cannot serialize type %s", dtype)
+}
+
+func decodeType(t typeJSON) (arrow.DataType, error) {
+	if dtype, ok := serializableTypes[t.Name]; ok {
+		return dtype, nil
+	}
+	var unit arrow.TimeUnit
+	for _, u := range timeUnits {
+		if u.String() == t.Unit {
+			unit = u
+		}
+	}
+	switch t.Name {
+	case "timestamp":
+		return &arrow.TimestampType{Unit: unit, TimeZone: t.TimeZone}, nil
+	case "time32":
+		return &arrow.Time32Type{Unit: unit}, nil
+	case "time64":
+		return &arrow.Time64Type{Unit: unit}, nil
+	case "duration":
+		return &arrow.DurationType{Unit: unit}, nil
+	case "decimal":
+		return &arrow.Decimal128Type{Precision: t.Precision, Scale: t.Scale}, nil
+	case "list", "struct":
+		fields, err := decodeFields(t.Fields)
+		if err != nil {
+			return nil, err
+		}
+		if t.Name == "struct" {
+			return arrow.StructOf(fields...), nil
+		}
+		if len(fields) != 1 {
+			return nil, fmt.Errorf("list type must have one element field")
+		}
+		return arrow.ListOfField(fields[0]), nil
+	}
+	return nil, fmt.Errorf("unknown type '%s'", t.Name)
+}
+
+type scanJSON struct {
+	Path       string            `json:"path"`
+	Source     json.RawMessage   `json:"source"`
+	Projection []string          `json:"projection"`
+	Fetch      int               `json:"fetch,omitempty"`
+	Filters    []json.RawMessage `json:"filters,omitempty"`
+}
+
+type projectionJSON struct {
+	Input json.RawMessage   `json:"input"`
+	Expr  []json.RawMessage `json:"expr"`
+}
+
+type selectionJSON struct {
+	Input json.RawMessage `json:"input"`
+	Expr  json.RawMessage `json:"expr"`
+}
+
+type aggregateJSON struct {
+	Input         json.RawMessage     `json:"input"`
+	GroupExpr     []json.RawMessage   `json:"group_expr"`
+	AggregateExpr []aggregateExprJSON `json:"aggregate_expr"`
+}
+
+type aggregateExprJSON struct {
+	Name  string          `json:"name"`
+	Expr  json.RawMessage `json:"expr"`
+	Alias string          `json:"alias,omitempty"`
+	// UserDefined is set for functions registered with the codec
+	UserDefined bool `json:"user_defined,omitempty"`
+}
+
+type limitJSON struct {
+	Input json.RawMessage `json:"input"`
+	Skip  int             `json:"skip"`
+	Fetch int             `json:"fetch"`
+}
+
+type unnestJSON struct {
+	Input  json.RawMessage `json:"input"`
+	Column string          `json:"column"`
+}
+
+type sortJSON struct {
+	Input json.RawMessage `json:"input"`
+	Expr  []sortExprJSON  `json:"expr"`
+	Fetch int             `json:"fetch,omitempty"`
+}
+
+type sortExprJSON struct {
+	Expr json.RawMessage `json:"expr"`
+	Asc  bool            `json:"asc"`
+}
+
+func registerLogicalPlans(r *CodecRegistry[LogicalPlan]) {
+	Register(r, "Scan", func(c *PlanCodec, p Scan) (scanJSON, error) {
+		source, err := c.EncodeDataSource(p.Source)
+		if err != nil {
+			return scanJSON{}, err
+		}
+		filters, err := encodeAll(c, c.LogicalExprs, p.Filters)
+		return scanJSON{p.Path, source, p.Projection, p.Fetch, filters}, err
+	}, func(c *PlanCodec, s scanJSON) (Scan, error) {
+		source, err := c.DecodeDataSource(s.Source)
+		if err != nil {
+			return Scan{}, err
+		}
+		filters, err := decodeAll(c, c.LogicalExprs, s.Filters)
+		if len(filters) == 0 {
+			filters = nil
+		}
+		if s.Projection == nil {
+			s.Projection = []string{}
+		}
+		return Scan{s.Path, source, s.Projection, s.Fetch, filters}, err
+	})
+	Register(r, "Projection", func(c *PlanCodec, p Projection) (projectionJSON, error) {
+		input, err := c.EncodeLogicalPlan(p.Input)
+		if err != nil {
+			return projectionJSON{}, err
+		}
+		expr, err := encodeAll(c, c.LogicalExprs, p.Expr)
+		return projectionJSON{input, expr}, err
+	}, func(c *PlanCodec, s projectionJSON) (Projection, error) {
+		input, err := c.DecodeLogicalPlan(s.Input)
+		if err != nil {
+			return Projection{}, err
+		}
+		expr, err := decodeAll(c, c.LogicalExprs, s.Expr)
+		return Projection{input, expr}, err
+	})
+	Register(r, "Selection", func(c *PlanCodec, p Selection) (selectionJSON, error) {
+		input, err := c.EncodeLogicalPlan(p.Input)
+		if err != nil {
+			return selectionJSON{}, err
+		}
+		expr, err := c.EncodeLogicalExpr(p.Expr)
+		return selectionJSON{input, expr}, err
+	}, func(c *PlanCodec, s selectionJSON) (Selection, error) {
+		input, err := c.DecodeLogicalPlan(s.Input)
+		if err != nil {
+			return Selection{}, err
+		}
+		expr, err := c.DecodeLogicalExpr(s.Expr)
+		return Selection{input, expr}, err
+	})
+	Register(r, "Aggregate", func(c *PlanCodec, p Aggregate) (aggregateJSON, error) {
+		input, err := c.EncodeLogicalPlan(p.Input)
+		if err != nil {
+			return aggregateJSON{}, err
+		}
+		group, err := encodeAll(c, c.LogicalExprs, p.GroupExpr)
+		if err != nil {
+			return aggregateJSON{}, err
+		}
+		aggregates := make([]aggregateExprJSON, len(p.AggregateExpr))
+		for i, e := range p.AggregateExpr {
+			expr, err := c.EncodeLogicalExpr(e.Expr)
+			if err != nil {
+				return aggregateJSON{}, err
+			}
+			aggregates[i] = aggregateExprJSON{e.Name, expr, e.Alias, e.Func != nil}
+		}
+		return aggregateJSON{input, group, aggregates}, nil
+	}, func(c *PlanCodec, s aggregateJSON) (Aggregate, error) {
+		input, err := c.DecodeLogicalPlan(s.Input)
+		if err != nil {
+			return Aggregate{}, err
+		}
+		group, err := decodeAll(c, c.LogicalExprs, s.GroupExpr)
+		if err != nil {
+			return Aggregate{}, err
+		}
+		aggregates := make([]AggregateExpr, len(s.AggregateExpr))
+		for i, e := range s.AggregateExpr {
+			expr, err := c.DecodeLogicalExpr(e.Expr)
+			if err != nil {
+				return Aggregate{}, err
+			}
+			aggregates[i] = AggregateExpr{Name: e.Name, Expr: expr, Alias: e.Alias}
+			if e.UserDefined {
+				if aggregates[i].Func, err = c.aggregateFunction(e.Name); err != nil {
+					return Aggregate{}, err
+				}
+			}
+		}
+		return Aggregate{input, group, aggregates}, nil
+	})
+	Register(r, "Limit", func(c *PlanCodec, p Limit) (limitJSON, error) {
+		input, err := c.EncodeLogicalPlan(p.Input)
+		return limitJSON{input, p.Skip, p.Fetch}, err
+	}, func(c *PlanCodec, s limitJSON) (Limit, error) {
+		input, err := c.DecodeLogicalPlan(s.Input)
+		return Limit{input, s.Skip, s.Fetch}, err
+	})
+	Register(r, "Unnest", func(c *PlanCodec, p Unnest) (unnestJSON, error) {
+		input, err := c.EncodeLogicalPlan(p.Input)
+		return unnestJSON{input, p.Column}, err
+	}, func(c *PlanCodec, s unnestJSON) (Unnest, error) {
+		input, err := c.DecodeLogicalPlan(s.Input)
+		return Unnest{input, s.Column}, err
+	})
+	Register(r, "Sort", func(c *PlanCodec, p Sort) (sortJSON, error) {
+		input, err := c.EncodeLogicalPlan(p.Input)
+		if err != nil {
+			return sortJSON{}, err
+		}
+		exprs := make([]sortExprJSON, len(p.Expr))
+		for i, e := range p.Expr {
+			expr, err := c.EncodeLogicalExpr(e.Expr)
+			if err != nil {
+				return sortJSON{}, err
+			}
+			exprs[i] = sortExprJSON{expr, e.Asc}
+		}
+		return sortJSON{input, exprs, p.Fetch}, nil
+	}, func(c *PlanCodec, s sortJSON) (Sort, error) {
+		input, err := c.DecodeLogicalPlan(s.Input)
+		if err != nil {
+			return Sort{}, err
+		}
+		exprs := make([]SortExpr, len(s.Expr))
+		for i, e := range s.Expr {
+			expr, err := c.DecodeLogicalExpr(e.Expr)
+			if err != nil {
+				return Sort{}, err
+			}
+			exprs[i] = SortExpr{expr, e.Asc}
+		}
+		return Sort{input, exprs, s.Fetch}, nil
+	})
+}
+
+type columnJSON struct {
+	Name string `json:"name"`
+}
+
+type literalJSON[T any] struct {
+	Value T `json:"value"`
+}
+
+type binaryExprJSON struct {
+	Name string          `json:"name"`
+	Op   string          `json:"op"`
+	L    json.RawMessage `json:"l"`
+	R    json.RawMessage `json:"r"`
+}
+
+type functionJSON struct {
+	Name string            `json:"name"`
+	Args []json.RawMessage `json:"args"`
+}
+
+type likeJSON struct {
+	Expr            json.RawMessage `json:"expr"`
+	Pattern         json.RawMessage `json:"pattern"`
+	Negated         bool            `json:"negated,omitempty"`
+	CaseInsensitive bool            `json:"case_insensitive,omitempty"`
+	Similar         bool            `json:"similar,omitempty"`
+}
+
+type aliasJSON struct {
+	Expr  json.RawMessage `json:"expr"`
+	Alias string          `json:"alias"`
+}
+
+func encodeBinaryExpr(c *PlanCodec, name, op string, l, r LogicalExpr) (binaryExprJSON, error) {
+	ll, err := c.EncodeLogicalExpr(l)
+	if err != nil {
+		return binaryExprJSON{}, err
+	}
+	rr, err := c.EncodeLogicalExpr(r)
+	return binaryExprJSON{name, op, ll, rr}, err
+}
+
+func decodeBinaryExpr(c *PlanCodec, s binaryExprJSON) (LogicalExpr, LogicalExpr, error) {
+	l, err := c.DecodeLogicalExpr(s.L)
+	if err != nil {
+		return nil, nil, err
+	}
+	r, err := c.DecodeLogicalExpr(s.R)
+	return l, r, err
+}
+
+func registerLogicalExprs(r *CodecRegistry[LogicalExpr]) {
+	Register(r, "Column", func(c *PlanCodec, e Column) (columnJSON, error) {
+		return columnJSON{e.name}, nil
+	}, func(c *PlanCodec, s columnJSON) (Column, error) {
+		return Column{s.Name}, nil
+	})
+	Register(r, "LiteralString", func(c *PlanCodec, e LiteralString) (literalJSON[string], error) {
+		return literalJSON[string]{e.Str}, nil
+	}, func(c *PlanCodec, s literalJSON[string]) (LiteralString, error) {
+		return LiteralString{s.Value}, nil
+	})
+	Register(r, "LiteralInt64", func(c *PlanCodec, e LiteralInt64) (literalJSON[int64], error) {
+		return literalJSON[int64]{e.n}, nil
+	}, func(c *PlanCodec, s literalJSON[int64]) (LiteralInt64, error) {
+		return LiteralInt64{s.Value}, nil
+	})
+	Register(r, "LiteralFloat64", func(c *PlanCodec, e LiteralFloat64) (literalJSON[float64], error) {
+		return literalJSON[float64]{e.n}, nil
+	}, func(c *PlanCodec, s literalJSON[float64]) (LiteralFloat64, error) {
+		return LiteralFloat64{s.Value}, nil
+	})
+	Register(r, "BooleanBinaryExpr", func(c *PlanCodec, e BooleanBinaryExpr) (binaryExprJSON, error) {
+		return encodeBinaryExpr(c, e.Name, e.Op, e.L, e.R)
+	}, func(c *PlanCodec, s binaryExprJSON) (BooleanBinaryExpr, error) {
+		l, r, err := decodeBinaryExpr(c, s)
+		return BooleanBinaryExpr{s.Name, s.Op, l, r}, err
+	})
+	Register(r, "MathExpr", func(c *PlanCodec, e MathExpr) (binaryExprJSON, error) {
+		return encodeBinaryExpr(c, e.Name, e.Op, e.L, e.R)
+	}, func(c *PlanCodec, s binaryExprJSON) (MathExpr, error) {
+		l, r, err := decodeBinaryExpr(c, s)
+		return MathExpr{s.Name, s.Op, l, r}, err
+	})
+	Register(r, "ScalarFunction", func(c *PlanCodec, e ScalarFunction) (functionJSON, error) {
+		args, err := encodeAll(c, c.LogicalExprs, e.Args)
+		return functionJSON{e.Func.Name, args}, err
+	}, func(c *PlanCodec, s functionJSON) (ScalarFunction, error) {
+		udf, err := c.function(s.Name)
+		if err != nil {
+			return ScalarFunction{}, err
+		}
+		args, err := decodeAll(c, c.LogicalExprs, s.Args)
+		return ScalarFunction{udf, args}, err
+	})
+	Register(r, "StringFunction", func(c *PlanCodec, e StringFunction) (functionJSON, error) {
+		args, err := encodeAll(c, c.LogicalExprs, e.Args)
+		return functionJSON{e.Name, args}, err
+	}, func(c *PlanCodec, s functionJSON) (StringFunction, error) {
+		if _, ok := stringFunctions[s.Name]; !ok {
+			return StringFunction{}, fmt.Errorf("unknown function '%s'", s.Name)
+		}
+		args, err := decodeAll(c, c.LogicalExprs, s.Args)
+		return StringFunction{s.Name, args}, err
+	})
+	Register(r, "LikeExpr", func(c *PlanCodec, e LikeExpr) (likeJSON, error) {
+		expr, err := c.EncodeLogicalExpr(e.Expr)
+		if err != nil {
+			return likeJSON{}, err
+		}
+		pattern, err := c.EncodeLogicalExpr(e.Pattern)
+		return likeJSON{expr, pattern, e.Negated, e.CaseInsensitive, e.Similar}, err
+	}, func(c *PlanCodec, s likeJSON) (LikeExpr, error) {
+		expr, err := c.DecodeLogicalExpr(s.Expr)
+		if err != nil {
+			return LikeExpr{}, err
+		}
+		pattern, err := c.DecodeLogicalExpr(s.Pattern)
+		return LikeExpr{expr, pattern, s.Negated, s.CaseInsensitive, s.Similar}, err
+	})
+	Register(r, "Alias", func(c *PlanCodec, e Alias) (aliasJSON, error) {
+		expr, err := c.EncodeLogicalExpr(e.Expr)
+		return aliasJSON{expr, e.Alias}, err
+	}, func(c *PlanCodec, s aliasJSON) (Alias, error) {
+		expr, err := c.DecodeLogicalExpr(s.Expr)
+		return Alias{expr, s.Alias}, err
+	})
+}
+
+type scanExecJSON struct {
+	Source     json.RawMessage `json:"source"`
+	Projection []string        `json:"projection"`
+	Fetch      int             `json:"fetch,omitempty"`
+}
+
+type projectionExecJSON struct {
+	Input  json.RawMessage   `json:"input"`
+	Schema schemaJSON        `json:"schema"`
+	Exprs  []json.RawMessage `json:"exprs"`
+}
+
+type sortExecJSON struct {
+	Input    json.RawMessage `json:"input"`
+	SortExpr []sortExprJSON  `json:"sort_expr"`
+	K        int             `json:"k,omitempty"`
+}
+
+type hashAggregateExecJSON struct {
+	Input         json.RawMessage   `json:"input"`
+	GroupExpr     []json.RawMessage `json:"group_expr"`
+	AggregateExpr []json.RawMessage `json:"aggregate_expr"`
+	Schema        schemaJSON        `json:"schema"`
+	Mode          string            `json:"mode"`
+}
+
+type coalesceJSON struct {
+	Input json.RawMessage `json:"input"`
+}
+
+type unnestExecJSON struct {
+	Input  json.RawMessage `json:"input"`
+	Column int             `json:"column"`
+}
+
+type repartitionJSON struct {
+	Input      json.RawMessage   `json:"input"`
+	Partitions int               `json:"partitions"`
+	HashExpr   []json.RawMessage `json:"hash_expr,omitempty"`
+}
+
+func encodeSortExpressions(c *PlanCodec, exprs []SortExpression) ([]sortExprJSON, error) {
+	out := make([]sortExprJSON, len(exprs))
+	for i, e := range exprs {
+		expr, err := c.EncodeExpression(e.Expr)
+		if err != nil {
+			return nil, err
+		}
+		out[i] = sortExprJSON{expr, e.Asc}
+	}
+	return out, nil
+}
+
+func decodeSortExpressions(c *PlanCodec, exprs []sortExprJSON) ([]SortExpression, error) {
+	out := make([]SortExpression, len(exprs))
+	for i, e := range exprs {
+		expr, err := c.DecodeExpression(e.Expr)
+		if err != nil {
+			return nil, err
+		}
+		out[i] = SortExpression{expr, e.Asc}
+	}
+	return out, nil
+}
+
+func registerPhysicalPlans(r *CodecRegistry[PhysicalPlan]) {
+	Register(r, "ScanExec", func(c *PlanCodec, p ScanExec) (scanExecJSON, error) {
+		source, err := c.EncodeDataSource(p.DataSource)
+		return scanExecJSON{source, p.Projection, p.Fetch}, err
+	}, func(c *PlanCodec, s scanExecJSON) (ScanExec, error) {
+		source, err := c.DecodeDataSource(s.Source)
+		if s.Projection == nil {
+			s.Projection = []string{}
+		}
+		return ScanExec{source, s.Projection, s.Fetch, NewMetrics()}, err
+	})
+	Register(r, "ProjectionExec", func(c *PlanCodec, p ProjectionExec) (projectionExecJSON, error) {
+		input, err := c.EncodePhysicalPlan(p.Input)
+		if err != nil {
+			return projectionExecJSON{}, err
+		}
+		schema, err := encodeSchema(p.Schema)
+		if err != nil {
+			return projectionExecJSON{}, err
+		}
+		exprs, err := encodeAll(c, c.Expressions, p.Exprs)
+		return projectionExecJSON{input, schema, exprs}, err
+	}, func(c *PlanCodec, s projectionExecJSON) (ProjectionExec, error) {
+		input, err := c.DecodePhysicalPlan(s.Input)
+		if err != nil {
+			return ProjectionExec{}, err
+		}
+		schema, err := decodeSchema(s.Schema)
+		if err != nil {
+			return ProjectionExec{}, err
+		}
+		exprs, err := decodeAll(c, c.Expressions, s.Exprs)
+		return ProjectionExec{input, schema, exprs, NewMetrics()}, err
+	})
+	Register(r, "SelectionExec", func(c *PlanCodec, p SelectionExec) (selectionJSON, error) {
+		input, err := c.EncodePhysicalPlan(p.Input)
+		if err != nil {
+			return selectionJSON{}, err
+		}
+		expr, err := c.EncodeExpression(p.Expr)
+		return selectionJSON{input, expr}, err
+	}, func(c *PlanCodec, s selectionJSON) (SelectionExec, error) {
+		input, err := c.DecodePhysicalPlan(s.Input)
+		if err != nil {
+			return SelectionExec{}, err
+		}
+		expr, err := c.DecodeExpression(s.Expr)
+		return SelectionExec{input, expr, NewMetrics()}, err
+	})
+	Register(r, "LimitExec", func(c *PlanCodec, p LimitExec) (limitJSON, error) {
+		input, err := c.EncodePhysicalPlan(p.Input)
+		return limitJSON{input, p.Skip, p.Fetch}, err
+	}, func(c *PlanCodec, s limitJSON) (LimitExec, error) {
+		input, err := c.DecodePhysicalPlan(s.Input)
+		return LimitExec{input, s.Skip, s.Fetch, NewMetrics()}, err
+	})
+	Register(r, "SortExec", func(c *PlanCodec, p SortExec) (sortExecJSON, error) {
+		input, err := c.EncodePhysicalPlan(p.Input)
+		if err != nil {
+			return sortExecJSON{}, err
+		}
+		exprs, err := encodeSortExpressions(c, p.SortExpr)
+		return sortExecJSON{input, exprs, 0}, err
+	}, func(c *PlanCodec, s sortExecJSON) (SortExec, error) {
+		input, err := c.DecodePhysicalPlan(s.Input)
+		if err != nil {
+			return SortExec{}, err
+		}
+		exprs, err := decodeSortExpressions(c, s.SortExpr)
+		return SortExec{input, exprs, NewMetrics()}, err
+	})
+	Register(r, "TopKExec", func(c *PlanCodec, p TopKExec) (sortExecJSON, error) {
+		input, err := c.EncodePhysicalPlan(p.Input)
+		if err != nil {
+			return sortExecJSON{}, err
+		}
+		exprs, err := encodeSortExpressions(c, p.SortExpr)
+		return sortExecJSON{input, exprs, p.K}, err
+	}, func(c *PlanCodec, s sortExecJSON) (TopKExec, error) {
+		input, err := c.DecodePhysicalPlan(s.Input)
+		if err != nil {
+			return TopKExec{}, err
+		}
+		exprs, err := decodeSortExpressions(c, s.SortExpr)
+		return TopKExec{input, exprs, s.K, NewMetrics()}, err
+	})
+	Register(r, "HashAggregateExec", func(c *PlanCodec, p HashAggregateExec) (hashAggregateExecJSON, error) {
+		input, err := c.EncodePhysicalPlan(p.Input)
+		if err != nil {
+			return hashAggregateExecJSON{}, err
+		}
+		group, err := encodeAll(c, c.Expressions, p.GroupExpr)
+		if err != nil {
+			return hashAggregateExecJSON{}, err
+		}
+		aggregates, err := encodeAll(c, c.Aggregates, p.AggregateExpr)
+		if err != nil {
+			return hashAggregateExecJSON{}, err
+		}
+		schema, err := encodeSchema(p.Schema)
+		return hashAggregateExecJSON{input, group, aggregates, schema, p.Mode.String()}, err
+	}, func(c *PlanCodec, s hashAggregateExecJSON) (HashAggregateExec, error) {
+		var mode AggregateMode
+		switch s.Mode {
+		case "Single":
+			mode = AggregateSingle
+		case "Partial":
+			mode = AggregatePartial
+		case "Final":
+			mode = AggregateFinal
+		default:
+			return HashAggregateExec{}, fmt.Errorf("unknown aggregate mode '%s'", s.Mode)
+		}
+		input, err := c.DecodePhysicalPlan(s.Input)
+		if err != nil {
+			return HashAggregateExec{}, err
+		}
+		group, err := decodeAll(c, c.Expressions, s.GroupExpr)
+		if err != nil {
+			return HashAggregateExec{}, err
+		}
+		aggregates, err := decodeAll(c, c.Aggregates, s.AggregateExpr)
+		if err != nil {
+			return HashAggregateExec{}, err
+		}
+		schema, err := decodeSchema(s.Schema)
+		return HashAggregateExec{input, group, aggregates, schema, mode, NewMetrics()}, err
+	})
+	Register(r, "CoalescePartitionsExec", func(c *PlanCodec, p CoalescePartitionsExec) (coalesceJSON, error) {
+		input, err := c.EncodePhysicalPlan(p.Input)
+		return coalesceJSON{input}, err
+	}, func(c *PlanCodec, s coalesceJSON) (CoalescePartitionsExec, error) {
+		input, err := c.DecodePhysicalPlan(s.Input)
+		return CoalescePartitionsExec{input, NewMetrics()}, err
+	})
+	Register(r, "UnnestExec", func(c *PlanCodec, p UnnestExec) (unnestExecJSON, error) {
+		input, err := c.EncodePhysicalPlan(p.Input)
+		return unnestExecJSON{input, p.Column}, err
+	}, func(c *PlanCodec, s unnestExecJSON) (UnnestExec, error) {
+		input, err := c.DecodePhysicalPlan(s.Input)
+		if err != nil {
+			return UnnestExec{}, err
+		}
+		schema := input.GetSchema()
+		if s.Column < 0 || s.Column >= len(schema.Fields()) {
+			return UnnestExec{}, fmt.Errorf("invalid unnest column %d", s.Column)
+		}
+		return UnnestExec{input, s.Column, unnestSchema(schema, s.Column), NewMetrics()}, nil
+	})
+	Register(r, "RepartitionExec", func(c *PlanCodec, p *RepartitionExec) (repartitionJSON, error) {
+		input, err := c.EncodePhysicalPlan(p.Input)
+		if err != nil {
+			return repartitionJSON{}, err
+		}
+		hash, err := encodeAll(c, c.Expressions, p.Partitioning.HashExpr)
+		return repartitionJSON{input, p.Partitioning.Partitions, hash}, err
+	}, func(c *PlanCodec, s repartitionJSON) (*RepartitionExec, error) {
+		input, err := c.DecodePhysicalPlan(s.Input)
+		if err != nil {
+			return nil, err
+		}
+		hash, err := decodeAll(c, c.Expressions, s.HashExpr)
+		if len(hash) == 0 {
+			hash = nil
+		}
+		return NewRepartitionExec(input, Partitioning{s.Partitions, hash}), err
+	})
+}
+
+type columnExpressionJSON struct {
+	Index int `json:"index"`
+}
+
+type booleanExpressionJSON struct {
+	L  json.RawMessage `json:"l"`
+	R  json.RawMessage `json:"r"`
+	Op string          `json:"op"`
+}
+
+type mathExpressionJSON struct {
+	L        json.RawMessage `json:"l"`
+	R        json.RawMessage `json:"r"`
+	Op       string          `json:"op"`
+	DataType typeJSON        `json:"data_type"`
+}
+
+type aggregateExpressionJSON struct {
+	Expr     json.RawMessage `json:"expr"`
+	DataType *typeJSON       `json:"data_type,omitempty"`
+}
+
+type aggregateUDFJSON struct {
+	Name string          `json:"name"`
+	Expr json.RawMessage `json:"expr"`
+}
+
+func registerExpressions(r *CodecRegistry[Expression]) {
+	Register(r, "ScalarFunctionExpression", func(c *PlanCodec, e ScalarFunctionExpression) (functionJSON, error) {
+		args, err := encodeAll(c, c.Expressions, e.args)
+		return functionJSON{e.udf.Name, args}, err
+	}, func(c *PlanCodec, s functionJSON) (ScalarFunctionExpression, error) {
+		udf, err := c.function(s.Name)
+		if err != nil {
+			return ScalarFunctionExpression{}, err
+		}
+		args, err := decodeAll(c, c.Expressions, s.Args)
+		return ScalarFunctionExpression{udf, args}, err
+	})
+	Register(r, "StringFunctionExpression", func(c *PlanCodec, e StringFunctionExpression) (functionJSON, error) {
+		args, err := encodeAll(c, c.Expressions, e.args)
+		return functionJSON{e.name, args}, err
+	}, func(c *PlanCodec, s functionJSON) (StringFunctionExpression, error) {
+		if _, ok := stringFunctions[s.Name]; !ok {
+			return StringFunctionExpression{}, fmt.Errorf("unknown function '%s'", s.Name)
+		}
+		args, err := decodeAll(c, c.Expressions, s.Args)
+		if err != nil {
+			return StringFunctionExpression{}, err
+		}
+		return newStringFunctionExpression(s.Name, args)
+	})
+	Register(r, "LikeExpression", func(c *PlanCodec, e LikeExpression) (likeJSON, error) {
+		expr, err := c.EncodeExpression(e.expr)
+		if err != nil {
+			return likeJSON{}, err
+		}
+		pattern, err := c.EncodeExpression(e.pattern)
+		return likeJSON{expr, pattern, e.negated, e.caseInsensitive, e.similar}, err
+	}, func(c *PlanCodec, s likeJSON) (LikeExpression, error) {
+		expr, err := c.DecodeExpression(s.Expr)
+		if err != nil {
+			return LikeExpression{}, err
+		}
+		pattern, err := c.DecodeExpression(s.Pattern)
+		if err != nil {
+			return LikeExpression{}, err
+		}
+		return newLikeExpression(expr, pattern, s.Negated, s.CaseInsensitive, s.Similar)
+	})
+	Register(r, "ColumnExpression", func(c *PlanCodec, e ColumnExpression) (columnExpressionJSON, error) {
+		return columnExpressionJSON{e.i}, nil
+	}, func(c *PlanCodec, s columnExpressionJSON) (ColumnExpression, error) {
+		return ColumnExpression{s.Index}, nil
+	})
+	Register(r, "LiteralInt64Expression", func(c *PlanCodec, e LiteralInt64Expression) (literalJSON[int64], error) {
+		return literalJSON[int64]{e.value}, nil
+	}, func(c *PlanCodec, s literalJSON[int64]) (LiteralInt64Expression, error) {
+		return LiteralInt64Expression{s.Value}, nil
+	})
+	Register(r, "LiteralFloat64Expression", func(c *PlanCodec, e LiteralFloat64Expression) (literalJSON[float64], error) {
+		return literalJSON[float64]{e.value}, nil
+	}, func(c *PlanCodec, s literalJSON[float64]) (LiteralFloat64Expression, error) {
+		return LiteralFloat64Expression{s.Value}, nil
+	})
+	Register(r, "LiteralStringExpression", func(c *PlanCodec, e LiteralStringExpression) (literalJSON[string], error) {
+		return literalJSON[string]{e.value}, nil
+	}, func(c *PlanCodec, s literalJSON[string]) (LiteralStringExpression, error) {
+		return LiteralStringExpression{s.Value}, nil
+	})
+	Register(r, "BooleanExpression", func(c *PlanCodec, e BooleanExpression) (booleanExpressionJSON, error) {
+		l, err := c.EncodeExpression(e.l)
+		if err != nil {
+			return booleanExpressionJSON{}, err
+		}
+		rr, err := c.EncodeExpression(e.r)
+		return booleanExpressionJSON{l, rr, e.op}, err
+	}, func(c *PlanCodec, s booleanExpressionJSON) (BooleanExpression, error) {
+		l, err := c.DecodeExpression(s.L)
+		if err != nil {
+			return BooleanExpression{}, err
+		}
+		rr, err := c.DecodeExpression(s.R)
+		return BooleanExpression{l, rr, s.Op}, err
+	})
+	Register(r, "MathExpression", func(c *PlanCodec, e MathExpression) (mathExpressionJSON, error) {
+		l, err := c.EncodeExpression(e.l)
+		if err != nil {
+			return mathExpressionJSON{}, err
+		}
+		rr, err := c.EncodeExpression(e.r)
+		if err != nil {
+			return mathExpressionJSON{}, err
+		}
+		dtype, err := encodeType(e.arrowType)
+		return mathExpressionJSON{l, rr, e.op, dtype}, err
+	}, func(c *PlanCodec, s mathExpressionJSON) (MathExpression, error) {
+		l, err := c.DecodeExpression(s.L)
+		if err != nil {
+			return MathExpression{}, err
+		}
+		rr, err := c.DecodeExpression(s.R)
+		if err != nil {
+			return MathExpression{}, err
+		}
+		dtype, err := decodeType(s.DataType)
+		return MathExpression{l, rr, s.Op, dtype}, err
+	})
+}
+
+// encodeAggregate serializes the input expression of an aggregate and, for
+// those whose result has the input's type, that type
+func encodeAggregate(c *PlanCodec, expr Expression, dataType arrow.DataType) (aggregateExpressionJSON, error) {
+	input, err := c.EncodeExpression(expr)
+	if err != nil || dataType == nil {
+		return aggregateExpressionJSON{input, nil}, err
+	}
+	dtype, err := encodeType(dataType)
+	return aggregateExpressionJSON{input, &dtype}, err
+}
+
+func decodeAggregate(c *PlanCodec, s aggregateExpressionJSON) (Expression, arrow.DataType, error) {
+	input, err := c.DecodeExpression(s.Expr)
+	if err != nil || s.DataType == nil {
+		return input, nil, err
+	}
+	dtype, err := decodeType(*s.DataType)
+	return input, dtype, err
+}
+
+func registerAggregates(r *CodecRegistry[AggregateExpression]) {
+	Register(r, "AggregateUDFExpression", func(c *PlanCodec, e AggregateUDFExpression) (aggregateUDFJSON, error) {
+		expr, err := c.EncodeExpression(e.expr)
+		return aggregateUDFJSON{e.udaf.Name, expr}, err
+	}, func(c *PlanCodec, s aggregateUDFJSON) (AggregateUDFExpression, error) {
+		udaf, err := c.aggregateFunction(s.Name)
+		if err != nil {
+			return AggregateUDFExpression{}, err
+		}
+		expr, err := c.DecodeExpression(s.Expr)
+		return AggregateUDFExpression{udaf, expr}, err
+	})
+	Register(r, "MaxExpression", func(c *PlanCodec, e MaxExpression) (aggregateExpressionJSON, error) {
+		return encodeAggregate(c, e.expr, e.dataType)
+	}, func(c *PlanCodec, s aggregateExpressionJSON) (MaxExpression, error) {
+		expr, dtype, err := decodeAggregate(c, s)
+		return MaxExpression{expr, dtype}, err
+	})
+	Register(r, "MinExpression", func(c *PlanCodec, e MinExpression) (aggregateExpressionJSON, error) {
+		return encodeAggregate(c, e.expr, e.dataType)
+	}, func(c *PlanCodec, s aggregateExpressionJSON) (MinExpression, error) {
+		expr, dtype, err := decodeAggregate(c, s)
+		return MinExpression{expr, dtype}, err
+	})
+	Register(r, "SumExpression", func(c *PlanCodec, e SumExpression) (aggregateExpressionJSON, error) {
+		return encodeAggregate(c, e.expr, e.dataType)
+	}, func(c *PlanCodec, s aggregateExpressionJSON) (SumExpression, error) {
+		expr, dtype, err := decodeAggregate(c, s)
+		return SumExpression{expr, dtype}, err
+	})
+	Register(r, "AvgExpression", func(c *PlanCodec, e AvgExpression) (aggregateExpressionJSON, error) {
+		return encodeAggregate(c, e.expr, nil)
+	}, func(c *PlanCodec, s aggregateExpressionJSON) (AvgExpression, error) {
+		expr, _, err := decodeAggregate(c, s)
+		return AvgExpression{expr}, err
+	})
+	Register(r, "CountExpression", func(c *PlanCodec, e CountExpression) (aggregateExpressionJSON, error) {
+		return encodeAggregate(c, e.expr, nil)
+	}, func(c *PlanCodec, s aggregateExpressionJSON) (CountExpression, error) {
+		expr, _, err := decodeAggregate(c, s)
+		return CountExpression{expr}, err
+	})
+}
+
+type csvSourceJSON struct {
+	Filename   string     `json:"filename"`
+	Schema     schemaJSON `json:"schema"`
+	HasHeaders bool       `json:"has_headers"`
+	BatchSize  int        `json:"batch_size,omitempty"`
+	Partitions int        `json:"partitions"`
+}
+
+type jsonSourceJSON struct {
+	Filename   string     `json:"filename"`
+	Schema     schemaJSON `json:"schema"`
+	BatchSize  int        `json:"batch_size,omitempty"`
+	SampleSize int        `json:"sample_size,omitempty"`
+}
+
+type parquetSourceJSON struct {
+	Filenames  []string          `json:"filenames"`
+	BatchSize  int               `json:"batch_size,omitempty"`
+	Partitions int               `json:"partitions"`
+	Filters    []json.RawMessage `json:"filters,omitempty"`
+}
+
+type arrowSourceJSON struct {
+	Filename   string `json:"filename"`
+	Partitions int    `json:"partitions"`
+}
+
+type seriesSourceJSON struct {
+	Name       string `json:"name"`
+	Start      int64  `json:"start"`
+	Stop       int64  `json:"stop"`
+	Step       int64  `json:"step"`
+	Inclusive  bool   `json:"inclusive"`
+	BatchSize  int    `json:"batch_size"`
+	Partitions int    `json:"partitions"`
+}
+
+type listingTableJSON struct {
+	Path       string            `json:"path"`
+	Format     string            `json:"format"`
+	BatchSize  int               `json:"batch_size,omitempty"`
+	Partitions int               `json:"partitions"`
+	Filters    []json.RawMessage `json:"filters,omitempty"`
+}
+
+// memTableJSON holds the table's batches as an Arrow IPC stream
+type memTableJSON struct {
+	Schema     schemaJSON `json:"schema"`
+	Partitions int        `json:"partitions"`
+	Batches    []byte     `json:"batches"`
+}
+
+type sqlSourceJSON struct {
+	Database  string            `json:"database"`
+	Dialect   string            `json:"dialect"`
+	Table     string            `json:"table"`
+	BatchSize int               `json:"batch_size,omitempty"`
+	Filters   []json.RawMessage `json:"filters,omitempty"`
+}
+
+// decodeFilters reads filters pushed down to a source, which are nil when
+// there are none
+func decodeFilters(c *PlanCodec, data []json.RawMessage) ([]LogicalExpr, error) {
+	if len(data) == 0 {
+		return nil, nil
+	}
+	return decodeAll(c, c.LogicalExprs, data)
+}
+
+func registerDataSources(r *CodecRegistry[DataSource]) {
+	Register(r, "CsvDataSource", func(c *PlanCodec, ds *CsvDataSource) (csvSourceJSON, error) {
+		schema, err := encodeSchema(ds.Schema)
+		return csvSourceJSON{ds.Filename, schema, ds.hasHeaders, ds.batchSize, ds.partitions}, err
+	}, func(c *PlanCodec, s csvSourceJSON) (*CsvDataSource, error) {
+		schema, err := decodeSchema(s.Schema)
+		return NewCsvDataSource(s.Filename, schema, s.HasHeaders, s.BatchSize).WithPartitions(s.Partitions), err
+	})
+	Register(r, "JsonDataSource", func(c *PlanCodec, ds *JsonDataSource) (jsonSourceJSON, error) {
+		schema, err := encodeSchema(ds.Schema)
+		return jsonSourceJSON{ds.Filename, schema, ds.batchSize, ds.sampleSize}, err
+	}, func(c *PlanCodec, s jsonSourceJSON) (*JsonDataSource, error) {
+		schema, err := decodeSchema(s.Schema)
+		return NewJsonDataSource(s.Filename, schema, s.BatchSize).WithSampleSize(s.SampleSize), err
+	})
+	Register(r, "ParquetDataSource", func(c *PlanCodec, ds *ParquetDataSource) (parquetSourceJSON, error) {
+		filters, err := encodeAll(c, c.LogicalExprs, ds.filters)
+		return parquetSourceJSON{ds.Filenames, ds.batchSize, ds.partitions, filters}, err
+	}, func(c *PlanCodec, s parquetSourceJSON) (*ParquetDataSource, error) {
+		ds := NewParquetDataSource(s.Filenames, s.BatchSize).WithPartitions(s.Partitions)
+		filters, err := decodeFilters(c, s.Filters)
+		ds.filters = filters
+		return ds, err
+	})
+	Register(r, "ArrowIpcDataSource", func(c *PlanCodec, ds *ArrowIpcDataSource) (arrowSourceJSON, error) {
+		return arrowSourceJSON{ds.Filename, ds.partitions}, nil
+	}, func(c *PlanCodec, s arrowSourceJSON) (*ArrowIpcDataSource, error) {
+		return NewArrowIpcDataSource(s.Filename).WithPartitions(s.Partitions), nil
+	})
+	Register(r, "ListingTable", func(c *PlanCodec, t *ListingTable) (listingTableJSON, error) {
+		filters, err := encodeAll(c, c.LogicalExprs, t.filters)
+		return listingTableJSON{t.Path, t.Format, t.batchSize, t.partitions, filters}, err
+	}, func(c *PlanCodec, s listingTableJSON) (*ListingTable, error) {
+		t := NewListingTable(s.Path, s.Format, s.BatchSize).WithPartitions(s.Partitions)
+		filters, err := decodeFilters(c, s.Filters)
+		t.filters = filters
+		return t, err
+	})
+	Register(r, "MemTable", encodeMemTable, decodeMemTable)
+	Register(r, "SeriesDataSource", func(c *PlanCodec, s *SeriesDataSource) (seriesSourceJSON, error) {
+		return seriesSourceJSON{s.Name, s.Start, s.Stop, s.Step, s.Inclusive, s.batchSize, s.partitions}, nil
+	}, func(c *PlanCodec, s seriesSourceJSON) (*SeriesDataSource, error) {
+		if s.Step == 0 {
+			return nil, fmt.Errorf("the step of series %s cannot be zero", s.Name)
+		}
+		return NewSeriesDataSource(s.Name, s.Start, s.Stop, s.Step, s.Inclusive, s.BatchSize).WithPartitions(s.Partitions), nil
+	})
+	Register(r, "SqlDataSource", func(c *PlanCodec, ds *SqlDataSource) (sqlSourceJSON, error) {
+		database := ""
+		for name, db := range c.databases {
+			if db == ds.DB {
+				database = name
+			}
+		}
+		if database == "" {
+			return sqlSourceJSON{}, fmt.Errorf("the database of SQL table %s is not registered with the codec", ds.Table)
+		}
+		filters, err := encodeAll(c, c.LogicalExprs, ds.filters)
+		return sqlSourceJSON{database, ds.Dialect.Name, ds.Table, ds.batchSize, filters}, err
+	}, func(c *PlanCodec, s sqlSourceJSON) (*SqlDataSource, error) {
+		db, ok := c.databases[s.Database]
+		if !ok {
+			return nil, fmt.Errorf("unknown database '%s'", s.Database)
+		}
+		var dialect SqlDialect
+		for _, d := range []SqlDialect{SQLite, Postgres, MySQL} {
+			if d.Name == s.Dialect {
+				dialect = d
+			}
+		}
+		if dialect.Name == "" {
+			return nil, fmt.Errorf("unknown SQL dialect '%s'", s.Dialect)
+		}
+		ds := NewSqlDataSource(db, dialect, s.Table, s.BatchSize)
+		filters, err := decodeFilters(c, s.Filters)
+		ds.filters = filters
+		return ds, err
+	})
+}
+
+func encodeMemTable(c *PlanCodec, t *MemTable) (memTableJSON, error) {
+	schema, err := encodeSchema(t.Schema)
+	if err != nil {
+		return memTableJSON{}, err
+	}
+	var buf bytes.Buffer
+	writer := ipc.NewWriter(&buf, ipc.WithSchema(nullableSchema(t.Schema)))
+	for _, batch := range t.batches {
+		record := toArrowRecord(batch)
+		err := writer.Write(record)
+		record.Release()
+		if err != nil {
+			return memTableJSON{}, err
+		}
+	}
+	if err := writer.Close(); err != nil {
+		return memTableJSON{}, err
+	}
+	return memTableJSON{schema, t.partitions, buf.Bytes()}, nil
+}
+
+func decodeMemTable(c *PlanCodec, s memTableJSON) (*MemTable, error) {
+	schema, err := decodeSchema(s.Schema)
+	if err != nil {
+		return nil, err
+	}
+	reader, err := ipc.NewReader(bytes.NewReader(s.Batches))
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Release()
+	batches := []RecordBatch{}
+	for reader.Next() {
+		record := reader.Record()
+		record.Retain()
+		columns := make([]ColumnVector, record.NumCols())
+		for i, col := range record.Columns() {
+			columns[i] = drogo.FromArrow(col)
+		}
+		batches = append(batches, RecordBatch{schema, columns})
+	}
+	if err := reader.Err(); err != nil {
+		return nil, err
+	}
+	t, err := NewMemTable(schema, batches)
+	if err != nil {
+		return nil, err
+	}
+	return t.WithPartitions(s.Partitions), nil
+}
@@ -0,0 +1,236 @@
+package engine
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/apache/arrow/go/v12/arrow"
+	"github.com/briansterle/drogo"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// roundTrip encodes a value, decodes it and checks that encoding the decoded
+// value gives the same JSON
+func roundTrip[T any](t *testing.T, c *PlanCodec, r *CodecRegistry[T], value T) (T, string) {
+	data, err := r.encode(c, value)
+	require.NoError(t, err)
+	decoded, err := r.decode(c, data)
+	require.NoError(t, err)
+	again, err := r.encode(c, decoded)
+	require.NoError(t, err)
+	assert.JSONEq(t, string(data), string(again))
+	return decoded, string(data)
+}
+
+func collectRows(t *testing.T, stream RecordBatchStream) []string {
+	batches, err := ReadAll(stream)
+	require.NoError(t, err)
+	rows := []string{}
+	for _, batch := range batches {
+		for i := 0; i < batch.RowCount(); i++ {
+			rows = append(rows, fmt.Sprint(rowValues(batch.Fields, i)))
+		}
+	}
+	return rows
+}
+
+// assertCovered checks that the JSON documents use every type in a registry
+func assertCovered[T any](t *testing.T, r *CodecRegistry[T], docs string) {
+	for name := range r.codecs {
+		assert.Contains(t, docs, `"type":"`+name+`"`)
+	}
+}
+
+func TestLogicalPlanSerde(t *testing.T) {
+	ec := sqlContext(t)
+	ec.RegisterFunction(roundTo)
+	ec.RegisterAggregateFunction(spread)
+	c := NewPlanCodec()
+	c.RegisterFunction(roundTo)
+	c.RegisterAggregateFunction(spread)
+	employees, err := ec.Table("employees")
+	require.NoError(t, err)
+	rounded, err := ec.Call("round_to", Col("salary"), Int(1000))
+	require.NoError(t, err)
+	salarySpread, err := ec.CallAggregate("spread", Col("salary"))
+	require.NoError(t, err)
+	frames := []DataFrame{
+		employees,
+		employees.Filter(And(Gt(Col("id"), Int(1)), Or(Eq(Col("state"), Str("CO")), LtEq(Col("salary"), Flt(12000.5))))),
+		employees.Project([]LogicalExpr{Col("id"), Alias{Multiply(Col("salary"), Flt(1.1)), "raise"}, Subtract(Col("id"), Int(1)), rounded}),
+		employees.Filter(LikeExpr{Col("last_name"), Str("%a_%"), true, true, false}).Project([]LogicalExpr{Upper(Col("first_name")), Concat(Col("state"), Str("-"), Col("id"))}),
+		employees.Aggregate([]LogicalExpr{Col("state")}, []AggregateExpr{Sum(Col("salary")), {Name: "COUNT", Expr: Col("id"), Alias: "n"}, salarySpread}),
+		employees.Sort([]SortExpr{Desc(Col("salary")), Asc(Col("id"))}).Offset(1).Limit(3),
+		employees.Project([]LogicalExpr{Col("id"), Alias{StringFunction{"regexp_match", []LogicalExpr{Col("job_title"), Str(`(\w+) (\w+)`)}}, "words"}}).Unnest("words"),
+	}
+	docs := ""
+	for _, df := range frames {
+		// the optimized plans have projections, filters and fetches pushed
+		// into their scans
+		for _, plan := range []LogicalPlan{df.LogicalPlan(), NewOptimizer().Optimize(df.LogicalPlan())} {
+			decoded, data := roundTrip(t, c, c.LogicalPlans, plan)
+			docs += data
+			assert.Equal(t, Format(plan, 0), Format(decoded, 0))
+
+			expected, err := ec.Execute(context.Background(), ec.FromLogicalPlan(plan))
+			require.NoError(t, err)
+			actual, err := ec.Execute(context.Background(), ec.FromLogicalPlan(decoded))
+			require.NoError(t, err)
+			assert.ElementsMatch(t, collectRows(t, expected), collectRows(t, actual))
+		}
+	}
+	assertCovered(t, c.LogicalPlans, docs)
+	assertCovered(t, c.LogicalExprs, docs)
+}
+
+func TestPhysicalPlanSerde(t *testing.T) {
+	ec := sqlContext(t)
+	ec.RegisterFunction(roundTo)
+	ec.RegisterAggregateFunction(spread)
+	c := NewPlanCodec()
+	c.RegisterFunction(roundTo)
+	c.RegisterAggregateFunction(spread)
+	queries := []string{
+		"SELECT state, SUM(salary), MIN(id), MAX(salary), AVG(salary), COUNT(id), spread(salary) FROM employees WHERE id > 1 GROUP BY state",
+		"SELECT id, salary * 2, round_to(salary / 1.5, 100) FROM employees WHERE first_name = 'Bill' OR salary >= 12000 ORDER BY salary DESC",
+		"SELECT id FROM employees ORDER BY salary LIMIT 2 OFFSET 1",
+		"SELECT lower(first_name) || '!', length(last_name) FROM employees WHERE state ILIKE 'c%'",
+		`SELECT regexp_replace(last_name, '(o)(p)', '\2\1', 'g'), regexp_extract(job_title, '(\w+) (\w+)', 2) FROM employees WHERE first_name SIMILAR TO '(G|L)%'`,
+		"SELECT COUNT(*) FROM employees",
+		`SELECT id, unnest(regexp_match(job_title, '(\w+) (\w+)')) FROM employees`,
+	}
+	docs := ""
+	for _, query := range queries {
+		df, err := ec.Sql(context.Background(), query)
+		require.NoError(t, err)
+		plan, err := ec.PhysicalPlan(context.Background(), df)
+		require.NoError(t, err)
+		decoded, data := roundTrip(t, c, c.PhysicalPlans, plan)
+		docs += data
+		assert.Equal(t, FormatPhysical(plan, 0), FormatPhysical(decoded, 0), query)
+
+		expected := collectRows(t, ec.ExecutePhysicalPlan(context.Background(), plan))
+		actual := collectRows(t, ec.ExecutePhysicalPlan(context.Background(), decoded))
+		assert.NotEmpty(t, expected)
+		assert.ElementsMatch(t, expected, actual, query)
+		assert.NotZero(t, decoded.Metrics().OutputRows())
+	}
+	assertCovered(t, c.PhysicalPlans, docs)
+	assertCovered(t, c.Expressions, docs)
+	assertCovered(t, c.Aggregates, docs)
+}
+
+func TestDataSourceSerde(t *testing.T) {
+	ec := &ExecutionContext{}
+	memTable, err := ec.values([]arrow.Field{
+		{Name: "n", Type: drogo.Int64},
+		{Name: "tags", Type: arrow.ListOf(drogo.String), Nullable: true},
+	}, [][]any{{int64(1), []any{"a", "b"}}, {int64(2), nil}})
+	require.NoError(t, err)
+	_, db := newFakeDB()
+	c := NewPlanCodec()
+	c.RegisterDatabase("app", db)
+
+	csvSchema := Schema{arrow.NewSchema([]arrow.Field{{Name: "user", Type: drogo.String}, {Name: "amount", Type: drogo.Int64}}, nil)}
+	sources := []DataSource{
+		NewCsvDataSource(writeEventsCsv(t, 10, 3), Schema{}, true, 4).WithPartitions(2),
+		NewCsvDataSource(writeEventsCsv(t, 10, 3), csvSchema, true, 0),
+		NewJsonDataSource(writeJson(t, "logs.json", logLines), Schema{}, 2),
+		NewParquetDataSource([]string{writeEventsParquet(t, 0, 20, 5)}, 0).WithPartitions(2).WithFilters([]LogicalExpr{Gt(Col("id"), Int(7))}),
+		NewArrowIpcDataSource(writeEventsArrow(t, 50, false)).WithPartitions(2),
+		NewListingTable(filepath.Join(writeFiles(t, map[string]string{"day=1/a.csv": "x\n1\n", "day=2/b.csv": "x\n2\n"}), "*", "*.csv"), "csv", 0),
+		memTable.LogicalPlan().(Scan).Source,
+		NewSeriesDataSource("n", 10, -5, -3, true, 2).WithPartitions(2),
+		NewSqlDataSource(db, SQLite, "users", 0).WithFilters([]LogicalExpr{Eq(Col("name"), Str("bob"))}),
+	}
+	docs := ""
+	for _, source := range sources {
+		decoded, data := roundTrip(t, c, c.DataSources, source)
+		docs += data
+		assert.Equal(t, source.GetSchema().String(), decoded.GetSchema().String())
+		assert.Equal(t, source.Partitions(), decoded.Partitions())
+		for p := 0; p < source.Partitions(); p++ {
+			assert.Equal(t,
+				collectRows(t, source.Scan(context.Background(), []string{}, 0, p)),
+				collectRows(t, decoded.Scan(context.Background(), []string{}, 0, p)))
+		}
+	}
+	assertCovered(t, c.DataSources, docs)
+	assert.Contains(t, docs, `"name":"list","fields":[{"name":"item","data_type":{"name":"utf8"},"nullable":true}]`)
+
+	_, err = NewPlanCodec().EncodeDataSource(sources[len(sources)-1])
+	assert.EqualError(t, err, "the database of SQL table users is not registered with the codec")
+	data, err := c.EncodeDataSource(sources[len(sources)-1])
+	require.NoError(t, err)
+	_, err = NewPlanCodec().DecodeDataSource(data)
+	assert.EqualError(t, err, "unknown database 'app'")
+}
+
+func TestTypeSerde(t *testing.T) {
+	types := []arrow.DataType{
+		arrow.FixedWidthTypes.Boolean, arrow.PrimitiveTypes.Int32, arrow.PrimitiveTypes.Uint8, arrow.PrimitiveTypes.Float32, arrow.BinaryTypes.LargeString, arrow.PrimitiveTypes.Date32,
+		&arrow.TimestampType{Unit: arrow.Microsecond, TimeZone: "UTC"},
+		arrow.FixedWidthTypes.Time32ms, arrow.FixedWidthTypes.Duration_ns,
+		&arrow.Decimal128Type{Precision: 10, Scale: 2},
+		arrow.StructOf(arrow.Field{Name: "a", Type: arrow.ListOf(drogo.Int64), Nullable: true}),
+	}
+	for _, dtype := range types {
+		encoded, err := encodeType(dtype)
+		require.NoError(t, err)
+		decoded, err := decodeType(encoded)
+		require.NoError(t, err)
+		assert.True(t, arrow.TypeEqual(dtype, decoded), "%s != %s", dtype, decoded)
+	}
+	_, err := encodeType(arrow.MapOf(drogo.String, drogo.Int64))
+	assert.EqualError(t, err, "cannot serialize type map<utf8, int64, items_nullable>")
+}
+
+// sample is a custom logical plan node that keeps every nth row
+type sample struct {
+	Input LogicalPlan
+	Every int
+}
+
+func (s sample) Schema() Schema          { return s.Input.Schema() }
+func (s sample) Children() []LogicalPlan { return []LogicalPlan{s.Input} }
+func (s sample) String() string          { return fmt.Sprintf("Sample: every=%d", s.Every) }
+
+type sampleJSON struct {
+	Input json.RawMessage `json:"input"`
+	Every int             `json:"every"`
+}
+
+func TestPlanCodecRegistry(t *testing.T) {
+	c := NewPlanCodec()
+	plan := Projection{sample{Scan{Path: "t", Source: NewCsvDataSource("t.csv", Schema{}, true, 0), Projection: []string{}}, 10}, []LogicalExpr{Col("a")}}
+
+	_, err := c.EncodeLogicalPlan(plan)
+	assert.EqualError(t, err, "no codec registered for logical plan engine.sample")
+
+	Register(c.LogicalPlans, "Sample", func(c *PlanCodec, s sample) (sampleJSON, error) {
+		input, err := c.EncodeLogicalPlan(s.Input)
+		return sampleJSON{input, s.Every}, err
+	}, func(c *PlanCodec, s sampleJSON) (sample, error) {
+		input, err := c.DecodeLogicalPlan(s.Input)
+		return sample{input, s.Every}, err
+	})
+	decoded, data := roundTrip(t, c, c.LogicalPlans, LogicalPlan(plan))
+	assert.Equal(t, plan, decoded)
+	assert.True(t, strings.HasPrefix(data, `{"type":"Projection","input":{"type":"Sample","input":{"type":"Scan",`), data)
+
+	_, err = c.DecodeLogicalExpr(json.RawMessage(`{"type":"ScalarFunction","name":"round_to","args":[]}`))
+	assert.EqualError(t, err, "unknown function 'round_to'")
+	_, err = c.DecodeLogicalPlan(json.RawMessage(`{"type":"Window"}`))
+	assert.EqualError(t, err, "unknown logical plan type 'Window'")
+	_, err = c.DecodeLogicalPlan(json.RawMessage(`{"type":"Limit","skip":"none"}`))
+	assert.ErrorContains(t, err, "cannot decode logical plan Limit")
+	assert.Panics(t, func() {
+		Register(c.LogicalPlans, "Column", func(c *PlanCodec, e Column) (columnJSON, error) { return columnJSON{}, nil },
+			func(c *PlanCodec, s columnJSON) (Column, error) { return Column{}, nil })
+	})
+}
@@ -0,0 +1,430 @@
+package engine
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/apache/arrow/go/v12/arrow"
+	"github.com/briansterle/drogo"
+)
+
+// SqlDialect describes how queries are written for a database
+type SqlDialect struct {
+	Name string
+	// quote is the character identifiers are quoted with
+	quote string
+	// numbered is true when placeholders are numbered, as in $1
+	numbered bool
+	// binaryStrings is true when the database compares strings byte by byte
+	// as the engine does. Otherwise only string equality is pushed down, and
+	// the rows it returns are filtered again.
+	binaryStrings bool
+}
+
+var (
+	SQLite   = SqlDialect{Name: "sqlite", quote: `"`, binaryStrings: true}
+	Postgres = SqlDialect{Name: "postgres", quote: `"`, numbered: true}
+	MySQL    = SqlDialect{Name: "mysql", quote: "`"}
+)
+
+// Quote quotes an identifier, which may be qualified as in schema.table
+func (d SqlDialect) Quote(name string) string {
+	parts := strings.Split(name, ".")
+	for i, part := range parts {
+		parts[i] = d.quote + strings.ReplaceAll(part, d.quote, d.quote+d.quote) + d.quote
+	}
+	return strings.Join(parts, ".")
+}
+
+func (d SqlDialect) placeholder(n int) string {
+	if d.numbered {
+		return "$" + strconv.Itoa(n)
+	}
+	return "?"
+}
+
+// SqlDataSource reads a table of a database through database/sql. The schema
+// comes from the types of the table's columns. Scans only select the
+// projected columns, and pushed down filters and limits are added to the
+// query so that the database only returns the rows that are needed.
+//
+// Comparisons of columns with literals and with each other, combined with
+// AND and OR, are translated; other filters are applied after reading.
+type SqlDataSource struct {
+	DB        *sql.DB
+	Dialect   SqlDialect
+	Table     string
+	batchSize int
+	filters   []LogicalExpr
+	// meta is shared with the copies made by WithFilters so the schema is
+	// only read once
+	meta *sqlMetadata
+}
+
+type sqlMetadata struct {
+	mu     sync.Mutex
+	loaded bool
+	schema Schema
+	err    error
+}
+
+func NewSqlDataSource(db *sql.DB, dialect SqlDialect, table string, batchSize int) *SqlDataSource {
+	return &SqlDataSource{DB: db, Dialect: dialect, Table: table, batchSize: batchSize, meta: &sqlMetadata{}}
+}
+
+// WithFilters returns a copy of the source that adds the filters it can
+// translate to its queries
+func (ds *SqlDataSource) WithFilters(filters []LogicalExpr) DataSource {
+	out := *ds
+	out.filters = filters
+	return &out
+}
+
+// ExactFilter reports whether the database evaluates the filter as the
+// engine would
+func (ds *SqlDataSource) ExactFilter(filter LogicalExpr) bool {
+	_, exact, ok := ds.translate(filter, &[]any{})
+	return ok && exact
+}
+
+// LoadSchema reads the column types, unless they have already been read,
+// by selecting no rows from the table. It gives up once ctx is done, and
+// such a failure is not kept, so a later call tries again.
+func (ds *SqlDataSource) LoadSchema(ctx context.Context) error {
+	meta := ds.meta
+	meta.mu.Lock()
+	defer meta.mu.Unlock()
+	if meta.loaded {
+		return meta.err
+	}
+	rows, err := ds.DB.QueryContext(ctx, "SELECT * FROM "+ds.Dialect.Quote(ds.Table)+" WHERE 1 = 0")
+	if err != nil {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		meta.loaded, meta.err = true, fmt.Errorf("%s: %w", ds.Table, err)
+		return meta.err
+	}
+	defer rows.Close()
+	types, err := rows.ColumnTypes()
+	if err != nil {
+		meta.loaded, meta.err = true, fmt.Errorf("%s: %w", ds.Table, err)
+		return meta.err
+	}
+	fields := make([]arrow.Field, len(types))
+	for i, t := range types {
+		nullable, ok := t.Nullable()
+		fields[i] = arrow.Field{Name: t.Name(), Type: sqlType(t.DatabaseTypeName()), Nullable: nullable || !ok}
+	}
+	meta.loaded, meta.schema = true, Schema{arrow.NewSchema(fields, nil)}
+	return nil
+}
+
+// sqlType maps the name of a database column type to an arrow type. Types
+// without a numeric or boolean equivalent, such as dates, are read as
+// strings.
+func sqlType(name string) arrow.DataType {
+	name = strings.ToUpper(name)
+	if i := strings.IndexAny(name, "( "); i >= 0 {
+		name = name[:i]
+	}
+	switch {
+	case strings.HasPrefix(name, "BOOL"):
+		return drogo.Boolean
+	case strings.HasSuffix(name, "INT") || strings.HasSuffix(name, "SERIAL") ||
+		name == "INTEGER" || name == "INT2" || name == "INT4" || name == "INT8":
+		return drogo.Int64
+	case name == "REAL" || name == "NUMERIC" || name == "DECIMAL" ||
+		strings.HasPrefix(name, "FLOAT") || strings.HasPrefix(name, "DOUBLE"):
+		return drogo.Float64
+	default:
+		return drogo.String
+	}
+}
+
+// GetSchema returns the table's schema, or an empty schema if it could not
+// be read. Planning loads it with LoadSchema first, so that reading it can
+// be cancelled.
+func (ds *SqlDataSource) GetSchema() Schema {
+	if ds.SchemaError() != nil {
+		return Schema{arrow.NewSchema(nil, nil)}
+	}
+	return ds.meta.schema
+}
+
+// SchemaError is the error that prevented the table's schema from being read
+func (ds *SqlDataSource) SchemaError() error {
+	return ds.LoadSchema(context.Background())
+}
+
+// Partitions is always 1: the table is read by a single query
+func (ds *SqlDataSource) Partitions() int {
+	return 1
+}
+
+// translate writes a filter in SQL, appending the values of its literals to
+// args. exact is false when the database may return rows the filter does not
+// match.
+func (ds *SqlDataSource) translate(expr LogicalExpr, args *[]any) (string, bool, bool) {
+	e, ok := expr.(BooleanBinaryExpr)
+	if !ok {
+		return "", false, false
+	}
+	if e.Op == "AND" || e.Op == "OR" {
+		l, lExact, ok := ds.translate(e.L, args)
+		if !ok {
+			return "", false, false
+		}
+		r, rExact, ok := ds.translate(e.R, args)
+		if !ok {
+			return "", false, false
+		}
+		return "(" + l + " " + e.Op + " " + r + ")", lExact && rExact, true
+	}
+	op := e.Op
+	switch op {
+	case "=", "<", "<=", ">", ">=":
+	case "!=":
+		op = "<>"
+	default:
+		return "", false, false
+	}
+	ltype, lok := ds.operandType(e.L)
+	rtype, rok := ds.operandType(e.R)
+	if !lok || !rok {
+		return "", false, false
+	}
+	exact := true
+	switch {
+	case isNumericType(ltype) && isNumericType(rtype):
+	case ltype.ID() == arrow.STRING && rtype.ID() == arrow.STRING:
+		if !ds.Dialect.binaryStrings {
+			// another collation may order strings differently or treat
+			// strings the engine thinks differ as equal, so only equality
+			// is safe to push down, and the rows must be filtered again
+			if op != "=" {
+				return "", false, false
+			}
+			exact = false
+		}
+	default:
+		return "", false, false
+	}
+	l := ds.operand(e.L, args)
+	r := ds.operand(e.R, args)
+	return l + " " + op + " " + r, exact, true
+}
+
+// operandType is the type of a column or literal of a comparison
+func (ds *SqlDataSource) operandType(expr LogicalExpr) (arrow.DataType, bool) {
+	switch e := expr.(type) {
+	case Column:
+		fields, ok := ds.GetSchema().FieldsByName(e.name)
+		if !ok {
+			return nil, false
+		}
+		return fields[0].Type, true
+	case LiteralString:
+		return drogo.String, true
+	case LiteralInt64:
+		return drogo.Int64, true
+	case LiteralFloat64:
+		return drogo.Float64, true
+	default:
+		return nil, false
+	}
+}
+
+func isNumericType(dtype arrow.DataType) bool {
+	return arrow.IsSignedInteger(dtype.ID()) || arrow.IsFloating(dtype.ID())
+}
+
+func (ds *SqlDataSource) operand(expr LogicalExpr, args *[]any) string {
+	if c, ok := expr.(Column); ok {
+		return ds.Dialect.Quote(c.name)
+	}
+	value, _ := literalValue(expr)
+	*args = append(*args, value)
+	return ds.Dialect.placeholder(len(*args))
+}
+
+// query writes the query that scans the columns
+func (ds *SqlDataSource) query(columns []string, fetch int) (string, []any) {
+	quoted := make([]string, len(columns))
+	for i, c := range columns {
+		quoted[i] = ds.Dialect.Quote(c)
+	}
+	var sb strings.Builder
+	sb.WriteString("SELECT " + strings.Join(quoted, ", ") + " FROM " + ds.Dialect.Quote(ds.Table))
+	args := []any{}
+	conditions := []string{}
+	for _, filter := range ds.filters {
+		if condition, _, ok := ds.translate(filter, &args); ok {
+			conditions = append(conditions, condition)
+		}
+	}
+	if len(conditions) > 0 {
+		sb.WriteString(" WHERE " + strings.Join(conditions, " AND "))
+	}
+	if fetch > 0 {
+		fmt.Fprintf(&sb, " LIMIT %d", fetch)
+	}
+	return sb.String(), args
+}
+
+func (ds *SqlDataSource) Scan(ctx context.Context, projection []string, fetch int, partition int) RecordBatchStream {
+	if err := ds.SchemaError(); err != nil {
+		return &sqlStream{err: err}
+	}
+	schema := ds.GetSchema()
+	if len(projection) > 0 {
+		schema = schema.Select(projection)
+	}
+	columns := make([]string, len(schema.Fields()))
+	for i, f := range schema.Fields() {
+		columns[i] = f.Name
+	}
+	query, args := ds.query(columns, fetch)
+	batchSize := ds.batchSize
+	if batchSize <= 0 {
+		batchSize = defaultBatchSize
+	}
+	return &sqlStream{ctx: ctx, source: ds, schema: schema, query: query, args: args, batchSize: batchSize}
+}
+
+type sqlStream struct {
+	ctx       context.Context
+	source    *SqlDataSource
+	schema    Schema
+	query     string
+	args      []any
+	batchSize int
+	// err is returned by Next when the table's schema could not be read
+	err  error
+	rows *sql.Rows
+	done bool
+}
+
+func (s *sqlStream) Next() (RecordBatch, error) {
+	if s.err != nil {
+		return RecordBatch{}, s.err
+	}
+	if s.done {
+		return RecordBatch{}, io.EOF
+	}
+	if err := s.ctx.Err(); err != nil {
+		s.Close()
+		return RecordBatch{}, err
+	}
+	if s.rows == nil {
+		rows, err := s.source.DB.QueryContext(s.ctx, s.query, s.args...)
+		if err != nil {
+			s.Close()
+			return RecordBatch{}, fmt.Errorf("%s: %w", s.source.Table, err)
+		}
+		s.rows = rows
+	}
+
+	n := len(s.schema.Fields())
+	columns := make([][]any, n)
+	values := make([]any, n)
+	pointers := make([]any, n)
+	for i := range values {
+		pointers[i] = &values[i]
+	}
+	rows := 0
+	for rows < s.batchSize && s.rows.Next() {
+		if err := s.rows.Scan(pointers...); err != nil {
+			s.Close()
+			return RecordBatch{}, err
+		}
+		for i, v := range values {
+			converted, err := convertSqlValue(v, s.schema.Field(i).Type)
+			if err != nil {
+				s.Close()
+				return RecordBatch{}, fmt.Errorf("%s: column %s: %w", s.source.Table, s.schema.Field(i).Name, err)
+			}
+			columns[i] = append(columns[i], converted)
+		}
+		rows++
+	}
+	if rows < s.batchSize {
+		err := s.rows.Err()
+		s.Close()
+		if err != nil {
+			return RecordBatch{}, err
+		}
+		if rows == 0 {
+			return RecordBatch{}, io.EOF
+		}
+	}
+	fields := make([]ColumnVector, n)
+	for i, values := range columns {
+		fields[i] = drogo.New(s.schema.Field(i).Type, rows, values)
+	}
+	return RecordBatch{s.schema, fields}, nil
+}
+
+func (s *sqlStream) Close() error {
+	s.done = true
+	if s.rows == nil {
+		return nil
+	}
+	err := s.rows.Close()
+	s.rows = nil
+	return err
+}
+
+// convertSqlValue converts a value returned by the database driver into the
+// Go value drogo.New expects for the arrow type
+func convertSqlValue(v any, dtype arrow.DataType) (any, error) {
+	if v == nil {
+		return nil, nil
+	}
+	if b, ok := v.([]byte); ok {
+		v = string(b)
+	}
+	switch dtype.ID() {
+	case arrow.BOOL:
+		switch v := v.(type) {
+		case bool:
+			return v, nil
+		case string:
+			return strconv.ParseBool(v)
+		}
+		if n, ok := toInt64(v); ok {
+			return n != 0, nil
+		}
+	case arrow.INT64:
+		if s, ok := v.(string); ok {
+			return strconv.ParseInt(s, 10, 64)
+		}
+		if isInteger(v) {
+			n, _ := toInt64(v)
+			return n, nil
+		}
+	case arrow.FLOAT64:
+		if s, ok := v.(string); ok {
+			return strconv.ParseFloat(s, 64)
+		}
+		if f, ok := toFloat64(v); ok {
+			return f, nil
+		}
+	case arrow.STRING:
+		switch v := v.(type) {
+		case string:
+			return v, nil
+		case time.Time:
+			return v.Format(time.RFC3339Nano), nil
+		default:
+			return fmt.Sprint(v), nil
+		}
+	}
+	return nil, fmt.Errorf("cannot convert %v to %s", v, dtype)
+}
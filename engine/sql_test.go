@@ -0,0 +1,450 @@
+package engine
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+	"unicode"
+
+	"github.com/apache/arrow/go/v12/arrow"
+	"github.com/briansterle/drogo"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeDB stands in for an embedded SQLite database. It answers the queries
+// SqlDataSource writes: SELECT of columns or * from a table, with an optional
+// WHERE of comparisons joined by AND and OR, and an optional LIMIT. Every
+// query is recorded.
+type fakeDB struct {
+	mu      sync.Mutex
+	tables  map[string]*fakeTable
+	queries []string
+}
+
+type fakeTable struct {
+	columns []string
+	// types are the declared column types; NOT NULL columns end in !
+	types []string
+	rows  [][]driver.Value
+}
+
+func (db *fakeDB) Connect(context.Context) (driver.Conn, error) { return fakeConn{db}, nil }
+func (db *fakeDB) Driver() driver.Driver                        { return nil }
+
+func (db *fakeDB) lastQuery() string {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	return db.queries[len(db.queries)-1]
+}
+
+type fakeConn struct{ db *fakeDB }
+
+func (c fakeConn) Prepare(query string) (driver.Stmt, error) { return fakeStmt{c.db, query}, nil }
+func (c fakeConn) Close() error                              { return nil }
+func (c fakeConn) Begin() (driver.Tx, error)                 { return nil, errors.New("not supported") }
+
+type fakeStmt struct {
+	db    *fakeDB
+	query string
+}
+
+func (s fakeStmt) Close() error  { return nil }
+func (s fakeStmt) NumInput() int { return -1 }
+func (s fakeStmt) Exec([]driver.Value) (driver.Result, error) {
+	return nil, errors.New("not supported")
+}
+
+func (s fakeStmt) Query(args []driver.Value) (driver.Rows, error) {
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+	s.db.queries = append(s.db.queries, s.query)
+	p := &fakeParser{tokens: tokenize(s.query), args: args}
+	return p.query(s.db.tables)
+}
+
+// tokenize splits a query into quoted identifiers (kept with their quotes),
+// words, numbers, placeholders and operators
+func tokenize(query string) []string {
+	tokens := []string{}
+	for i := 0; i < len(query); {
+		c := rune(query[i])
+		j := i + 1
+		switch {
+		case c == ' ':
+			i++
+			continue
+		case c == '"':
+			for j < len(query) && query[j] != '"' {
+				j++
+			}
+			j++
+		case c == '$' || unicode.IsLetter(c) || unicode.IsDigit(c):
+			for j < len(query) && (unicode.IsLetter(rune(query[j])) || unicode.IsDigit(rune(query[j]))) {
+				j++
+			}
+		case strings.ContainsRune("<>", c) && j < len(query) && strings.ContainsRune("=>", rune(query[j])):
+			j++
+		}
+		tokens = append(tokens, query[i:j])
+		i = j
+	}
+	return tokens
+}
+
+type fakeParser struct {
+	tokens []string
+	args   []driver.Value
+	arg    int
+}
+
+func (p *fakeParser) next() string {
+	if len(p.tokens) == 0 {
+		return ""
+	}
+	t := p.tokens[0]
+	p.tokens = p.tokens[1:]
+	return t
+}
+
+func (p *fakeParser) peek() string {
+	if len(p.tokens) == 0 {
+		return ""
+	}
+	return p.tokens[0]
+}
+
+func (p *fakeParser) expect(token string) error {
+	if t := p.next(); t != token {
+		return fmt.Errorf("expected %s, found %s", token, t)
+	}
+	return nil
+}
+
+func unquote(t string) string {
+	return strings.Trim(t, `"`)
+}
+
+func (p *fakeParser) query(tables map[string]*fakeTable) (driver.Rows, error) {
+	if err := p.expect("SELECT"); err != nil {
+		return nil, err
+	}
+	selected := []string{}
+	for {
+		selected = append(selected, unquote(p.next()))
+		if p.peek() != "," {
+			break
+		}
+		p.next()
+	}
+	if err := p.expect("FROM"); err != nil {
+		return nil, err
+	}
+	table, ok := tables[unquote(p.next())]
+	if !ok {
+		return nil, errors.New("no such table")
+	}
+	if selected[0] == "*" {
+		selected = table.columns
+	}
+	indices := make([]int, len(selected))
+	for i, name := range selected {
+		indices[i] = -1
+		for j, c := range table.columns {
+			if c == name {
+				indices[i] = j
+			}
+		}
+		if indices[i] < 0 {
+			return nil, fmt.Errorf("no such column: %s", name)
+		}
+	}
+	where := func([]driver.Value) bool { return true }
+	if p.peek() == "WHERE" {
+		p.next()
+		var err error
+		if where, err = p.or(table); err != nil {
+			return nil, err
+		}
+	}
+	limit := -1
+	if p.peek() == "LIMIT" {
+		p.next()
+		limit, _ = strconv.Atoi(p.next())
+	}
+	if t := p.next(); t != "" {
+		return nil, fmt.Errorf("unexpected %s", t)
+	}
+	rows := &fakeRows{table: table, indices: indices}
+	for _, row := range table.rows {
+		if where(row) && (limit < 0 || len(rows.rows) < limit) {
+			rows.rows = append(rows.rows, row)
+		}
+	}
+	return rows, nil
+}
+
+type predicate func(row []driver.Value) bool
+
+func (p *fakeParser) or(table *fakeTable) (predicate, error) {
+	l, err := p.and(table)
+	for err == nil && p.peek() == "OR" {
+		p.next()
+		var r predicate
+		r, err = p.and(table)
+		left := l
+		l = func(row []driver.Value) bool { return left(row) || r(row) }
+	}
+	return l, err
+}
+
+func (p *fakeParser) and(table *fakeTable) (predicate, error) {
+	l, err := p.comparison(table)
+	for err == nil && p.peek() == "AND" {
+		p.next()
+		var r predicate
+		r, err = p.comparison(table)
+		left := l
+		l = func(row []driver.Value) bool { return left(row) && r(row) }
+	}
+	return l, err
+}
+
+func (p *fakeParser) comparison(table *fakeTable) (predicate, error) {
+	if p.peek() == "(" {
+		p.next()
+		expr, err := p.or(table)
+		if err != nil {
+			return nil, err
+		}
+		return expr, p.expect(")")
+	}
+	l, err := p.operand(table)
+	if err != nil {
+		return nil, err
+	}
+	op := p.next()
+	r, err := p.operand(table)
+	if err != nil {
+		return nil, err
+	}
+	return func(row []driver.Value) bool {
+		a, b := l(row), r(row)
+		if a == nil || b == nil {
+			return false
+		}
+		c := compareValues(a, b)
+		switch op {
+		case "=":
+			return c == 0
+		case "<>":
+			return c != 0
+		case "<":
+			return c < 0
+		case "<=":
+			return c <= 0
+		case ">":
+			return c > 0
+		default:
+			return c >= 0
+		}
+	}, nil
+}
+
+func (p *fakeParser) operand(table *fakeTable) (func([]driver.Value) driver.Value, error) {
+	t := p.next()
+	switch {
+	case t == "?":
+		v := p.args[p.arg]
+		p.arg++
+		return func([]driver.Value) driver.Value { return v }, nil
+	case strings.HasPrefix(t, `"`):
+		for i, c := range table.columns {
+			if c == unquote(t) {
+				return func(row []driver.Value) driver.Value {
+					if b, ok := row[i].([]byte); ok {
+						return string(b)
+					}
+					return row[i]
+				}, nil
+			}
+		}
+		return nil, fmt.Errorf("no such column: %s", t)
+	default:
+		n, err := strconv.ParseInt(t, 10, 64)
+		return func([]driver.Value) driver.Value { return n }, err
+	}
+}
+
+type fakeRows struct {
+	table   *fakeTable
+	indices []int
+	rows    [][]driver.Value
+}
+
+func (r *fakeRows) Columns() []string {
+	columns := make([]string, len(r.indices))
+	for i, idx := range r.indices {
+		columns[i] = r.table.columns[idx]
+	}
+	return columns
+}
+
+func (r *fakeRows) ColumnTypeDatabaseTypeName(i int) string {
+	return strings.TrimSuffix(r.table.types[r.indices[i]], "!")
+}
+
+func (r *fakeRows) ColumnTypeNullable(i int) (bool, bool) {
+	return !strings.HasSuffix(r.table.types[r.indices[i]], "!"), true
+}
+
+func (r *fakeRows) Close() error { return nil }
+
+func (r *fakeRows) Next(dest []driver.Value) error {
+	if len(r.rows) == 0 {
+		return io.EOF
+	}
+	for i, idx := range r.indices {
+		dest[i] = r.rows[0][idx]
+	}
+	r.rows = r.rows[1:]
+	return nil
+}
+
+func newFakeDB() (*fakeDB, *sql.DB) {
+	db := &fakeDB{tables: map[string]*fakeTable{
+		"users": {
+			columns: []string{"id", "name", "score", "active", "joined"},
+			types:   []string{"INTEGER!", "TEXT", "REAL", "BOOLEAN", "DATE"},
+			rows: [][]driver.Value{
+				{int64(1), "ann", 2.5, int64(1), "2026-01-01"},
+				{int64(2), "bob", 1.0, int64(0), nil},
+				{int64(3), []byte("Cat"), nil, int64(1), "2026-03-01"},
+				{int64(4), "dan", 4.0, nil, "2026-04-01"},
+			},
+		},
+	}}
+	return db, sql.OpenDB(db)
+}
+
+func TestSqlSchema(t *testing.T) {
+	_, db := newFakeDB()
+	source := NewSqlDataSource(db, SQLite, "users", 0)
+	require.NoError(t, source.SchemaError())
+	expected := arrow.NewSchema([]arrow.Field{
+		{Name: "id", Type: drogo.Int64},
+		{Name: "name", Type: drogo.String, Nullable: true},
+		{Name: "score", Type: drogo.Float64, Nullable: true},
+		{Name: "active", Type: drogo.Boolean, Nullable: true},
+		{Name: "joined", Type: drogo.String, Nullable: true},
+	}, nil)
+	assert.True(t, expected.Equal(source.GetSchema().Schema), source.GetSchema().String())
+
+	assert.Error(t, NewSqlDataSource(db, SQLite, "missing", 0).SchemaError())
+	assert.Equal(t, drogo.Int64, sqlType("bigint"))
+	assert.Equal(t, drogo.Float64, sqlType("DOUBLE PRECISION"))
+	assert.Equal(t, drogo.String, sqlType("INTERVAL"))
+	assert.Equal(t, drogo.String, sqlType("VARCHAR(20)"))
+}
+
+// unreachableDB waits for the context of every connection attempt to be
+// done until it is brought up, after which it answers like db
+type unreachableDB struct {
+	db *fakeDB
+	up atomic.Bool
+}
+
+func (u *unreachableDB) Connect(ctx context.Context) (driver.Conn, error) {
+	if u.up.Load() {
+		return u.db.Connect(ctx)
+	}
+	<-ctx.Done()
+	return nil, ctx.Err()
+}
+
+func (u *unreachableDB) Driver() driver.Driver { return nil }
+
+func TestSqlSchemaCancelled(t *testing.T) {
+	fake, _ := newFakeDB()
+	remote := &unreachableDB{db: fake}
+	ec := &ExecutionContext{QueryTimeout: 50 * time.Millisecond}
+	ec.RegisterDataSource("users", NewSqlDataSource(sql.OpenDB(remote), SQLite, "users", 0))
+	_, err := ec.Sql(context.Background(), "SELECT id FROM users")
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = ec.Sql(ctx, "DESCRIBE users")
+	assert.ErrorIs(t, err, context.Canceled)
+
+	// cancelled reads are not remembered
+	remote.up.Store(true)
+	rows := sqlRows(t, ec, "SELECT id FROM users WHERE id = 2")
+	assert.Equal(t, [][]any{{int64(2)}}, rows)
+}
+
+func TestSqlPushDown(t *testing.T) {
+	fake, db := newFakeDB()
+	ctx := &ExecutionContext{BatchSize: 2}
+	require.NoError(t, ctx.RegisterSqlTable("users", db, SQLite, "users"))
+	users, err := ctx.Table("users")
+	require.NoError(t, err)
+
+	df := users.Filter(Or(Gt(Col("score"), Flt(1.5)), Eq(Col("name"), Str("bob")))).
+		Project([]LogicalExpr{Col("id"), Col("name")}).
+		Limit(2)
+	// the database evaluates the filter, so the limit reaches the scan
+	expected := `Projection: #id, #name
+	Limit: skip=0, fetch=2
+		Scan: users; projection=[id name]; filters=[#score > 1.5 OR #name = 'bob']; fetch=2
+`
+	assert.Equal(t, expected, Format(NewOptimizer().Optimize(df.LogicalPlan()), 0))
+	batches, err := df.Collect(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, []any{int64(1), int64(2)}, column(batches, 0))
+	assert.Equal(t, `SELECT "id", "name" FROM "users" WHERE ("score" > ? OR "name" = ?) LIMIT 2`, fake.lastQuery())
+
+	batches, err = users.Filter(Eq(Modulus(Col("id"), Int(2)), Int(1))).Collect(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, []any{int64(1), int64(3)}, column(batches, 0))
+	assert.Equal(t, []any{"ann", "Cat"}, column(batches, 1))
+	assert.Equal(t, []any{true, true}, column(batches, 3))
+	assert.Equal(t, []any{"2026-01-01", "2026-03-01"}, column(batches, 4))
+	// arithmetic is not translated, so the engine filters every row
+	assert.Equal(t, `SELECT "id", "name", "score", "active", "joined" FROM "users"`, fake.lastQuery())
+}
+
+func TestSqlDialects(t *testing.T) {
+	_, db := newFakeDB()
+	filters := []LogicalExpr{
+		Neq(Col("id"), Int(3)),
+		Lt(Col("name"), Str("c")),
+		Eq(Col("name"), Str("bob")),
+		Gt(Col("score"), Col("id")),
+	}
+	sqlite := NewSqlDataSource(db, SQLite, "users", 0).WithFilters(filters).(*SqlDataSource)
+	query, args := sqlite.query([]string{"id"}, 0)
+	assert.Equal(t, `SELECT "id" FROM "users" WHERE "id" <> ? AND "name" < ? AND "name" = ? AND "score" > "id"`, query)
+	assert.Equal(t, []any{int64(3), "c", "bob"}, args)
+	assert.True(t, sqlite.ExactFilter(filters[1]))
+
+	// other collations may order strings differently
+	postgres := NewSqlDataSource(db, Postgres, "public.users", 0)
+	postgres.meta = sqlite.meta
+	postgres = postgres.WithFilters(filters).(*SqlDataSource)
+	query, args = postgres.query([]string{"id"}, 5)
+	assert.Equal(t, `SELECT "id" FROM "public"."users" WHERE "id" <> $1 AND "name" = $2 AND "score" > "id" LIMIT 5`, query)
+	assert.Equal(t, []any{int64(3), "bob"}, args)
+	assert.False(t, postgres.ExactFilter(filters[2]))
+	assert.True(t, postgres.ExactFilter(filters[0]))
+
+	assert.Equal(t, "`my``table`", MySQL.Quote("my`table"))
+}
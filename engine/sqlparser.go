@@ -0,0 +1,873 @@
+package engine
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+type sqlTokenKind int
+
+const (
+	sqlEOF sqlTokenKind = iota
+	// sqlWord is a keyword or an unquoted identifier
+	sqlWord
+	sqlQuotedIdent
+	sqlStringLit
+	sqlNumber
+	// sqlSymbol is an operator or punctuation
+	sqlSymbol
+)
+
+type sqlToken struct {
+	kind sqlTokenKind
+	text string
+	pos  int
+}
+
+func (t sqlToken) String() string {
+	switch t.kind {
+	case sqlEOF:
+		return "end of input"
+	case sqlStringLit:
+		return "'" + t.text + "'"
+	case sqlQuotedIdent:
+		return `"` + t.text + `"`
+	default:
+		return t.text
+	}
+}
+
+// sqlSymbols are the operators and punctuation, longest first
+var sqlSymbols = []string{"<>", "!=", "<=", ">=", "||", "=", "<", ">", "+", "-", "*", "/", "%", "(", ")", ",", ".", ";"}
+
+// tokenizeSql splits a statement into tokens, skipping whitespace and --
+// comments. Quoted identifiers and strings escape their quote by doubling it.
+func tokenizeSql(sql string) ([]sqlToken, error) {
+	tokens := []sqlToken{}
+	for i := 0; i < len(sql); {
+		c := rune(sql[i])
+		switch {
+		case unicode.IsSpace(c):
+			i++
+		case strings.HasPrefix(sql[i:], "--"):
+			for i < len(sql) && sql[i] != '\n' {
+				i++
+			}
+		case c == '\'' || c == '"':
+			var sb strings.Builder
+			j := i + 1
+			for {
+				if j >= len(sql) {
+					return nil, fmt.Errorf("unterminated quote at position %d", i)
+				}
+				if rune(sql[j]) == c {
+					if j+1 < len(sql) && rune(sql[j+1]) == c {
+						sb.WriteByte(sql[j])
+						j += 2
+						continue
+					}
+					break
+				}
+				sb.WriteByte(sql[j])
+				j++
+			}
+			kind := sqlStringLit
+			if c == '"' {
+				kind = sqlQuotedIdent
+			}
+			tokens = append(tokens, sqlToken{kind, sb.String(), i})
+			i = j + 1
+		case unicode.IsDigit(c) || (c == '.' && i+1 < len(sql) && unicode.IsDigit(rune(sql[i+1]))):
+			j := i
+			for j < len(sql) && (unicode.IsDigit(rune(sql[j])) || sql[j] == '.') {
+				j++
+			}
+			if j < len(sql) && (sql[j] == 'e' || sql[j] == 'E') {
+				j++
+				if j < len(sql) && (sql[j] == '+' || sql[j] == '-') {
+					j++
+				}
+				for j < len(sql) && unicode.IsDigit(rune(sql[j])) {
+					j++
+				}
+			}
+			tokens = append(tokens, sqlToken{sqlNumber, sql[i:j], i})
+			i = j
+		case unicode.IsLetter(c) || c == '_':
+			j := i
+			for j < len(sql) && (unicode.IsLetter(rune(sql[j])) || unicode.IsDigit(rune(sql[j])) || sql[j] == '_') {
+				j++
+			}
+			tokens = append(tokens, sqlToken{sqlWord, sql[i:j], i})
+			i = j
+		default:
+			symbol := ""
+			for _, s := range sqlSymbols {
+				if strings.HasPrefix(sql[i:], s) {
+					symbol = s
+					break
+				}
+			}
+			if symbol == "" {
+				return nil, fmt.Errorf("unexpected character '%c' at position %d", c, i)
+			}
+			tokens = append(tokens, sqlToken{sqlSymbol, symbol, i})
+			i += len(symbol)
+		}
+	}
+	return append(tokens, sqlToken{sqlEOF, "", len(sql)}), nil
+}
+
+// sqlReserved are the keywords that cannot be used as an alias without AS
+var sqlReserved = map[string]bool{
+	"SELECT": true, "FROM": true, "WHERE": true, "GROUP": true, "BY": true, "HAVING": true,
+	"ORDER": true, "LIMIT": true, "OFFSET": true, "AS": true, "AND": true, "OR": true,
+	"NOT": true, "ASC": true, "DESC": true, "DISTINCT": true, "ON": true, "JOIN": true,
+	"UNION": true, "TO": true, "STORED": true, "PARTITIONED": true, "OPTIONS": true,
+	"LIKE": true, "ILIKE": true, "SIMILAR": true, "IS": true, "NULL": true, "IN": true,
+	"BETWEEN": true,
+}
+
+// sqlExpr is an expression in a SQL statement. String returns its canonical
+// text, which names the column it produces and identifies repeated uses of
+// the same expression.
+type sqlExpr interface {
+	String() string
+}
+
+type sqlIdent struct {
+	// table is the qualifier in table.name, if any. It is checked when the
+	// query is planned and is not part of the column's canonical name.
+	table string
+	name  string
+}
+
+func (e sqlIdent) String() string {
+	return e.name
+}
+
+type sqlNumberLit struct {
+	text string
+}
+
+func (e sqlNumberLit) String() string {
+	return e.text
+}
+
+type sqlString struct {
+	value string
+}
+
+func (e sqlString) String() string {
+	return "'" + strings.ReplaceAll(e.value, "'", "''") + "'"
+}
+
+type sqlBinary struct {
+	op   string
+	l, r sqlExpr
+}
+
+func (e sqlBinary) String() string {
+	return e.l.String() + " " + e.op + " " + e.r.String()
+}
+
+type sqlUnary struct {
+	op   string
+	expr sqlExpr
+}
+
+func (e sqlUnary) String() string {
+	if e.op == "-" {
+		return "-" + e.expr.String()
+	}
+	return e.op + " " + e.expr.String()
+}
+
+// sqlParen keeps the parentheses around an expression in its name
+type sqlParen struct {
+	expr sqlExpr
+}
+
+func (e sqlParen) String() string {
+	return "(" + e.expr.String() + ")"
+}
+
+type sqlFunction struct {
+	name string
+	args []sqlExpr
+	// star is set for COUNT(*)
+	star bool
+}
+
+func (e sqlFunction) String() string {
+	if e.star {
+		return e.name + "(*)"
+	}
+	args := make([]string, len(e.args))
+	for i, a := range e.args {
+		args[i] = a.String()
+	}
+	return e.name + "(" + strings.Join(args, ", ") + ")"
+}
+
+// sqlStar is * in a select list
+type sqlStar struct{}
+
+func (sqlStar) String() string {
+	return "*"
+}
+
+type sqlStatement interface{}
+
+type sqlSelectItem struct {
+	expr  sqlExpr
+	alias string
+}
+
+type sqlOrderItem struct {
+	expr sqlExpr
+	asc  bool
+}
+
+// sqlFrom is the relation a query reads: a table, a subquery or a call to
+// a table function
+type sqlFrom struct {
+	table    string
+	subquery *sqlSelect
+	alias    string
+	// call is set when table names a table function called with args
+	call bool
+	args []sqlExpr
+}
+
+type sqlSelect struct {
+	distinct bool
+	items    []sqlSelectItem
+	// from is nil when the query has no FROM clause
+	from    *sqlFrom
+	where   sqlExpr
+	groupBy []sqlExpr
+	having  sqlExpr
+	orderBy []sqlOrderItem
+	// limit and offset are -1 when not given
+	limit  int
+	offset int
+}
+
+type sqlCreateExternalTable struct {
+	name        string
+	format      string
+	location    string
+	ifNotExists bool
+}
+
+type sqlCopy struct {
+	// the source is either a table or a query
+	table       string
+	query       *sqlSelect
+	path        string
+	format      string
+	partitionBy []string
+	options     map[string]string
+}
+
+// sqlExplain describes the plan of a query, or with analyze runs it and
+// describes the plan with its metrics
+type sqlExplain struct {
+	analyze bool
+	query   *sqlSelect
+}
+
+type sqlShowTables struct{}
+
+type sqlDescribe struct {
+	table string
+}
+
+type sqlParser struct {
+	tokens []sqlToken
+	pos    int
+}
+
+// parseSql parses a single statement, which may end with a semicolon
+func parseSql(sql string) (sqlStatement, error) {
+	tokens, err := tokenizeSql(sql)
+	if err != nil {
+		return nil, err
+	}
+	p := &sqlParser{tokens: tokens}
+	stmt, err := p.statement()
+	if err != nil {
+		return nil, err
+	}
+	p.acceptSymbol(";")
+	if p.peek().kind != sqlEOF {
+		return nil, p.unexpected("end of statement")
+	}
+	return stmt, nil
+}
+
+func (p *sqlParser) peek() sqlToken {
+	return p.tokens[p.pos]
+}
+
+func (p *sqlParser) next() sqlToken {
+	t := p.tokens[p.pos]
+	if t.kind != sqlEOF {
+		p.pos++
+	}
+	return t
+}
+
+func (p *sqlParser) unexpected(expected string) error {
+	t := p.peek()
+	return fmt.Errorf("syntax error at position %d: expected %s, found %s", t.pos, expected, t)
+}
+
+func (p *sqlParser) isKeyword(keyword string) bool {
+	t := p.peek()
+	return t.kind == sqlWord && strings.EqualFold(t.text, keyword)
+}
+
+// acceptKeyword consumes the keyword if it is next
+func (p *sqlParser) acceptKeyword(keyword string) bool {
+	if p.isKeyword(keyword) {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+func (p *sqlParser) expectKeyword(keywords ...string) error {
+	for _, k := range keywords {
+		if !p.acceptKeyword(k) {
+			return p.unexpected(k)
+		}
+	}
+	return nil
+}
+
+func (p *sqlParser) isSymbol(symbol string) bool {
+	t := p.peek()
+	return t.kind == sqlSymbol && t.text == symbol
+}
+
+func (p *sqlParser) acceptSymbol(symbol string) bool {
+	if p.isSymbol(symbol) {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+func (p *sqlParser) expectSymbol(symbol string) error {
+	if !p.acceptSymbol(symbol) {
+		return p.unexpected("'" + symbol + "'")
+	}
+	return nil
+}
+
+// identifier reads a quoted or unquoted name. Unquoted names keep their case.
+func (p *sqlParser) identifier() (string, error) {
+	t := p.peek()
+	if t.kind == sqlQuotedIdent || (t.kind == sqlWord && !sqlReserved[strings.ToUpper(t.text)]) {
+		p.pos++
+		return t.text, nil
+	}
+	return "", p.unexpected("identifier")
+}
+
+// qualifiedName reads a name that may contain dots, as in schema.table
+func (p *sqlParser) qualifiedName() (string, error) {
+	name, err := p.identifier()
+	for err == nil && p.acceptSymbol(".") {
+		var part string
+		part, err = p.identifier()
+		name += "." + part
+	}
+	return name, err
+}
+
+func (p *sqlParser) stringLiteral() (string, error) {
+	t := p.peek()
+	if t.kind != sqlStringLit {
+		return "", p.unexpected("string")
+	}
+	p.pos++
+	return t.text, nil
+}
+
+func (p *sqlParser) integer() (int, error) {
+	t := p.peek()
+	n, err := strconv.Atoi(t.text)
+	if t.kind != sqlNumber || err != nil || n < 0 {
+		return 0, p.unexpected("non-negative integer")
+	}
+	p.pos++
+	return n, nil
+}
+
+func (p *sqlParser) statement() (sqlStatement, error) {
+	switch {
+	case p.isKeyword("SELECT"):
+		return p.query()
+	case p.acceptKeyword("CREATE"):
+		return p.createExternalTable()
+	case p.acceptKeyword("COPY"):
+		return p.copyTo()
+	case p.acceptKeyword("EXPLAIN"):
+		analyze := p.acceptKeyword("ANALYZE")
+		query, err := p.query()
+		return &sqlExplain{analyze, query}, err
+	case p.acceptKeyword("SHOW"):
+		return sqlShowTables{}, p.expectKeyword("TABLES")
+	case p.acceptKeyword("DESCRIBE"):
+		table, err := p.qualifiedName()
+		return &sqlDescribe{table}, err
+	default:
+		return nil, p.unexpected("SELECT, CREATE EXTERNAL TABLE, COPY, EXPLAIN, SHOW TABLES or DESCRIBE")
+	}
+}
+
+func (p *sqlParser) query() (*sqlSelect, error) {
+	if err := p.expectKeyword("SELECT"); err != nil {
+		return nil, err
+	}
+	s := &sqlSelect{limit: -1, offset: -1}
+	s.distinct = p.acceptKeyword("DISTINCT")
+	for {
+		item, err := p.selectItem()
+		if err != nil {
+			return nil, err
+		}
+		s.items = append(s.items, item)
+		if !p.acceptSymbol(",") {
+			break
+		}
+	}
+	var err error
+	if p.acceptKeyword("FROM") {
+		if s.from, err = p.from(); err != nil {
+			return nil, err
+		}
+	}
+	if p.acceptKeyword("WHERE") {
+		if s.where, err = p.expr(); err != nil {
+			return nil, err
+		}
+	}
+	if p.acceptKeyword("GROUP") {
+		if err := p.expectKeyword("BY"); err != nil {
+			return nil, err
+		}
+		if s.groupBy, err = p.exprList(); err != nil {
+			return nil, err
+		}
+	}
+	if p.acceptKeyword("HAVING") {
+		if s.having, err = p.expr(); err != nil {
+			return nil, err
+		}
+	}
+	if p.acceptKeyword("ORDER") {
+		if err := p.expectKeyword("BY"); err != nil {
+			return nil, err
+		}
+		for {
+			expr, err := p.expr()
+			if err != nil {
+				return nil, err
+			}
+			asc := !p.acceptKeyword("DESC")
+			if asc {
+				p.acceptKeyword("ASC")
+			}
+			s.orderBy = append(s.orderBy, sqlOrderItem{expr, asc})
+			if !p.acceptSymbol(",") {
+				break
+			}
+		}
+	}
+	for {
+		switch {
+		case p.acceptKeyword("LIMIT"):
+			if s.limit, err = p.integer(); err != nil {
+				return nil, err
+			}
+		case p.acceptKeyword("OFFSET"):
+			if s.offset, err = p.integer(); err != nil {
+				return nil, err
+			}
+		default:
+			return s, nil
+		}
+	}
+}
+
+func (p *sqlParser) selectItem() (sqlSelectItem, error) {
+	if p.acceptSymbol("*") {
+		return sqlSelectItem{expr: sqlStar{}}, nil
+	}
+	expr, err := p.expr()
+	if err != nil {
+		return sqlSelectItem{}, err
+	}
+	alias, err := p.alias()
+	return sqlSelectItem{expr, alias}, err
+}
+
+// alias reads an optional [AS] name
+func (p *sqlParser) alias() (string, error) {
+	if p.acceptKeyword("AS") {
+		return p.identifier()
+	}
+	t := p.peek()
+	if t.kind == sqlQuotedIdent || (t.kind == sqlWord && !sqlReserved[strings.ToUpper(t.text)]) {
+		return p.identifier()
+	}
+	return "", nil
+}
+
+func (p *sqlParser) from() (*sqlFrom, error) {
+	from := &sqlFrom{}
+	var err error
+	if p.acceptSymbol("(") {
+		if from.subquery, err = p.query(); err != nil {
+			return nil, err
+		}
+		if err := p.expectSymbol(")"); err != nil {
+			return nil, err
+		}
+	} else if from.table, err = p.qualifiedName(); err != nil {
+		return nil, err
+	} else if p.acceptSymbol("(") {
+		from.call = true
+		if !p.acceptSymbol(")") {
+			if from.args, err = p.exprList(); err != nil {
+				return nil, err
+			}
+			if err := p.expectSymbol(")"); err != nil {
+				return nil, err
+			}
+		}
+	}
+	from.alias, err = p.alias()
+	return from, err
+}
+
+func (p *sqlParser) exprList() ([]sqlExpr, error) {
+	exprs := []sqlExpr{}
+	for {
+		expr, err := p.expr()
+		if err != nil {
+			return nil, err
+		}
+		exprs = append(exprs, expr)
+		if !p.acceptSymbol(",") {
+			return exprs, nil
+		}
+	}
+}
+
+// expr parses an expression. From loosest to tightest binding the operators
+// are OR, AND, NOT, comparisons and [NOT] LIKE/ILIKE/SIMILAR TO, + - ||,
+// * / %, and unary minus.
+func (p *sqlParser) expr() (sqlExpr, error) {
+	return p.or()
+}
+
+func (p *sqlParser) or() (sqlExpr, error) {
+	l, err := p.and()
+	for err == nil && p.acceptKeyword("OR") {
+		var r sqlExpr
+		if r, err = p.and(); err == nil {
+			l = sqlBinary{"OR", l, r}
+		}
+	}
+	return l, err
+}
+
+func (p *sqlParser) and() (sqlExpr, error) {
+	l, err := p.not()
+	for err == nil && p.acceptKeyword("AND") {
+		var r sqlExpr
+		if r, err = p.not(); err == nil {
+			l = sqlBinary{"AND", l, r}
+		}
+	}
+	return l, err
+}
+
+func (p *sqlParser) not() (sqlExpr, error) {
+	if p.acceptKeyword("NOT") {
+		expr, err := p.not()
+		return sqlUnary{"NOT", expr}, err
+	}
+	return p.comparison()
+}
+
+var sqlComparisons = map[string]bool{"=": true, "<>": true, "!=": true, "<": true, "<=": true, ">": true, ">=": true}
+
+func (p *sqlParser) comparison() (sqlExpr, error) {
+	l, err := p.additive()
+	if err != nil {
+		return nil, err
+	}
+	if t := p.peek(); t.kind == sqlSymbol && sqlComparisons[t.text] {
+		p.pos++
+		r, err := p.additive()
+		return sqlBinary{t.text, l, r}, err
+	}
+	start := p.pos
+	negated := p.acceptKeyword("NOT")
+	for _, op := range []string{"LIKE", "ILIKE", "SIMILAR"} {
+		if p.acceptKeyword(op) {
+			if op == "SIMILAR" {
+				if err := p.expectKeyword("TO"); err != nil {
+					return nil, err
+				}
+				op = "SIMILAR TO"
+			}
+			if negated {
+				op = "NOT " + op
+			}
+			r, err := p.additive()
+			return sqlBinary{op, l, r}, err
+		}
+	}
+	p.pos = start
+	return l, nil
+}
+
+func (p *sqlParser) additive() (sqlExpr, error) {
+	l, err := p.multiplicative()
+	for err == nil && (p.isSymbol("+") || p.isSymbol("-") || p.isSymbol("||")) {
+		op := p.next().text
+		var r sqlExpr
+		if r, err = p.multiplicative(); err == nil {
+			l = sqlBinary{op, l, r}
+		}
+	}
+	return l, err
+}
+
+func (p *sqlParser) multiplicative() (sqlExpr, error) {
+	l, err := p.unary()
+	for err == nil && (p.isSymbol("*") || p.isSymbol("/") || p.isSymbol("%")) {
+		op := p.next().text
+		var r sqlExpr
+		if r, err = p.unary(); err == nil {
+			l = sqlBinary{op, l, r}
+		}
+	}
+	return l, err
+}
+
+func (p *sqlParser) unary() (sqlExpr, error) {
+	if p.acceptSymbol("-") {
+		expr, err := p.unary()
+		return sqlUnary{"-", expr}, err
+	}
+	return p.primary()
+}
+
+func (p *sqlParser) primary() (sqlExpr, error) {
+	t := p.peek()
+	switch t.kind {
+	case sqlNumber:
+		p.pos++
+		return sqlNumberLit{t.text}, nil
+	case sqlStringLit:
+		p.pos++
+		return sqlString{t.text}, nil
+	case sqlSymbol:
+		if p.acceptSymbol("(") {
+			expr, err := p.expr()
+			if err != nil {
+				return nil, err
+			}
+			return sqlParen{expr}, p.expectSymbol(")")
+		}
+	case sqlWord, sqlQuotedIdent:
+		if t.kind == sqlWord && p.tokens[p.pos+1].kind == sqlSymbol && p.tokens[p.pos+1].text == "(" {
+			return p.function()
+		}
+		name, err := p.identifier()
+		if err != nil {
+			return nil, err
+		}
+		if p.acceptSymbol(".") {
+			column, err := p.identifier()
+			return sqlIdent{name, column}, err
+		}
+		return sqlIdent{name: name}, nil
+	}
+	return nil, p.unexpected("expression")
+}
+
+func (p *sqlParser) function() (sqlExpr, error) {
+	f := sqlFunction{name: strings.ToUpper(p.next().text)}
+	p.next()
+	if p.acceptSymbol("*") {
+		f.star = true
+		return f, p.expectSymbol(")")
+	}
+	if p.acceptSymbol(")") {
+		return f, nil
+	}
+	args, err := p.exprList()
+	if err != nil {
+		return nil, err
+	}
+	// POSITION(substring IN string)
+	if f.name == "POSITION" && len(args) == 1 && p.acceptKeyword("IN") {
+		s, err := p.additive()
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, s)
+	}
+	f.args = args
+	return f, p.expectSymbol(")")
+}
+
+// createExternalTable parses the rest of
+//
+//	CREATE EXTERNAL TABLE [IF NOT EXISTS] name STORED AS format
+//	    [WITH HEADER ROW] LOCATION 'path'
+func (p *sqlParser) createExternalTable() (sqlStatement, error) {
+	if err := p.expectKeyword("EXTERNAL", "TABLE"); err != nil {
+		return nil, err
+	}
+	s := &sqlCreateExternalTable{}
+	if p.acceptKeyword("IF") {
+		if err := p.expectKeyword("NOT", "EXISTS"); err != nil {
+			return nil, err
+		}
+		s.ifNotExists = true
+	}
+	var err error
+	if s.name, err = p.qualifiedName(); err != nil {
+		return nil, err
+	}
+	for {
+		switch {
+		case p.acceptKeyword("STORED"):
+			if err := p.expectKeyword("AS"); err != nil {
+				return nil, err
+			}
+			format, err := p.identifier()
+			if err != nil {
+				return nil, err
+			}
+			s.format = strings.ToLower(format)
+		case p.acceptKeyword("WITH"):
+			// CSV files are always read with a header row
+			if err := p.expectKeyword("HEADER", "ROW"); err != nil {
+				return nil, err
+			}
+		case p.acceptKeyword("LOCATION"):
+			if s.location, err = p.stringLiteral(); err != nil {
+				return nil, err
+			}
+		default:
+			if s.location == "" {
+				return nil, p.unexpected("LOCATION")
+			}
+			return s, nil
+		}
+	}
+}
+
+// copyTo parses the rest of
+//
+//	COPY {table | (query)} TO 'path' [STORED AS format]
+//	    [PARTITIONED BY (column, ...)] [OPTIONS (name 'value', ...)]
+func (p *sqlParser) copyTo() (sqlStatement, error) {
+	s := &sqlCopy{options: map[string]string{}}
+	var err error
+	if p.acceptSymbol("(") {
+		if s.query, err = p.query(); err != nil {
+			return nil, err
+		}
+		if err := p.expectSymbol(")"); err != nil {
+			return nil, err
+		}
+	} else if s.table, err = p.qualifiedName(); err != nil {
+		return nil, err
+	}
+	if err := p.expectKeyword("TO"); err != nil {
+		return nil, err
+	}
+	if s.path, err = p.stringLiteral(); err != nil {
+		return nil, err
+	}
+	for {
+		switch {
+		case p.acceptKeyword("STORED"):
+			if err := p.expectKeyword("AS"); err != nil {
+				return nil, err
+			}
+			format, err := p.identifier()
+			if err != nil {
+				return nil, err
+			}
+			s.format = strings.ToLower(format)
+		case p.acceptKeyword("PARTITIONED"):
+			if err := p.expectKeyword("BY"); err != nil {
+				return nil, err
+			}
+			if err := p.expectSymbol("("); err != nil {
+				return nil, err
+			}
+			for {
+				column, err := p.identifier()
+				if err != nil {
+					return nil, err
+				}
+				s.partitionBy = append(s.partitionBy, column)
+				if !p.acceptSymbol(",") {
+					break
+				}
+			}
+			if err := p.expectSymbol(")"); err != nil {
+				return nil, err
+			}
+		case p.acceptKeyword("OPTIONS"):
+			if err := p.expectSymbol("("); err != nil {
+				return nil, err
+			}
+			for {
+				name, err := p.identifier()
+				if err != nil {
+					return nil, err
+				}
+				value, err := p.optionValue()
+				if err != nil {
+					return nil, err
+				}
+				s.options[strings.ToLower(name)] = value
+				if !p.acceptSymbol(",") {
+					break
+				}
+			}
+			if err := p.expectSymbol(")"); err != nil {
+				return nil, err
+			}
+		default:
+			return s, nil
+		}
+	}
+}
+
+// optionValue reads a string, number or word as the value of an option
+func (p *sqlParser) optionValue() (string, error) {
+	t := p.peek()
+	if t.kind == sqlStringLit || t.kind == sqlNumber || t.kind == sqlWord {
+		p.pos++
+		return t.text, nil
+	}
+	return "", p.unexpected("option value")
+}
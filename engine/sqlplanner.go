@@ -0,0 +1,815 @@
+package engine
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/apache/arrow/go/v12/arrow"
+	"github.com/briansterle/drogo"
+)
+
+// Sql plans a SQL statement against the registered tables. A SELECT returns
+// a DataFrame that is executed when it is collected. CREATE EXTERNAL TABLE
+// and COPY run immediately and return a DataFrame with no columns, and
+// EXPLAIN, SHOW TABLES and DESCRIBE return their output as a DataFrame.
+//
+// Planning gives up once ctx is done or QueryTimeout has passed, which
+// bounds the time spent reading the schemas of database tables.
+func (ec *ExecutionContext) Sql(ctx context.Context, sql string) (DataFrame, error) {
+	stmt, err := parseSql(sql)
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := ec.queryContext(ctx)
+	defer cancel()
+	switch s := stmt.(type) {
+	case *sqlSelect:
+		return ec.planSelect(ctx, s)
+	case *sqlCreateExternalTable:
+		if s.ifNotExists {
+			if _, err := ec.Table(s.name); err == nil {
+				return ec.values(nil, nil)
+			}
+		}
+		if err := ec.RegisterFile(s.name, s.location, s.format); err != nil {
+			return nil, err
+		}
+		return ec.values(nil, nil)
+	case *sqlCopy:
+		if err := ec.copyTo(ctx, s); err != nil {
+			return nil, err
+		}
+		return ec.values(nil, nil)
+	case *sqlExplain:
+		df, err := ec.planSelect(ctx, s.query)
+		if err != nil {
+			return nil, err
+		}
+		return ec.Explain(ctx, df, s.analyze)
+	case sqlShowTables:
+		names := ec.TableNames()
+		rows := make([][]any, len(names))
+		for i, name := range names {
+			rows[i] = []any{name}
+		}
+		return ec.values([]arrow.Field{{Name: "table_name", Type: drogo.String}}, rows)
+	case *sqlDescribe:
+		df, err := ec.Table(s.table)
+		if err != nil {
+			return nil, err
+		}
+		if err := loadSchema(ctx, df.LogicalPlan().(Scan).Source); err != nil {
+			return nil, err
+		}
+		rows := [][]any{}
+		for _, f := range df.Schema().Fields() {
+			nullable := "NO"
+			if f.Nullable {
+				nullable = "YES"
+			}
+			rows = append(rows, []any{f.Name, f.Type.String(), nullable})
+		}
+		return ec.values([]arrow.Field{
+			{Name: "column_name", Type: drogo.String},
+			{Name: "data_type", Type: drogo.String},
+			{Name: "is_nullable", Type: drogo.String},
+		}, rows)
+	default:
+		return nil, fmt.Errorf("unsupported statement")
+	}
+}
+
+// values returns a DataFrame of rows held in memory
+func (ec *ExecutionContext) values(fields []arrow.Field, rows [][]any) (DataFrame, error) {
+	schema := Schema{arrow.NewSchema(fields, nil)}
+	batches := []RecordBatch{}
+	if len(rows) > 0 {
+		columns := make([]ColumnVector, len(fields))
+		for i, f := range fields {
+			values := make([]any, len(rows))
+			for j, row := range rows {
+				values[j] = row[i]
+			}
+			columns[i] = drogo.New(f.Type, len(rows), values)
+		}
+		batches = append(batches, RecordBatch{schema, columns})
+	}
+	table, err := NewMemTable(schema, batches)
+	if err != nil {
+		return nil, err
+	}
+	return ec.Scan("values", table), nil
+}
+
+// copyTo writes the results of a COPY statement's table or query to a file
+// or, with PARTITIONED BY, to a directory of files
+func (ec *ExecutionContext) copyTo(ctx context.Context, s *sqlCopy) error {
+	var df DataFrame
+	var err error
+	if s.query != nil {
+		df, err = ec.planSelect(ctx, s.query)
+	} else {
+		df, err = ec.Table(s.table)
+	}
+	if err != nil {
+		return err
+	}
+	format := s.format
+	if format == "" {
+		if format = fileFormatOf(s.path); format == "" {
+			return fmt.Errorf("cannot tell the format of %s, use STORED AS", s.path)
+		}
+	}
+	options := copyOptions{s.options, format}
+	compression := options.take("compression")
+	if compression == "" && (format == "csv" || format == "json") && trimCompression(s.path) != s.path {
+		compression = "gzip"
+	}
+	switch format {
+	case "csv":
+		csvOptions := CsvWriteOptions{Compression: compression, PartitionBy: s.partitionBy}
+		if delimiter := options.take("delimiter"); delimiter != "" {
+			r, size := utf8.DecodeRuneInString(delimiter)
+			if size != len(delimiter) {
+				return fmt.Errorf("delimiter must be a single character: '%s'", delimiter)
+			}
+			csvOptions.Delimiter = r
+		}
+		header, err := options.bool("header", true)
+		if err != nil {
+			return err
+		}
+		csvOptions.NoHeader = !header
+		if err := options.done(); err != nil {
+			return err
+		}
+		return df.WriteCsv(ctx, s.path, csvOptions)
+	case "json":
+		if err := options.done(); err != nil {
+			return err
+		}
+		return df.WriteJson(ctx, s.path, JsonWriteOptions{Compression: compression, PartitionBy: s.partitionBy})
+	case "parquet":
+		parquetOptions := ParquetWriteOptions{Compression: compression, PartitionBy: s.partitionBy}
+		if size := options.take("row_group_size"); size != "" {
+			n, err := strconv.Atoi(size)
+			if err != nil || n < 1 {
+				return fmt.Errorf("row_group_size must be a positive integer: '%s'", size)
+			}
+			parquetOptions.RowGroupSize = n
+		}
+		if err := options.done(); err != nil {
+			return err
+		}
+		return df.WriteParquet(ctx, s.path, parquetOptions)
+	case "arrow":
+		stream, err := options.bool("stream", false)
+		if err != nil {
+			return err
+		}
+		if err := options.done(); err != nil {
+			return err
+		}
+		return df.WriteArrow(ctx, s.path, ArrowWriteOptions{Compression: compression, Stream: stream, PartitionBy: s.partitionBy})
+	default:
+		return fmt.Errorf("unknown file format '%s'", format)
+	}
+}
+
+// copyOptions are the OPTIONS of a COPY statement. Each option is taken by
+// the format that understands it, and any left over are an error.
+type copyOptions struct {
+	values map[string]string
+	format string
+}
+
+func (o copyOptions) take(name string) string {
+	value := o.values[name]
+	delete(o.values, name)
+	return value
+}
+
+func (o copyOptions) bool(name string, unset bool) (bool, error) {
+	value := strings.ToLower(o.take(name))
+	switch value {
+	case "":
+		return unset, nil
+	case "true":
+		return true, nil
+	case "false":
+		return false, nil
+	default:
+		return false, fmt.Errorf("%s must be true or false: '%s'", name, value)
+	}
+}
+
+func (o copyOptions) done() error {
+	for name := range o.values {
+		return fmt.Errorf("unknown option '%s' for %s files", name, o.format)
+	}
+	return nil
+}
+
+// sqlScope is what the expressions of a query can refer to
+type sqlScope struct {
+	ec   *ExecutionContext
+	plan LogicalPlan
+	// qualifier is the name or alias of the table being read
+	qualifier string
+	// groups maps the canonical text of grouping expressions and aggregate
+	// calls to the aggregate's output columns. It is nil before aggregation,
+	// and after it the input columns can only be reached through it.
+	groups map[string]string
+}
+
+var sqlAggregates = map[string]bool{"SUM": true, "MIN": true, "MAX": true, "AVG": true, "COUNT": true}
+
+// isAggregate reports whether a function is a built-in or registered
+// aggregate function
+func (scope *sqlScope) isAggregate(name string) bool {
+	if sqlAggregates[name] {
+		return true
+	}
+	_, ok := scope.ec.AggregateFunction(name)
+	return ok
+}
+
+func (ec *ExecutionContext) planSelect(ctx context.Context, s *sqlSelect) (DataFrame, error) {
+	df, qualifier, err := ec.planFrom(ctx, s.from)
+	if err != nil {
+		return nil, err
+	}
+	scope := &sqlScope{ec: ec, plan: df.LogicalPlan(), qualifier: qualifier}
+	if s.where != nil {
+		if scope.containsAggregate(s.where) {
+			return nil, fmt.Errorf("aggregate functions are not allowed in WHERE")
+		}
+		predicate, err := scope.boolean(s.where, "WHERE")
+		if err != nil {
+			return nil, err
+		}
+		df = df.Filter(predicate)
+		scope.plan = df.LogicalPlan()
+	}
+
+	aggregating := len(s.groupBy) > 0 || s.having != nil
+	for _, item := range s.items {
+		aggregating = aggregating || scope.containsAggregate(item.expr)
+	}
+	for _, o := range s.orderBy {
+		aggregating = aggregating || scope.containsAggregate(o.expr)
+	}
+	if aggregating {
+		if df, err = scope.aggregate(df, s); err != nil {
+			return nil, err
+		}
+	}
+	if s.having != nil {
+		predicate, err := scope.boolean(s.having, "HAVING")
+		if err != nil {
+			return nil, err
+		}
+		df = df.Filter(predicate)
+		scope.plan = df.LogicalPlan()
+	}
+
+	exprs, names, unnest, err := scope.selectList(s.items)
+	if err != nil {
+		return nil, err
+	}
+	sortExprs, exprs, err := scope.orderBy(s, exprs, names)
+	if err != nil {
+		return nil, err
+	}
+	df = df.Project(exprs)
+	if unnest != "" {
+		df = df.Unnest(unnest)
+	}
+	if s.distinct {
+		df = df.Aggregate(columns(names), nil)
+	}
+	if len(sortExprs) > 0 {
+		df = df.Sort(sortExprs)
+	}
+	if len(exprs) > len(names) {
+		// drop the columns that were only added to sort by
+		df = df.Project(columns(names))
+	}
+	if s.offset > 0 {
+		df = df.Offset(s.offset)
+	}
+	if s.limit >= 0 {
+		df = df.Limit(s.limit)
+	}
+	return df, nil
+}
+
+func columns(names []string) []LogicalExpr {
+	exprs := make([]LogicalExpr, len(names))
+	for i, name := range names {
+		exprs[i] = Col(name)
+	}
+	return exprs
+}
+
+// planFrom returns the relation a query reads and the name its columns can
+// be qualified with. A query without FROM reads a single row with no columns
+// it can refer to.
+func (ec *ExecutionContext) planFrom(ctx context.Context, from *sqlFrom) (DataFrame, string, error) {
+	if from == nil {
+		df, err := ec.values([]arrow.Field{{Name: "", Type: drogo.Int64}}, [][]any{{int64(0)}})
+		return df, "", err
+	}
+	if from.subquery != nil {
+		df, err := ec.planSelect(ctx, from.subquery)
+		return df, from.alias, err
+	}
+	var df DataFrame
+	var err error
+	if from.call {
+		df, err = ec.planCall(ctx, from)
+	} else {
+		df, err = ec.Table(from.table)
+	}
+	if err != nil {
+		return nil, "", err
+	}
+	if err := loadSchema(ctx, df.LogicalPlan().(Scan).Source); err != nil {
+		return nil, "", err
+	}
+	qualifier := from.alias
+	if qualifier == "" {
+		qualifier = from.table[strings.LastIndex(from.table, ".")+1:]
+	}
+	return df, qualifier, nil
+}
+
+// planCall calls the table function in a FROM clause. Its arguments must be
+// constants, and arguments written name = value are passed as options.
+func (ec *ExecutionContext) planCall(ctx context.Context, from *sqlFrom) (DataFrame, error) {
+	args, options := []any{}, map[string]any{}
+	for _, arg := range from.args {
+		if b, ok := arg.(sqlBinary); ok && b.op == "=" {
+			if name, ok := b.l.(sqlIdent); ok && name.table == "" {
+				value, err := ec.constant(ctx, b.r)
+				if err != nil {
+					return nil, fmt.Errorf("invalid option %s of %s: %w", name.name, from.table, err)
+				}
+				options[strings.ToLower(name.name)] = value
+				continue
+			}
+		}
+		value, err := ec.constant(ctx, arg)
+		if err != nil {
+			return nil, fmt.Errorf("invalid argument %s of %s: %w", arg, from.table, err)
+		}
+		args = append(args, value)
+	}
+	return ec.CallTable(from.table, args, options)
+}
+
+// constant evaluates an expression that does not read any table. TRUE and
+// FALSE are read as booleans.
+func (ec *ExecutionContext) constant(ctx context.Context, e sqlExpr) (any, error) {
+	if ident, ok := e.(sqlIdent); ok && ident.table == "" {
+		switch strings.ToUpper(ident.name) {
+		case "TRUE":
+			return true, nil
+		case "FALSE":
+			return false, nil
+		}
+	}
+	row, _, err := ec.planFrom(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	scope := &sqlScope{ec: ec, plan: row.LogicalPlan()}
+	if scope.containsAggregate(e) {
+		return nil, fmt.Errorf("aggregate functions are not allowed in FROM")
+	}
+	expr, err := scope.expr(e)
+	if err != nil {
+		return nil, err
+	}
+	physical, err := QueryPlanner{1}.CreatePhysicalExpr(expr, row.LogicalPlan())
+	if err != nil {
+		return nil, err
+	}
+	batch := RecordBatch{row.Schema(), []ColumnVector{drogo.New(drogo.Int64, 1, []any{int64(0)})}}
+	v, err := evaluate(physical, batch)
+	if err != nil {
+		return nil, err
+	}
+	return v.GetValue(0), nil
+}
+
+// aggregate plans the grouping and aggregate calls of a query, after which
+// the scope resolves them to the aggregate's output columns
+func (scope *sqlScope) aggregate(df DataFrame, s *sqlSelect) (DataFrame, error) {
+	groups := map[string]string{}
+	groupExprs := []LogicalExpr{}
+	for _, g := range s.groupBy {
+		g = resolveGroup(g, s.items, scope.plan.Schema())
+		if scope.containsAggregate(g) {
+			return nil, fmt.Errorf("aggregate functions are not allowed in GROUP BY")
+		}
+		expr, err := scope.expr(g)
+		if err != nil {
+			return nil, err
+		}
+		name := g.String()
+		if _, ok := groups[name]; ok {
+			continue
+		}
+		if c, ok := expr.(Column); !ok || c.name != name {
+			expr = Alias{expr, name}
+		}
+		groups[name] = name
+		groupExprs = append(groupExprs, expr)
+	}
+
+	calls := []sqlFunction{}
+	for _, item := range s.items {
+		calls = scope.collectAggregates(item.expr, calls)
+	}
+	if s.having != nil {
+		calls = scope.collectAggregates(s.having, calls)
+	}
+	for _, o := range s.orderBy {
+		calls = scope.collectAggregates(o.expr, calls)
+	}
+	aggregateExprs := []AggregateExpr{}
+	for _, call := range calls {
+		name := call.String()
+		if _, ok := groups[name]; ok {
+			continue
+		}
+		var arg LogicalExpr
+		switch {
+		case call.star && call.name == "COUNT":
+			arg = Int(1)
+		case call.star || len(call.args) != 1:
+			return nil, fmt.Errorf("%s takes one argument", call.name)
+		default:
+			if scope.containsAggregate(call.args[0]) {
+				return nil, fmt.Errorf("aggregate function calls cannot be nested: %s", name)
+			}
+			var err error
+			if arg, err = scope.expr(call.args[0]); err != nil {
+				return nil, err
+			}
+			if sqlAggregates[call.name] && call.name != "COUNT" && call.name != "MIN" && call.name != "MAX" && !isNumericType(arg.ToField(scope.plan).Type) {
+				return nil, fmt.Errorf("%s needs a numeric argument: %s", call.name, name)
+			}
+		}
+		groups[name] = name
+		if sqlAggregates[call.name] {
+			aggregateExprs = append(aggregateExprs, AggregateExpr{Name: call.name, Expr: arg, Alias: name})
+			continue
+		}
+		e, err := scope.ec.CallAggregate(call.name, arg)
+		if err != nil {
+			return nil, err
+		}
+		e.Alias = name
+		if err := e.check(scope.plan); err != nil {
+			return nil, err
+		}
+		aggregateExprs = append(aggregateExprs, e)
+	}
+	df = df.Aggregate(groupExprs, aggregateExprs)
+	scope.plan = df.LogicalPlan()
+	scope.groups = groups
+	return df, nil
+}
+
+// resolveGroup turns a GROUP BY position or select list alias into the
+// expression it refers to. Input columns take precedence over aliases.
+func resolveGroup(g sqlExpr, items []sqlSelectItem, schema Schema) sqlExpr {
+	if n, ok := position(g); ok && n >= 1 && n <= len(items) {
+		return items[n-1].expr
+	}
+	if id, ok := g.(sqlIdent); ok && id.table == "" {
+		if _, found := schema.FieldsByName(id.name); !found {
+			for _, item := range items {
+				if item.alias == id.name {
+					return item.expr
+				}
+			}
+		}
+	}
+	return g
+}
+
+// position returns the integer of an ordinal in GROUP BY or ORDER BY
+func position(e sqlExpr) (int, bool) {
+	lit, ok := e.(sqlNumberLit)
+	if !ok {
+		return 0, false
+	}
+	n, err := strconv.Atoi(lit.text)
+	return n, err == nil
+}
+
+// selectList plans the items of a select list and names the columns they
+// produce. One item may be UNNEST(list), whose column is expanded into a row
+// for each element after the other items are computed; its name is
+// returned as well.
+func (scope *sqlScope) selectList(items []sqlSelectItem) ([]LogicalExpr, []string, string, error) {
+	exprs := []LogicalExpr{}
+	names := []string{}
+	unnest := ""
+	for _, item := range items {
+		if _, ok := item.expr.(sqlStar); ok {
+			if scope.groups != nil {
+				return nil, nil, "", fmt.Errorf("SELECT * cannot be used with GROUP BY or aggregate functions")
+			}
+			for _, f := range scope.plan.Schema().Fields() {
+				if f.Name == "" {
+					return nil, nil, "", fmt.Errorf("SELECT * needs a FROM clause")
+				}
+				exprs = append(exprs, Col(f.Name))
+				names = append(names, f.Name)
+			}
+			continue
+		}
+		name := item.alias
+		if name == "" {
+			name = item.expr.String()
+		}
+		var expr LogicalExpr
+		var err error
+		if f, ok := item.expr.(sqlFunction); ok && f.name == "UNNEST" {
+			if expr, err = scope.unnest(f); err != nil {
+				return nil, nil, "", err
+			}
+			if unnest != "" {
+				return nil, nil, "", fmt.Errorf("only one UNNEST is allowed in a select list")
+			}
+			unnest = name
+		} else if expr, err = scope.expr(item.expr); err != nil {
+			return nil, nil, "", err
+		}
+		if c, ok := expr.(Column); !ok || c.name != name {
+			expr = Alias{expr, name}
+		}
+		exprs = append(exprs, expr)
+		names = append(names, name)
+	}
+	return exprs, names, unnest, nil
+}
+
+// unnest plans the list argument of UNNEST in a select list
+func (scope *sqlScope) unnest(f sqlFunction) (LogicalExpr, error) {
+	if scope.groups != nil {
+		return nil, fmt.Errorf("UNNEST cannot be used with GROUP BY or aggregate functions")
+	}
+	if f.star || len(f.args) != 1 {
+		return nil, fmt.Errorf("UNNEST takes one argument: %s", f)
+	}
+	expr, err := scope.expr(f.args[0])
+	if err != nil {
+		return nil, err
+	}
+	if dtype := expr.ToField(scope.plan).Type; dtype.ID() != arrow.LIST {
+		return nil, fmt.Errorf("UNNEST needs a list, got %s: %s", dtype, f)
+	}
+	return expr, nil
+}
+
+// orderBy plans the ORDER BY of a query, whose items are positions or names
+// of output columns, or expressions. An expression that is not in the
+// select list is added to it as a hidden column to sort by.
+func (scope *sqlScope) orderBy(s *sqlSelect, exprs []LogicalExpr, names []string) ([]SortExpr, []LogicalExpr, error) {
+	sortExprs := []SortExpr{}
+	for i, o := range s.orderBy {
+		column := ""
+		if n, ok := position(o.expr); ok {
+			if n < 1 || n > len(names) {
+				return nil, nil, fmt.Errorf("ORDER BY position %d is not in the select list", n)
+			}
+			column = names[n-1]
+		} else if id, ok := o.expr.(sqlIdent); ok && id.table == "" {
+			for _, name := range names {
+				if name == id.name {
+					column = name
+					break
+				}
+			}
+		}
+		if column == "" {
+			for j, item := range s.items {
+				if item.expr.String() == o.expr.String() {
+					column = names[j]
+					break
+				}
+			}
+		}
+		if column == "" {
+			if s.distinct {
+				return nil, nil, fmt.Errorf("with SELECT DISTINCT, ORDER BY expressions must appear in the select list: %s", o.expr)
+			}
+			expr, err := scope.expr(o.expr)
+			if err != nil {
+				return nil, nil, err
+			}
+			column = fmt.Sprintf("__sort_%d", i)
+			exprs = append(exprs, Alias{expr, column})
+		}
+		sortExprs = append(sortExprs, SortExpr{Col(column), o.asc})
+	}
+	return sortExprs, exprs, nil
+}
+
+func (scope *sqlScope) boolean(e sqlExpr, clause string) (LogicalExpr, error) {
+	expr, err := scope.expr(e)
+	if err != nil {
+		return nil, err
+	}
+	if expr.ToField(scope.plan).Type.ID() != arrow.BOOL {
+		return nil, fmt.Errorf("%s must be a boolean expression: %s", clause, e)
+	}
+	return expr, nil
+}
+
+var sqlComparisonExprs = map[string]func(l, r LogicalExpr) BooleanBinaryExpr{
+	"=": Eq, "<>": Neq, "!=": Neq, "<": Lt, "<=": LtEq, ">": Gt, ">=": GtEq,
+}
+
+var sqlLikeOps = map[string]bool{"LIKE": true, "NOT LIKE": true, "ILIKE": true, "NOT ILIKE": true, "SIMILAR TO": true, "NOT SIMILAR TO": true}
+
+var sqlMathExprs = map[string]func(l, r LogicalExpr) MathExpr{
+	"+": Add, "-": Subtract, "*": Multiply, "/": Divide, "%": Modulus,
+}
+
+// expr plans an expression, checking that the columns it refers to exist
+// and that its operands have types the operators accept
+func (scope *sqlScope) expr(e sqlExpr) (LogicalExpr, error) {
+	if scope.groups != nil {
+		if name, ok := scope.groups[e.String()]; ok {
+			return Col(name), nil
+		}
+	}
+	switch e := e.(type) {
+	case sqlIdent:
+		if e.table != "" && e.table != scope.qualifier {
+			return nil, fmt.Errorf("unknown table '%s' in %s.%s", e.table, e.table, e.name)
+		}
+		if scope.groups != nil {
+			return nil, fmt.Errorf("column '%s' must appear in GROUP BY or be used in an aggregate function", e.name)
+		}
+		if _, ok := scope.plan.Schema().FieldsByName(e.name); !ok || e.name == "" {
+			return nil, fmt.Errorf("no column named '%s'", e.name)
+		}
+		return Col(e.name), nil
+	case sqlNumberLit:
+		return numberLiteral(e.text)
+	case sqlString:
+		return Str(e.value), nil
+	case sqlParen:
+		return scope.expr(e.expr)
+	case sqlUnary:
+		if e.op != "-" {
+			return nil, fmt.Errorf("%s is not supported", e.op)
+		}
+		if lit, ok := e.expr.(sqlNumberLit); ok {
+			return numberLiteral("-" + lit.text)
+		}
+		expr, err := scope.expr(e.expr)
+		if err != nil {
+			return nil, err
+		}
+		if !isNumericType(expr.ToField(scope.plan).Type) {
+			return nil, fmt.Errorf("cannot negate %s", e.expr)
+		}
+		return Multiply(expr, Int(-1)), nil
+	case sqlBinary:
+		l, err := scope.expr(e.l)
+		if err != nil {
+			return nil, err
+		}
+		r, err := scope.expr(e.r)
+		if err != nil {
+			return nil, err
+		}
+		lt, rt := l.ToField(scope.plan).Type, r.ToField(scope.plan).Type
+		switch {
+		case e.op == "AND" || e.op == "OR":
+			if lt.ID() != arrow.BOOL || rt.ID() != arrow.BOOL {
+				return nil, fmt.Errorf("%s needs boolean operands: %s", e.op, e)
+			}
+			if e.op == "AND" {
+				return And(l, r), nil
+			}
+			return Or(l, r), nil
+		case sqlComparisonExprs[e.op] != nil:
+			if !(isNumericType(lt) && isNumericType(rt)) && !arrow.TypeEqual(lt, rt) {
+				return nil, fmt.Errorf("cannot compare %s with %s: %s", lt, rt, e)
+			}
+			return sqlComparisonExprs[e.op](l, r), nil
+		case e.op == "||":
+			return StringFunction{"||", []LogicalExpr{l, r}}, nil
+		case sqlLikeOps[e.op]:
+			like := LikeExpr{l, r, strings.HasPrefix(e.op, "NOT "), strings.HasSuffix(e.op, "ILIKE"), strings.HasSuffix(e.op, "SIMILAR TO")}
+			if err := like.check(scope.plan); err != nil {
+				return nil, err
+			}
+			return like, nil
+		case sqlMathExprs[e.op] != nil:
+			if !isNumericType(lt) || !isNumericType(rt) {
+				return nil, fmt.Errorf("%s needs numeric operands: %s", e.op, e)
+			}
+			return sqlMathExprs[e.op](l, r), nil
+		default:
+			return nil, fmt.Errorf("operator %s is not supported", e.op)
+		}
+	case sqlFunction:
+		if e.name == "UNNEST" {
+			return nil, fmt.Errorf("UNNEST is only allowed as an item of the select list")
+		}
+		if scope.isAggregate(e.name) {
+			return nil, fmt.Errorf("aggregate function %s is not allowed here", e)
+		}
+		if e.star {
+			return nil, fmt.Errorf("* is only allowed in the select list or COUNT(*)")
+		}
+		args := make([]LogicalExpr, len(e.args))
+		for i, arg := range e.args {
+			expr, err := scope.expr(arg)
+			if err != nil {
+				return nil, err
+			}
+			args[i] = expr
+		}
+		if _, ok := stringFunctions[strings.ToLower(e.name)]; ok {
+			call, err := StringFunc(e.name, args...)
+			if err != nil {
+				return nil, err
+			}
+			if err := call.check(scope.plan); err != nil {
+				return nil, err
+			}
+			return call, nil
+		}
+		call, err := scope.ec.Call(e.name, args...)
+		if err != nil {
+			return nil, err
+		}
+		if err := call.check(scope.plan); err != nil {
+			return nil, err
+		}
+		return call, nil
+	case sqlStar:
+		return nil, fmt.Errorf("* is only allowed in the select list or COUNT(*)")
+	default:
+		return nil, fmt.Errorf("unsupported expression %s", e)
+	}
+}
+
+func numberLiteral(text string) (LogicalExpr, error) {
+	if !strings.ContainsAny(text, ".eE") {
+		if n, err := strconv.ParseInt(text, 10, 64); err == nil {
+			return Int(n), nil
+		}
+	}
+	f, err := strconv.ParseFloat(text, 64)
+	if err != nil {
+		return nil, fmt.Errorf("invalid number %s", text)
+	}
+	return Flt(f), nil
+}
+
+func (scope *sqlScope) containsAggregate(e sqlExpr) bool {
+	return len(scope.collectAggregates(e, nil)) > 0
+}
+
+// collectAggregates appends the aggregate calls in an expression that are
+// not already in calls. Calls nested in another aggregate's argument are
+// left for the planner to reject.
+func (scope *sqlScope) collectAggregates(e sqlExpr, calls []sqlFunction) []sqlFunction {
+	switch e := e.(type) {
+	case sqlFunction:
+		if !scope.isAggregate(e.name) {
+			for _, arg := range e.args {
+				calls = scope.collectAggregates(arg, calls)
+			}
+			return calls
+		}
+		for _, call := range calls {
+			if call.String() == e.String() {
+				return calls
+			}
+		}
+		return append(calls, e)
+	case sqlBinary:
+		return scope.collectAggregates(e.r, scope.collectAggregates(e.l, calls))
+	case sqlUnary:
+		return scope.collectAggregates(e.expr, calls)
+	case sqlParen:
+		return scope.collectAggregates(e.expr, calls)
+	}
+	return calls
+}
@@ -0,0 +1,134 @@
+package engine
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func sqlContext(t *testing.T) *ExecutionContext {
+	ec := &ExecutionContext{BatchSize: 3, TargetPartitions: 2}
+	_, err := ec.Sql(context.Background(), "CREATE EXTERNAL TABLE employees STORED AS CSV WITH HEADER ROW LOCATION 'testdata/employees.csv'")
+	require.NoError(t, err)
+	return ec
+}
+
+func sqlRows(t *testing.T, ec *ExecutionContext, sql string) [][]any {
+	df, err := ec.Sql(context.Background(), sql)
+	require.NoError(t, err)
+	batches, err := df.Collect(context.Background())
+	require.NoError(t, err)
+	rows := [][]any{}
+	for _, batch := range batches {
+		for i := 0; i < batch.RowCount(); i++ {
+			row := make([]any, len(batch.Fields))
+			for j, f := range batch.Fields {
+				row[j] = f.GetValue(i)
+			}
+			rows = append(rows, row)
+		}
+	}
+	return rows
+}
+
+func TestSqlSelect(t *testing.T) {
+	ec := sqlContext(t)
+	assert.Equal(t, [][]any{
+		{int64(7), "Linus", int64(13000)},
+		{int64(3), "John", int64(11500)},
+	}, sqlRows(t, ec, `SELECT id, first_name, e.salary FROM employees e
+		WHERE job_title = 'Software Engineer' AND id <> 4
+		ORDER BY salary DESC, 1`))
+
+	assert.Equal(t, [][]any{
+		{int64(2), 15000.0},
+		{int64(3), 17250.0},
+	}, sqlRows(t, ec, "SELECT id, salary * 1.5 AS raised FROM employees ORDER BY -id DESC LIMIT 2 OFFSET 1"))
+
+	assert.Equal(t, [][]any{{int64(7), "x"}}, sqlRows(t, ec, "SELECT 3 + 4, 'x'"))
+	assert.Equal(t, [][]any{{int64(8)}}, sqlRows(t, ec, "SELECT n FROM (SELECT id AS n FROM employees) t WHERE t.n > 7"))
+}
+
+func TestSqlAggregate(t *testing.T) {
+	ec := sqlContext(t)
+	assert.Equal(t, [][]any{
+		{"Software Engineer", int64(3), int64(36000), 12000.0},
+		{"Manager", int64(2), int64(26000), 13000.0},
+		{"Driver", int64(2), int64(18000), 9000.0},
+	}, sqlRows(t, ec, `SELECT job_title, COUNT(*), SUM(salary) total, AVG(salary)
+		FROM employees GROUP BY job_title HAVING COUNT(*) > 1 ORDER BY SUM(salary) DESC`))
+
+	assert.Equal(t, [][]any{{int64(8), int64(8000), int64(14000)}},
+		sqlRows(t, ec, "SELECT COUNT(id), MIN(salary), MAX(salary) FROM employees"))
+
+	assert.Equal(t, [][]any{{int64(1), int64(4)}, {int64(0), int64(4)}},
+		sqlRows(t, ec, "SELECT id % 2 AS odd, COUNT(*) n FROM employees GROUP BY odd ORDER BY 1 DESC"))
+
+	assert.Equal(t, [][]any{{"CO"}, {"OH"}},
+		sqlRows(t, ec, "SELECT DISTINCT state FROM employees WHERE salary < 11000 AND salary > 9000 OR state = 'CO' ORDER BY state"))
+}
+
+func TestSqlCatalog(t *testing.T) {
+	ec := sqlContext(t)
+	assert.Equal(t, [][]any{{"employees"}}, sqlRows(t, ec, "SHOW TABLES"))
+	assert.Equal(t, [][]any{
+		{"id", "int64", "YES"},
+		{"first_name", "utf8", "YES"},
+	}, sqlRows(t, ec, "DESCRIBE employees")[:2])
+
+	_, err := ec.Sql(context.Background(), "CREATE EXTERNAL TABLE IF NOT EXISTS employees STORED AS csv LOCATION 'missing.csv'")
+	assert.NoError(t, err)
+	_, err = ec.Sql(context.Background(), "CREATE EXTERNAL TABLE other STORED AS csv LOCATION 'missing.csv'")
+	assert.Error(t, err)
+}
+
+func TestSqlCopy(t *testing.T) {
+	ec := sqlContext(t)
+	dir := t.TempDir()
+	for _, path := range []string{"out.csv.gz", "out.json", "out.parquet", "out.arrow"} {
+		path = filepath.Join(dir, path)
+		_, err := ec.Sql(context.Background(), "COPY (SELECT id, state FROM employees WHERE id < 4) TO '"+path+"'")
+		require.NoError(t, err)
+		require.NoError(t, ec.RegisterFile("copied", path, ""))
+		assert.Equal(t, [][]any{{int64(1), "CA"}, {int64(2), "CO"}, {int64(3), "CO"}},
+			sqlRows(t, ec, "SELECT id, state FROM copied ORDER BY id"), path)
+	}
+
+	path := filepath.Join(dir, "by_state")
+	_, err := ec.Sql(context.Background(), "COPY employees TO '"+path+"' STORED AS csv PARTITIONED BY (state)")
+	require.NoError(t, err)
+	require.NoError(t, ec.RegisterFile("by_state", path, "csv"))
+	assert.Len(t, sqlRows(t, ec, "SELECT id FROM by_state"), 8)
+
+	_, err = ec.Sql(context.Background(), "COPY employees TO '"+filepath.Join(dir, "x.json")+"' OPTIONS (delimiter '|')")
+	assert.ErrorContains(t, err, "unknown option 'delimiter' for json files")
+}
+
+func TestSqlErrors(t *testing.T) {
+	ec := sqlContext(t)
+	for sql, message := range map[string]string{
+		"SELECT nope FROM employees":                       "no column named 'nope'",
+		"SELECT x.id FROM employees":                       "unknown table 'x'",
+		"SELECT id FROM missing":                           "table 'missing' not found",
+		"SELECT state, COUNT(*) FROM employees":            "column 'state' must appear in GROUP BY",
+		"SELECT * FROM employees GROUP BY state":           "SELECT * cannot be used",
+		"SELECT id FROM employees WHERE SUM(id) > 1":       "not allowed in WHERE",
+		"SELECT SUM(COUNT(id)) FROM employees":             "cannot be nested",
+		"SELECT first_name + 1 FROM employees":             "+ needs numeric operands",
+		"SELECT id FROM employees WHERE id = 'a'":          "cannot compare int64 with utf8",
+		"SELECT id FROM employees WHERE id":                "WHERE must be a boolean expression",
+		"SELECT initcap(state) FROM employees":             "unknown function INITCAP",
+		"SELECT DISTINCT state FROM employees ORDER BY id": "must appear in the select list",
+		"SELECT id FROM employees ORDER BY 3":              "ORDER BY position 3",
+		"SELECT id FROM employees WHERE":                   "expected expression",
+		"SELECT id FROM employees LIMIT 'a'":               "expected non-negative integer",
+		"SELECT 'unterminated":                             "unterminated quote",
+		"DROP TABLE employees":                             "expected SELECT, CREATE",
+	} {
+		_, err := ec.Sql(context.Background(), sql)
+		assert.ErrorContains(t, err, message, sql)
+	}
+}
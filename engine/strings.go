@@ -0,0 +1,644 @@
+package engine
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/apache/arrow/go/v12/arrow"
+	"github.com/briansterle/drogo"
+)
+
+// stringFunction is a built-in string function. It takes between minArgs
+// and len(argTypes) arguments, or any number from minArgs if it is variadic,
+// in which case the last type applies to the rest. A nil type accepts a
+// value of any type, which is formatted as a string.
+type stringFunction struct {
+	argTypes   []arrow.DataType
+	minArgs    int
+	variadic   bool
+	returnType arrow.DataType
+	// nullable functions are passed null arguments; the others return null
+	// when any argument is null
+	nullable bool
+	fn       func(args []any) any
+	// regexp functions take a pattern as their second argument and, if
+	// flags is not zero, optional flags at that position. They are called
+	// with the compiled pattern instead of fn.
+	regexp func(re *regexp.Regexp, args []any) any
+	flags  int
+}
+
+var (
+	stringArg = []arrow.DataType{drogo.String}
+	anyArgs   = []arrow.DataType{nil}
+)
+
+var stringFunctions = map[string]stringFunction{
+	"upper": {argTypes: stringArg, minArgs: 1, returnType: drogo.String, fn: func(args []any) any {
+		return strings.ToUpper(args[0].(string))
+	}},
+	"lower": {argTypes: stringArg, minArgs: 1, returnType: drogo.String, fn: func(args []any) any {
+		return strings.ToLower(args[0].(string))
+	}},
+	// length counts characters rather than bytes
+	"length": {argTypes: stringArg, minArgs: 1, returnType: drogo.Int64, fn: func(args []any) any {
+		return int64(utf8.RuneCountInString(args[0].(string)))
+	}},
+	"reverse": {argTypes: stringArg, minArgs: 1, returnType: drogo.String, fn: func(args []any) any {
+		runes := []rune(args[0].(string))
+		for i, j := 0, len(runes)-1; i < j; i, j = i+1, j-1 {
+			runes[i], runes[j] = runes[j], runes[i]
+		}
+		return string(runes)
+	}},
+	"substring": {argTypes: []arrow.DataType{drogo.String, drogo.Int64, drogo.Int64}, minArgs: 2, returnType: drogo.String, fn: substring},
+	"substr":    {argTypes: []arrow.DataType{drogo.String, drogo.Int64, drogo.Int64}, minArgs: 2, returnType: drogo.String, fn: substring},
+	"trim":      {argTypes: []arrow.DataType{drogo.String, drogo.String}, minArgs: 1, returnType: drogo.String, fn: trim(strings.Trim)},
+	"btrim":     {argTypes: []arrow.DataType{drogo.String, drogo.String}, minArgs: 1, returnType: drogo.String, fn: trim(strings.Trim)},
+	"ltrim":     {argTypes: []arrow.DataType{drogo.String, drogo.String}, minArgs: 1, returnType: drogo.String, fn: trim(strings.TrimLeft)},
+	"rtrim":     {argTypes: []arrow.DataType{drogo.String, drogo.String}, minArgs: 1, returnType: drogo.String, fn: trim(strings.TrimRight)},
+	// concat skips null arguments, while the || operator is null when either
+	// operand is
+	"concat": {argTypes: anyArgs, minArgs: 1, variadic: true, returnType: drogo.String, nullable: true, fn: func(args []any) any {
+		var sb strings.Builder
+		for _, arg := range args {
+			if arg != nil {
+				sb.WriteString(arg.(string))
+			}
+		}
+		return sb.String()
+	}},
+	"||": {argTypes: []arrow.DataType{nil, nil}, minArgs: 2, returnType: drogo.String, fn: func(args []any) any {
+		return args[0].(string) + args[1].(string)
+	}},
+	"replace": {argTypes: []arrow.DataType{drogo.String, drogo.String, drogo.String}, minArgs: 3, returnType: drogo.String, fn: func(args []any) any {
+		if args[1] == "" {
+			return args[0]
+		}
+		return strings.ReplaceAll(args[0].(string), args[1].(string), args[2].(string))
+	}},
+	"split_part": {argTypes: []arrow.DataType{drogo.String, drogo.String, drogo.Int64}, minArgs: 3, returnType: drogo.String, fn: splitPart},
+	"starts_with": {argTypes: []arrow.DataType{drogo.String, drogo.String}, minArgs: 2, returnType: drogo.Boolean, fn: func(args []any) any {
+		return strings.HasPrefix(args[0].(string), args[1].(string))
+	}},
+	"ends_with": {argTypes: []arrow.DataType{drogo.String, drogo.String}, minArgs: 2, returnType: drogo.Boolean, fn: func(args []any) any {
+		return strings.HasSuffix(args[0].(string), args[1].(string))
+	}},
+	"lpad": {argTypes: []arrow.DataType{drogo.String, drogo.Int64, drogo.String}, minArgs: 2, returnType: drogo.String, fn: pad(true)},
+	"rpad": {argTypes: []arrow.DataType{drogo.String, drogo.Int64, drogo.String}, minArgs: 2, returnType: drogo.String, fn: pad(false)},
+	// position(substring IN string) and strpos(string, substring) return the
+	// 1-based character position of the first occurrence, or 0
+	"position": {argTypes: []arrow.DataType{drogo.String, drogo.String}, minArgs: 2, returnType: drogo.Int64, fn: func(args []any) any {
+		return strpos(args[1].(string), args[0].(string))
+	}},
+	"strpos": {argTypes: []arrow.DataType{drogo.String, drogo.String}, minArgs: 2, returnType: drogo.Int64, fn: func(args []any) any {
+		return strpos(args[0].(string), args[1].(string))
+	}},
+	"regexp_like":    {argTypes: []arrow.DataType{drogo.String, drogo.String, drogo.String}, minArgs: 2, returnType: drogo.Boolean, regexp: regexpLike, flags: 2},
+	"regexp_match":   {argTypes: []arrow.DataType{drogo.String, drogo.String, drogo.String}, minArgs: 2, returnType: arrow.ListOf(drogo.String), regexp: regexpMatch, flags: 2},
+	"regexp_replace": {argTypes: []arrow.DataType{drogo.String, drogo.String, drogo.String, drogo.String}, minArgs: 3, returnType: drogo.String, regexp: regexpReplace, flags: 3},
+	"regexp_extract": {argTypes: []arrow.DataType{drogo.String, drogo.String, drogo.Int64}, minArgs: 2, returnType: drogo.String, regexp: regexpExtract},
+}
+
+// substring returns length characters, or the rest of the string, from the
+// 1-based start. A start before the first character shortens the result as
+// if the string were padded on the left.
+func substring(args []any) any {
+	runes := []rune(args[0].(string))
+	start, end := args[1].(int64), int64(len(runes))+1
+	if len(args) > 2 {
+		length := args[2].(int64)
+		if length < 0 {
+			return ""
+		}
+		if start+length < end {
+			end = start + length
+		}
+	}
+	if start < 1 {
+		start = 1
+	}
+	if start >= end {
+		return ""
+	}
+	return string(runes[start-1 : end-1])
+}
+
+// trim removes the characters in the second argument, or spaces, from the
+// string
+func trim(cut func(s, cutset string) string) func(args []any) any {
+	return func(args []any) any {
+		cutset := " "
+		if len(args) > 1 {
+			cutset = args[1].(string)
+		}
+		return cut(args[0].(string), cutset)
+	}
+}
+
+// splitPart returns the nth field of a string split on a delimiter,
+// counting from the end when n is negative. Fields past the end are empty,
+// and field 0 is null.
+func splitPart(args []any) any {
+	s, delim, n := args[0].(string), args[1].(string), args[2].(int64)
+	parts := []string{s}
+	if delim != "" {
+		parts = strings.Split(s, delim)
+	}
+	switch {
+	case n == 0:
+		return nil
+	case n > int64(len(parts)) || -n > int64(len(parts)):
+		return ""
+	case n > 0:
+		return parts[n-1]
+	default:
+		return parts[int64(len(parts))+n]
+	}
+}
+
+// pad extends a string to a length in characters with repetitions of the
+// third argument, or spaces, or truncates it to that length
+func pad(left bool) func(args []any) any {
+	return func(args []any) any {
+		runes, n := []rune(args[0].(string)), args[1].(int64)
+		if n < 0 {
+			n = 0
+		}
+		if int64(len(runes)) >= n {
+			return string(runes[:n])
+		}
+		fill := []rune(" ")
+		if len(args) > 2 {
+			fill = []rune(args[2].(string))
+		}
+		if len(fill) == 0 {
+			return string(runes)
+		}
+		padding := make([]rune, n-int64(len(runes)))
+		for i := range padding {
+			padding[i] = fill[i%len(fill)]
+		}
+		if left {
+			return string(padding) + string(runes)
+		}
+		return string(runes) + string(padding)
+	}
+}
+
+func strpos(s, substring string) int64 {
+	i := strings.Index(s, substring)
+	if i < 0 {
+		return 0
+	}
+	return int64(utf8.RuneCountInString(s[:i])) + 1
+}
+
+func (f stringFunction) argType(i int) arrow.DataType {
+	if i >= len(f.argTypes) {
+		return f.argTypes[len(f.argTypes)-1]
+	}
+	return f.argTypes[i]
+}
+
+func (f stringFunction) arity() string {
+	switch {
+	case f.variadic:
+		return fmt.Sprintf("at least %d", f.minArgs)
+	case f.minArgs == len(f.argTypes):
+		return fmt.Sprint(f.minArgs)
+	default:
+		return fmt.Sprintf("%d to %d", f.minArgs, len(f.argTypes))
+	}
+}
+
+func (f stringFunction) accepts(n int) bool {
+	return n >= f.minArgs && (f.variadic || n <= len(f.argTypes))
+}
+
+// evaluate calls the function on each row, converting the arguments to the
+// types it declares. A regexp function uses re if it is set and otherwise
+// compiles each distinct pattern once, returning null for invalid ones.
+func (f stringFunction) evaluate(args []ColumnVector, rows int, re *regexp.Regexp) ColumnVector {
+	values := make([]any, rows)
+	row := make([]any, len(args))
+	compiled := map[[2]string]*regexp.Regexp{}
+	for i := range values {
+		null := false
+		for j, arg := range args {
+			row[j] = f.convert(j, arg.GetValue(i))
+			null = null || row[j] == nil
+		}
+		switch {
+		case null && !f.nullable:
+		case f.regexp == nil:
+			values[i] = f.fn(row)
+		case re != nil:
+			values[i] = f.regexp(re, row)
+		default:
+			key := [2]string{row[1].(string), f.flagsOf(row)}
+			m, ok := compiled[key]
+			if !ok {
+				m, _ = compileRegexp(key[0], key[1])
+				compiled[key] = m
+			}
+			if m != nil {
+				values[i] = f.regexp(m, row)
+			}
+		}
+	}
+	return drogo.New(f.returnType, rows, values)
+}
+
+// flagsOf returns the flags among the arguments of a regexp function
+func (f stringFunction) flagsOf(args []any) string {
+	if f.flags == 0 || len(args) <= f.flags {
+		return ""
+	}
+	return args[f.flags].(string)
+}
+
+func (f stringFunction) convert(i int, v any) any {
+	if v == nil {
+		return nil
+	}
+	switch dtype := f.argType(i); {
+	case dtype == nil:
+		if s, ok := v.(string); ok {
+			return s
+		}
+		return fmt.Sprint(v)
+	case isNumericType(dtype):
+		return castNumeric(v, dtype)
+	default:
+		return v
+	}
+}
+
+// StringFunction calls a built-in string function
+type StringFunction struct {
+	Name string
+	Args []LogicalExpr
+}
+
+// StringFunc returns a call to the built-in string function with a name,
+// which is case insensitive. The types of the arguments are checked when
+// the query is planned.
+func StringFunc(name string, args ...LogicalExpr) (StringFunction, error) {
+	f, ok := stringFunctions[strings.ToLower(name)]
+	if !ok {
+		return StringFunction{}, fmt.Errorf("unknown function %s", name)
+	}
+	if !f.accepts(len(args)) {
+		return StringFunction{}, fmt.Errorf("function %s takes %s arguments, got %d", strings.ToLower(name), f.arity(), len(args))
+	}
+	return StringFunction{strings.ToLower(name), args}, nil
+}
+
+func Upper(e LogicalExpr) StringFunction {
+	return StringFunction{"upper", []LogicalExpr{e}}
+}
+
+func Lower(e LogicalExpr) StringFunction {
+	return StringFunction{"lower", []LogicalExpr{e}}
+}
+
+func Length(e LogicalExpr) StringFunction {
+	return StringFunction{"length", []LogicalExpr{e}}
+}
+
+func Trim(e LogicalExpr) StringFunction {
+	return StringFunction{"trim", []LogicalExpr{e}}
+}
+
+func Reverse(e LogicalExpr) StringFunction {
+	return StringFunction{"reverse", []LogicalExpr{e}}
+}
+
+func Substring(e, start, length LogicalExpr) StringFunction {
+	return StringFunction{"substring", []LogicalExpr{e, start, length}}
+}
+
+func Replace(e, from, to LogicalExpr) StringFunction {
+	return StringFunction{"replace", []LogicalExpr{e, from, to}}
+}
+
+func StartsWith(e, prefix LogicalExpr) StringFunction {
+	return StringFunction{"starts_with", []LogicalExpr{e, prefix}}
+}
+
+func EndsWith(e, suffix LogicalExpr) StringFunction {
+	return StringFunction{"ends_with", []LogicalExpr{e, suffix}}
+}
+
+func Concat(args ...LogicalExpr) StringFunction {
+	return StringFunction{"concat", args}
+}
+
+func (f StringFunction) ToField(input LogicalPlan) arrow.Field {
+	return arrow.Field{Name: f.Name, Type: stringFunctions[f.Name].returnType, Nullable: true}
+}
+
+func (f StringFunction) String() string {
+	args := make([]string, len(f.Args))
+	for i, arg := range f.Args {
+		args[i] = arg.String()
+	}
+	if f.Name == "||" {
+		return strings.Join(args, " || ")
+	}
+	return f.Name + "(" + strings.Join(args, ", ") + ")"
+}
+
+// check verifies that the function exists and that its arguments have the
+// types it declares. Numbers are accepted for integer parameters.
+func (f StringFunction) check(input LogicalPlan) error {
+	fn, ok := stringFunctions[f.Name]
+	if !ok {
+		return fmt.Errorf("unknown function %s", f.Name)
+	}
+	if !fn.accepts(len(f.Args)) {
+		return fmt.Errorf("function %s takes %s arguments, got %d", f.Name, fn.arity(), len(f.Args))
+	}
+	for i, arg := range f.Args {
+		want, got := fn.argType(i), arg.ToField(input).Type
+		if want != nil && !arrow.TypeEqual(want, got) && !(isNumericType(want) && isNumericType(got)) {
+			return fmt.Errorf("function %s expects argument %d to be %s, got %s: %s", f.Name, i+1, want, got, f)
+		}
+	}
+	if fn.regexp != nil {
+		if pattern, flags, ok := literalPattern(fn, f.Args); ok {
+			if _, err := compileRegexp(pattern, flags); err != nil {
+				return fmt.Errorf("function %s: %w", f.Name, err)
+			}
+		}
+	}
+	return nil
+}
+
+// literalPattern returns the pattern and flags of a regexp function call if
+// they are both literals
+func literalPattern(fn stringFunction, args []LogicalExpr) (string, string, bool) {
+	pattern, ok := args[1].(LiteralString)
+	if !ok {
+		return "", "", false
+	}
+	if fn.flags == 0 || len(args) <= fn.flags {
+		return pattern.Str, "", true
+	}
+	flags, ok := args[fn.flags].(LiteralString)
+	return pattern.Str, flags.Str, ok
+}
+
+// StringFunctionExpression evaluates a built-in string function over a
+// batch. When the first argument is dictionary-encoded and the others are
+// literals, the function is applied to each distinct value only.
+type StringFunctionExpression struct {
+	name string
+	args []Expression
+	// re is the pattern of a regexp function, compiled when the expression
+	// is created if the pattern and flags are literals
+	re *regexp.Regexp
+}
+
+func newStringFunctionExpression(name string, args []Expression) (StringFunctionExpression, error) {
+	e := StringFunctionExpression{name: name, args: args}
+	f := stringFunctions[name]
+	if f.regexp == nil {
+		return e, nil
+	}
+	pattern, ok := args[1].(LiteralStringExpression)
+	if !ok {
+		return e, nil
+	}
+	var flags LiteralStringExpression
+	if f.flags > 0 && len(args) > f.flags {
+		if flags, ok = args[f.flags].(LiteralStringExpression); !ok {
+			return e, nil
+		}
+	}
+	var err error
+	if e.re, err = compileRegexp(pattern.value, flags.value); err != nil {
+		return StringFunctionExpression{}, fmt.Errorf("function %s: %w", name, err)
+	}
+	return e, nil
+}
+
+func (e StringFunctionExpression) Evaluate(input RecordBatch) ColumnVector {
+	f := stringFunctions[e.name]
+	args := make([]ColumnVector, len(e.args))
+	for i, arg := range e.args {
+		args[i] = arg.Evaluate(input)
+	}
+	if d, ok := args[0].(DictionaryVector); ok && !f.nullable && d.Len() > 0 && isLiteral(e.args[1:]...) {
+		return d.mapValues(func(values ColumnVector) ColumnVector {
+			distinct := []ColumnVector{values}
+			for _, arg := range args[1:] {
+				distinct = append(distinct, LiteralValueVector{arg.DataType(), arg.GetValue(0), values.Len()})
+			}
+			return f.evaluate(distinct, values.Len(), e.re)
+		})
+	}
+	return f.evaluate(args, input.RowCount(), e.re)
+}
+
+func (e StringFunctionExpression) String() string {
+	args := make([]string, len(e.args))
+	for i, arg := range e.args {
+		args[i] = arg.String()
+	}
+	if e.name == "||" {
+		return strings.Join(args, " || ")
+	}
+	return e.name + "(" + strings.Join(args, ", ") + ")"
+}
+
+// isLiteral reports whether expressions evaluate to the same value for
+// every row
+func isLiteral(exprs ...Expression) bool {
+	for _, e := range exprs {
+		switch e.(type) {
+		case LiteralStringExpression, LiteralInt64Expression, LiteralFloat64Expression:
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// LikeExpr matches a string against a SQL pattern, in which % matches any
+// sequence of characters and _ any single character. A backslash makes the
+// character after it match itself. SIMILAR TO patterns may also use the
+// regular expression operators | * + ? {m,n}, parentheses and brackets.
+type LikeExpr struct {
+	Expr            LogicalExpr
+	Pattern         LogicalExpr
+	Negated         bool
+	CaseInsensitive bool
+	Similar         bool
+}
+
+func Like(e, pattern LogicalExpr) LikeExpr {
+	return LikeExpr{Expr: e, Pattern: pattern}
+}
+
+func ILike(e, pattern LogicalExpr) LikeExpr {
+	return LikeExpr{Expr: e, Pattern: pattern, CaseInsensitive: true}
+}
+
+func SimilarTo(e, pattern LogicalExpr) LikeExpr {
+	return LikeExpr{Expr: e, Pattern: pattern, Similar: true}
+}
+
+func (e LikeExpr) op() string {
+	op := "LIKE"
+	switch {
+	case e.Similar:
+		op = "SIMILAR TO"
+	case e.CaseInsensitive:
+		op = "ILIKE"
+	}
+	if e.Negated {
+		op = "NOT " + op
+	}
+	return op
+}
+
+func (e LikeExpr) ToField(input LogicalPlan) arrow.Field {
+	return arrow.Field{Name: "like", Type: arrow.FixedWidthTypes.Boolean}
+}
+
+func (e LikeExpr) String() string {
+	return e.Expr.String() + " " + e.op() + " " + e.Pattern.String()
+}
+
+func (e LikeExpr) check(input LogicalPlan) error {
+	if e.Expr.ToField(input).Type.ID() != arrow.STRING || e.Pattern.ToField(input).Type.ID() != arrow.STRING {
+		return fmt.Errorf("%s needs string operands: %s", e.op(), e)
+	}
+	if lit, ok := e.Pattern.(LiteralString); ok {
+		if _, err := compileLike(lit.Str, e.Similar, e.CaseInsensitive); err != nil {
+			return fmt.Errorf("%s: %w", e.op(), err)
+		}
+	}
+	return nil
+}
+
+// compileLike translates a LIKE or SIMILAR TO pattern to an anchored regular
+// expression. Only SIMILAR TO patterns can be invalid.
+func compileLike(pattern string, similar, caseInsensitive bool) (*regexp.Regexp, error) {
+	var sb strings.Builder
+	sb.WriteString("(?s")
+	if caseInsensitive {
+		sb.WriteString("i")
+	}
+	sb.WriteString(")^(?:")
+	escaped, bracket := false, false
+	for _, c := range pattern {
+		switch {
+		case escaped:
+			sb.WriteString(regexp.QuoteMeta(string(c)))
+			escaped = false
+		case bracket:
+			// bracket expressions are passed through up to the closing ]
+			sb.WriteRune(c)
+			bracket = c != ']'
+		case c == '\\':
+			escaped = true
+		case c == '%':
+			sb.WriteString(".*")
+		case c == '_':
+			sb.WriteString(".")
+		case similar && strings.ContainsRune("|*+?{}()", c):
+			sb.WriteRune(c)
+		case similar && c == '[':
+			sb.WriteRune(c)
+			bracket = true
+		default:
+			sb.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	if escaped {
+		sb.WriteString(`\\`)
+	}
+	sb.WriteString(")$")
+	re, err := regexp.Compile(sb.String())
+	if err != nil {
+		return nil, fmt.Errorf("invalid pattern '%s'", pattern)
+	}
+	return re, nil
+}
+
+// LikeExpression evaluates LIKE, ILIKE and SIMILAR TO. A literal pattern is
+// compiled once, when the expression is created, and applied to each
+// distinct value of a dictionary-encoded column. Invalid patterns in other
+// rows make the result null.
+type LikeExpression struct {
+	expr            Expression
+	pattern         Expression
+	negated         bool
+	caseInsensitive bool
+	similar         bool
+	matcher         *regexp.Regexp
+}
+
+func newLikeExpression(expr, pattern Expression, negated, caseInsensitive, similar bool) (LikeExpression, error) {
+	e := LikeExpression{expr: expr, pattern: pattern, negated: negated, caseInsensitive: caseInsensitive, similar: similar}
+	if lit, ok := pattern.(LiteralStringExpression); ok {
+		var err error
+		if e.matcher, err = compileLike(lit.value, similar, caseInsensitive); err != nil {
+			return LikeExpression{}, fmt.Errorf("%s: %w", e.op(), err)
+		}
+	}
+	return e, nil
+}
+
+func (e LikeExpression) op() string {
+	return LikeExpr{Negated: e.negated, CaseInsensitive: e.caseInsensitive, Similar: e.similar}.op()
+}
+
+func (e LikeExpression) Evaluate(input RecordBatch) ColumnVector {
+	v := e.expr.Evaluate(input)
+	if d, ok := v.(DictionaryVector); ok && e.matcher != nil {
+		return d.mapValues(func(values ColumnVector) ColumnVector {
+			return e.match(values, nil)
+		})
+	}
+	return e.match(v, e.pattern.Evaluate(input))
+}
+
+// match matches each value against the literal pattern or, without one,
+// against the pattern in the same row
+func (e LikeExpression) match(v, patterns ColumnVector) ColumnVector {
+	compiled := map[string]*regexp.Regexp{}
+	values := make([]any, v.Len())
+	for i := range values {
+		s := v.GetValue(i)
+		if s == nil {
+			continue
+		}
+		matcher := e.matcher
+		if matcher == nil {
+			p := patterns.GetValue(i)
+			if p == nil {
+				continue
+			}
+			var ok bool
+			if matcher, ok = compiled[p.(string)]; !ok {
+				matcher, _ = compileLike(p.(string), e.similar, e.caseInsensitive)
+				compiled[p.(string)] = matcher
+			}
+			if matcher == nil {
+				continue
+			}
+		}
+		values[i] = matcher.MatchString(s.(string)) != e.negated
+	}
+	return drogo.New(drogo.Boolean, len(values), values)
+}
+
+func (e LikeExpression) String() string {
+	return e.expr.String() + " " + e.op() + " " + e.pattern.String()
+}
@@ -0,0 +1,242 @@
+package engine
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/apache/arrow/go/v12/arrow"
+	"github.com/apache/arrow/go/v12/arrow/array"
+	"github.com/apache/arrow/go/v12/arrow/ipc"
+	"github.com/apache/arrow/go/v12/arrow/memory"
+	"github.com/briansterle/drogo"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStringFunctions(t *testing.T) {
+	for _, test := range []struct {
+		name string
+		args []any
+		want any
+	}{
+		{"upper", []any{"café"}, "CAFÉ"},
+		{"lower", []any{"ÀB"}, "àb"},
+		{"length", []any{"héllo"}, int64(5)},
+		{"reverse", []any{"añb"}, "bña"},
+		{"substring", []any{"héllo", int64(2), int64(3)}, "éll"},
+		{"substring", []any{"hello", int64(3)}, "llo"},
+		{"substr", []any{"hello", int64(0), int64(3)}, "he"},
+		{"substring", []any{"hello", int64(-5), int64(3)}, ""},
+		{"substring", []any{"hello", int64(9)}, ""},
+		{"substring", []any{"hello", int64(2), int64(-1)}, ""},
+		{"trim", []any{"  a b  "}, "a b"},
+		{"btrim", []any{"xxaxyx", "xy"}, "a"},
+		{"ltrim", []any{"  a  "}, "a  "},
+		{"rtrim", []any{"  a  "}, "  a"},
+		{"concat", []any{"a", nil, int64(1), 2.5}, "a12.5"},
+		{"||", []any{"a", int64(1)}, "a1"},
+		{"||", []any{"a", nil}, nil},
+		{"replace", []any{"banana", "an", "o"}, "booa"},
+		{"replace", []any{"banana", "", "o"}, "banana"},
+		{"split_part", []any{"a,b,,c", ",", int64(2)}, "b"},
+		{"split_part", []any{"a,b,,c", ",", int64(3)}, ""},
+		{"split_part", []any{"a,b,,c", ",", int64(-1)}, "c"},
+		{"split_part", []any{"a,b,,c", ",", int64(5)}, ""},
+		{"split_part", []any{"a,b", ",", int64(0)}, nil},
+		{"split_part", []any{"a,b", "", int64(1)}, "a,b"},
+		{"starts_with", []any{"hello", "he"}, true},
+		{"ends_with", []any{"hello", "he"}, false},
+		{"lpad", []any{"7", int64(3), "0"}, "007"},
+		{"lpad", []any{"hi", int64(5), "xy"}, "xyxhi"},
+		{"lpad", []any{"hello", int64(2)}, "he"},
+		{"rpad", []any{"hi", int64(4)}, "hi  "},
+		{"rpad", []any{"hi", int64(4), ""}, "hi"},
+		{"position", []any{"l", "héllo"}, int64(3)},
+		{"strpos", []any{"héllo", "z"}, int64(0)},
+		{"upper", []any{nil}, nil},
+	} {
+		f := stringFunctions[test.name]
+		args := make([]ColumnVector, len(test.args))
+		for i, arg := range test.args {
+			dtype := arrow.DataType(drogo.String)
+			switch arg.(type) {
+			case int64:
+				dtype = drogo.Int64
+			case float64:
+				dtype = drogo.Float64
+			}
+			args[i] = LiteralValueVector{dtype, arg, 1}
+		}
+		assert.Equal(t, test.want, f.evaluate(args, 1, nil).GetValue(0), "%s%v", test.name, test.args)
+	}
+}
+
+func TestStringFunctionsSql(t *testing.T) {
+	ec := sqlContext(t)
+	rows := sqlRows(t, ec, `SELECT upper(first_name), LOWER(last_name), length(job_title), substring(last_name, 2, 3),
+		concat(first_name, ' ', last_name), state || '-' || id, replace(job_title, 'Software ', ''),
+		split_part(job_title, ' ', 2), lpad(first_name, 6, '*'), position('o' IN last_name), reverse(first_name)
+		FROM employees WHERE starts_with(job_title, 'Soft') ORDER BY id`)
+	assert.Equal(t, [][]any{
+		{"JOHN", "travis", int64(17), "rav", "John Travis", "CO-3", "Engineer", "Engineer", "**John", int64(0), "nhoJ"},
+		{"VON", "mill", int64(17), "ill", "Von Mill", "-4", "Engineer", "Engineer", "***Von", int64(0), "noV"},
+		{"LINUS", "torvalds", int64(17), "orv", "Linus Torvalds", "OR-7", "Engineer", "Engineer", "*Linus", int64(2), "suniL"},
+	}, rows)
+
+	rows = sqlRows(t, ec, "SELECT id FROM employees WHERE last_name LIKE '%o%' AND first_name NOT LIKE 'G%' ORDER BY id")
+	assert.Equal(t, [][]any{{int64(1)}, {int64(5)}, {int64(7)}, {int64(8)}}, rows)
+	rows = sqlRows(t, ec, "SELECT id FROM employees WHERE job_title ILIKE '%engineer' OR first_name LIKE '_da' ORDER BY id")
+	assert.Equal(t, [][]any{{int64(3)}, {int64(4)}, {int64(5)}, {int64(7)}}, rows)
+	rows = sqlRows(t, ec, "SELECT upper(state), COUNT(*) FROM employees WHERE state NOT ILIKE 'c_' GROUP BY upper(state) ORDER BY 1")
+	assert.Equal(t, [][]any{{"", int64(1)}, {"OH", int64(2)}, {"OR", int64(1)}}, rows)
+
+	for _, test := range []struct{ sql, err string }{
+		{"SELECT upper(id) FROM employees", "function upper expects argument 1 to be utf8, got int64: upper(#id)"},
+		{"SELECT substring(first_name) FROM employees", "function substring takes 2 to 3 arguments, got 1"},
+		{"SELECT concat() FROM employees", "function concat takes at least 1 arguments, got 0"},
+		{"SELECT id FROM employees WHERE id LIKE '1%'", "LIKE needs string operands: #id LIKE '1%'"},
+		{"SELECT id FROM employees WHERE first_name NOT ILIKE salary", "NOT ILIKE needs string operands: #first_name NOT ILIKE #salary"},
+	} {
+		_, err := ec.Sql(context.Background(), test.sql)
+		assert.EqualError(t, err, test.err, test.sql)
+	}
+}
+
+func TestLike(t *testing.T) {
+	for _, test := range []struct {
+		pattern         string
+		caseInsensitive bool
+		matches         []string
+		misses          []string
+	}{
+		{"a%", false, []string{"a", "abc", "a\nb"}, []string{"", "ba", "Abc"}},
+		{"%b_", false, []string{"abc", "bb"}, []string{"ab", "abcd"}},
+		{`100\%`, false, []string{"100%"}, []string{"1000", "100"}},
+		{`a\_b.c`, false, []string{"a_b.c"}, []string{"axb.c", "a_bxc"}},
+		{"[a]*", false, []string{"[a]*"}, []string{"a"}},
+		{`x\`, false, []string{`x\`}, []string{"x"}},
+		{"HeLLo%", true, []string{"hello world", "HELLO"}, []string{"hell"}},
+	} {
+		re, err := compileLike(test.pattern, false, test.caseInsensitive)
+		require.NoError(t, err)
+		for _, s := range test.matches {
+			assert.True(t, re.MatchString(s), "%s LIKE %s", s, test.pattern)
+		}
+		for _, s := range test.misses {
+			assert.False(t, re.MatchString(s), "%s LIKE %s", s, test.pattern)
+		}
+	}
+
+	// patterns that are not literals are compiled for each distinct value
+	schema := Schema{arrow.NewSchema([]arrow.Field{{Name: "s", Type: drogo.String}, {Name: "p", Type: drogo.String}}, nil)}
+	batch := RecordBatch{schema, []ColumnVector{
+		drogo.New(drogo.String, 4, []any{"abc", "abc", nil, "xyz"}),
+		drogo.New(drogo.String, 4, []any{"a%", "%d", "a%", nil}),
+	}}
+	e, err := newLikeExpression(ColumnExpression{0}, ColumnExpression{1}, true, false, false)
+	require.NoError(t, err)
+	assert.Nil(t, e.matcher)
+	result := e.Evaluate(batch)
+	assert.Equal(t, []any{false, true, nil, nil}, []any{result.GetValue(0), result.GetValue(1), result.GetValue(2), result.GetValue(3)})
+}
+
+func TestStringFunctionDataFrame(t *testing.T) {
+	ec := &ExecutionContext{}
+	f, err := StringFunc("Split_Part", Col("job_title"), Str(" "), Int(1))
+	require.NoError(t, err)
+	df := ec.Csv("testdata/employees.csv").
+		Filter(Like(Lower(Col("job_title")), Str("%e%"))).
+		Project([]LogicalExpr{Alias{f, "word"}, Length(Trim(Col("last_name"))), Substring(Col("first_name"), Int(1), Int(1))})
+	assert.Equal(t, drogo.Int64, df.Schema().Field(1).Type)
+	assert.Contains(t, Format(NewOptimizer().Optimize(df.LogicalPlan()), 0), "projection=[first_name last_name job_title]")
+	stream, err := ec.Execute(context.Background(), df)
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []string{"[Manager 7 B]", "[Driver 8 G]", "[Software 6 J]", "[Software 4 V]", "[Software 8 L]", "[Manager 6 G]", "[Driver 8 K]"},
+		collectRows(t, stream))
+
+	_, err = StringFunc("upper")
+	assert.EqualError(t, err, "function upper takes 1 arguments, got 0")
+	_, err = StringFunc("soundex", Col("x"))
+	assert.EqualError(t, err, "unknown function soundex")
+	_, err = ec.Execute(context.Background(), ec.Csv("testdata/employees.csv").Project([]LogicalExpr{StartsWith(Col("id"), Str("1"))}))
+	assert.EqualError(t, err, "function starts_with expects argument 1 to be utf8, got int64: starts_with(#id, '1')")
+}
+
+func TestDictionaryStrings(t *testing.T) {
+	dict := DictionaryVector{[]int32{0, 1, -1, 1, 0}, drogo.New(drogo.String, 2, []any{" ab ", "Cd"})}
+	schema := Schema{arrow.NewSchema([]arrow.Field{{Name: "s", Type: drogo.String}}, nil)}
+	batch := RecordBatch{schema, []ColumnVector{dict}}
+	values := func(v ColumnVector) []any {
+		out := make([]any, v.Len())
+		for i := range out {
+			out[i] = v.GetValue(i)
+		}
+		return out
+	}
+
+	// string results stay dictionary-encoded
+	upper := StringFunctionExpression{name: "upper", args: []Expression{StringFunctionExpression{name: "trim", args: []Expression{ColumnExpression{0}}}}}
+	result := upper.Evaluate(batch)
+	require.IsType(t, DictionaryVector{}, result)
+	assert.Equal(t, 2, result.(DictionaryVector).Values.Len())
+	assert.Equal(t, []any{"AB", "CD", nil, "CD", "AB"}, values(result))
+
+	result = StringFunctionExpression{name: "lpad", args: []Expression{ColumnExpression{0}, LiteralInt64Expression{5}, LiteralStringExpression{"-"}}}.Evaluate(batch)
+	assert.Equal(t, []any{"- ab ", "---Cd", nil, "---Cd", "- ab "}, values(result))
+	result = StringFunctionExpression{name: "length", args: []Expression{ColumnExpression{0}}}.Evaluate(batch)
+	assert.Equal(t, []any{int64(4), int64(2), nil, int64(2), int64(4)}, values(result))
+	// concat is not null for null rows, so it is evaluated row by row
+	result = StringFunctionExpression{name: "concat", args: []Expression{ColumnExpression{0}, LiteralStringExpression{"!"}}}.Evaluate(batch)
+	assert.IsType(t, drogo.Array{}, result)
+	assert.Equal(t, []any{" ab !", "Cd!", "!", "Cd!", " ab !"}, values(result))
+
+	like, err := newLikeExpression(ColumnExpression{0}, LiteralStringExpression{"c%"}, false, true, false)
+	require.NoError(t, err)
+	result = like.Evaluate(batch)
+	assert.Equal(t, []any{false, true, nil, true, false}, values(result))
+}
+
+// writeDictionaryArrow writes an Arrow IPC file with a dictionary-encoded
+// string column
+func writeDictionaryArrow(t *testing.T, keys []int, dictionary []string) string {
+	dictType := &arrow.DictionaryType{IndexType: arrow.PrimitiveTypes.Int32, ValueType: arrow.BinaryTypes.String}
+	schema := arrow.NewSchema([]arrow.Field{{Name: "city", Type: dictType, Nullable: true}}, nil)
+	b := array.NewDictionaryBuilder(memory.DefaultAllocator, dictType).(*array.BinaryDictionaryBuilder)
+	defer b.Release()
+	for _, k := range keys {
+		if k < 0 {
+			b.AppendNull()
+		} else {
+			require.NoError(t, b.AppendString(dictionary[k]))
+		}
+	}
+	column := b.NewArray()
+	defer column.Release()
+	record := array.NewRecord(schema, []arrow.Array{column}, int64(len(keys)))
+	defer record.Release()
+
+	path := filepath.Join(t.TempDir(), "cities.arrow")
+	f, err := os.Create(path)
+	require.NoError(t, err)
+	defer f.Close()
+	w, err := ipc.NewFileWriter(f, ipc.WithSchema(schema))
+	require.NoError(t, err)
+	require.NoError(t, w.Write(record))
+	require.NoError(t, w.Close())
+	return path
+}
+
+func TestDictionaryArrowFile(t *testing.T) {
+	ec := &ExecutionContext{}
+	df := ec.Arrow(writeDictionaryArrow(t, []int{0, 1, 0, -1, 2, 1, 0}, []string{"Denver", "Boulder", "Dallas"}))
+	assert.Equal(t, drogo.String, df.Schema().Field(0).Type)
+	stream, err := ec.Execute(context.Background(), df.Filter(Like(Col("city"), Str("D%"))).Project([]LogicalExpr{Upper(Col("city"))}))
+	require.NoError(t, err)
+	assert.Equal(t, []string{"[DENVER]", "[DENVER]", "[DALLAS]", "[DENVER]"}, collectRows(t, stream))
+
+	ec.RegisterDataSource("cities", df.LogicalPlan().(Scan).Source)
+	rows := sqlRows(t, ec, "SELECT lower(city), COUNT(*) FROM cities GROUP BY lower(city) ORDER BY 2 DESC, 1")
+	assert.Equal(t, [][]any{{"denver", int64(3)}, {"boulder", int64(2)}, {"dallas", int64(1)}, {nil, int64(1)}}, rows)
+}
@@ -0,0 +1,429 @@
+package engine
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"reflect"
+
+	"github.com/apache/arrow/go/v12/arrow"
+	"github.com/briansterle/drogo"
+)
+
+// structTag is the struct tag that names the column a field maps to. A tag
+// of "-" leaves the field out; without a tag the field's name is used.
+const structTag = "drogo"
+
+// structField is an exported field of a Go struct and the column it maps to
+type structField struct {
+	index int
+	name  string
+	field arrow.Field
+}
+
+// taggedFields lists the exported fields of a struct type with the names of
+// the columns they map to
+func taggedFields(t reflect.Type) []structField {
+	fields := []structField{}
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		name := f.Name
+		if tag, ok := f.Tag.Lookup(structTag); ok {
+			if tag == "-" {
+				continue
+			}
+			if tag != "" {
+				name = tag
+			}
+		}
+		if f.IsExported() {
+			fields = append(fields, structField{index: i, name: name})
+		}
+	}
+	return fields
+}
+
+// structFields maps the exported fields of a struct type to columns
+func structFields(t reflect.Type) ([]structField, error) {
+	fields := taggedFields(t)
+	for i, f := range fields {
+		dtype, nullable, err := arrowTypeOf(t.Field(f.index).Type)
+		if err != nil {
+			return nil, fmt.Errorf("field %s: %w", t.Field(f.index).Name, err)
+		}
+		fields[i].field = arrow.Field{Name: f.name, Type: dtype, Nullable: nullable}
+	}
+	return fields, nil
+}
+
+// arrowTypeOf returns the column type for values of a Go type, and whether
+// they can be null. Pointers and slices are nullable, structs become struct
+// columns and slices list columns.
+func arrowTypeOf(t reflect.Type) (arrow.DataType, bool, error) {
+	switch t.Kind() {
+	case reflect.Pointer:
+		dtype, _, err := arrowTypeOf(t.Elem())
+		return dtype, true, err
+	case reflect.Bool:
+		return drogo.Boolean, false, nil
+	case reflect.Int8:
+		return drogo.Int8, false, nil
+	case reflect.Int16:
+		return drogo.Int16, false, nil
+	case reflect.Int32:
+		return drogo.Int32, false, nil
+	case reflect.Int, reflect.Int64:
+		return drogo.Int64, false, nil
+	case reflect.Float32:
+		return drogo.Float32, false, nil
+	case reflect.Float64:
+		return drogo.Float64, false, nil
+	case reflect.String:
+		return drogo.String, false, nil
+	case reflect.Slice:
+		elem, nullable, err := arrowTypeOf(t.Elem())
+		if err != nil {
+			return nil, false, err
+		}
+		return arrow.ListOfField(arrow.Field{Name: "item", Type: elem, Nullable: nullable}), true, nil
+	case reflect.Struct:
+		fields, err := structFields(t)
+		if err != nil {
+			return nil, false, err
+		}
+		arrowFields := make([]arrow.Field, len(fields))
+		for i, f := range fields {
+			arrowFields[i] = f.field
+		}
+		return arrow.StructOf(arrowFields...), false, nil
+	default:
+		return nil, false, fmt.Errorf("unsupported type %s", t)
+	}
+}
+
+// goValue converts a Go value to the value drogo.New expects for the column
+// type chosen by arrowTypeOf
+func goValue(v reflect.Value) any {
+	switch v.Kind() {
+	case reflect.Pointer:
+		if v.IsNil() {
+			return nil
+		}
+		return goValue(v.Elem())
+	case reflect.Bool:
+		return v.Bool()
+	case reflect.Int8:
+		return int8(v.Int())
+	case reflect.Int16:
+		return int16(v.Int())
+	case reflect.Int32:
+		return int32(v.Int())
+	case reflect.Int, reflect.Int64:
+		return v.Int()
+	case reflect.Float32:
+		return float32(v.Float())
+	case reflect.Float64:
+		return v.Float()
+	case reflect.String:
+		return v.String()
+	case reflect.Slice:
+		if v.IsNil() {
+			return nil
+		}
+		values := make([]any, v.Len())
+		for i := range values {
+			values[i] = goValue(v.Index(i))
+		}
+		return values
+	case reflect.Struct:
+		// structFields cannot fail here since the type was already mapped
+		fields, _ := structFields(v.Type())
+		value := make(map[string]any, len(fields))
+		for _, f := range fields {
+			value[f.name] = goValue(v.Field(f.index))
+		}
+		return value
+	default:
+		panic(fmt.Sprintf("unsupported type %s", v.Type()))
+	}
+}
+
+// FromStructs returns a DataFrame over a slice of structs or struct
+// pointers. Each exported field becomes a column named by its drogo struct
+// tag or, without one, by the field's name. Pointer and slice fields are
+// nullable, nested structs become struct columns and slices list columns.
+func FromStructs[T any](ec *ExecutionContext, rows []T) (DataFrame, error) {
+	t := reflect.TypeOf((*T)(nil)).Elem()
+	isPointer := t.Kind() == reflect.Pointer
+	if isPointer {
+		t = t.Elem()
+	}
+	if t.Kind() != reflect.Struct {
+		return nil, fmt.Errorf("FromStructs needs a struct type, not %s", t)
+	}
+	fields, err := structFields(t)
+	if err != nil {
+		return nil, err
+	}
+	if len(fields) == 0 {
+		return nil, fmt.Errorf("%s has no exported fields", t)
+	}
+	arrowFields := make([]arrow.Field, len(fields))
+	for i, f := range fields {
+		arrowFields[i] = f.field
+	}
+	schema := Schema{arrow.NewSchema(arrowFields, nil)}
+
+	batchSize := ec.BatchSize
+	if batchSize <= 0 {
+		batchSize = defaultBatchSize
+	}
+	batches := []RecordBatch{}
+	for start := 0; start < len(rows); start += batchSize {
+		end := start + batchSize
+		if end > len(rows) {
+			end = len(rows)
+		}
+		columns := make([][]any, len(fields))
+		for _, row := range rows[start:end] {
+			v := reflect.ValueOf(row)
+			if isPointer {
+				if v.IsNil() {
+					return nil, errors.New("FromStructs cannot convert a nil row")
+				}
+				v = v.Elem()
+			}
+			for i, f := range fields {
+				columns[i] = append(columns[i], goValue(v.Field(f.index)))
+			}
+		}
+		vectors := make([]ColumnVector, len(fields))
+		for i, values := range columns {
+			vectors[i] = drogo.New(arrowFields[i].Type, len(values), values)
+		}
+		batches = append(batches, RecordBatch{schema, vectors})
+	}
+	table, err := NewMemTable(schema, batches)
+	if err != nil {
+		return nil, err
+	}
+	return ec.Scan(t.String(), table.WithPartitions(ec.targetPartitions())), nil
+}
+
+// checkAssignable reports why values of a column cannot be stored in a Go
+// value of type t. Integers and floats may be stored in Go types at least as
+// wide as the column's, and any column may be stored in an interface.
+func checkAssignable(t reflect.Type, dtype arrow.DataType) error {
+	mismatch := fmt.Errorf("cannot store %s in %s", dtype, t)
+	switch t.Kind() {
+	case reflect.Pointer:
+		return checkAssignable(t.Elem(), dtype)
+	case reflect.Interface:
+		if t.NumMethod() == 0 {
+			return nil
+		}
+	case reflect.Bool:
+		if dtype.ID() == arrow.BOOL {
+			return nil
+		}
+	case reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64, reflect.Int:
+		if arrow.IsSignedInteger(dtype.ID()) && dtype.(arrow.FixedWidthDataType).BitWidth() <= t.Bits() {
+			return nil
+		}
+	case reflect.Float32, reflect.Float64:
+		if arrow.IsFloating(dtype.ID()) && dtype.(arrow.FixedWidthDataType).BitWidth() <= t.Bits() {
+			return nil
+		}
+	case reflect.String:
+		if dtype.ID() == arrow.STRING {
+			return nil
+		}
+	case reflect.Slice:
+		if list, ok := dtype.(*arrow.ListType); ok {
+			return checkAssignable(t.Elem(), list.Elem())
+		}
+	case reflect.Struct:
+		if st, ok := dtype.(*arrow.StructType); ok {
+			_, _, err := structColumns(t, st.Fields())
+			return err
+		}
+	}
+	return mismatch
+}
+
+// structColumns matches the fields of a struct type to columns by name and
+// checks their types. It returns the index of each field's column.
+func structColumns(t reflect.Type, columns []arrow.Field) ([]structField, []int, error) {
+	fields := taggedFields(t)
+	indices := make([]int, len(fields))
+	for i, f := range fields {
+		indices[i] = -1
+		for j, c := range columns {
+			if c.Name == f.name {
+				indices[i] = j
+			}
+		}
+		name := t.Field(f.index).Name
+		if indices[i] < 0 {
+			return nil, nil, fmt.Errorf("no column named '%s' for field %s", f.name, name)
+		}
+		if err := checkAssignable(t.Field(f.index).Type, columns[indices[i]].Type); err != nil {
+			return nil, nil, fmt.Errorf("field %s: %w", name, err)
+		}
+	}
+	return fields, indices, nil
+}
+
+// setValue stores a value read from a column in dst, whose type has been
+// checked by checkAssignable. Null can only be stored in pointers, slices
+// and interfaces.
+func setValue(dst reflect.Value, v any) error {
+	if v == nil {
+		switch dst.Kind() {
+		case reflect.Pointer, reflect.Slice, reflect.Interface:
+			dst.Set(reflect.Zero(dst.Type()))
+			return nil
+		default:
+			return fmt.Errorf("cannot store null in %s, use a pointer", dst.Type())
+		}
+	}
+	switch dst.Kind() {
+	case reflect.Pointer:
+		elem := reflect.New(dst.Type().Elem())
+		if err := setValue(elem.Elem(), v); err != nil {
+			return err
+		}
+		dst.Set(elem)
+	case reflect.Interface:
+		dst.Set(reflect.ValueOf(v))
+	case reflect.Bool:
+		dst.SetBool(v.(bool))
+	case reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64, reflect.Int:
+		n, _ := toInt64(v)
+		dst.SetInt(n)
+	case reflect.Float32, reflect.Float64:
+		f, _ := toFloat64(v)
+		dst.SetFloat(f)
+	case reflect.String:
+		dst.SetString(v.(string))
+	case reflect.Slice:
+		values := v.([]any)
+		out := reflect.MakeSlice(dst.Type(), len(values), len(values))
+		for i, e := range values {
+			if err := setValue(out.Index(i), e); err != nil {
+				return err
+			}
+		}
+		dst.Set(out)
+	case reflect.Struct:
+		value := v.(map[string]any)
+		for _, f := range taggedFields(dst.Type()) {
+			if err := setValue(dst.Field(f.index), value[f.name]); err != nil {
+				return fmt.Errorf("%s: %w", dst.Type().Field(f.index).Name, err)
+			}
+		}
+	default:
+		return fmt.Errorf("unsupported type %s", dst.Type())
+	}
+	return nil
+}
+
+// RowIterator reads the results of a query one row at a time into structs.
+// Columns are matched to fields as in FromStructs.
+type RowIterator[T any] struct {
+	stream  RecordBatchStream
+	fields  []structField
+	columns []int
+	batch   RecordBatch
+	row     int
+	value   T
+	err     error
+}
+
+// Iterate executes the DataFrame and returns an iterator over its rows. It
+// fails if a field of T has no matching column or cannot hold the column's
+// values. The caller must Close the iterator if it stops before the end.
+func Iterate[T any](ctx context.Context, df DataFrame) (*RowIterator[T], error) {
+	t := reflect.TypeOf((*T)(nil)).Elem()
+	if t.Kind() != reflect.Struct {
+		return nil, fmt.Errorf("cannot read rows into %s, which is not a struct", t)
+	}
+	fields, columns, err := structColumns(t, df.Schema().Fields())
+	if err != nil {
+		return nil, err
+	}
+	impl, ok := df.(*DataFrameImpl)
+	if !ok || impl.ec == nil {
+		return nil, errors.New("DataFrame was not created by an ExecutionContext")
+	}
+	stream, err := impl.ec.Execute(ctx, df)
+	if err != nil {
+		return nil, err
+	}
+	return &RowIterator[T]{stream: stream, fields: fields, columns: columns}, nil
+}
+
+// Next advances to the next row, returning false at the end of the results
+// or on error
+func (it *RowIterator[T]) Next() bool {
+	if it.err != nil || it.stream == nil {
+		return false
+	}
+	for len(it.batch.Fields) == 0 || it.row >= it.batch.RowCount() {
+		batch, err := it.stream.Next()
+		if err != nil {
+			if err != io.EOF {
+				it.err = err
+			}
+			it.Close()
+			return false
+		}
+		it.batch, it.row = batch, 0
+	}
+	var value T
+	v := reflect.ValueOf(&value).Elem()
+	for i, f := range it.fields {
+		if err := setValue(v.Field(f.index), it.batch.Field(it.columns[i]).GetValue(it.row)); err != nil {
+			it.err = fmt.Errorf("field %s: %w", v.Type().Field(f.index).Name, err)
+			it.Close()
+			return false
+		}
+	}
+	it.value = value
+	it.row++
+	return true
+}
+
+// Value is the current row
+func (it *RowIterator[T]) Value() T {
+	return it.value
+}
+
+// Err is the error that ended the iteration, if any
+func (it *RowIterator[T]) Err() error {
+	return it.err
+}
+
+func (it *RowIterator[T]) Close() error {
+	if it.stream == nil {
+		return nil
+	}
+	err := it.stream.Close()
+	it.stream = nil
+	return err
+}
+
+// CollectAs executes the DataFrame and reads all of its rows into structs
+func CollectAs[T any](ctx context.Context, df DataFrame) ([]T, error) {
+	it, err := Iterate[T](ctx, df)
+	if err != nil {
+		return nil, err
+	}
+	defer it.Close()
+	rows := []T{}
+	for it.Next() {
+		rows = append(rows, it.Value())
+	}
+	return rows, it.Err()
+}
@@ -0,0 +1,184 @@
+package engine
+
+import (
+	"context"
+	"testing"
+
+	"github.com/apache/arrow/go/v12/arrow"
+	"github.com/briansterle/drogo"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type address struct {
+	City string `drogo:"city"`
+	Zip  *int32 `drogo:"zip"`
+}
+
+type employee struct {
+	ID      int      `drogo:"id"`
+	Name    string   `drogo:"name"`
+	Salary  *float64 `drogo:"salary"`
+	Skills  []string `drogo:"skills"`
+	Address address  `drogo:"address"`
+	Manager bool
+	secret  string
+	Ignored string `drogo:"-"`
+}
+
+func ptr[T any](v T) *T {
+	return &v
+}
+
+func employees() []employee {
+	return []employee{
+		{ID: 1, Name: "ann", Salary: ptr(100.0), Skills: []string{"go"}, Address: address{"Austin", ptr(int32(78701))}, Manager: true},
+		{ID: 2, Name: "bob", Address: address{City: "Boston"}, secret: "x"},
+		{ID: 3, Name: "cat", Salary: ptr(50.0), Skills: []string{}, Address: address{"Austin", nil}},
+	}
+}
+
+func TestFromStructsSchema(t *testing.T) {
+	df, err := FromStructs(&ExecutionContext{}, employees())
+	require.NoError(t, err)
+	expected := arrow.NewSchema([]arrow.Field{
+		{Name: "id", Type: drogo.Int64},
+		{Name: "name", Type: drogo.String},
+		{Name: "salary", Type: drogo.Float64, Nullable: true},
+		{Name: "skills", Type: arrow.ListOfField(arrow.Field{Name: "item", Type: drogo.String}), Nullable: true},
+		{Name: "address", Type: arrow.StructOf(
+			arrow.Field{Name: "city", Type: drogo.String},
+			arrow.Field{Name: "zip", Type: drogo.Int32, Nullable: true},
+		)},
+		{Name: "Manager", Type: drogo.Boolean},
+	}, nil)
+	assert.True(t, expected.Equal(df.Schema().Schema), df.Schema().String())
+}
+
+func TestFromStructs(t *testing.T) {
+	ctx := &ExecutionContext{BatchSize: 2, TargetPartitions: 1}
+	df, err := FromStructs(ctx, employees())
+	require.NoError(t, err)
+
+	batches, err := df.Collect(context.Background())
+	require.NoError(t, err)
+	require.Len(t, batches, 2)
+	assert.Equal(t, []any{int64(1), int64(2), int64(3)}, column(batches, 0))
+	assert.Equal(t, []any{100.0, nil, 50.0}, column(batches, 2))
+	assert.Equal(t, []any{[]any{"go"}, nil, []any{}}, column(batches, 3))
+	assert.Equal(t, map[string]any{"city": "Austin", "zip": int32(78701)}, column(batches, 4)[0])
+
+	batches, err = df.Filter(Gt(Col("salary"), Flt(60))).
+		Project([]LogicalExpr{Col("name")}).
+		Collect(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, []any{"ann"}, column(batches, 0))
+
+	pointers, err := FromStructs(ctx, []*employee{{ID: 4}})
+	require.NoError(t, err)
+	batches, err = pointers.Collect(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, []any{int64(4)}, column(batches, 0))
+	_, err = FromStructs(ctx, []*employee{nil})
+	assert.Error(t, err)
+}
+
+func TestFromStructsUnsupported(t *testing.T) {
+	_, err := FromStructs(&ExecutionContext{}, []int{1})
+	assert.Error(t, err)
+	_, err = FromStructs(&ExecutionContext{}, []struct{ N uint }{{1}})
+	assert.ErrorContains(t, err, "unsupported type uint")
+}
+
+func TestMemTable(t *testing.T) {
+	schema := Schema{arrow.NewSchema([]arrow.Field{
+		{Name: "a", Type: drogo.Int64},
+		{Name: "b", Type: drogo.String},
+	}, nil)}
+	batch := func(a int64, b string) RecordBatch {
+		return RecordBatch{schema, []ColumnVector{
+			drogo.New(drogo.Int64, 1, []any{a}),
+			drogo.New(drogo.String, 1, []any{b}),
+		}}
+	}
+	table, err := NewMemTable(schema, []RecordBatch{batch(1, "x"), batch(2, "y"), batch(3, "z")})
+	require.NoError(t, err)
+	table.WithPartitions(2)
+	assert.Equal(t, 2, table.Partitions())
+
+	batches, err := ReadAll(table.Scan(context.Background(), []string{"b"}, 0, 1))
+	require.NoError(t, err)
+	assert.Equal(t, []any{"y"}, column(batches, 0))
+	batches, err = ReadAll(table.Scan(context.Background(), nil, 1, 0))
+	require.NoError(t, err)
+	assert.Equal(t, []any{int64(1)}, column(batches, 0))
+
+	other := Schema{arrow.NewSchema([]arrow.Field{{Name: "a", Type: drogo.Int64}}, nil)}
+	_, err = NewMemTable(other, []RecordBatch{batch(1, "x")})
+	assert.Error(t, err)
+}
+
+func TestCollectAs(t *testing.T) {
+	ctx := &ExecutionContext{BatchSize: 2, TargetPartitions: 1}
+	df, err := FromStructs(ctx, employees())
+	require.NoError(t, err)
+
+	rows, err := CollectAs[employee](context.Background(), df)
+	require.NoError(t, err)
+	expected := employees()
+	expected[1].secret = ""
+	assert.Equal(t, expected, rows)
+
+	type summary struct {
+		Name   string
+		Salary *float64 `drogo:"salary"`
+		Any    any      `drogo:"id"`
+	}
+	names, err := CollectAs[summary](context.Background(), df.Project([]LogicalExpr{
+		Col("id"), Col("salary"), Alias{Col("name"), "Name"}}))
+	require.NoError(t, err)
+	assert.Equal(t, []summary{{"ann", ptr(100.0), int64(1)}, {"bob", nil, int64(2)}, {"cat", ptr(50.0), int64(3)}}, names)
+}
+
+func TestCollectAsErrors(t *testing.T) {
+	ctx := &ExecutionContext{}
+	df, err := FromStructs(ctx, employees())
+	require.NoError(t, err)
+
+	_, err = CollectAs[struct{ Missing string }](context.Background(), df)
+	assert.ErrorContains(t, err, "no column named 'Missing'")
+	_, err = CollectAs[struct {
+		ID int32 `drogo:"id"`
+	}](context.Background(), df)
+	assert.ErrorContains(t, err, "cannot store int64 in int32")
+	_, err = CollectAs[struct {
+		Salary float64 `drogo:"salary"`
+	}](context.Background(), df)
+	assert.ErrorContains(t, err, "use a pointer")
+	_, err = CollectAs[int](context.Background(), df)
+	assert.Error(t, err)
+}
+
+func TestIterate(t *testing.T) {
+	ctx := &ExecutionContext{BatchSize: 10, TargetPartitions: 1}
+	df := ctx.Csv(writeEventsCsv(t, 100, 5))
+
+	type event struct {
+		User   string `drogo:"user"`
+		Amount int64  `drogo:"amount"`
+	}
+	it, err := Iterate[event](context.Background(), df)
+	require.NoError(t, err)
+	count := 0
+	for it.Next() {
+		assert.Equal(t, int64(count), it.Value().Amount)
+		count++
+		if count == 25 {
+			break
+		}
+	}
+	require.NoError(t, it.Err())
+	require.NoError(t, it.Close())
+	assert.Equal(t, 25, count)
+	assert.False(t, it.Next())
+}
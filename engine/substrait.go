@@ -0,0 +1,1201 @@
+package engine
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/apache/arrow/go/v12/arrow"
+)
+
+// Substrait plans are read and written in the protobuf JSON encoding of the
+// Substrait specification. The types below model the parts of it drogo can
+// translate: read, project, filter, aggregate, sort and fetch relations,
+// field references, literals and the functions in substraitFunctions.
+
+const (
+	substraitComparison = "https://github.com/substrait-io/substrait/blob/main/extensions/functions_comparison.yaml"
+	substraitBoolean    = "https://github.com/substrait-io/substrait/blob/main/extensions/functions_boolean.yaml"
+	substraitArithmetic = "https://github.com/substrait-io/substrait/blob/main/extensions/functions_arithmetic.yaml"
+	substraitGeneric    = "https://github.com/substrait-io/substrait/blob/main/extensions/functions_aggregate_generic.yaml"
+)
+
+// substraitFunction maps a drogo binary expression or aggregate, by its
+// name, to a Substrait function
+type substraitFunction struct {
+	drogo string
+	uri   string
+	name  string
+	// build creates the binary expression; it is nil for aggregates
+	build func(l, r LogicalExpr) LogicalExpr
+}
+
+var substraitFunctions = []substraitFunction{
+	{"eq", substraitComparison, "equal", func(l, r LogicalExpr) LogicalExpr { return Eq(l, r) }},
+	{"neq", substraitComparison, "not_equal", func(l, r LogicalExpr) LogicalExpr { return Neq(l, r) }},
+	{"lt", substraitComparison, "lt", func(l, r LogicalExpr) LogicalExpr { return Lt(l, r) }},
+	{"lteq", substraitComparison, "lte", func(l, r LogicalExpr) LogicalExpr { return LtEq(l, r) }},
+	{"gt", substraitComparison, "gt", func(l, r LogicalExpr) LogicalExpr { return Gt(l, r) }},
+	{"gteq", substraitComparison, "gte", func(l, r LogicalExpr) LogicalExpr { return GtEq(l, r) }},
+	{"and", substraitBoolean, "and", func(l, r LogicalExpr) LogicalExpr { return And(l, r) }},
+	{"or", substraitBoolean, "or", func(l, r LogicalExpr) LogicalExpr { return Or(l, r) }},
+	{"add", substraitArithmetic, "add", func(l, r LogicalExpr) LogicalExpr { return Add(l, r) }},
+	{"subtract", substraitArithmetic, "subtract", func(l, r LogicalExpr) LogicalExpr { return Subtract(l, r) }},
+	{"multiply", substraitArithmetic, "multiply", func(l, r LogicalExpr) LogicalExpr { return Multiply(l, r) }},
+	{"divide", substraitArithmetic, "divide", func(l, r LogicalExpr) LogicalExpr { return Divide(l, r) }},
+	{"modulus", substraitArithmetic, "modulus", func(l, r LogicalExpr) LogicalExpr { return Modulus(l, r) }},
+	{"SUM", substraitArithmetic, "sum", nil},
+	{"MIN", substraitArithmetic, "min", nil},
+	{"MAX", substraitArithmetic, "max", nil},
+	{"AVG", substraitArithmetic, "avg", nil},
+	{"COUNT", substraitGeneric, "count", nil},
+}
+
+func substraitFunctionOf(drogoName string) (substraitFunction, bool) {
+	for _, f := range substraitFunctions {
+		if f.drogo == drogoName {
+			return f, true
+		}
+	}
+	return substraitFunction{}, false
+}
+
+// substraitFunctionNamed finds a function by its Substrait name, which may
+// be followed by its signature, as in add:i64_i64
+func substraitFunctionNamed(name string) (substraitFunction, bool) {
+	name, _, _ = strings.Cut(name, ":")
+	for _, f := range substraitFunctions {
+		if f.name == name {
+			return f, true
+		}
+	}
+	return substraitFunction{}, false
+}
+
+type substraitPlan struct {
+	Version       *substraitVersion       `json:"version,omitempty"`
+	ExtensionUris []substraitExtensionUri `json:"extensionUris,omitempty"`
+	Extensions    []substraitExtension    `json:"extensions,omitempty"`
+	Relations     []substraitPlanRel      `json:"relations"`
+}
+
+type substraitVersion struct {
+	MajorNumber int    `json:"majorNumber,omitempty"`
+	MinorNumber int    `json:"minorNumber,omitempty"`
+	Producer    string `json:"producer,omitempty"`
+}
+
+type substraitExtensionUri struct {
+	ExtensionUriAnchor uint32 `json:"extensionUriAnchor"`
+	Uri                string `json:"uri"`
+}
+
+type substraitExtension struct {
+	ExtensionFunction *substraitExtensionFunction `json:"extensionFunction,omitempty"`
+}
+
+type substraitExtensionFunction struct {
+	ExtensionUriReference uint32 `json:"extensionUriReference"`
+	FunctionAnchor        uint32 `json:"functionAnchor"`
+	Name                  string `json:"name"`
+}
+
+type substraitPlanRel struct {
+	Root *substraitRelRoot `json:"root,omitempty"`
+	Rel  *substraitRel     `json:"rel,omitempty"`
+}
+
+type substraitRelRoot struct {
+	Input *substraitRel `json:"input"`
+	Names []string      `json:"names"`
+}
+
+// substraitRel holds one of the relation types. Relations drogo cannot
+// translate, such as joins, are kept as raw JSON so they can be named in
+// errors.
+type substraitRel struct {
+	Read      *substraitReadRel      `json:"read,omitempty"`
+	Project   *substraitProjectRel   `json:"project,omitempty"`
+	Filter    *substraitFilterRel    `json:"filter,omitempty"`
+	Aggregate *substraitAggregateRel `json:"aggregate,omitempty"`
+	Sort      *substraitSortRel      `json:"sort,omitempty"`
+	Fetch     *substraitFetchRel     `json:"fetch,omitempty"`
+	Join      json.RawMessage        `json:"join,omitempty"`
+	Cross     json.RawMessage        `json:"cross,omitempty"`
+	Set       json.RawMessage        `json:"set,omitempty"`
+}
+
+type substraitRelCommon struct {
+	Direct *struct{}      `json:"direct,omitempty"`
+	Emit   *substraitEmit `json:"emit,omitempty"`
+}
+
+type substraitEmit struct {
+	OutputMapping []int `json:"outputMapping"`
+}
+
+type substraitReadRel struct {
+	Common       *substraitRelCommon      `json:"common,omitempty"`
+	BaseSchema   substraitNamedStruct     `json:"baseSchema"`
+	Filter       *substraitExpr           `json:"filter,omitempty"`
+	Projection   *substraitMaskExpression `json:"projection,omitempty"`
+	NamedTable   *substraitNamedTable     `json:"namedTable,omitempty"`
+	LocalFiles   *substraitLocalFiles     `json:"localFiles,omitempty"`
+	VirtualTable *substraitVirtualTable   `json:"virtualTable,omitempty"`
+}
+
+type substraitNamedTable struct {
+	Names []string `json:"names"`
+}
+
+type substraitLocalFiles struct {
+	Items []substraitFileItem `json:"items"`
+}
+
+type substraitFileItem struct {
+	UriPath     string    `json:"uriPath,omitempty"`
+	UriPathGlob string    `json:"uriPathGlob,omitempty"`
+	UriFile     string    `json:"uriFile,omitempty"`
+	UriFolder   string    `json:"uriFolder,omitempty"`
+	Parquet     *struct{} `json:"parquet,omitempty"`
+	Arrow       *struct{} `json:"arrow,omitempty"`
+}
+
+type substraitVirtualTable struct {
+	Values []substraitStructLiteral `json:"values"`
+}
+
+type substraitStructLiteral struct {
+	Fields []substraitLiteral `json:"fields"`
+}
+
+type substraitMaskExpression struct {
+	Select                 substraitStructSelect `json:"select"`
+	MaintainSingularStruct bool                  `json:"maintainSingularStruct,omitempty"`
+}
+
+type substraitStructSelect struct {
+	StructItems []substraitStructItem `json:"structItems"`
+}
+
+type substraitStructItem struct {
+	Field int `json:"field"`
+}
+
+type substraitProjectRel struct {
+	Common      *substraitRelCommon `json:"common,omitempty"`
+	Input       *substraitRel       `json:"input"`
+	Expressions []substraitExpr     `json:"expressions"`
+}
+
+type substraitFilterRel struct {
+	Common    *substraitRelCommon `json:"common,omitempty"`
+	Input     *substraitRel       `json:"input"`
+	Condition substraitExpr       `json:"condition"`
+}
+
+type substraitAggregateRel struct {
+	Common    *substraitRelCommon `json:"common,omitempty"`
+	Input     *substraitRel       `json:"input"`
+	Groupings []substraitGrouping `json:"groupings,omitempty"`
+	Measures  []substraitMeasure  `json:"measures,omitempty"`
+}
+
+type substraitGrouping struct {
+	GroupingExpressions []substraitExpr `json:"groupingExpressions"`
+}
+
+type substraitMeasure struct {
+	Measure substraitAggregateFunction `json:"measure"`
+	Filter  *substraitExpr             `json:"filter,omitempty"`
+}
+
+type substraitAggregateFunction struct {
+	FunctionReference uint32                      `json:"functionReference"`
+	Arguments         []substraitFunctionArgument `json:"arguments"`
+	OutputType        *substraitType              `json:"outputType,omitempty"`
+	Phase             string                      `json:"phase,omitempty"`
+	Invocation        string                      `json:"invocation,omitempty"`
+}
+
+type substraitSortRel struct {
+	Common *substraitRelCommon  `json:"common,omitempty"`
+	Input  *substraitRel        `json:"input"`
+	Sorts  []substraitSortField `json:"sorts"`
+}
+
+type substraitSortField struct {
+	Expr      substraitExpr `json:"expr"`
+	Direction string        `json:"direction"`
+}
+
+type substraitFetchRel struct {
+	Common *substraitRelCommon `json:"common,omitempty"`
+	Input  *substraitRel       `json:"input"`
+	Offset substraitInt64      `json:"offset,omitempty"`
+	// Count is the number of rows to keep, or -1 for all of them
+	Count substraitInt64 `json:"count"`
+}
+
+type substraitExpr struct {
+	Selection      *substraitFieldReference `json:"selection,omitempty"`
+	Literal        *substraitLiteral        `json:"literal,omitempty"`
+	ScalarFunction *substraitScalarFunction `json:"scalarFunction,omitempty"`
+	Cast           json.RawMessage          `json:"cast,omitempty"`
+	IfThen         json.RawMessage          `json:"ifThen,omitempty"`
+}
+
+type substraitFieldReference struct {
+	DirectReference substraitReferenceSegment `json:"directReference"`
+	RootReference   *struct{}                 `json:"rootReference,omitempty"`
+}
+
+type substraitReferenceSegment struct {
+	StructField *substraitStructField `json:"structField,omitempty"`
+}
+
+type substraitStructField struct {
+	Field int                        `json:"field"`
+	Child *substraitReferenceSegment `json:"child,omitempty"`
+}
+
+type substraitScalarFunction struct {
+	FunctionReference uint32                      `json:"functionReference"`
+	Arguments         []substraitFunctionArgument `json:"arguments"`
+	OutputType        *substraitType              `json:"outputType,omitempty"`
+}
+
+type substraitFunctionArgument struct {
+	Value *substraitExpr `json:"value,omitempty"`
+}
+
+type substraitLiteral struct {
+	Boolean  *bool           `json:"boolean,omitempty"`
+	I8       *int32          `json:"i8,omitempty"`
+	I16      *int32          `json:"i16,omitempty"`
+	I32      *int32          `json:"i32,omitempty"`
+	I64      *substraitInt64 `json:"i64,omitempty"`
+	Fp32     *float32        `json:"fp32,omitempty"`
+	Fp64     *float64        `json:"fp64,omitempty"`
+	String   *string         `json:"string,omitempty"`
+	Null     *substraitType  `json:"null,omitempty"`
+	Nullable bool            `json:"nullable,omitempty"`
+}
+
+// substraitInt64 is a 64-bit integer, which the protobuf JSON encoding writes
+// as a string. Numbers are accepted as well.
+type substraitInt64 int64
+
+func (n substraitInt64) MarshalJSON() ([]byte, error) {
+	return json.Marshal(strconv.FormatInt(int64(n), 10))
+}
+
+func (n *substraitInt64) UnmarshalJSON(data []byte) error {
+	s := strings.Trim(string(data), `"`)
+	v, err := strconv.ParseInt(s, 10, 64)
+	if err != nil {
+		return fmt.Errorf("invalid 64-bit integer %s", data)
+	}
+	*n = substraitInt64(v)
+	return nil
+}
+
+type substraitNamedStruct struct {
+	Names  []string            `json:"names"`
+	Struct substraitStructType `json:"struct"`
+}
+
+type substraitStructType struct {
+	Types       []substraitType `json:"types"`
+	Nullability string          `json:"nullability,omitempty"`
+}
+
+type substraitType struct {
+	Bool   *substraitTypeParams `json:"bool,omitempty"`
+	I8     *substraitTypeParams `json:"i8,omitempty"`
+	I16    *substraitTypeParams `json:"i16,omitempty"`
+	I32    *substraitTypeParams `json:"i32,omitempty"`
+	I64    *substraitTypeParams `json:"i64,omitempty"`
+	Fp32   *substraitTypeParams `json:"fp32,omitempty"`
+	Fp64   *substraitTypeParams `json:"fp64,omitempty"`
+	String *substraitTypeParams `json:"string,omitempty"`
+	Binary *substraitTypeParams `json:"binary,omitempty"`
+	Date   *substraitTypeParams `json:"date,omitempty"`
+	List   *substraitListType   `json:"list,omitempty"`
+	Struct *substraitStructType `json:"struct,omitempty"`
+}
+
+type substraitTypeParams struct {
+	Nullability string `json:"nullability,omitempty"`
+}
+
+type substraitListType struct {
+	Type        substraitType `json:"type"`
+	Nullability string        `json:"nullability,omitempty"`
+}
+
+func substraitNullability(nullable bool) string {
+	if nullable {
+		return "NULLABILITY_NULLABLE"
+	}
+	return "NULLABILITY_REQUIRED"
+}
+
+func toSubstraitType(dtype arrow.DataType, nullable bool) (substraitType, error) {
+	params := &substraitTypeParams{substraitNullability(nullable)}
+	switch dtype.ID() {
+	case arrow.BOOL:
+		return substraitType{Bool: params}, nil
+	case arrow.INT8:
+		return substraitType{I8: params}, nil
+	case arrow.INT16:
+		return substraitType{I16: params}, nil
+	case arrow.INT32:
+		return substraitType{I32: params}, nil
+	case arrow.INT64:
+		return substraitType{I64: params}, nil
+	case arrow.FLOAT32:
+		return substraitType{Fp32: params}, nil
+	case arrow.FLOAT64:
+		return substraitType{Fp64: params}, nil
+	case arrow.STRING, arrow.LARGE_STRING:
+		return substraitType{String: params}, nil
+	case arrow.BINARY, arrow.LARGE_BINARY:
+		return substraitType{Binary: params}, nil
+	case arrow.DATE32:
+		return substraitType{Date: params}, nil
+	case arrow.LIST:
+		elem := dtype.(*arrow.ListType).ElemField()
+		t, err := toSubstraitType(elem.Type, elem.Nullable)
+		return substraitType{List: &substraitListType{t, params.Nullability}}, err
+	case arrow.STRUCT:
+		s, err := toSubstraitStruct(dtype.(*arrow.StructType).Fields(), nullable)
+		return substraitType{Struct: &s}, err
+	}
+	return substraitType{}, fmt.Errorf("type %s has no Substrait equivalent", dtype)
+}
+
+func toSubstraitStruct(fields []arrow.Field, nullable bool) (substraitStructType, error) {
+	s := substraitStructType{Types: []substraitType{}, Nullability: substraitNullability(nullable)}
+	for _, f := range fields {
+		t, err := toSubstraitType(f.Type, f.Nullable)
+		if err != nil {
+			return s, err
+		}
+		s.Types = append(s.Types, t)
+	}
+	return s, nil
+}
+
+// fromSubstraitType returns the arrow type of a Substrait type and whether
+// it is nullable. names holds the names of struct fields, depth first, and
+// is advanced past those the type uses.
+func fromSubstraitType(t substraitType, names *[]string) (arrow.DataType, bool, error) {
+	nullable := func(p *substraitTypeParams) bool { return p.Nullability != "NULLABILITY_REQUIRED" }
+	switch {
+	case t.Bool != nil:
+		return arrow.FixedWidthTypes.Boolean, nullable(t.Bool), nil
+	case t.I8 != nil:
+		return arrow.PrimitiveTypes.Int8, nullable(t.I8), nil
+	case t.I16 != nil:
+		return arrow.PrimitiveTypes.Int16, nullable(t.I16), nil
+	case t.I32 != nil:
+		return arrow.PrimitiveTypes.Int32, nullable(t.I32), nil
+	case t.I64 != nil:
+		return arrow.PrimitiveTypes.Int64, nullable(t.I64), nil
+	case t.Fp32 != nil:
+		return arrow.PrimitiveTypes.Float32, nullable(t.Fp32), nil
+	case t.Fp64 != nil:
+		return arrow.PrimitiveTypes.Float64, nullable(t.Fp64), nil
+	case t.String != nil:
+		return arrow.BinaryTypes.String, nullable(t.String), nil
+	case t.Binary != nil:
+		return arrow.BinaryTypes.Binary, nullable(t.Binary), nil
+	case t.Date != nil:
+		return arrow.PrimitiveTypes.Date32, nullable(t.Date), nil
+	case t.List != nil:
+		elem, elemNullable, err := fromSubstraitType(t.List.Type, names)
+		if err != nil {
+			return nil, false, err
+		}
+		return arrow.ListOfField(arrow.Field{Name: "item", Type: elem, Nullable: elemNullable}),
+			t.List.Nullability != "NULLABILITY_REQUIRED", nil
+	case t.Struct != nil:
+		fields, err := fromSubstraitStruct(*t.Struct, names)
+		if err != nil {
+			return nil, false, err
+		}
+		return arrow.StructOf(fields...), t.Struct.Nullability != "NULLABILITY_REQUIRED", nil
+	}
+	return nil, false, fmt.Errorf("unsupported Substrait type")
+}
+
+func fromSubstraitStruct(s substraitStructType, names *[]string) ([]arrow.Field, error) {
+	fields := make([]arrow.Field, len(s.Types))
+	for i, t := range s.Types {
+		if len(*names) == 0 {
+			return nil, fmt.Errorf("Substrait schema has fewer names than fields")
+		}
+		fields[i].Name = (*names)[0]
+		*names = (*names)[1:]
+		dtype, nullable, err := fromSubstraitType(t, names)
+		if err != nil {
+			return nil, err
+		}
+		fields[i].Type, fields[i].Nullable = dtype, nullable
+	}
+	return fields, nil
+}
+
+// substraitNames lists the names of the fields, and of the fields of any
+// structs they hold, depth first as Substrait schemas name them
+func substraitNames(fields []arrow.Field) []string {
+	names := []string{}
+	for _, f := range fields {
+		names = append(names, f.Name)
+		if st, ok := f.Type.(*arrow.StructType); ok {
+			names = append(names, substraitNames(st.Fields())...)
+		}
+	}
+	return names
+}
+
+// ToSubstrait translates a DataFrame's logical plan to a Substrait plan in
+// the protobuf JSON encoding. Registered tables are read as named tables,
+// files as local files and in-memory rows as virtual tables.
+func (ec *ExecutionContext) ToSubstrait(df DataFrame) ([]byte, error) {
+	x := &substraitExporter{ec: ec, uris: map[string]uint32{}, functions: map[string]uint32{}}
+	rel, err := x.rel(df.LogicalPlan())
+	if err != nil {
+		return nil, err
+	}
+	x.plan.Version = &substraitVersion{Producer: "drogo"}
+	x.plan.Relations = []substraitPlanRel{{Root: &substraitRelRoot{rel, substraitNames(df.Schema().Fields())}}}
+	return json.MarshalIndent(x.plan, "", "  ")
+}
+
+type substraitExporter struct {
+	ec        *ExecutionContext
+	plan      substraitPlan
+	uris      map[string]uint32
+	functions map[string]uint32
+}
+
+// function returns the anchor of a function, declaring it and its extension
+// the first time it is used
+func (x *substraitExporter) function(f substraitFunction) uint32 {
+	if anchor, ok := x.functions[f.name]; ok {
+		return anchor
+	}
+	uri, ok := x.uris[f.uri]
+	if !ok {
+		uri = uint32(len(x.uris) + 1)
+		x.uris[f.uri] = uri
+		x.plan.ExtensionUris = append(x.plan.ExtensionUris, substraitExtensionUri{uri, f.uri})
+	}
+	anchor := uint32(len(x.functions) + 1)
+	x.functions[f.name] = anchor
+	x.plan.Extensions = append(x.plan.Extensions, substraitExtension{&substraitExtensionFunction{uri, anchor, f.name}})
+	return anchor
+}
+
+func (x *substraitExporter) rel(plan LogicalPlan) (*substraitRel, error) {
+	switch p := plan.(type) {
+	case Scan:
+		return x.read(p)
+	case Projection:
+		input, err := x.rel(p.Input)
+		if err != nil {
+			return nil, err
+		}
+		exprs, err := x.exprs(p.Expr, p.Input)
+		if err != nil {
+			return nil, err
+		}
+		// a project relation emits its input's fields followed by the
+		// expressions, so keep just the expressions
+		inputFields := len(p.Input.Schema().Fields())
+		mapping := make([]int, len(exprs))
+		for i := range mapping {
+			mapping[i] = inputFields + i
+		}
+		common := &substraitRelCommon{Emit: &substraitEmit{mapping}}
+		return &substraitRel{Project: &substraitProjectRel{common, input, exprs}}, nil
+	case Selection:
+		input, err := x.rel(p.Input)
+		if err != nil {
+			return nil, err
+		}
+		condition, err := x.expr(p.Expr, p.Input)
+		if err != nil {
+			return nil, err
+		}
+		return &substraitRel{Filter: &substraitFilterRel{Input: input, Condition: condition}}, nil
+	case Aggregate:
+		input, err := x.rel(p.Input)
+		if err != nil {
+			return nil, err
+		}
+		group, err := x.exprs(p.GroupExpr, p.Input)
+		if err != nil {
+			return nil, err
+		}
+		rel := &substraitAggregateRel{Input: input, Groupings: []substraitGrouping{{group}}}
+		for i := range p.AggregateExpr {
+			e := &p.AggregateExpr[i]
+			f, ok := substraitFunctionOf(e.Name)
+			if !ok || e.Func != nil {
+				return nil, fmt.Errorf("aggregate function %s has no Substrait equivalent", e.Name)
+			}
+			arg, err := x.expr(e.Expr, p.Input)
+			if err != nil {
+				return nil, err
+			}
+			field := e.toField(p.Input)
+			outputType, err := toSubstraitType(field.Type, true)
+			if err != nil {
+				return nil, err
+			}
+			rel.Measures = append(rel.Measures, substraitMeasure{Measure: substraitAggregateFunction{
+				FunctionReference: x.function(f),
+				Arguments:         []substraitFunctionArgument{{&arg}},
+				OutputType:        &outputType,
+				Phase:             "AGGREGATION_PHASE_INITIAL_TO_RESULT",
+				Invocation:        "AGGREGATION_INVOCATION_ALL",
+			}})
+		}
+		return &substraitRel{Aggregate: rel}, nil
+	case Sort:
+		input, err := x.rel(p.Input)
+		if err != nil {
+			return nil, err
+		}
+		sorts := make([]substraitSortField, len(p.Expr))
+		for i, e := range p.Expr {
+			expr, err := x.expr(e.Expr, p.Input)
+			if err != nil {
+				return nil, err
+			}
+			// drogo sorts nulls last when ascending and first when descending
+			direction := "SORT_DIRECTION_DESC_NULLS_FIRST"
+			if e.Asc {
+				direction = "SORT_DIRECTION_ASC_NULLS_LAST"
+			}
+			sorts[i] = substraitSortField{expr, direction}
+		}
+		rel := &substraitRel{Sort: &substraitSortRel{Input: input, Sorts: sorts}}
+		if p.Fetch > 0 {
+			rel = &substraitRel{Fetch: &substraitFetchRel{Input: rel, Count: substraitInt64(p.Fetch)}}
+		}
+		return rel, nil
+	case Limit:
+		input, err := x.rel(p.Input)
+		if err != nil {
+			return nil, err
+		}
+		return &substraitRel{Fetch: &substraitFetchRel{Input: input, Offset: substraitInt64(p.Skip), Count: substraitInt64(p.Fetch)}}, nil
+	}
+	return nil, fmt.Errorf("%T has no Substrait equivalent", plan)
+}
+
+func (x *substraitExporter) read(scan Scan) (*substraitRel, error) {
+	fields := scan.Source.GetSchema().Fields()
+	schema, err := toSubstraitStruct(fields, false)
+	if err != nil {
+		return nil, err
+	}
+	read := &substraitReadRel{BaseSchema: substraitNamedStruct{substraitNames(fields), schema}}
+
+	x.ec.tablesMu.Lock()
+	_, registered := x.ec.tables[scan.Path]
+	x.ec.tablesMu.Unlock()
+	switch table, memory := scan.Source.(*MemTable); {
+	case registered:
+		read.NamedTable = &substraitNamedTable{[]string{scan.Path}}
+	case memory:
+		values, err := substraitValues(table)
+		if err != nil {
+			return nil, err
+		}
+		read.VirtualTable = &substraitVirtualTable{values}
+	default:
+		files := &substraitLocalFiles{}
+		for _, path := range strings.Split(scan.Path, ",") {
+			format := fileFormatOf(path)
+			if format == "" {
+				return nil, fmt.Errorf("cannot translate the scan of %s to Substrait: it is not a registered table or a file", scan.Path)
+			}
+			item := substraitFileItem{UriPath: path}
+			if hasGlob(path) {
+				item = substraitFileItem{UriPathGlob: path}
+			}
+			switch format {
+			case "parquet":
+				item.Parquet = &struct{}{}
+			case "arrow":
+				item.Arrow = &struct{}{}
+			}
+			files.Items = append(files.Items, item)
+		}
+		read.LocalFiles = files
+	}
+
+	// filters and the projection refer to the fields of the source
+	source := Scan{Path: scan.Path, Source: scan.Source, Projection: []string{}}
+	if len(scan.Filters) > 0 {
+		filter := scan.Filters[0]
+		for _, f := range scan.Filters[1:] {
+			filter = And(filter, f)
+		}
+		expr, err := x.expr(filter, source)
+		if err != nil {
+			return nil, err
+		}
+		read.Filter = &expr
+	}
+	if len(scan.Projection) > 0 {
+		mask := &substraitMaskExpression{MaintainSingularStruct: true}
+		for _, name := range scan.Projection {
+			i, err := substraitFieldIndex(source, name)
+			if err != nil {
+				return nil, err
+			}
+			mask.Select.StructItems = append(mask.Select.StructItems, substraitStructItem{i})
+		}
+		read.Projection = mask
+	}
+	return &substraitRel{Read: read}, nil
+}
+
+// substraitValues returns the rows of an in-memory table as literals
+func substraitValues(table *MemTable) ([]substraitStructLiteral, error) {
+	rows := []substraitStructLiteral{}
+	fields := table.Schema.Fields()
+	for _, batch := range table.batches {
+		for r := 0; r < batch.RowCount(); r++ {
+			row := substraitStructLiteral{Fields: make([]substraitLiteral, len(fields))}
+			for i, column := range batch.Fields {
+				literal, err := substraitLiteralOf(column.GetValue(r), fields[i])
+				if err != nil {
+					return nil, err
+				}
+				row.Fields[i] = literal
+			}
+			rows = append(rows, row)
+		}
+	}
+	return rows, nil
+}
+
+func substraitLiteralOf(v any, field arrow.Field) (substraitLiteral, error) {
+	switch v := v.(type) {
+	case nil:
+		t, err := toSubstraitType(field.Type, true)
+		return substraitLiteral{Null: &t}, err
+	case bool:
+		return substraitLiteral{Boolean: &v}, nil
+	case int64:
+		n := substraitInt64(v)
+		return substraitLiteral{I64: &n}, nil
+	case float64:
+		return substraitLiteral{Fp64: &v}, nil
+	case string:
+		return substraitLiteral{String: &v}, nil
+	}
+	return substraitLiteral{}, fmt.Errorf("cannot translate %T value of column %s to a Substrait literal", v, field.Name)
+}
+
+func substraitFieldIndex(input LogicalPlan, name string) (int, error) {
+	indices := input.Schema().FieldIndices(name)
+	if len(indices) == 0 {
+		return 0, fmt.Errorf("no column named '%s'", name)
+	}
+	return indices[0], nil
+}
+
+func (x *substraitExporter) exprs(exprs []LogicalExpr, input LogicalPlan) ([]substraitExpr, error) {
+	out := make([]substraitExpr, len(exprs))
+	for i, e := range exprs {
+		expr, err := x.expr(e, input)
+		if err != nil {
+			return nil, err
+		}
+		out[i] = expr
+	}
+	return out, nil
+}
+
+func (x *substraitExporter) expr(e LogicalExpr, input LogicalPlan) (substraitExpr, error) {
+	switch e := e.(type) {
+	case Column:
+		i, err := substraitFieldIndex(input, e.name)
+		if err != nil {
+			return substraitExpr{}, err
+		}
+		return substraitExpr{Selection: &substraitFieldReference{
+			DirectReference: substraitReferenceSegment{StructField: &substraitStructField{Field: i}},
+			RootReference:   &struct{}{},
+		}}, nil
+	case LiteralString:
+		return substraitExpr{Literal: &substraitLiteral{String: &e.Str}}, nil
+	case LiteralInt64:
+		n := substraitInt64(e.n)
+		return substraitExpr{Literal: &substraitLiteral{I64: &n}}, nil
+	case LiteralFloat64:
+		return substraitExpr{Literal: &substraitLiteral{Fp64: &e.n}}, nil
+	case Alias:
+		// Substrait expressions are not named; the plan's output names are
+		// kept by the root relation
+		return x.expr(e.Expr, input)
+	case BooleanBinaryExpr:
+		return x.call(e.Name, e.L, e.R, e, input)
+	case MathExpr:
+		return x.call(e.Name, e.L, e.R, e, input)
+	}
+	return substraitExpr{}, fmt.Errorf("expression %s has no Substrait equivalent", e)
+}
+
+func (x *substraitExporter) call(name string, l, r, e LogicalExpr, input LogicalPlan) (substraitExpr, error) {
+	f, ok := substraitFunctionOf(name)
+	if !ok || f.build == nil {
+		return substraitExpr{}, fmt.Errorf("expression %s has no Substrait equivalent", e)
+	}
+	args, err := x.exprs([]LogicalExpr{l, r}, input)
+	if err != nil {
+		return substraitExpr{}, err
+	}
+	outputType, err := toSubstraitType(e.ToField(input).Type, true)
+	if err != nil {
+		return substraitExpr{}, err
+	}
+	return substraitExpr{ScalarFunction: &substraitScalarFunction{
+		FunctionReference: x.function(f),
+		Arguments:         []substraitFunctionArgument{{&args[0]}, {&args[1]}},
+		OutputType:        &outputType,
+	}}, nil
+}
+
+// FromSubstrait translates a Substrait plan in the protobuf JSON encoding to
+// a DataFrame. Named tables are looked up among the registered tables. Only
+// the relations and functions that ToSubstrait produces are understood;
+// joins in particular cannot be translated, since drogo has no join
+// operator.
+func (ec *ExecutionContext) FromSubstrait(data []byte) (DataFrame, error) {
+	var plan substraitPlan
+	if err := json.Unmarshal(data, &plan); err != nil {
+		return nil, fmt.Errorf("invalid Substrait plan: %w", err)
+	}
+	x := &substraitImporter{ec: ec, functions: map[uint32]string{}}
+	for _, ext := range plan.Extensions {
+		if f := ext.ExtensionFunction; f != nil {
+			x.functions[f.FunctionAnchor] = f.Name
+		}
+	}
+	if len(plan.Relations) == 0 {
+		return nil, fmt.Errorf("Substrait plan has no relations")
+	}
+	root := plan.Relations[0]
+	var rel *substraitRel
+	var names []string
+	switch {
+	case root.Root != nil:
+		rel, names = root.Root.Input, root.Root.Names
+	case root.Rel != nil:
+		rel = root.Rel
+	}
+	logical, err := x.rel(rel)
+	if err != nil {
+		return nil, err
+	}
+	fields := logical.Schema().Fields()
+	if len(names) == len(fields) {
+		exprs := make([]LogicalExpr, len(fields))
+		renamed := false
+		for i, f := range fields {
+			exprs[i] = Col(f.Name)
+			if names[i] != f.Name {
+				exprs[i] = Alias{exprs[i], names[i]}
+				renamed = true
+			}
+		}
+		if renamed {
+			logical = Projection{logical, exprs}
+		}
+	}
+	return ec.FromLogicalPlan(logical), nil
+}
+
+type substraitImporter struct {
+	ec *ExecutionContext
+	// functions are the names of the plan's functions by anchor
+	functions map[uint32]string
+}
+
+func (x *substraitImporter) rel(rel *substraitRel) (LogicalPlan, error) {
+	if rel == nil {
+		return nil, fmt.Errorf("Substrait relation is missing")
+	}
+	var plan LogicalPlan
+	var common *substraitRelCommon
+	var err error
+	switch {
+	case rel.Read != nil:
+		common = rel.Read.Common
+		plan, err = x.read(rel.Read)
+	case rel.Project != nil:
+		return x.project(rel.Project)
+	case rel.Filter != nil:
+		common = rel.Filter.Common
+		plan, err = x.filter(rel.Filter)
+	case rel.Aggregate != nil:
+		common = rel.Aggregate.Common
+		plan, err = x.aggregate(rel.Aggregate)
+	case rel.Sort != nil:
+		common = rel.Sort.Common
+		plan, err = x.sort(rel.Sort)
+	case rel.Fetch != nil:
+		common = rel.Fetch.Common
+		var input LogicalPlan
+		if input, err = x.rel(rel.Fetch.Input); err == nil {
+			fetch := int(rel.Fetch.Count)
+			if fetch < 0 {
+				fetch = -1
+			}
+			plan = Limit{input, int(rel.Fetch.Offset), fetch}
+		}
+	case rel.Join != nil || rel.Cross != nil:
+		return nil, fmt.Errorf("Substrait join relations are not supported")
+	default:
+		return nil, fmt.Errorf("unsupported Substrait relation")
+	}
+	if err != nil {
+		return nil, err
+	}
+	return emit(plan, common, nil)
+}
+
+// emit applies a relation's output mapping. items are the expressions the
+// relation outputs, which default to the plan's columns.
+func emit(plan LogicalPlan, common *substraitRelCommon, items []LogicalExpr) (LogicalPlan, error) {
+	if items == nil {
+		for _, f := range plan.Schema().Fields() {
+			items = append(items, Col(f.Name))
+		}
+	}
+	if common == nil || common.Emit == nil {
+		if _, ok := plan.(Projection); ok || len(items) == len(plan.Schema().Fields()) {
+			return plan, nil
+		}
+		return Projection{plan, items}, nil
+	}
+	exprs := make([]LogicalExpr, len(common.Emit.OutputMapping))
+	for i, n := range common.Emit.OutputMapping {
+		if n < 0 || n >= len(items) {
+			return nil, fmt.Errorf("Substrait output mapping refers to field %d of %d", n, len(items))
+		}
+		exprs[i] = items[n]
+	}
+	return Projection{plan, exprs}, nil
+}
+
+func (x *substraitImporter) read(read *substraitReadRel) (LogicalPlan, error) {
+	var df DataFrame
+	var err error
+	switch {
+	case read.NamedTable != nil:
+		df, err = x.ec.Table(strings.Join(read.NamedTable.Names, "."))
+	case read.LocalFiles != nil:
+		df, err = x.localFiles(read.LocalFiles)
+	case read.VirtualTable != nil:
+		df, err = x.virtualTable(read)
+	default:
+		err = fmt.Errorf("unsupported Substrait read relation")
+	}
+	if err != nil {
+		return nil, err
+	}
+	plan := df.LogicalPlan()
+	if read.Filter != nil {
+		condition, err := x.expr(*read.Filter, plan)
+		if err != nil {
+			return nil, err
+		}
+		plan = Selection{plan, condition}
+	}
+	if read.Projection != nil {
+		fields := plan.Schema().Fields()
+		exprs := []LogicalExpr{}
+		for _, item := range read.Projection.Select.StructItems {
+			if item.Field < 0 || item.Field >= len(fields) {
+				return nil, fmt.Errorf("Substrait projection refers to field %d of %d", item.Field, len(fields))
+			}
+			exprs = append(exprs, Col(fields[item.Field].Name))
+		}
+		plan = Projection{plan, exprs}
+	}
+	return plan, nil
+}
+
+func (x *substraitImporter) localFiles(files *substraitLocalFiles) (DataFrame, error) {
+	paths := []string{}
+	format := ""
+	for _, item := range files.Items {
+		path := item.UriPath
+		for _, p := range []string{item.UriPathGlob, item.UriFile, item.UriFolder} {
+			if path == "" {
+				path = p
+			}
+		}
+		path = strings.TrimPrefix(path, "file://")
+		itemFormat := fileFormatOf(path)
+		switch {
+		case item.Parquet != nil:
+			itemFormat = "parquet"
+		case item.Arrow != nil:
+			itemFormat = "arrow"
+		}
+		if itemFormat == "" {
+			return nil, fmt.Errorf("cannot tell the format of %s", path)
+		}
+		if format != "" && format != itemFormat {
+			return nil, fmt.Errorf("Substrait local files must all have the same format")
+		}
+		format = itemFormat
+		paths = append(paths, path)
+	}
+	switch {
+	case len(paths) == 0:
+		return nil, fmt.Errorf("Substrait local files list no files")
+	case len(paths) == 1:
+		return x.ec.Scan(paths[0], x.ec.fileSource(paths[0], format)), nil
+	case format == "parquet":
+		return x.ec.Parquet(paths...), nil
+	}
+	return nil, fmt.Errorf("only Parquet files can be read as one table")
+}
+
+func (x *substraitImporter) virtualTable(read *substraitReadRel) (DataFrame, error) {
+	names := read.BaseSchema.Names
+	fields, err := fromSubstraitStruct(read.BaseSchema.Struct, &names)
+	if err != nil {
+		return nil, err
+	}
+	rows := make([][]any, len(read.VirtualTable.Values))
+	for i, value := range read.VirtualTable.Values {
+		if len(value.Fields) != len(fields) {
+			return nil, fmt.Errorf("Substrait virtual table row %d has %d values, expected %d", i, len(value.Fields), len(fields))
+		}
+		rows[i] = make([]any, len(fields))
+		for j, literal := range value.Fields {
+			v, err := substraitValue(literal)
+			if err != nil {
+				return nil, err
+			}
+			rows[i][j] = v
+		}
+	}
+	return x.ec.values(fields, rows)
+}
+
+// substraitValue returns the value of a literal, with integers as int64 and
+// floating point numbers as float64
+func substraitValue(l substraitLiteral) (any, error) {
+	switch {
+	case l.Null != nil:
+		return nil, nil
+	case l.Boolean != nil:
+		return *l.Boolean, nil
+	case l.I8 != nil:
+		return int64(*l.I8), nil
+	case l.I16 != nil:
+		return int64(*l.I16), nil
+	case l.I32 != nil:
+		return int64(*l.I32), nil
+	case l.I64 != nil:
+		return int64(*l.I64), nil
+	case l.Fp32 != nil:
+		return float64(*l.Fp32), nil
+	case l.Fp64 != nil:
+		return *l.Fp64, nil
+	case l.String != nil:
+		return *l.String, nil
+	}
+	return nil, fmt.Errorf("unsupported Substrait literal")
+}
+
+func (x *substraitImporter) project(project *substraitProjectRel) (LogicalPlan, error) {
+	input, err := x.rel(project.Input)
+	if err != nil {
+		return nil, err
+	}
+	items := []LogicalExpr{}
+	for _, f := range input.Schema().Fields() {
+		items = append(items, Col(f.Name))
+	}
+	for _, e := range project.Expressions {
+		expr, err := x.expr(e, input)
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, named(expr))
+	}
+	if project.Common == nil || project.Common.Emit == nil {
+		return Projection{input, items}, nil
+	}
+	return emit(input, project.Common, items)
+}
+
+// named gives an expression a name other plans can refer to it by. Columns
+// keep theirs, and computed values are named after the expression.
+func named(expr LogicalExpr) LogicalExpr {
+	if _, ok := expr.(Column); ok {
+		return expr
+	}
+	return Alias{expr, expr.String()}
+}
+
+func (x *substraitImporter) filter(filter *substraitFilterRel) (LogicalPlan, error) {
+	input, err := x.rel(filter.Input)
+	if err != nil {
+		return nil, err
+	}
+	condition, err := x.expr(filter.Condition, input)
+	if err != nil {
+		return nil, err
+	}
+	return Selection{input, condition}, nil
+}
+
+func (x *substraitImporter) aggregate(aggregate *substraitAggregateRel) (LogicalPlan, error) {
+	input, err := x.rel(aggregate.Input)
+	if err != nil {
+		return nil, err
+	}
+	if len(aggregate.Groupings) > 1 {
+		return nil, fmt.Errorf("Substrait grouping sets are not supported")
+	}
+	group := []LogicalExpr{}
+	if len(aggregate.Groupings) == 1 {
+		for _, e := range aggregate.Groupings[0].GroupingExpressions {
+			expr, err := x.expr(e, input)
+			if err != nil {
+				return nil, err
+			}
+			group = append(group, named(expr))
+		}
+	}
+	aggregates := []AggregateExpr{}
+	for _, m := range aggregate.Measures {
+		if m.Filter != nil || m.Measure.Invocation == "AGGREGATION_INVOCATION_DISTINCT" {
+			return nil, fmt.Errorf("Substrait aggregate filters and DISTINCT are not supported")
+		}
+		f, err := x.function(m.Measure.FunctionReference)
+		if err != nil {
+			return nil, err
+		}
+		if f.build != nil || len(m.Measure.Arguments) > 1 {
+			return nil, fmt.Errorf("%s is not an aggregate function of one argument", f.name)
+		}
+		var arg LogicalExpr = Int(1)
+		if len(m.Measure.Arguments) == 1 {
+			if arg, err = x.argument(m.Measure.Arguments[0], input); err != nil {
+				return nil, err
+			}
+		}
+		e := AggregateExpr{Name: f.drogo, Expr: arg}
+		e.Alias = e.String()
+		aggregates = append(aggregates, e)
+	}
+	return Aggregate{input, group, aggregates}, nil
+}
+
+func (x *substraitImporter) sort(s *substraitSortRel) (LogicalPlan, error) {
+	input, err := x.rel(s.Input)
+	if err != nil {
+		return nil, err
+	}
+	exprs := make([]SortExpr, len(s.Sorts))
+	for i, field := range s.Sorts {
+		expr, err := x.expr(field.Expr, input)
+		if err != nil {
+			return nil, err
+		}
+		switch field.Direction {
+		case "SORT_DIRECTION_ASC_NULLS_FIRST", "SORT_DIRECTION_ASC_NULLS_LAST":
+			exprs[i] = Asc(expr)
+		case "SORT_DIRECTION_DESC_NULLS_FIRST", "SORT_DIRECTION_DESC_NULLS_LAST":
+			exprs[i] = Desc(expr)
+		default:
+			return nil, fmt.Errorf("unsupported Substrait sort direction '%s'", field.Direction)
+		}
+	}
+	return Sort{Input: input, Expr: exprs}, nil
+}
+
+func (x *substraitImporter) function(anchor uint32) (substraitFunction, error) {
+	name, ok := x.functions[anchor]
+	if !ok {
+		return substraitFunction{}, fmt.Errorf("Substrait function %d is not declared", anchor)
+	}
+	f, ok := substraitFunctionNamed(name)
+	if !ok {
+		return substraitFunction{}, fmt.Errorf("unsupported Substrait function '%s'", name)
+	}
+	return f, nil
+}
+
+func (x *substraitImporter) argument(arg substraitFunctionArgument, input LogicalPlan) (LogicalExpr, error) {
+	if arg.Value == nil {
+		return nil, fmt.Errorf("Substrait function arguments must be values")
+	}
+	return x.expr(*arg.Value, input)
+}
+
+func (x *substraitImporter) expr(e substraitExpr, input LogicalPlan) (LogicalExpr, error) {
+	switch {
+	case e.Selection != nil:
+		field := e.Selection.DirectReference.StructField
+		if field == nil || field.Child != nil {
+			return nil, fmt.Errorf("only references to top-level fields are supported")
+		}
+		fields := input.Schema().Fields()
+		if field.Field < 0 || field.Field >= len(fields) {
+			return nil, fmt.Errorf("Substrait field reference %d is out of range for %d fields", field.Field, len(fields))
+		}
+		return Col(fields[field.Field].Name), nil
+	case e.Literal != nil:
+		v, err := substraitValue(*e.Literal)
+		if err != nil {
+			return nil, err
+		}
+		switch v := v.(type) {
+		case int64:
+			return Int(v), nil
+		case float64:
+			return Flt(v), nil
+		case string:
+			return Str(v), nil
+		}
+		return nil, fmt.Errorf("unsupported Substrait literal %v", v)
+	case e.ScalarFunction != nil:
+		f, err := x.function(e.ScalarFunction.FunctionReference)
+		if err != nil {
+			return nil, err
+		}
+		args := e.ScalarFunction.Arguments
+		if f.build == nil || len(args) != 2 {
+			return nil, fmt.Errorf("%s is not a scalar function of two arguments", f.name)
+		}
+		l, err := x.argument(args[0], input)
+		if err != nil {
+			return nil, err
+		}
+		r, err := x.argument(args[1], input)
+		if err != nil {
+			return nil, err
+		}
+		return f.build(l, r), nil
+	}
+	return nil, fmt.Errorf("unsupported Substrait expression")
+}
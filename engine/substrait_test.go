@@ -0,0 +1,130 @@
+package engine
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSubstraitRoundTrip(t *testing.T) {
+	ec := sqlContext(t)
+	queries := []string{
+		"SELECT * FROM employees",
+		"SELECT id, first_name FROM employees WHERE state = 'CO' AND salary >= 10000",
+		"SELECT id, salary * 1.1 AS raise, id % 2 FROM employees WHERE id != 3 OR salary < 11000",
+		"SELECT state, SUM(salary), MIN(id), MAX(salary), AVG(salary), COUNT(*) FROM employees GROUP BY state",
+		"SELECT id FROM employees ORDER BY salary DESC, id LIMIT 2 OFFSET 1",
+		"SELECT 1 + 2, 'a'",
+	}
+	frames := []DataFrame{}
+	for _, query := range queries {
+		df, err := ec.Sql(context.Background(), query)
+		require.NoError(t, err)
+		frames = append(frames, df)
+	}
+	// files that are not registered tables are read as local files
+	events := ec.Parquet(writeEventsParquet(t, 0, 20, 5))
+	frames = append(frames, events.Filter(Gt(Col("id"), Int(12))).Project([]LogicalExpr{Col("id"), Col("name")}))
+
+	for _, df := range frames {
+		for _, plan := range []LogicalPlan{df.LogicalPlan(), NewOptimizer().Optimize(df.LogicalPlan())} {
+			data, err := ec.ToSubstrait(ec.FromLogicalPlan(plan))
+			require.NoError(t, err, Format(plan, 0))
+			imported, err := ec.FromSubstrait(data)
+			require.NoError(t, err, Format(plan, 0))
+			assert.Equal(t, df.Schema().String(), imported.Schema().String(), Format(plan, 0))
+
+			expected, err := ec.Execute(context.Background(), df)
+			require.NoError(t, err)
+			actual, err := ec.Execute(context.Background(), imported)
+			require.NoError(t, err)
+			if _, sorted := df.LogicalPlan().(Limit); sorted {
+				assert.Equal(t, collectRows(t, expected), collectRows(t, actual), Format(plan, 0))
+			} else {
+				assert.ElementsMatch(t, collectRows(t, expected), collectRows(t, actual), Format(plan, 0))
+			}
+		}
+	}
+}
+
+func TestToSubstrait(t *testing.T) {
+	ec := sqlContext(t)
+	df, err := ec.Sql(context.Background(), "SELECT state, SUM(salary + 1) FROM employees WHERE id > 2 GROUP BY state")
+	require.NoError(t, err)
+	data, err := ec.ToSubstrait(df)
+	require.NoError(t, err)
+	var plan substraitPlan
+	require.NoError(t, json.Unmarshal(data, &plan))
+
+	assert.Equal(t, "drogo", plan.Version.Producer)
+	assert.Equal(t, []substraitExtensionUri{{1, substraitComparison}, {2, substraitArithmetic}}, plan.ExtensionUris)
+	functions := []string{}
+	for _, ext := range plan.Extensions {
+		functions = append(functions, ext.ExtensionFunction.Name)
+	}
+	assert.Equal(t, []string{"gt", "add", "sum"}, functions)
+
+	root := plan.Relations[0].Root
+	assert.Equal(t, []string{"state", "SUM(salary + 1)"}, root.Names)
+	aggregate := root.Input.Project.Input.Aggregate
+	measure := aggregate.Measures[0].Measure
+	assert.Equal(t, "AGGREGATION_PHASE_INITIAL_TO_RESULT", measure.Phase)
+	assert.Equal(t, uint32(2), measure.Arguments[0].Value.ScalarFunction.FunctionReference)
+	assert.Equal(t, 3, aggregate.Groupings[0].GroupingExpressions[0].Selection.DirectReference.StructField.Field)
+	read := aggregate.Input.Filter.Input.Read
+	assert.Equal(t, []string{"employees"}, read.NamedTable.Names)
+	assert.Equal(t, "id", read.BaseSchema.Names[0])
+	assert.Contains(t, string(data), `"i64": "2"`)
+}
+
+func TestFromSubstrait(t *testing.T) {
+	ec := sqlContext(t)
+	// a plan as other producers write it, with function signatures, numeric
+	// 64-bit integers and an output mapping on the filter
+	plan := `{
+	  "extensionUris": [{"extensionUriAnchor": 1, "uri": "functions_comparison.yaml"}],
+	  "extensions": [
+	    {"extensionFunction": {"extensionUriReference": 1, "functionAnchor": 7, "name": "gt:i64_i64"}}
+	  ],
+	  "relations": [{"root": {
+	    "input": {"filter": {
+	      "common": {"emit": {"outputMapping": [1, 0]}},
+	      "input": {"read": {
+	        "baseSchema": {"names": ["id", "first_name", "last_name", "state", "job_title", "salary"], "struct": {"types": [
+	          {"i64": {}}, {"string": {}}, {"string": {}}, {"string": {}}, {"string": {}}, {"i64": {}}
+	        ]}},
+	        "namedTable": {"names": ["employees"]}
+	      }},
+	      "condition": {"scalarFunction": {"functionReference": 7, "arguments": [
+	        {"value": {"selection": {"directReference": {"structField": {"field": 5}}, "rootReference": {}}}},
+	        {"value": {"literal": {"i64": 12000}}}
+	      ]}}
+	    }},
+	    "names": ["name", "id"]
+	  }}]
+	}`
+	df, err := ec.FromSubstrait([]byte(plan))
+	require.NoError(t, err)
+	assert.Equal(t, "name", df.Schema().Field(0).Name)
+	assert.Equal(t, "id", df.Schema().Field(1).Name)
+	stream, err := ec.Execute(context.Background(), df)
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []string{"[Grace 6]", "[Linus 7]"}, collectRows(t, stream))
+
+	for _, test := range []struct{ plan, err string }{
+		{`{"relations": []}`, "Substrait plan has no relations"},
+		{`{"relations": [{"root": {"input": {"join": {}}}}]}`, "Substrait join relations are not supported"},
+		{`{"relations": [{"root": {"input": {"read": {"namedTable": {"names": ["missing"]}}}}}]}`, "table 'missing' not found"},
+		{`{"extensions": [{"extensionFunction": {"functionAnchor": 1, "name": "concat:str_str"}}],
+		  "relations": [{"root": {"input": {"filter": {
+		    "input": {"read": {"namedTable": {"names": ["employees"]}}},
+		    "condition": {"scalarFunction": {"functionReference": 1, "arguments": []}}}}}}]}`,
+			"unsupported Substrait function 'concat:str_str'"},
+	} {
+		_, err := ec.FromSubstrait([]byte(test.plan))
+		assert.EqualError(t, err, test.err)
+	}
+}
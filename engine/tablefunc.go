@@ -0,0 +1,396 @@
+package engine
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/apache/arrow/go/v12/arrow"
+	"github.com/briansterle/drogo"
+)
+
+// TableFunction creates the table read by a call in a FROM clause, such as
+// generate_series(1, 10). The arguments are constants: int64, float64,
+// string or bool. Arguments written name = value are passed in options,
+// keyed by the lower case name.
+type TableFunction interface {
+	Call(ec *ExecutionContext, args []any, options map[string]any) (DataSource, error)
+}
+
+// TableFunctionFunc adapts a function to the TableFunction interface
+type TableFunctionFunc func(ec *ExecutionContext, args []any, options map[string]any) (DataSource, error)
+
+func (f TableFunctionFunc) Call(ec *ExecutionContext, args []any, options map[string]any) (DataSource, error) {
+	return f(ec, args, options)
+}
+
+// builtinTableFunctions are available in every context unless a function of
+// the same name is registered
+var builtinTableFunctions = map[string]TableFunction{
+	"generate_series": TableFunctionFunc(func(ec *ExecutionContext, args []any, options map[string]any) (DataSource, error) {
+		return series(ec, "generate_series", args, options, true)
+	}),
+	"range": TableFunctionFunc(func(ec *ExecutionContext, args []any, options map[string]any) (DataSource, error) {
+		return series(ec, "range", args, options, false)
+	}),
+	"read_csv":     TableFunctionFunc(readCsv),
+	"read_parquet": TableFunctionFunc(readParquet),
+}
+
+// RegisterTableFunction makes a table function available to queries,
+// replacing any function, including a built-in one, registered under the
+// same name. Names are case insensitive.
+func (ec *ExecutionContext) RegisterTableFunction(name string, f TableFunction) {
+	ec.functionsMu.Lock()
+	defer ec.functionsMu.Unlock()
+	if ec.tableFunctions == nil {
+		ec.tableFunctions = map[string]TableFunction{}
+	}
+	ec.tableFunctions[strings.ToLower(name)] = f
+}
+
+// TableFunction returns the table function registered under a name, or the
+// built-in one
+func (ec *ExecutionContext) TableFunction(name string) (TableFunction, bool) {
+	name = strings.ToLower(name)
+	ec.functionsMu.Lock()
+	f, ok := ec.tableFunctions[name]
+	ec.functionsMu.Unlock()
+	if !ok {
+		f, ok = builtinTableFunctions[name]
+	}
+	return f, ok
+}
+
+// CallTable returns a DataFrame that reads the table a table function
+// creates from the arguments
+func (ec *ExecutionContext) CallTable(name string, args []any, options map[string]any) (DataFrame, error) {
+	f, ok := ec.TableFunction(name)
+	if !ok {
+		return nil, fmt.Errorf("unknown table function %s", name)
+	}
+	if options == nil {
+		options = map[string]any{}
+	}
+	source, err := f.Call(ec, args, options)
+	if err != nil {
+		return nil, err
+	}
+	return ec.Scan(formatCall(name, args, options), source), nil
+}
+
+// formatCall renders a table function call to name the table it reads
+func formatCall(name string, args []any, options map[string]any) string {
+	parts := []string{}
+	for _, arg := range args {
+		parts = append(parts, formatArg(arg))
+	}
+	names := make([]string, 0, len(options))
+	for name := range options {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		parts = append(parts, name+" = "+formatArg(options[name]))
+	}
+	return name + "(" + strings.Join(parts, ", ") + ")"
+}
+
+func formatArg(arg any) string {
+	if s, ok := arg.(string); ok {
+		return "'" + s + "'"
+	}
+	return fmt.Sprint(arg)
+}
+
+// checkOptions rejects options a table function does not understand
+func checkOptions(function string, options map[string]any, known ...string) error {
+	for name := range options {
+		found := false
+		for _, k := range known {
+			found = found || name == k
+		}
+		if !found {
+			return fmt.Errorf("unknown option '%s' for %s", name, function)
+		}
+	}
+	return nil
+}
+
+// series implements generate_series and range, which count from start, or
+// zero with one argument, to stop by step, or one. generate_series includes
+// stop and range stops before it.
+func series(ec *ExecutionContext, name string, args []any, options map[string]any, inclusive bool) (DataSource, error) {
+	if err := checkOptions(name, options); err != nil {
+		return nil, err
+	}
+	if len(args) < 1 || len(args) > 3 {
+		return nil, fmt.Errorf("%s takes 1 to 3 arguments, got %d", name, len(args))
+	}
+	bounds := []int64{0, 0, 1}
+	if len(args) == 1 {
+		args = []any{int64(0), args[0]}
+	}
+	for i, arg := range args {
+		n, ok := arg.(int64)
+		if !ok {
+			return nil, fmt.Errorf("%s needs integer arguments, got %s", name, formatArg(arg))
+		}
+		bounds[i] = n
+	}
+	if bounds[2] == 0 {
+		return nil, fmt.Errorf("the step of %s cannot be zero", name)
+	}
+	return NewSeriesDataSource(name, bounds[0], bounds[1], bounds[2], inclusive, ec.BatchSize).WithPartitions(ec.targetPartitions()), nil
+}
+
+func readCsv(ec *ExecutionContext, args []any, options map[string]any) (DataSource, error) {
+	if err := checkOptions("read_csv", options, "header"); err != nil {
+		return nil, err
+	}
+	if len(args) != 1 {
+		return nil, fmt.Errorf("read_csv takes a path, got %d arguments", len(args))
+	}
+	path, ok := args[0].(string)
+	if !ok {
+		return nil, fmt.Errorf("read_csv needs a path, got %s", formatArg(args[0]))
+	}
+	header := true
+	if v, ok := options["header"]; ok {
+		if header, ok = v.(bool); !ok {
+			return nil, fmt.Errorf("header must be true or false, got %s", formatArg(v))
+		}
+	}
+	if isListing(path) {
+		if !header {
+			return nil, fmt.Errorf("header = false is only supported for single files")
+		}
+		return ec.listing(path, "csv"), nil
+	}
+	return NewCsvDataSource(path, Schema{}, header, ec.BatchSize).WithPartitions(ec.targetPartitions()), nil
+}
+
+func readParquet(ec *ExecutionContext, args []any, options map[string]any) (DataSource, error) {
+	if err := checkOptions("read_parquet", options); err != nil {
+		return nil, err
+	}
+	if len(args) == 0 {
+		return nil, fmt.Errorf("read_parquet takes one or more paths")
+	}
+	paths := make([]string, len(args))
+	for i, arg := range args {
+		path, ok := arg.(string)
+		if !ok {
+			return nil, fmt.Errorf("read_parquet needs paths, got %s", formatArg(arg))
+		}
+		paths[i] = path
+	}
+	return ec.parquet(paths), nil
+}
+
+// SeriesDataSource produces a single int64 column of the values from Start
+// to Stop by Step, including Stop if Inclusive is set. The values are
+// generated as they are read, split into contiguous runs, one per partition.
+type SeriesDataSource struct {
+	Name      string
+	Start     int64
+	Stop      int64
+	Step      int64
+	Inclusive bool
+
+	batchSize  int
+	partitions int
+}
+
+// NewSeriesDataSource returns a series with a column called name. Step must
+// not be zero.
+func NewSeriesDataSource(name string, start, stop, step int64, inclusive bool, batchSize int) *SeriesDataSource {
+	return &SeriesDataSource{Name: name, Start: start, Stop: stop, Step: step, Inclusive: inclusive,
+		batchSize: batchSize, partitions: 1}
+}
+
+// WithPartitions splits the series into up to n partitions
+func (s *SeriesDataSource) WithPartitions(n int) *SeriesDataSource {
+	s.partitions = n
+	return s
+}
+
+func (s *SeriesDataSource) GetSchema() Schema {
+	return Schema{arrow.NewSchema([]arrow.Field{{Name: s.Name, Type: drogo.Int64}}, nil)}
+}
+
+// count is the number of values in the series. It is computed with unsigned
+// arithmetic so that series spanning the whole int64 range do not overflow.
+func (s *SeriesDataSource) count() uint64 {
+	var span, step uint64
+	switch {
+	case s.Step > 0 && s.Start <= s.Stop:
+		span, step = uint64(s.Stop)-uint64(s.Start), uint64(s.Step)
+	case s.Step < 0 && s.Start >= s.Stop:
+		span, step = uint64(s.Start)-uint64(s.Stop), uint64(-s.Step)
+	default:
+		return 0
+	}
+	n := span/step + 1
+	if !s.Inclusive && span%step == 0 {
+		n--
+	}
+	return n
+}
+
+func (s *SeriesDataSource) Partitions() int {
+	n := s.partitions
+	if count := s.count(); uint64(n) > count {
+		n = int(count)
+	}
+	if n < 1 {
+		return 1
+	}
+	return n
+}
+
+func (s *SeriesDataSource) Scan(ctx context.Context, projection []string, fetch int, partition int) RecordBatchStream {
+	count, partitions := s.count(), uint64(s.Partitions())
+	// each partition gets an equal share, with the remainder spread over the
+	// first ones
+	share, rest, p := count/partitions, count%partitions, uint64(partition)
+	start := p*share + p
+	if p >= rest {
+		start = p*share + rest
+	}
+	end := start + share
+	if p < rest {
+		end++
+	}
+	if fetch > 0 && uint64(fetch) < end-start {
+		end = start + uint64(fetch)
+	}
+	batchSize := s.batchSize
+	if batchSize <= 0 {
+		batchSize = defaultBatchSize
+	}
+	return &seriesStream{ctx: ctx, source: s, schema: s.GetSchema(), next: start, end: end, batchSize: uint64(batchSize)}
+}
+
+type seriesStream struct {
+	ctx       context.Context
+	source    *SeriesDataSource
+	schema    Schema
+	next      uint64
+	end       uint64
+	batchSize uint64
+}
+
+func (s *seriesStream) Next() (RecordBatch, error) {
+	if err := s.ctx.Err(); err != nil {
+		return RecordBatch{}, err
+	}
+	if s.next >= s.end {
+		return RecordBatch{}, io.EOF
+	}
+	n := s.end - s.next
+	if n > s.batchSize {
+		n = s.batchSize
+	}
+	values := make([]any, n)
+	for i := range values {
+		values[i] = s.source.Start + int64(s.next+uint64(i))*s.source.Step
+	}
+	s.next += n
+	return RecordBatch{s.schema, []ColumnVector{drogo.New(drogo.Int64, len(values), values)}}, nil
+}
+
+func (s *seriesStream) Close() error {
+	return nil
+}
+
+// Unnest expands a list column into a row for each of its elements,
+// repeating the values of the other columns. Rows whose list is null or
+// empty produce no rows.
+type Unnest struct {
+	Input  LogicalPlan
+	Column string
+}
+
+func (u Unnest) Schema() Schema {
+	schema := u.Input.Schema()
+	indices := schema.FieldIndices(u.Column)
+	if len(indices) == 0 {
+		return schema
+	}
+	return unnestSchema(schema, indices[0])
+}
+
+func (u Unnest) Children() []LogicalPlan {
+	return []LogicalPlan{u.Input}
+}
+
+func (u Unnest) String() string {
+	return fmt.Sprintf("Unnest: column=%s", u.Column)
+}
+
+// unnestSchema replaces the type of a list column with its element type
+func unnestSchema(schema Schema, column int) Schema {
+	fields := append([]arrow.Field{}, schema.Fields()...)
+	if list, ok := fields[column].Type.(*arrow.ListType); ok {
+		fields[column] = arrow.Field{Name: fields[column].Name, Type: list.Elem(), Nullable: true}
+	}
+	return Schema{arrow.NewSchema(fields, nil)}
+}
+
+// UnnestExec expands the list column at index Column of its input
+type UnnestExec struct {
+	Input   PhysicalPlan
+	Column  int
+	Schema  Schema
+	metrics *Metrics
+}
+
+func (u UnnestExec) GetSchema() Schema {
+	return u.Schema
+}
+
+func (u UnnestExec) OutputPartitions() int {
+	return u.Input.OutputPartitions()
+}
+
+func (u UnnestExec) Children() []PhysicalPlan {
+	return []PhysicalPlan{u.Input}
+}
+
+func (u UnnestExec) String() string {
+	return fmt.Sprintf("UnnestExec: column=%d", u.Column)
+}
+
+func (u UnnestExec) Execute(ctx context.Context, task *TaskContext, partition int) RecordBatchStream {
+	return u.metrics.record(&mapStream{ctx, u.Input.Execute(ctx, task, partition), func(batch RecordBatch) (RecordBatch, error) {
+		// the input row of each output row, and the elements
+		rows, elements := []int{}, []any{}
+		for row := 0; row < batch.RowCount(); row++ {
+			list, _ := batch.Fields[u.Column].GetValue(row).([]any)
+			for _, v := range list {
+				rows = append(rows, row)
+				elements = append(elements, v)
+			}
+		}
+		columns := make([]ColumnVector, len(batch.Fields))
+		for i, f := range batch.Fields {
+			if i == u.Column {
+				columns[i] = drogo.New(u.Schema.Field(i).Type, len(elements), elements)
+				continue
+			}
+			values := make([]any, len(rows))
+			for j, row := range rows {
+				values[j] = f.GetValue(row)
+			}
+			columns[i] = drogo.New(f.DataType(), len(values), values)
+		}
+		return RecordBatch{u.Schema, columns}, nil
+	}})
+}
+
+func (u UnnestExec) Metrics() *Metrics {
+	return u.metrics
+}
@@ -0,0 +1,192 @@
+package engine
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"testing"
+
+	"github.com/apache/arrow/go/v12/arrow"
+	"github.com/briansterle/drogo"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSeriesSql(t *testing.T) {
+	ec := sqlContext(t)
+	rows := sqlRows(t, ec, "SELECT * FROM generate_series(1, 5) ORDER BY generate_series")
+	assert.Equal(t, [][]any{{int64(1)}, {int64(2)}, {int64(3)}, {int64(4)}, {int64(5)}}, rows)
+	rows = sqlRows(t, ec, "SELECT s.range FROM range(3) s ORDER BY range")
+	assert.Equal(t, [][]any{{int64(0)}, {int64(1)}, {int64(2)}}, rows)
+	rows = sqlRows(t, ec, "SELECT n.generate_series * 2 AS x FROM generate_series(10, 1 - 2, -4) AS n WHERE generate_series < 10 ORDER BY x")
+	assert.Equal(t, [][]any{{int64(4)}, {int64(12)}}, rows)
+	rows = sqlRows(t, ec, "SELECT COUNT(*), SUM(range), MAX(range) FROM range(0, 100, 7)")
+	assert.Equal(t, [][]any{{int64(15), int64(735), int64(98)}}, rows)
+	rows = sqlRows(t, ec, "SELECT * FROM (SELECT * FROM generate_series(1, 1000)) ORDER BY generate_series DESC LIMIT 2")
+	assert.Equal(t, [][]any{{int64(1000)}, {int64(999)}}, rows)
+
+	for _, test := range []struct{ sql, err string }{
+		{"SELECT * FROM range(1, 2, 0)", "the step of range cannot be zero"},
+		{"SELECT * FROM range()", "range takes 1 to 3 arguments, got 0"},
+		{"SELECT * FROM generate_series(1, 'a')", "generate_series needs integer arguments, got 'a'"},
+		{"SELECT * FROM generate_series(1, id)", "invalid argument id of generate_series: no column named 'id'"},
+		{"SELECT * FROM generate_series(1, 3, step = 1)", "unknown option 'step' for generate_series"},
+		{"SELECT * FROM explode(1)", "unknown table function explode"},
+	} {
+		_, err := ec.Sql(context.Background(), test.sql)
+		assert.EqualError(t, err, test.err, test.sql)
+	}
+}
+
+func TestSeriesDataSource(t *testing.T) {
+	for _, test := range []struct {
+		start, stop, step int64
+		inclusive         bool
+		count             uint64
+	}{
+		{0, 10, 1, false, 10},
+		{0, 10, 3, true, 4},
+		{0, 9, 3, true, 4},
+		{0, 9, 3, false, 3},
+		{5, 5, 1, true, 1},
+		{5, 5, 1, false, 0},
+		{5, 0, 1, true, 0},
+		{0, 5, -1, true, 0},
+		{math.MinInt64, math.MaxInt64, math.MaxInt64, true, 3},
+		{math.MaxInt64, math.MinInt64, math.MinInt64, false, 2},
+	} {
+		s := NewSeriesDataSource("n", test.start, test.stop, test.step, test.inclusive, 0)
+		assert.Equal(t, test.count, s.count(), "%+v", test)
+	}
+
+	s := NewSeriesDataSource("n", 1, 10, 1, true, 2).WithPartitions(3)
+	assert.Equal(t, 3, s.Partitions())
+	rows := []string{}
+	for p := 0; p < s.Partitions(); p++ {
+		rows = append(rows, collectRows(t, s.Scan(context.Background(), nil, 0, p))...)
+	}
+	assert.Equal(t, []string{"[1]", "[2]", "[3]", "[4]", "[5]", "[6]", "[7]", "[8]", "[9]", "[10]"}, rows)
+	assert.Equal(t, []string{"[5]", "[6]"}, collectRows(t, s.Scan(context.Background(), nil, 2, 1)))
+	assert.Equal(t, 2, NewSeriesDataSource("n", 0, 2, 1, false, 0).WithPartitions(8).Partitions())
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err := s.Scan(ctx, nil, 0, 0).Next()
+	assert.ErrorIs(t, err, context.Canceled)
+}
+
+func TestReadFileFunctions(t *testing.T) {
+	ec := &ExecutionContext{BatchSize: 3, TargetPartitions: 2}
+	rows := sqlRows(t, ec, "SELECT e.id, first_name FROM read_csv('testdata/employees.csv') e WHERE state = 'CA' ORDER BY id")
+	assert.Equal(t, [][]any{{int64(1), "Bill"}, {int64(8), "Ken"}}, rows)
+	rows = sqlRows(t, ec, "SELECT COUNT(*) FROM read_csv('testdata/employees.csv', header = false)")
+	assert.Equal(t, [][]any{{int64(9)}}, rows)
+	df, err := ec.Sql(context.Background(), "SELECT * FROM read_csv('testdata/employees.csv', HEADER = FALSE)")
+	require.NoError(t, err)
+	assert.Equal(t, drogo.String, df.Schema().Field(0).Type)
+
+	path := writeEventsParquet(t, 0, 20, 5)
+	rows = sqlRows(t, ec, fmt.Sprintf("SELECT COUNT(*), MAX(id) FROM read_parquet('%s') WHERE id >= 5", path))
+	assert.Equal(t, [][]any{{int64(15), int64(19)}}, rows)
+
+	for _, test := range []struct{ sql, err string }{
+		{"SELECT * FROM read_csv('testdata/employees.csv', delimiter = ';')", "unknown option 'delimiter' for read_csv"},
+		{"SELECT * FROM read_csv('testdata/employees.csv', header = 1)", "header must be true or false, got 1"},
+		{"SELECT * FROM read_csv(1)", "read_csv needs a path, got 1"},
+		{"SELECT * FROM read_parquet()", "read_parquet takes one or more paths"},
+	} {
+		_, err := ec.Sql(context.Background(), test.sql)
+		assert.EqualError(t, err, test.err, test.sql)
+	}
+	_, err = ec.Sql(context.Background(), "SELECT * FROM read_csv('testdata/missing.csv')")
+	assert.Error(t, err)
+}
+
+func TestRegisterTableFunction(t *testing.T) {
+	ec := &ExecutionContext{}
+	// repeat returns a table with n copies of a string
+	ec.RegisterTableFunction("Repeat", TableFunctionFunc(func(ec *ExecutionContext, args []any, options map[string]any) (DataSource, error) {
+		if len(args) != 2 {
+			return nil, fmt.Errorf("repeat takes 2 arguments")
+		}
+		n, _ := args[1].(int64)
+		rows := make([][]any, n)
+		for i := range rows {
+			rows[i] = []any{fmt.Sprint(args[0])}
+		}
+		name, _ := options["name"].(string)
+		if name == "" {
+			name = "value"
+		}
+		df, err := ec.values([]arrow.Field{{Name: name, Type: drogo.String}}, rows)
+		if err != nil {
+			return nil, err
+		}
+		return df.LogicalPlan().(Scan).Source, nil
+	}))
+	_, ok := ec.TableFunction("REPEAT")
+	assert.True(t, ok)
+
+	rows := sqlRows(t, ec, "SELECT word, COUNT(*) FROM repeat('abc', 2 + 1, name = 'word') GROUP BY word")
+	assert.Equal(t, [][]any{{"abc", int64(3)}}, rows)
+	df, err := ec.CallTable("repeat", []any{1.5, int64(2)}, nil)
+	require.NoError(t, err)
+	assert.Equal(t, "repeat(1.5, 2)", df.LogicalPlan().(Scan).Path)
+	stream, err := ec.Execute(context.Background(), df)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"[1.5]", "[1.5]"}, collectRows(t, stream))
+
+	_, err = ec.Sql(context.Background(), "SELECT * FROM repeat(1)")
+	assert.EqualError(t, err, "repeat takes 2 arguments")
+
+	// registered functions replace the built-in ones
+	ec.RegisterTableFunction("range", TableFunctionFunc(func(ec *ExecutionContext, args []any, options map[string]any) (DataSource, error) {
+		return NewSeriesDataSource("r", 0, 1, 1, true, 0), nil
+	}))
+	rows = sqlRows(t, ec, "SELECT r FROM range(10) ORDER BY r")
+	assert.Equal(t, [][]any{{int64(0)}, {int64(1)}}, rows)
+}
+
+func TestUnnestSql(t *testing.T) {
+	ec := sqlContext(t)
+	rows := sqlRows(t, ec, "SELECT id, unnest(regexp_match(first_name, '^(.)(.)')) AS c FROM employees WHERE id <= 2 ORDER BY id, c")
+	assert.Equal(t, [][]any{{int64(1), "B"}, {int64(1), "i"}, {int64(2), "G"}, {int64(2), "r"}}, rows)
+	// rows with a null list produce no rows
+	rows = sqlRows(t, ec, `SELECT DISTINCT unnest(regexp_match(job_title, '(\w+) (\w+)')) AS word FROM employees ORDER BY word`)
+	assert.Equal(t, [][]any{{"Engineer"}, {"Software"}}, rows)
+	rows = sqlRows(t, ec, `SELECT COUNT(*) FROM (SELECT unnest(regexp_match(job_title, '(\w+) (\w+)')) FROM employees)`)
+	assert.Equal(t, [][]any{{int64(6)}}, rows)
+
+	for _, test := range []struct{ sql, err string }{
+		{"SELECT unnest(id) FROM employees", "UNNEST needs a list, got int64: UNNEST(id)"},
+		{"SELECT unnest() FROM employees", "UNNEST takes one argument: UNNEST()"},
+		{"SELECT unnest(regexp_match(state, 'C')), unnest(regexp_match(state, 'O')) FROM employees", "only one UNNEST is allowed in a select list"},
+		{"SELECT id FROM employees WHERE unnest(regexp_match(state, 'C')) = 'C'", "UNNEST is only allowed as an item of the select list"},
+		{"SELECT unnest(regexp_match(state, 'C')), COUNT(*) FROM employees", "UNNEST cannot be used with GROUP BY or aggregate functions"},
+	} {
+		_, err := ec.Sql(context.Background(), test.sql)
+		assert.EqualError(t, err, test.err, test.sql)
+	}
+}
+
+func TestUnnestDataFrame(t *testing.T) {
+	ec := &ExecutionContext{BatchSize: 2}
+	path := writeJson(t, "posts.json", `{"id":1,"title":"a","tags":["x","y"]}
+{"id":2,"title":"b","tags":[]}
+{"id":3,"title":"c","tags":null}
+{"id":4,"title":"d","tags":["z"]}
+`)
+	df := ec.Json(path).Unnest("tags").Project([]LogicalExpr{Col("id"), Col("tags")})
+	assert.Equal(t, drogo.String, df.Schema().Field(1).Type)
+	optimized := NewOptimizer().Optimize(df.LogicalPlan())
+	assert.Contains(t, Format(optimized, 0), "Unnest: column=tags")
+	assert.Contains(t, Format(optimized, 0), "projection=[id tags]")
+	stream, err := ec.Execute(context.Background(), df)
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []string{"[1 x]", "[1 y]", "[4 z]"}, collectRows(t, stream))
+
+	_, err = ec.Execute(context.Background(), ec.Json(path).Unnest("title"))
+	assert.EqualError(t, err, "cannot unnest column 'title' of type utf8")
+	_, err = ec.Execute(context.Background(), ec.Json(path).Unnest("labels"))
+	assert.EqualError(t, err, "no column named 'labels'")
+}
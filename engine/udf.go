@@ -0,0 +1,236 @@
+package engine
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/apache/arrow/go/v12/arrow"
+	"github.com/briansterle/drogo"
+)
+
+// ScalarUDF is a Go function that queries can call by name once it is
+// registered with an ExecutionContext. It is applied a batch at a time: Fn
+// gets one column per argument, converted to the argument's declared type,
+// and must return a column of ReturnType with a value for every row. Null
+// arguments are passed to Fn as is. An error from Fn, or a result of the
+// wrong length, fails the query.
+type ScalarUDF struct {
+	Name       string
+	ArgTypes   []arrow.DataType
+	ReturnType arrow.DataType
+	Fn         func(args []ColumnVector) (ColumnVector, error)
+}
+
+// RegisterFunction makes a scalar function available to queries, replacing
+// any function already registered under its name. Names are case
+// insensitive, and the built-in string functions take precedence in SQL.
+func (ec *ExecutionContext) RegisterFunction(udf *ScalarUDF) {
+	ec.functionsMu.Lock()
+	defer ec.functionsMu.Unlock()
+	if ec.functions == nil {
+		ec.functions = map[string]*ScalarUDF{}
+	}
+	ec.functions[strings.ToLower(udf.Name)] = udf
+}
+
+// Function returns the scalar function registered under a name
+func (ec *ExecutionContext) Function(name string) (*ScalarUDF, bool) {
+	ec.functionsMu.Lock()
+	defer ec.functionsMu.Unlock()
+	udf, ok := ec.functions[strings.ToLower(name)]
+	return udf, ok
+}
+
+// Call returns an expression that calls the scalar function registered under
+// a name. The types of the arguments are checked when the query is planned.
+func (ec *ExecutionContext) Call(name string, args ...LogicalExpr) (ScalarFunction, error) {
+	udf, ok := ec.Function(name)
+	if !ok {
+		return ScalarFunction{}, fmt.Errorf("unknown function %s", name)
+	}
+	if len(args) != len(udf.ArgTypes) {
+		return ScalarFunction{}, fmt.Errorf("function %s takes %d arguments, got %d", udf.Name, len(udf.ArgTypes), len(args))
+	}
+	return ScalarFunction{udf, args}, nil
+}
+
+// ScalarFunction calls a user-defined function on each row
+type ScalarFunction struct {
+	Func *ScalarUDF
+	Args []LogicalExpr
+}
+
+func (f ScalarFunction) ToField(input LogicalPlan) arrow.Field {
+	return arrow.Field{Name: f.Func.Name, Type: f.Func.ReturnType, Nullable: true}
+}
+
+func (f ScalarFunction) String() string {
+	args := make([]string, len(f.Args))
+	for i, arg := range f.Args {
+		args[i] = arg.String()
+	}
+	return f.Func.Name + "(" + strings.Join(args, ", ") + ")"
+}
+
+// check verifies that the arguments have the types the function declares.
+// Numeric arguments are accepted for numeric parameters of another type and
+// converted when the function is evaluated.
+func (f ScalarFunction) check(input LogicalPlan) error {
+	if len(f.Args) != len(f.Func.ArgTypes) {
+		return fmt.Errorf("function %s takes %d arguments, got %d", f.Func.Name, len(f.Func.ArgTypes), len(f.Args))
+	}
+	for i, arg := range f.Args {
+		want, got := f.Func.ArgTypes[i], arg.ToField(input).Type
+		if !arrow.TypeEqual(want, got) && !(isNumericType(want) && isNumericType(got)) {
+			return fmt.Errorf("function %s expects argument %d to be %s, got %s: %s", f.Func.Name, i+1, want, got, f)
+		}
+	}
+	return nil
+}
+
+// ScalarFunctionExpression evaluates a user-defined function over a batch
+type ScalarFunctionExpression struct {
+	udf  *ScalarUDF
+	args []Expression
+}
+
+func (e ScalarFunctionExpression) Evaluate(input RecordBatch) ColumnVector {
+	args := make([]ColumnVector, len(e.args))
+	for i, arg := range e.args {
+		args[i] = coerceVector(arg.Evaluate(input), e.udf.ArgTypes[i])
+	}
+	result, err := e.udf.Fn(args)
+	if err != nil {
+		panic(evaluationError{fmt.Errorf("function %s: %w", e.udf.Name, err)})
+	}
+	if result == nil || result.Len() != input.RowCount() {
+		n := 0
+		if result != nil {
+			n = result.Len()
+		}
+		panic(evaluationError{fmt.Errorf("function %s returned %d values for %d rows", e.udf.Name, n, input.RowCount())})
+	}
+	return result
+}
+
+func (e ScalarFunctionExpression) String() string {
+	args := make([]string, len(e.args))
+	for i, arg := range e.args {
+		args[i] = arg.String()
+	}
+	return e.udf.Name + "(" + strings.Join(args, ", ") + ")"
+}
+
+// coerceVector converts a numeric column to another numeric type
+func coerceVector(v ColumnVector, dtype arrow.DataType) ColumnVector {
+	if arrow.TypeEqual(v.DataType(), dtype) {
+		return v
+	}
+	values := make([]any, v.Len())
+	for i := range values {
+		if value := v.GetValue(i); value != nil {
+			values[i] = castNumeric(value, dtype)
+		}
+	}
+	return drogo.New(dtype, len(values), values)
+}
+
+// AggregateUDF is a user-defined aggregate function. Every group is
+// aggregated by its own Accumulator from NewAccumulator, which gets the
+// group's argument values, nulls included, converted to ArgType. When a
+// query runs on several partitions, each partition's accumulators pass
+// their State, one value of each of StateTypes, to the Merge of a final
+// accumulator whose FinalValue is the result.
+type AggregateUDF struct {
+	Name           string
+	ArgType        arrow.DataType
+	ReturnType     arrow.DataType
+	StateTypes     []arrow.DataType
+	NewAccumulator func() Accumulator
+}
+
+// RegisterAggregateFunction makes an aggregate function available to
+// queries, replacing any aggregate function already registered under its
+// name. Names are case insensitive, and the built-in aggregates take
+// precedence in SQL.
+func (ec *ExecutionContext) RegisterAggregateFunction(udaf *AggregateUDF) {
+	ec.functionsMu.Lock()
+	defer ec.functionsMu.Unlock()
+	if ec.aggregates == nil {
+		ec.aggregates = map[string]*AggregateUDF{}
+	}
+	ec.aggregates[strings.ToLower(udaf.Name)] = udaf
+}
+
+// AggregateFunction returns the aggregate function registered under a name
+func (ec *ExecutionContext) AggregateFunction(name string) (*AggregateUDF, bool) {
+	ec.functionsMu.Lock()
+	defer ec.functionsMu.Unlock()
+	udaf, ok := ec.aggregates[strings.ToLower(name)]
+	return udaf, ok
+}
+
+// CallAggregate returns an aggregate expression for the aggregate function
+// registered under a name. The type of the argument is checked when the
+// query is planned.
+func (ec *ExecutionContext) CallAggregate(name string, arg LogicalExpr) (AggregateExpr, error) {
+	udaf, ok := ec.AggregateFunction(name)
+	if !ok {
+		return AggregateExpr{}, fmt.Errorf("unknown aggregate function %s", name)
+	}
+	return AggregateExpr{Name: udaf.Name, Expr: arg, Func: udaf}, nil
+}
+
+// check verifies that the argument of a user-defined aggregate has the type
+// it declares or, for a numeric type, another numeric type
+func (e *AggregateExpr) check(input LogicalPlan) error {
+	want, got := e.Func.ArgType, e.Expr.ToField(input).Type
+	if !arrow.TypeEqual(want, got) && !(isNumericType(want) && isNumericType(got)) {
+		return fmt.Errorf("aggregate function %s expects a %s argument, got %s: %s", e.Name, want, got, e.String())
+	}
+	return nil
+}
+
+// AggregateUDFExpression aggregates with a user-defined function
+type AggregateUDFExpression struct {
+	udaf *AggregateUDF
+	expr Expression
+}
+
+func (e AggregateUDFExpression) InputExpression() Expression {
+	return e.expr
+}
+
+func (e AggregateUDFExpression) CreateAccumulator() Accumulator {
+	acc := e.udaf.NewAccumulator()
+	if isNumericType(e.udaf.ArgType) {
+		return coercingAccumulator{acc, e.udaf.ArgType}
+	}
+	return acc
+}
+
+func (e AggregateUDFExpression) StateFields() []arrow.Field {
+	fields := make([]arrow.Field, len(e.udaf.StateTypes))
+	for i, dtype := range e.udaf.StateTypes {
+		fields[i] = stateField(e, fmt.Sprint(i), dtype)
+	}
+	return fields
+}
+
+func (e AggregateUDFExpression) String() string {
+	return e.udaf.Name + "(" + e.expr.String() + ")"
+}
+
+// coercingAccumulator converts numeric values to the type an accumulator
+// expects
+type coercingAccumulator struct {
+	Accumulator
+	dtype arrow.DataType
+}
+
+func (a coercingAccumulator) Accumulate(value any) {
+	if value != nil {
+		value = castNumeric(value, a.dtype)
+	}
+	a.Accumulator.Accumulate(value)
+}
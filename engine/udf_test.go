@@ -0,0 +1,205 @@
+package engine
+
+import (
+	"context"
+	"errors"
+	"math"
+	"testing"
+
+	"github.com/apache/arrow/go/v12/arrow"
+	"github.com/briansterle/drogo"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// roundTo rounds a number to a multiple of a step
+var roundTo = &ScalarUDF{
+	Name:       "round_to",
+	ArgTypes:   []arrow.DataType{drogo.Float64, drogo.Int64},
+	ReturnType: drogo.Float64,
+	Fn: func(args []ColumnVector) (ColumnVector, error) {
+		values := make([]any, args[0].Len())
+		for i := range values {
+			v, step := args[0].GetValue(i), args[1].GetValue(i)
+			if v == nil || step == nil {
+				continue
+			}
+			s := float64(step.(int64))
+			values[i] = math.Round(v.(float64)/s) * s
+		}
+		return drogo.New(drogo.Float64, len(values), values), nil
+	},
+}
+
+func TestScalarUDFSql(t *testing.T) {
+	ec := sqlContext(t)
+	ec.RegisterFunction(roundTo)
+
+	df, err := ec.Sql(context.Background(), "SELECT id, round_to(salary, 5000) AS rounded FROM employees WHERE ROUND_TO(salary / 2, 1000) >= 6000 ORDER BY id")
+	require.NoError(t, err)
+	assert.Equal(t, "rounded", df.Schema().Field(1).Name)
+	assert.Equal(t, drogo.Float64, df.Schema().Field(1).Type)
+	rows := sqlRows(t, ec, "SELECT id, round_to(salary, 5000) AS rounded FROM employees WHERE ROUND_TO(salary / 2, 1000) >= 6000 ORDER BY id")
+	assert.Equal(t, [][]any{{int64(1), 10000.0}, {int64(3), 10000.0}, {int64(4), 10000.0}, {int64(6), 15000.0}, {int64(7), 15000.0}}, rows)
+
+	rows = sqlRows(t, ec, "SELECT state, SUM(salary) FROM employees GROUP BY state HAVING round_to(SUM(salary), 10000) = 20000 ORDER BY state")
+	assert.Equal(t, [][]any{{"CA", int64(20000)}, {"CO", int64(21500)}, {"OH", int64(23500)}}, rows)
+
+	for _, test := range []struct{ sql, err string }{
+		{"SELECT round_up(salary) FROM employees", "unknown function ROUND_UP"},
+		{"SELECT round_to(salary) FROM employees", "function round_to takes 2 arguments, got 1"},
+		{"SELECT round_to(first_name, 10) FROM employees", "function round_to expects argument 1 to be float64, got utf8: round_to(#first_name, 10)"},
+		{"SELECT round_to(*) FROM employees", "* is only allowed in the select list or COUNT(*)"},
+	} {
+		_, err := ec.Sql(context.Background(), test.sql)
+		assert.EqualError(t, err, test.err, test.sql)
+	}
+}
+
+func TestScalarUDFErrors(t *testing.T) {
+	ec := sqlContext(t)
+	ec.RegisterFunction(&ScalarUDF{
+		Name:       "checked",
+		ArgTypes:   []arrow.DataType{drogo.Int64},
+		ReturnType: drogo.Int64,
+		Fn: func(args []ColumnVector) (ColumnVector, error) {
+			for i := 0; i < args[0].Len(); i++ {
+				if args[0].GetValue(i) == int64(5) {
+					return nil, errors.New("bad id 5")
+				}
+			}
+			return args[0], nil
+		},
+	})
+	ec.RegisterFunction(&ScalarUDF{
+		Name:       "short",
+		ArgTypes:   []arrow.DataType{drogo.Int64},
+		ReturnType: drogo.Int64,
+		Fn: func(args []ColumnVector) (ColumnVector, error) {
+			return drogo.New(drogo.Int64, 1, []any{int64(1)}), nil
+		},
+	})
+
+	// the errors reach the caller from every operator that evaluates
+	// expressions, including those running in their own goroutines
+	for _, test := range []struct{ sql, err string }{
+		{"SELECT checked(id) FROM employees", "function checked: bad id 5"},
+		{"SELECT id FROM employees WHERE checked(id) > 0", "function checked: bad id 5"},
+		{"SELECT checked(id), COUNT(*) FROM employees GROUP BY checked(id)", "function checked: bad id 5"},
+		{"SELECT SUM(checked(id)) FROM employees", "function checked: bad id 5"},
+		{"SELECT id FROM employees ORDER BY checked(id)", "function checked: bad id 5"},
+		{"SELECT id FROM employees ORDER BY checked(id) LIMIT 2", "function checked: bad id 5"},
+		{"SELECT short(id) FROM employees", "function short returned 1 values for 3 rows"},
+		{"SELECT * FROM generate_series(1, checked(5))", "invalid argument CHECKED(5) of generate_series: function checked: bad id 5"},
+	} {
+		df, err := ec.Sql(context.Background(), test.sql)
+		if err == nil {
+			_, err = df.Collect(context.Background())
+		}
+		assert.EqualError(t, err, test.err, test.sql)
+	}
+}
+
+func TestScalarUDFDataFrame(t *testing.T) {
+	ec := &ExecutionContext{}
+	ec.RegisterFunction(roundTo)
+	call, err := ec.Call("Round_To", Col("salary"), Int(1000))
+	require.NoError(t, err)
+	assert.Same(t, roundTo, call.Func)
+
+	df := ec.Csv("testdata/employees.csv").Project([]LogicalExpr{Col("id"), Alias{call, "k"}}).Filter(Gt(Col("k"), Int(12000)))
+	optimized := NewOptimizer().Optimize(df.LogicalPlan())
+	assert.Contains(t, Format(optimized, 0), "projection=[id salary]")
+	stream, err := ec.Execute(context.Background(), df)
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []string{"[6 14000]", "[7 13000]"}, collectRows(t, stream))
+
+	// types are checked when the query is planned
+	call, err = ec.Call("round_to", Col("state"), Int(1))
+	require.NoError(t, err)
+	_, err = ec.Execute(context.Background(), ec.Csv("testdata/employees.csv").Project([]LogicalExpr{call}))
+	assert.EqualError(t, err, "function round_to expects argument 1 to be float64, got utf8: round_to(#state, 1)")
+
+	_, err = ec.Call("round_to", Col("salary"))
+	assert.EqualError(t, err, "function round_to takes 2 arguments, got 1")
+	_, err = ec.Call("ceil", Col("salary"))
+	assert.EqualError(t, err, "unknown function ceil")
+}
+
+// spread is the difference between the largest and smallest value
+var spread = &AggregateUDF{
+	Name:           "spread",
+	ArgType:        drogo.Float64,
+	ReturnType:     drogo.Float64,
+	StateTypes:     []arrow.DataType{drogo.Float64, drogo.Float64},
+	NewAccumulator: func() Accumulator { return &spreadAccumulator{} },
+}
+
+type spreadAccumulator struct {
+	min, max any
+}
+
+func (a *spreadAccumulator) Accumulate(value any) {
+	if value == nil {
+		return
+	}
+	if a.min == nil || value.(float64) < a.min.(float64) {
+		a.min = value
+	}
+	if a.max == nil || value.(float64) > a.max.(float64) {
+		a.max = value
+	}
+}
+
+func (a *spreadAccumulator) FinalValue() any {
+	if a.min == nil {
+		return nil
+	}
+	return a.max.(float64) - a.min.(float64)
+}
+
+func (a *spreadAccumulator) State() []any {
+	return []any{a.min, a.max}
+}
+
+func (a *spreadAccumulator) Merge(state []any) {
+	a.Accumulate(state[0])
+	a.Accumulate(state[1])
+}
+
+func TestAggregateUDFSql(t *testing.T) {
+	ec := sqlContext(t)
+	ec.RegisterAggregateFunction(spread)
+
+	rows := sqlRows(t, ec, "SELECT spread(salary), SPREAD(id), COUNT(*) FROM employees")
+	assert.Equal(t, [][]any{{6000.0, 7.0, int64(8)}}, rows)
+	rows = sqlRows(t, ec, "SELECT state, spread(salary) AS s FROM employees WHERE id < 8 GROUP BY state HAVING spread(salary) > 0 ORDER BY s DESC")
+	assert.Equal(t, [][]any{{"OH", 4500.0}, {"CO", 1500.0}}, rows)
+
+	for _, test := range []struct{ sql, err string }{
+		{"SELECT spread(first_name) FROM employees", "aggregate function spread expects a float64 argument, got utf8: spread(#first_name)"},
+		{"SELECT id FROM employees WHERE spread(salary) > 1", "aggregate functions are not allowed in WHERE"},
+		{"SELECT spread(SUM(salary)) FROM employees", "aggregate function calls cannot be nested: SPREAD(SUM(salary))"},
+		{"SELECT spread(id, salary) FROM employees", "SPREAD takes one argument"},
+	} {
+		_, err := ec.Sql(context.Background(), test.sql)
+		assert.EqualError(t, err, test.err, test.sql)
+	}
+}
+
+func TestAggregateUDFDataFrame(t *testing.T) {
+	ec := &ExecutionContext{TargetPartitions: 3}
+	ec.RegisterAggregateFunction(spread)
+	e, err := ec.CallAggregate("Spread", Col("salary"))
+	require.NoError(t, err)
+	assert.Same(t, spread, e.Func)
+
+	df := ec.Csv("testdata/employees.csv").Aggregate([]LogicalExpr{Col("job_title")}, []AggregateExpr{e, Max(Col("id"))})
+	assert.Equal(t, "spread", df.Schema().Field(1).Name)
+	stream, err := ec.Execute(context.Background(), df)
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []string{"[Manager 2000 6]", "[Driver 2000 8]", "[Software Engineer 1500 7]", "[Analyst 0 5]"}, collectRows(t, stream))
+
+	_, err = ec.CallAggregate("median", Col("salary"))
+	assert.EqualError(t, err, "unknown aggregate function median")
+}
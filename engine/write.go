@@ -0,0 +1,490 @@
+package engine
+
+import (
+	"bufio"
+	"compress/gzip"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+
+	"github.com/apache/arrow/go/v12/arrow"
+	"github.com/apache/arrow/go/v12/arrow/array"
+	"github.com/apache/arrow/go/v12/arrow/ipc"
+	"github.com/apache/arrow/go/v12/parquet"
+	"github.com/apache/arrow/go/v12/parquet/compress"
+	"github.com/apache/arrow/go/v12/parquet/pqarrow"
+	"github.com/briansterle/drogo"
+)
+
+// hiveDefaultPartition is the directory name used for null partition values
+const hiveDefaultPartition = "__HIVE_DEFAULT_PARTITION__"
+
+// CsvWriteOptions configure DataFrame.WriteCsv
+type CsvWriteOptions struct {
+	// Delimiter separates fields. Zero means a comma.
+	Delimiter rune
+	// NoHeader leaves out the row of column names
+	NoHeader bool
+	// Compression is "gzip" or empty for none
+	Compression string
+	// PartitionBy writes a directory of files, one per distinct value of
+	// these columns. See DataFrame.WriteCsv.
+	PartitionBy []string
+}
+
+// JsonWriteOptions configure DataFrame.WriteJson
+type JsonWriteOptions struct {
+	// Compression is "gzip" or empty for none
+	Compression string
+	PartitionBy []string
+}
+
+// ParquetWriteOptions configure DataFrame.WriteParquet
+type ParquetWriteOptions struct {
+	// Compression is one of "snappy", "gzip", "zstd", "brotli" or "none".
+	// Empty means snappy.
+	Compression string
+	// RowGroupSize is the most rows written to a row group. Zero means the
+	// parquet library's default.
+	RowGroupSize int
+	PartitionBy  []string
+}
+
+// ArrowWriteOptions configure DataFrame.WriteArrow
+type ArrowWriteOptions struct {
+	// Compression is "lz4", "zstd" or empty for none
+	Compression string
+	// Stream writes the IPC streaming format instead of the file format
+	Stream      bool
+	PartitionBy []string
+}
+
+// batchWriter encodes record batches in a file format. Close finishes the
+// format but leaves closing the underlying file to the caller.
+type batchWriter interface {
+	Write(batch RecordBatch) error
+	Close() error
+}
+
+type newBatchWriter func(w io.WriteSeeker, schema Schema) (batchWriter, error)
+
+func (df *DataFrameImpl) WriteCsv(ctx context.Context, path string, options CsvWriteOptions) error {
+	compression, err := fileCompression(options.Compression)
+	if err != nil {
+		return err
+	}
+	return df.write(ctx, path, "csv"+compression.ext, options.PartitionBy, func(w io.WriteSeeker, schema Schema) (batchWriter, error) {
+		out := &csvWriter{compressed: compression.wrap(w)}
+		out.writer = csv.NewWriter(out.compressed)
+		if options.Delimiter != 0 {
+			out.writer.Comma = options.Delimiter
+		}
+		if !options.NoHeader {
+			names := make([]string, len(schema.Fields()))
+			for i, f := range schema.Fields() {
+				names[i] = f.Name
+			}
+			if err := out.writer.Write(names); err != nil {
+				return nil, err
+			}
+		}
+		return out, nil
+	})
+}
+
+func (df *DataFrameImpl) WriteJson(ctx context.Context, path string, options JsonWriteOptions) error {
+	compression, err := fileCompression(options.Compression)
+	if err != nil {
+		return err
+	}
+	return df.write(ctx, path, "json"+compression.ext, options.PartitionBy, func(w io.WriteSeeker, schema Schema) (batchWriter, error) {
+		compressed := compression.wrap(w)
+		return &jsonWriter{compressed: compressed, writer: bufio.NewWriter(compressed)}, nil
+	})
+}
+
+func (df *DataFrameImpl) WriteParquet(ctx context.Context, path string, options ParquetWriteOptions) error {
+	codec, ok := parquetCodecs[options.Compression]
+	if !ok {
+		return fmt.Errorf("unsupported parquet compression: %s", options.Compression)
+	}
+	props := []parquet.WriterProperty{parquet.WithCompression(codec)}
+	if options.RowGroupSize > 0 {
+		props = append(props, parquet.WithMaxRowGroupLength(int64(options.RowGroupSize)))
+	}
+	return df.write(ctx, path, "parquet", options.PartitionBy, func(w io.WriteSeeker, schema Schema) (batchWriter, error) {
+		writer, err := pqarrow.NewFileWriter(nullableSchema(schema), w, parquet.NewWriterProperties(props...), pqarrow.DefaultWriterProps())
+		if err != nil {
+			return nil, err
+		}
+		return &parquetWriter{writer}, nil
+	})
+}
+
+var parquetCodecs = map[string]compress.Compression{
+	"":       compress.Codecs.Snappy,
+	"snappy": compress.Codecs.Snappy,
+	"gzip":   compress.Codecs.Gzip,
+	"zstd":   compress.Codecs.Zstd,
+	"brotli": compress.Codecs.Brotli,
+	"none":   compress.Codecs.Uncompressed,
+}
+
+func (df *DataFrameImpl) WriteArrow(ctx context.Context, path string, options ArrowWriteOptions) error {
+	ipcOptions := []ipc.Option{}
+	switch options.Compression {
+	case "":
+	case "lz4":
+		ipcOptions = append(ipcOptions, ipc.WithLZ4())
+	case "zstd":
+		ipcOptions = append(ipcOptions, ipc.WithZstd())
+	default:
+		return fmt.Errorf("unsupported arrow compression: %s", options.Compression)
+	}
+	return df.write(ctx, path, "arrow", options.PartitionBy, func(w io.WriteSeeker, schema Schema) (batchWriter, error) {
+		schemaOption := ipc.WithSchema(nullableSchema(schema))
+		if options.Stream {
+			return &arrowWriter{ipc.NewWriter(w, append(ipcOptions, schemaOption)...)}, nil
+		}
+		writer, err := ipc.NewFileWriter(w, append(ipcOptions, schemaOption)...)
+		if err != nil {
+			return nil, err
+		}
+		return &arrowWriter{writer}, nil
+	})
+}
+
+// write executes the DataFrame and streams its batches to path.
+//
+// Without partition columns path is the file that is written. Otherwise path
+// is a directory, and the rows for each distinct combination of values of
+// the partition columns are written to path/col1=value1/col2=value2/part-0.ext
+// without the partition columns themselves, as Hive does.
+func (df *DataFrameImpl) write(ctx context.Context, path, ext string, partitionBy []string, create newBatchWriter) error {
+	if df.ec == nil {
+		return fmt.Errorf("DataFrame was not created by an ExecutionContext")
+	}
+	stream, err := df.ec.Execute(ctx, df)
+	if err != nil {
+		return err
+	}
+	defer stream.Close()
+
+	schema := df.Schema()
+	partitionIndices := make([]int, len(partitionBy))
+	for i, name := range partitionBy {
+		indices := schema.FieldIndices(name)
+		if len(indices) == 0 {
+			return fmt.Errorf("no column named '%s' to partition by", name)
+		}
+		partitionIndices[i] = indices[0]
+	}
+
+	if len(partitionBy) == 0 {
+		out, err := openOutput(path, schema, create)
+		if err != nil {
+			return err
+		}
+		err = forEachBatch(ctx, stream, out.Write)
+		if closeErr := out.Close(); err == nil {
+			err = closeErr
+		}
+		return err
+	}
+
+	if err := os.MkdirAll(path, 0o755); err != nil {
+		return err
+	}
+	dataSchema, dataIndices := withoutColumns(schema, partitionIndices)
+	outputs := map[string]*outputFile{}
+	err = forEachBatch(ctx, stream, func(batch RecordBatch) error {
+		rows := map[string][]int{}
+		order := []string{}
+		for row := 0; row < batch.RowCount(); row++ {
+			dir := hivePartitionDir(batch, partitionBy, partitionIndices, row)
+			if _, ok := rows[dir]; !ok {
+				order = append(order, dir)
+			}
+			rows[dir] = append(rows[dir], row)
+		}
+		for _, dir := range order {
+			out, ok := outputs[dir]
+			if !ok {
+				if err := os.MkdirAll(filepath.Join(path, dir), 0o755); err != nil {
+					return err
+				}
+				created, err := openOutput(filepath.Join(path, dir, "part-0."+ext), dataSchema, create)
+				if err != nil {
+					return err
+				}
+				out = created
+				outputs[dir] = out
+			}
+			part := batch.Take(rows[dir])
+			if err := out.Write(selectColumns(part, dataSchema, dataIndices)); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	for _, out := range outputs {
+		if closeErr := out.Close(); err == nil {
+			err = closeErr
+		}
+	}
+	return err
+}
+
+// hivePartitionDir returns the relative directory for a row's partition values
+func hivePartitionDir(batch RecordBatch, names []string, indices []int, row int) string {
+	parts := make([]string, len(names))
+	for i, name := range names {
+		value := hiveDefaultPartition
+		if v := batch.Field(indices[i]).GetValue(row); v != nil {
+			value = url.PathEscape(formatValue(v))
+		}
+		parts[i] = url.PathEscape(name) + "=" + value
+	}
+	return filepath.Join(parts...)
+}
+
+// withoutColumns returns the schema without the given columns, and the
+// indices of the columns that remain
+func withoutColumns(schema Schema, drop []int) (Schema, []int) {
+	fields := []arrow.Field{}
+	indices := []int{}
+	for i, f := range schema.Fields() {
+		dropped := false
+		for _, d := range drop {
+			dropped = dropped || d == i
+		}
+		if !dropped {
+			fields = append(fields, f)
+			indices = append(indices, i)
+		}
+	}
+	return Schema{arrow.NewSchema(fields, nil)}, indices
+}
+
+func selectColumns(batch RecordBatch, schema Schema, indices []int) RecordBatch {
+	fields := make([]ColumnVector, len(indices))
+	for i, idx := range indices {
+		fields[i] = batch.Field(idx)
+	}
+	return RecordBatch{schema, fields}
+}
+
+// outputFile is a batch writer together with the file it writes to
+type outputFile struct {
+	batchWriter
+	file *os.File
+}
+
+func openOutput(path string, schema Schema, create newBatchWriter) (*outputFile, error) {
+	file, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	// the file is closed here rather than by the format writers, some of
+	// which would otherwise close it themselves
+	writer, err := create(struct{ io.WriteSeeker }{file}, schema)
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	return &outputFile{writer, file}, nil
+}
+
+func (o *outputFile) Close() error {
+	err := o.batchWriter.Close()
+	if closeErr := o.file.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// outputCompression wraps text formats in an optional compressor
+type outputCompression struct {
+	// ext is appended to the file extension, such as ".gz"
+	ext  string
+	wrap func(w io.Writer) io.WriteCloser
+}
+
+func fileCompression(name string) (outputCompression, error) {
+	switch name {
+	case "":
+		return outputCompression{"", func(w io.Writer) io.WriteCloser { return nopWriteCloser{w} }}, nil
+	case "gzip":
+		return outputCompression{".gz", func(w io.Writer) io.WriteCloser { return gzip.NewWriter(w) }}, nil
+	default:
+		return outputCompression{}, fmt.Errorf("unsupported compression: %s", name)
+	}
+}
+
+type nopWriteCloser struct {
+	io.Writer
+}
+
+func (nopWriteCloser) Close() error {
+	return nil
+}
+
+// formatValue renders a value as text the CSV reader parses back
+func formatValue(v any) string {
+	switch v := v.(type) {
+	case float32:
+		return strconv.FormatFloat(float64(v), 'f', -1, 32)
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+type csvWriter struct {
+	writer     *csv.Writer
+	compressed io.WriteCloser
+	record     []string
+}
+
+func (w *csvWriter) Write(batch RecordBatch) error {
+	for row := 0; row < batch.RowCount(); row++ {
+		w.record = w.record[:0]
+		for _, column := range batch.Fields {
+			v := column.GetValue(row)
+			if v == nil {
+				w.record = append(w.record, "")
+				continue
+			}
+			w.record = append(w.record, formatValue(v))
+		}
+		if err := w.writer.Write(w.record); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (w *csvWriter) Close() error {
+	w.writer.Flush()
+	if err := w.writer.Error(); err != nil {
+		return err
+	}
+	return w.compressed.Close()
+}
+
+// jsonWriter writes one JSON object per line with the keys in column order
+type jsonWriter struct {
+	writer     *bufio.Writer
+	compressed io.WriteCloser
+}
+
+func (w *jsonWriter) Write(batch RecordBatch) error {
+	keys := make([][]byte, batch.ColumnCount())
+	for i, f := range batch.Schema.Fields() {
+		key, err := json.Marshal(f.Name)
+		if err != nil {
+			return err
+		}
+		keys[i] = key
+	}
+	for row := 0; row < batch.RowCount(); row++ {
+		w.writer.WriteByte('{')
+		for i, column := range batch.Fields {
+			if i > 0 {
+				w.writer.WriteByte(',')
+			}
+			w.writer.Write(keys[i])
+			w.writer.WriteByte(':')
+			value, err := json.Marshal(column.GetValue(row))
+			if err != nil {
+				return fmt.Errorf("column %s: %w", batch.Schema.Field(i).Name, err)
+			}
+			w.writer.Write(value)
+		}
+		if _, err := w.writer.WriteString("}\n"); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (w *jsonWriter) Close() error {
+	if err := w.writer.Flush(); err != nil {
+		return err
+	}
+	return w.compressed.Close()
+}
+
+type parquetWriter struct {
+	writer *pqarrow.FileWriter
+}
+
+func (w *parquetWriter) Write(batch RecordBatch) error {
+	record := toArrowRecord(batch)
+	defer record.Release()
+	// buffered writes fill row groups up to the configured size across
+	// batches rather than starting a row group per batch
+	return w.writer.WriteBuffered(record)
+}
+
+func (w *parquetWriter) Close() error {
+	return w.writer.Close()
+}
+
+type arrowRecordWriter interface {
+	Write(rec arrow.Record) error
+	Close() error
+}
+
+type arrowWriter struct {
+	writer arrowRecordWriter
+}
+
+func (w *arrowWriter) Write(batch RecordBatch) error {
+	record := toArrowRecord(batch)
+	defer record.Release()
+	return w.writer.Write(record)
+}
+
+func (w *arrowWriter) Close() error {
+	return w.writer.Close()
+}
+
+// nullableSchema marks every field nullable, since the engine does not track
+// whether computed columns can contain nulls
+func nullableSchema(schema Schema) *arrow.Schema {
+	fields := make([]arrow.Field, len(schema.Fields()))
+	for i, f := range schema.Fields() {
+		fields[i] = arrow.Field{Name: f.Name, Type: f.Type, Nullable: true}
+	}
+	return arrow.NewSchema(fields, nil)
+}
+
+// toArrowRecord converts a batch to an arrow record, reusing the arrow
+// arrays of columns that already have one
+func toArrowRecord(batch RecordBatch) arrow.Record {
+	arrays := make([]arrow.Array, batch.ColumnCount())
+	for i, column := range batch.Fields {
+		if arr, ok := column.(drogo.Array); ok {
+			arrays[i] = arr.ArrowArray()
+			continue
+		}
+		values := make([]any, column.Len())
+		for j := range values {
+			values[j] = column.GetValue(j)
+		}
+		arrays[i] = drogo.New(column.DataType(), len(values), values).ArrowArray()
+	}
+	rows := 0
+	if len(arrays) > 0 {
+		rows = arrays[0].Len()
+	}
+	return array.NewRecord(nullableSchema(batch.Schema), arrays, int64(rows))
+}
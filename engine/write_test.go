@@ -0,0 +1,129 @@
+package engine
+
+import (
+	"compress/gzip"
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/apache/arrow/go/v12/arrow/ipc"
+	"github.com/apache/arrow/go/v12/parquet/file"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func readFile(t *testing.T, path string) string {
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	return string(data)
+}
+
+func TestWriteCsv(t *testing.T) {
+	ctx := &ExecutionContext{TargetPartitions: 1}
+	df := ctx.Csv(writeEventsCsv(t, 3, 2)).
+		Project([]LogicalExpr{Col("user"), Alias{Divide(Col("amount"), Flt(2)), "half"}})
+	path := filepath.Join(t.TempDir(), "out.csv")
+	require.NoError(t, df.WriteCsv(context.Background(), path, CsvWriteOptions{Delimiter: ';'}))
+	assert.Equal(t, "user;half\nuser-0;0\nuser-1;0.5\nuser-0;1\n", readFile(t, path))
+
+	path = filepath.Join(t.TempDir(), "out.csv.gz")
+	require.NoError(t, df.WriteCsv(context.Background(), path, CsvWriteOptions{NoHeader: true, Compression: "gzip"}))
+	f, err := os.Open(path)
+	require.NoError(t, err)
+	defer f.Close()
+	gz, err := gzip.NewReader(f)
+	require.NoError(t, err)
+	data, err := io.ReadAll(gz)
+	require.NoError(t, err)
+	assert.Equal(t, "user-0,0\nuser-1,0.5\nuser-0,1\n", string(data))
+
+	assert.Error(t, df.WriteCsv(context.Background(), path, CsvWriteOptions{Compression: "lzo"}))
+}
+
+func TestWriteJson(t *testing.T) {
+	ctx := &ExecutionContext{TargetPartitions: 1}
+	df := ctx.Csv(writeEventsCsv(t, 2, 2)).
+		Project([]LogicalExpr{Col("user"), Col("amount"), Alias{Gt(Col("amount"), Int(0)), "positive"}})
+	path := filepath.Join(t.TempDir(), "out.json")
+	require.NoError(t, df.WriteJson(context.Background(), path, JsonWriteOptions{}))
+	assert.Equal(t, `{"user":"user-0","amount":0,"positive":false}
+{"user":"user-1","amount":1,"positive":true}
+`, readFile(t, path))
+}
+
+func TestWriteParquet(t *testing.T) {
+	ctx := &ExecutionContext{TargetPartitions: 4}
+	df := ctx.Csv(writeEventsCsv(t, 1000, 10)).Sort([]SortExpr{Asc(Col("amount"))})
+	path := filepath.Join(t.TempDir(), "out.parquet")
+	options := ParquetWriteOptions{Compression: "zstd", RowGroupSize: 300}
+	require.NoError(t, df.WriteParquet(context.Background(), path, options))
+
+	rdr, err := file.OpenParquetFile(path, false)
+	require.NoError(t, err)
+	assert.Equal(t, 4, rdr.NumRowGroups())
+	rdr.Close()
+
+	batches, err := ctx.Parquet(path).Collect(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, sequence(1000), int64Column(batches, 1))
+}
+
+func TestWriteArrow(t *testing.T) {
+	ctx := &ExecutionContext{TargetPartitions: 2}
+	df := ctx.Csv(writeEventsCsv(t, 100, 10))
+	for _, stream := range []bool{false, true} {
+		path := filepath.Join(t.TempDir(), "out.arrow")
+		require.NoError(t, df.WriteArrow(context.Background(), path, ArrowWriteOptions{Compression: "lz4", Stream: stream}))
+
+		f, err := os.Open(path)
+		require.NoError(t, err)
+		rows := 0
+		if stream {
+			reader, err := ipc.NewReader(f)
+			require.NoError(t, err)
+			for reader.Next() {
+				rows += int(reader.Record().NumRows())
+			}
+			reader.Release()
+		} else {
+			reader, err := ipc.NewFileReader(f)
+			require.NoError(t, err)
+			for i := 0; i < reader.NumRecords(); i++ {
+				record, err := reader.Record(i)
+				require.NoError(t, err)
+				rows += int(record.NumRows())
+			}
+			reader.Close()
+		}
+		f.Close()
+		assert.Equal(t, 100, rows)
+	}
+}
+
+func TestWritePartitioned(t *testing.T) {
+	ctx := &ExecutionContext{TargetPartitions: 2}
+	dir := filepath.Join(t.TempDir(), "events")
+	df := ctx.Csv(writeEventsCsv(t, 30, 3)).
+		Project([]LogicalExpr{Col("amount"), Col("user"), Alias{Modulus(Col("amount"), Int(2)), "parity"}})
+	require.NoError(t, df.WriteCsv(context.Background(), dir, CsvWriteOptions{PartitionBy: []string{"user", "parity"}}))
+
+	entries, err := os.ReadDir(dir)
+	require.NoError(t, err)
+	users := []string{}
+	for _, e := range entries {
+		users = append(users, e.Name())
+	}
+	assert.Equal(t, []string{"user=user-0", "user=user-1", "user=user-2"}, users)
+
+	// user-1 has amounts 1, 4, 7, ... of which the even ones are 4, 10, ...
+	data := readFile(t, filepath.Join(dir, "user=user-1", "parity=0", "part-0.csv"))
+	lines := strings.Split(strings.TrimSpace(data), "\n")
+	assert.Equal(t, "amount", lines[0])
+	assert.ElementsMatch(t, []string{"4", "10", "16", "22", "28"}, lines[1:])
+
+	err = df.WriteCsv(context.Background(), dir, CsvWriteOptions{PartitionBy: []string{"missing"}})
+	assert.ErrorContains(t, err, "missing")
+}